@@ -2,46 +2,113 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"procguard/internal/app"
 	"procguard/internal/data"
-	"slices"
-	"strings"
 	"time"
 )
 
+// parseBlockExpiry converts the optional `duration` (e.g. "2h") or `expires_at` (RFC 3339) fields of a block request
+// into a Unix expiry time. It returns 0 when neither is set, meaning the block is permanent.
+func parseBlockExpiry(duration, expiresAt string, now time.Time) (int64, error) {
+	switch {
+	case duration != "" && expiresAt != "":
+		return 0, errors.New("only one of duration and expires_at may be set")
+	case duration != "":
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %w", err)
+		}
+		if d <= 0 {
+			return 0, errors.New("duration must be positive")
+		}
+		return now.Add(d).Unix(), nil
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		if !t.After(now) {
+			return 0, errors.New("expires_at must be in the future")
+		}
+		return t.Unix(), nil
+	}
+	return 0, nil
+}
+
 // handleBlockApps adds one or more applications to the blocklist.
-// It expects a JSON request with a `names` field containing a list of application names.
+// It expects a JSON request with a `names` field containing a list of application names,
+// and optionally a `reason` and `tags` that are stored with every entry.
+// A `duration` or `expires_at` makes the block temporary.
 func (s *Server) handleBlockApps(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Names []string `json:"names"`
+		Names     []string `json:"names"`
+		Reason    string   `json:"reason"`
+		Tags      []string `json:"tags"`
+		Duration  string   `json:"duration"`
+		ExpiresAt string   `json:"expires_at"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	list, err := data.LoadAppBlocklist()
+	expiresAt, err := parseBlockExpiry(req.Duration, req.ExpiresAt, time.Now())
 	if err != nil {
-		http.Error(w, "Failed to load blocklist", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	entries := make([]data.BlocklistEntry, 0, len(req.Names))
 	for _, name := range req.Names {
-		lowerName := strings.ToLower(name)
-		if !slices.Contains(list, lowerName) {
-			list = append(list, lowerName)
-		}
+		entries = append(entries, data.BlocklistEntry{
+			Name:          name,
+			BlocklistMeta: data.BlocklistMeta{AddedBy: data.AddedByGUI, Reason: req.Reason, Tags: req.Tags, ExpiresAt: expiresAt},
+		})
 	}
 
-	if err := data.SaveAppBlocklist(list); err != nil {
+	if _, err := data.AddAppsToBlocklist(entries); err != nil {
 		http.Error(w, "Failed to save blocklist", http.StatusInternalServerError)
 		return
 	}
 
+	s.disableBlockedExecutables(req.Names)
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// disableBlockedExecutables disables the last known executable of each newly blocked app on disk,
+// if the configured enforcement mode asks for it. Failures are logged but do not fail the request,
+// because the blocklist itself has already been saved and is still enforced.
+func (s *Server) disableBlockedExecutables(names []string) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		s.Logger.Error("Failed to load config", "err", err)
+		return
+	}
+	if !cfg.DisablesExecutables() {
+		return
+	}
+
+	for _, name := range names {
+		exePath, err := data.GetLatestExePath(s.db, name)
+		if err != nil {
+			s.Logger.Error("Error querying exe_path", "app", name, "err", err)
+			continue
+		}
+		if exePath == "" {
+			// The enforcer will disable the executable the next time the app is seen.
+			continue
+		}
+		if err := app.DisableExecutable(name, exePath); err != nil {
+			s.Logger.Error("Failed to disable executable", "path", exePath, "err", err)
+		}
 	}
 }
 
@@ -56,27 +123,19 @@ func (s *Server) handleUnblockApps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	list, err := data.LoadAppBlocklist()
-	if err != nil {
-		http.Error(w, "Failed to load blocklist", http.StatusInternalServerError)
+	if _, err := data.RemoveAppsFromBlocklist(req.Names); err != nil {
+		http.Error(w, "Failed to save blocklist", http.StatusInternalServerError)
 		return
 	}
 
-	for _, name := range req.Names {
-		lowerName := strings.ToLower(name)
-		list = slices.DeleteFunc(list, func(item string) bool {
-			return item == lowerName
-		})
-	}
-
-	if err := data.SaveAppBlocklist(list); err != nil {
-		http.Error(w, "Failed to save blocklist", http.StatusInternalServerError)
-		return
+	// Give back any executables that were disabled on disk while the apps were blocked.
+	if err := app.RestoreExecutables(req.Names); err != nil {
+		s.Logger.Error("Failed to restore disabled executables", "err", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -89,7 +148,7 @@ func (s *Server) handleGetAppBlocklist(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(list); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -99,32 +158,24 @@ func (s *Server) handleClearAppBlocklist(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to clear blocklist", http.StatusInternalServerError)
 		return
 	}
+	if err := app.RestoreAllExecutables(); err != nil {
+		s.Logger.Error("Failed to restore disabled executables", "err", err)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // handleSaveAppBlocklist saves the current application blocklist to a file for export.
 func (s *Server) handleSaveAppBlocklist(w http.ResponseWriter, r *http.Request) {
-	list, err := data.LoadAppBlocklist()
+	b, err := data.MarshalAppBlocklist()
 	if err != nil {
 		http.Error(w, "Failed to get blocklist", http.StatusInternalServerError)
 		return
 	}
 
-	header := map[string]interface{}{
-		"exported_at": time.Now().Format(time.RFC3339),
-		"blocked":     list,
-	}
-
-	b, err := json.MarshalIndent(header, "", "  ")
-	if err != nil {
-		http.Error(w, "Failed to marshal blocklist", http.StatusInternalServerError)
-		return
-	}
-
 	w.Header().Set("Content-Disposition", "attachment; filename=procguard_blocklist.json")
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(b); err != nil {
-		s.Logger.Printf("Error writing response: %v", err)
+		s.Logger.Error("Error writing response", "err", err)
 	}
 }
 
@@ -137,7 +188,7 @@ func (s *Server) handleLoadAppBlocklist(w http.ResponseWriter, r *http.Request)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			s.Logger.Printf("Error closing file: %v", err)
+			s.Logger.Error("Error closing file", "err", err)
 		}
 	}()
 
@@ -147,34 +198,12 @@ func (s *Server) handleLoadAppBlocklist(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var newEntries []string
-	var savedList struct {
-		Blocked []string `json:"blocked"`
-	}
-
-	err = json.Unmarshal(content, &newEntries)
-	if err != nil {
-		err2 := json.Unmarshal(content, &savedList)
-		if err2 != nil {
-			http.Error(w, "Invalid JSON format in uploaded file", http.StatusBadRequest)
-			return
-		}
-		newEntries = savedList.Blocked
-	}
-
-	existingList, err := data.LoadAppBlocklist()
-	if err != nil {
-		http.Error(w, "Failed to load existing blocklist", http.StatusInternalServerError)
+	if !json.Valid(content) {
+		http.Error(w, "Invalid JSON format in uploaded file", http.StatusBadRequest)
 		return
 	}
 
-	for _, entry := range newEntries {
-		if !slices.Contains(existingList, entry) {
-			existingList = append(existingList, entry)
-		}
-	}
-
-	if err := data.SaveAppBlocklist(existingList); err != nil {
+	if _, err := data.ImportAppBlocklistJSON(content); err != nil {
 		http.Error(w, "Failed to save merged blocklist", http.StatusInternalServerError)
 		return
 	}
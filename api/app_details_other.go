@@ -0,0 +1,8 @@
+//go:build !windows
+
+package api
+
+// fileCommercialName returns "" on this platform, where executables carry no version information.
+func fileCommercialName(exePath string) string {
+	return ""
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package api
+
+import "github.com/bi-zone/go-fileversion"
+
+// fileCommercialName returns the name of the application from the version information of the executable at exePath,
+// or "" if it has none.
+func fileCommercialName(exePath string) string {
+	info, err := fileversion.New(exePath)
+	if err != nil {
+		return ""
+	}
+	if name := info.FileDescription(); name != "" {
+		return name
+	}
+	if name := info.ProductName(); name != "" {
+		return name
+	}
+	return info.OriginalFilename()
+}
@@ -1,18 +1,106 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"procguard/internal/auth"
 	"procguard/internal/data"
+	"time"
 )
 
-// handleLogout handles the user logout.
+// errPasswordSet is returned by handleSetPassword's config update when a password has already been set.
+var errPasswordSet = errors.New("password already set")
+
+// sessionCookieName is the name of the cookie that holds the session token.
+const sessionCookieName = "procguard_session"
+
+// IsAuthenticated reports whether r carries the cookie of a session that is still valid.
+func (s *Server) IsAuthenticated(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	return s.sessions.Validate(cookie.Value)
+}
+
+// CSRFToken returns the CSRF token of the session that r belongs to, or "" if r isn't authenticated.
+// Pages hand it to their scripts, which send it in the X-CSRF-Token header: see requestGuard.
+func (s *Server) CSRFToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	token, _ := s.sessions.CSRFToken(cookie.Value)
+	return token
+}
+
+// startSession logs the client in by creating a session and sending its token in a cookie.
+// The cookie is HttpOnly, so scripts can't read it, and SameSite=Strict, so other sites can't make requests with it.
+func (s *Server) startSession(w http.ResponseWriter) error {
+	token, err := s.sessions.Create()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(s.sessions.MaxLifetime().Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// endSession logs the client out by ending its session and deleting its cookie.
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		s.sessions.Revoke(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// handleLogout handles the user logout. The encrypted data is locked once no other client is logged in.
+// It is a POST with the CSRF token, so that another site can't log the user out; the GUI then goes to the login page.
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
-	s.Mu.Lock()
-	s.IsAuthenticated = false
-	s.Mu.Unlock()
-	http.Redirect(w, r, "/login", http.StatusFound)
+	s.endSession(w, r)
+	s.lockIfNoSessions()
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockIfNoSessions locks the encrypted data if no session is left, since the key was unlocked for the sessions.
+func (s *Server) lockIfNoSessions() {
+	s.unlockMu.Lock()
+	defer s.unlockMu.Unlock()
+	if s.sessions.Active() == 0 {
+		data.LockEncryption()
+	}
+}
+
+// lockWhenSessionsExpire locks the encrypted data once the last session has expired, until ctx is cancelled.
+// Clients that go away without logging out would otherwise leave it unlocked.
+func (s *Server) lockWhenSessionsExpire(ctx context.Context) {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if status := data.GetEncryptionStatus(); status.Enabled && !status.Locked {
+				s.lockIfNoSessions()
+			}
+		}
+	}
 }
 
 // handleHasPassword checks if a password has been set for the application.
@@ -25,13 +113,14 @@ func (s *Server) handleHasPassword(w http.ResponseWriter, r *http.Request) {
 	}
 	hasPassword := cfg.PasswordHash != ""
 	if err := json.NewEncoder(w).Encode(map[string]bool{"hasPassword": hasPassword}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // handleLogin handles the user login.
 // It expects a JSON request with a `password` field.
 // It returns a JSON response with a boolean `success` field.
+// A successful login also unlocks the encrypted activity data.
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Password string `json:"password"`
@@ -48,15 +137,25 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if auth.CheckPasswordHash(req.Password, cfg.PasswordHash) {
-		s.Mu.Lock()
-		s.IsAuthenticated = true
-		s.Mu.Unlock()
+		// The session is started along with the unlock, so that the end of another session doesn't lock it again.
+		s.unlockMu.Lock()
+		if err := data.UnlockEncryption(s.db, req.Password); err != nil {
+			// The rest of the application works without it; only encrypted values can't be shown.
+			s.Logger.Error("Failed to unlock encrypted data", "err", err)
+		}
+		err := s.startSession(w)
+		s.unlockMu.Unlock()
+		if err != nil {
+			s.Logger.Error("Failed to start session", "err", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
 		if err := json.NewEncoder(w).Encode(map[string]bool{"success": true}); err != nil {
-			s.Logger.Printf("Error encoding response: %v", err)
+			s.Logger.Error("Error encoding response", "err", err)
 		}
 	} else {
 		if err := json.NewEncoder(w).Encode(map[string]bool{"success": false}); err != nil {
-			s.Logger.Printf("Error encoding response: %v", err)
+			s.Logger.Error("Error encoding response", "err", err)
 		}
 	}
 }
@@ -73,33 +172,90 @@ func (s *Server) handleSetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg, err := data.LoadConfig()
+	hash, err := auth.HashPassword(req.Password)
 	if err != nil {
-		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	if cfg.PasswordHash != "" {
+	err = data.UpdateConfig(func(cfg *data.Config) error {
+		if cfg.PasswordHash != "" {
+			return errPasswordSet
+		}
+		cfg.PasswordHash = hash
+		return nil
+	})
+	if errors.Is(err, errPasswordSet) {
 		http.Error(w, "Password already set", http.StatusForbidden)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to save password", http.StatusInternalServerError)
+		return
+	}
 
-	hash, err := auth.HashPassword(req.Password)
+	if err := s.startSession(w); err != nil {
+		s.Logger.Error("Failed to start session", "err", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]bool{"success": true}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleChangePassword replaces the admin password.
+// It expects a JSON request with `old_password` and `new_password` fields.
+// The encryption key of the activity data is protected with the new password before it is saved.
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.NewPassword == "" {
+		http.Error(w, "New password cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	cfg.PasswordHash = hash
-	if err := cfg.Save(); err != nil {
+	// The new hash and the rewrapped key are saved together, so the key always opens with the password that logs in.
+	err = data.UpdateConfig(func(cfg *data.Config) error {
+		if !auth.CheckPasswordHash(req.OldPassword, cfg.PasswordHash) {
+			return data.ErrWrongPassword
+		}
+		if err := data.RewrapEncryptionKey(cfg, req.OldPassword, req.NewPassword); err != nil {
+			return err
+		}
+		cfg.PasswordHash = hash
+		return nil
+	})
+	if errors.Is(err, data.ErrWrongPassword) {
+		http.Error(w, "Wrong password", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.Logger.Error("Failed to change password", "err", err)
 		http.Error(w, "Failed to save password", http.StatusInternalServerError)
 		return
 	}
 
-	s.Mu.Lock()
-	s.IsAuthenticated = true
-	s.Mu.Unlock()
+	// Every session was opened with the old password, so all of them end. The client that changed it gets a new one.
+	s.sessions.RevokeAll()
+	if err := s.startSession(w); err != nil {
+		s.Logger.Error("Failed to start session", "err", err)
+		http.Error(w, "Password changed, but failed to log in again", http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(map[string]bool{"success": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"procguard/internal/auth"
+	"procguard/internal/data"
+	"time"
+)
+
+// maxBackupMemory is how much of an uploaded backup is kept in memory; the rest is buffered in a temporary file.
+const maxBackupMemory = 32 << 20
+
+// ErrRestore is the cause with which the root context is cancelled when a backup has been staged for restore.
+// The application restarts once it has shut down, and the new instance swaps the backup in.
+var ErrRestore = errors.New("restore requested")
+
+// handleDownloadBackup sends a backup archive of the complete state: the database, settings and blocklists.
+func (s *Server) handleDownloadBackup(w http.ResponseWriter, r *http.Request) {
+	dataDir, err := data.DataPath()
+	if err != nil {
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	// The archive is written to a file first, so a failure can still be reported with an error status.
+	f, err := os.CreateTemp(dataDir, "download-*.zip")
+	if err != nil {
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			s.Logger.Error("Failed to close backup", "err", err)
+		}
+		if err := os.Remove(f.Name()); err != nil {
+			s.Logger.Error("Failed to remove temporary backup", "err", err)
+		}
+	}()
+
+	manifest, err := data.CreateBackup(r.Context(), s.db, f)
+	if err != nil {
+		s.Logger.Error("Failed to create backup", "err", err)
+		http.Error(w, "Failed to create backup", http.StatusInternalServerError)
+		return
+	}
+
+	name := "procguard-" + manifest.CreatedAt.Format("20060102T150405") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, manifest.CreatedAt, f)
+}
+
+// handleRestoreBackup restores a backup archive uploaded as the `backup` field of a multipart form,
+// together with the admin `password`. The archive is validated before anything is changed. ProcGuard then
+// shuts down, which stops enforcement, and restarts with the restored state.
+func (s *Server) handleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxBackupMemory); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := r.MultipartForm.RemoveAll(); err != nil {
+			s.Logger.Error("Failed to remove uploaded backup", "err", err)
+		}
+	}()
+
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if !auth.CheckPasswordHash(r.FormValue("password"), cfg.PasswordHash) {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+
+	file, header, err := r.FormFile("backup")
+	if err != nil {
+		http.Error(w, "Missing backup file", http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			s.Logger.Error("Failed to close uploaded backup", "err", err)
+		}
+	}()
+
+	// The password was checked, so backups from other installations are accepted too.
+	manifest, err := data.StageRestore(file, header.Size, true)
+	if errors.Is(err, data.ErrInvalidBackup) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.Logger.Error("Failed to stage backup for restore", "err", err)
+		http.Error(w, "Failed to restore backup", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "created_at": manifest.CreatedAt}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+
+	s.Logger.Info("Restoring backup, restarting", "created_at", manifest.CreatedAt.Format(time.RFC3339))
+	if s.shutdown != nil {
+		s.shutdown(ErrRestore)
+	}
+}
+
+// handleGetBackupSettings returns the schedule of the automatic backups.
+func (s *Server) handleGetBackupSettings(w http.ResponseWriter, r *http.Request) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cfg.Backup); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleSetBackupSettings updates the schedule of the automatic backups.
+// It expects a JSON request with the `enabled`, `dir`, `interval_hours` and `keep` fields.
+// An empty `dir` writes the backups to the backups directory inside the data directory.
+func (s *Server) handleSetBackupSettings(w http.ResponseWriter, r *http.Request) {
+	var req data.BackupConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.IntervalHours < 1 || req.Keep < 1 {
+		http.Error(w, "The interval and the number of backups kept must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if req.Dir != "" && !filepath.IsAbs(req.Dir) {
+		http.Error(w, "The backup directory must be an absolute path", http.StatusBadRequest)
+		return
+	}
+
+	err := data.UpdateConfig(func(cfg *data.Config) error {
+		cfg.Backup = req
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"procguard/internal/auth"
+	"procguard/internal/data"
+)
+
+// handleGetEncryptionStatus returns whether sensitive activity columns are encrypted and whether they are locked.
+func (s *Server) handleGetEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data.GetEncryptionStatus()); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleEnableEncryption starts encrypting URLs, page titles, executable paths and command lines.
+// It expects a JSON request with the admin `password`, which protects the key.
+func (s *Server) handleEnableEncryption(w http.ResponseWriter, r *http.Request) {
+	s.handleSetEncryption(w, r, data.EnableEncryption)
+}
+
+// handleDisableEncryption stops encrypting sensitive columns and decrypts the values encrypted so far.
+// It expects a JSON request with the admin `password`.
+func (s *Server) handleDisableEncryption(w http.ResponseWriter, r *http.Request) {
+	s.handleSetEncryption(w, r, data.DisableEncryption)
+}
+
+// handleSetEncryption checks the admin password of the request and passes it to set.
+func (s *Server) handleSetEncryption(w http.ResponseWriter, r *http.Request, set func(db *sql.DB, password string) error) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if !auth.CheckPasswordHash(req.Password, cfg.PasswordHash) {
+		http.Error(w, "Wrong password", http.StatusForbidden)
+		return
+	}
+
+	err = set(s.db, req.Password)
+	if errors.Is(err, data.ErrWrongPassword) {
+		http.Error(w, "The password does not open the encryption key", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.Logger.Error("Failed to change encryption", "err", err)
+		http.Error(w, "Failed to change encryption", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data.GetEncryptionStatus()); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
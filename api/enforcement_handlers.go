@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"procguard/internal/app"
+	"procguard/internal/data"
+)
+
+// handleGetEnforcementMode returns the current enforcement mode and the executables it has disabled on disk.
+func (s *Server) handleGetEnforcementMode(w http.ResponseWriter, r *http.Request) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	disabled, err := data.LoadDisabledExecutables()
+	if err != nil {
+		http.Error(w, "Failed to load disabled executables", http.StatusInternalServerError)
+		return
+	}
+
+	mode := cfg.EnforcementMode
+	if mode == "" {
+		mode = data.EnforcementKill
+	}
+
+	response := struct {
+		Mode     string                    `json:"mode"`
+		Disabled []data.DisabledExecutable `json:"disabled"`
+	}{
+		Mode:     mode,
+		Disabled: disabled,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleSetEnforcementMode switches between killing blocked apps and also disabling their executables on disk.
+// It expects a JSON request with a `mode` field. Switching back to "kill" restores every disabled executable.
+func (s *Server) handleSetEnforcementMode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Mode != data.EnforcementKill && req.Mode != data.EnforcementDisable {
+		http.Error(w, "Unknown enforcement mode", http.StatusBadRequest)
+		return
+	}
+
+	err := data.UpdateConfig(func(cfg *data.Config) error {
+		cfg.EnforcementMode = req.Mode
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Mode == data.EnforcementKill {
+		if err := app.RestoreAllExecutables(); err != nil {
+			s.Logger.Error("Failed to restore disabled executables", "err", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
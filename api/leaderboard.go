@@ -13,6 +13,8 @@ type AppLeaderboardItem struct {
 	Name  string `json:"name"`
 	Icon  string `json:"icon"`
 	Count int    `json:"count"`
+	// Duration is the total running time in seconds.
+	Duration int64 `json:"duration"`
 }
 
 // WebLeaderboardItem represents a single item in the web leaderboard.
@@ -22,6 +24,8 @@ type WebLeaderboardItem struct {
 	Title  string `json:"title"`
 	Icon   string `json:"icon"`
 	Count  int    `json:"count"`
+	// Duration is the estimated viewing time in seconds. It is only known when served from the daily rollups.
+	Duration int64 `json:"duration,omitempty"`
 }
 
 // handleGetAppLeaderboard retrieves the top 10 most used applications and returns them as a leaderboard.
@@ -30,39 +34,36 @@ func (s *Server) handleGetAppLeaderboard(w http.ResponseWriter, r *http.Request)
 	untilStr := r.URL.Query().Get("until")
 
 	leaderboard, err := s.getAppLeaderboard(sinceStr, untilStr)
+	if writeTimeRangeError(w, err) {
+		return
+	}
 	if err != nil {
-		s.Logger.Printf("Error getting app leaderboard: %v", err)
+		s.Logger.Error("Error getting app leaderboard", "err", err)
 		http.Error(w, "Failed to get app leaderboard", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(leaderboard); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // getAppLeaderboard retrieves the top 10 most used applications from the database.
 func (s *Server) getAppLeaderboard(since, until string) ([]AppLeaderboardItem, error) {
-	var sinceTime, untilTime time.Time
-	var err error
-
-	if since != "" {
-		sinceTime, err = data.ParseTime(since)
-		if err != nil {
-			return nil, err
-		}
+	sinceTime, untilTime, err := data.ParseTimeRange(since, until)
+	if err != nil {
+		return nil, err
 	}
 
-	if until != "" {
-		untilTime, err = data.ParseTime(until)
-		if err != nil {
-			return nil, err
-		}
+	// Whole days can be answered from the daily rollups without scanning the raw events.
+	if data.IsDayAligned(sinceTime, untilTime) {
+		return s.getAppLeaderboardFromRollups(sinceTime, untilTime)
 	}
 
-	q := "SELECT process_name, COUNT(*) as count FROM app_events WHERE 1=1"
-	args := make([]interface{}, 0)
+	// Sessions that are still running count up to now.
+	q := "SELECT process_name, COUNT(*) as count, SUM(COALESCE(end_time, ?) - start_time) as duration FROM app_events WHERE 1=1"
+	args := []interface{}{time.Now().Unix()}
 
 	if !sinceTime.IsZero() {
 		q += " AND start_time >= ?"
@@ -82,7 +83,7 @@ func (s *Server) getAppLeaderboard(since, until string) ([]AppLeaderboardItem, e
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			s.Logger.Printf("Failed to close rows: %v", err)
+			s.Logger.Error("Failed to close rows", "err", err)
 		}
 	}()
 
@@ -91,21 +92,11 @@ func (s *Server) getAppLeaderboard(since, until string) ([]AppLeaderboardItem, e
 	for rows.Next() {
 		var item AppLeaderboardItem
 		item.Rank = rank
-		if err := rows.Scan(&item.Name, &item.Count); err != nil {
+		if err := rows.Scan(&item.Name, &item.Count, &item.Duration); err != nil {
 			continue
 		}
 
-		// Enrich with icon and commercial name
-		var exePath string
-		row := s.db.QueryRow("SELECT exe_path FROM app_events WHERE process_name = ? AND exe_path IS NOT NULL ORDER BY start_time DESC LIMIT 1", item.Name)
-		if err := row.Scan(&exePath); err == nil {
-			commercialName, icon := s.getAppDetails(exePath)
-			if commercialName != "" {
-				item.Name = commercialName
-			}
-			item.Icon = icon
-		}
-
+		s.enrichAppLeaderboardItem(&item)
 		leaderboard = append(leaderboard, item)
 		rank++
 	}
@@ -113,53 +104,83 @@ func (s *Server) getAppLeaderboard(since, until string) ([]AppLeaderboardItem, e
 	return leaderboard, nil
 }
 
+// getAppLeaderboardFromRollups builds the application leaderboard from the daily rollups.
+// The range must start and end on day boundaries.
+func (s *Server) getAppLeaderboardFromRollups(since, until time.Time) ([]AppLeaderboardItem, error) {
+	sinceDay, untilDay := rollupDayRange(since, until)
+	totals, err := data.GetTopRollups(s.db, data.RollupKindApp, sinceDay, untilDay, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaderboard []AppLeaderboardItem
+	for i, t := range totals {
+		item := AppLeaderboardItem{Rank: i + 1, Name: t.Key, Count: int(t.Count), Duration: t.Duration}
+		s.enrichAppLeaderboardItem(&item)
+		leaderboard = append(leaderboard, item)
+	}
+	return leaderboard, nil
+}
+
+// enrichAppLeaderboardItem replaces the process name with the app's commercial name and adds its icon.
+func (s *Server) enrichAppLeaderboardItem(item *AppLeaderboardItem) {
+	exePath, err := data.GetLatestExePath(s.db, item.Name)
+	if err != nil || exePath == "" {
+		return
+	}
+	commercialName, icon := s.getAppDetails(exePath)
+	if commercialName != "" {
+		item.Name = commercialName
+	}
+	item.Icon = icon
+}
+
+// rollupDayRange converts a day-aligned time range into the inclusive and exclusive day keys used by the rollup tables.
+func rollupDayRange(since, until time.Time) (string, string) {
+	sinceDay := ""
+	if !since.IsZero() {
+		sinceDay = data.DayKey(since)
+	}
+	return sinceDay, data.DayKey(until)
+}
+
 // handleGetWebLeaderboard retrieves the top 10 most visited websites and returns them as a leaderboard.
 func (s *Server) handleGetWebLeaderboard(w http.ResponseWriter, r *http.Request) {
 	sinceStr := r.URL.Query().Get("since")
 	untilStr := r.URL.Query().Get("until")
 
 	leaderboard, err := s.getWebLeaderboard(sinceStr, untilStr)
+	if writeTimeRangeError(w, err) {
+		return
+	}
 	if err != nil {
-		s.Logger.Printf("Error getting web leaderboard: %v", err)
+		s.Logger.Error("Error getting web leaderboard", "err", err)
 		http.Error(w, "Failed to get web leaderboard", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(leaderboard); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // getWebLeaderboard retrieves the top 10 most visited websites from the database.
 func (s *Server) getWebLeaderboard(since, until string) ([]WebLeaderboardItem, error) {
-	var sinceTime, untilTime time.Time
-	var err error
-
-	if since != "" {
-		sinceTime, err = data.ParseTime(since)
-		if err != nil {
-			return nil, err
-		}
+	sinceTime, untilTime, err := data.ParseTimeRange(since, until)
+	if err != nil {
+		return nil, err
 	}
 
-	if until != "" {
-		untilTime, err = data.ParseTime(until)
-		if err != nil {
-			return nil, err
-		}
+	if data.IsDayAligned(sinceTime, untilTime) {
+		return s.getWebLeaderboardFromRollups(sinceTime, untilTime)
 	}
 
+	// Like the daily rollups, the leaderboard counts visits per host. URLs without one, such as file:// URLs, are left out.
 	q := `
-		SELECT
-			CASE
-				WHEN INSTR(SUBSTR(url, INSTR(url, '//') + 2), '/') > 0
-				THEN SUBSTR(url, INSTR(url, '//') + 2, INSTR(SUBSTR(url, INSTR(url, '//') + 2), '/') - 1)
-				ELSE SUBSTR(url, INSTR(url, '//') + 2)
-			END as domain,
-			COUNT(*) as count
+		SELECT host, COUNT(*) as count
 		FROM web_events
-		WHERE 1=1
+		WHERE host IS NOT NULL AND host != ''
 	`
 	args := make([]interface{}, 0)
 
@@ -173,7 +194,7 @@ func (s *Server) getWebLeaderboard(since, until string) ([]WebLeaderboardItem, e
 		args = append(args, untilTime.Unix())
 	}
 
-	q += " GROUP BY domain ORDER BY count DESC LIMIT 10"
+	q += " GROUP BY host ORDER BY count DESC LIMIT 10"
 
 	rows, err := s.db.Query(q, args...)
 	if err != nil {
@@ -181,7 +202,7 @@ func (s *Server) getWebLeaderboard(since, until string) ([]WebLeaderboardItem, e
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			s.Logger.Printf("Failed to close rows: %v", err)
+			s.Logger.Error("Failed to close rows", "err", err)
 		}
 	}()
 
@@ -194,15 +215,36 @@ func (s *Server) getWebLeaderboard(since, until string) ([]WebLeaderboardItem, e
 			continue
 		}
 
-		// Enrich with metadata
-		if meta, err := data.GetWebMetadata(s.db, item.Domain); err == nil && meta != nil {
-			item.Title = meta.Title
-			item.Icon = meta.IconURL
-		}
-
+		s.enrichWebLeaderboardItem(&item)
 		leaderboard = append(leaderboard, item)
 		rank++
 	}
 
 	return leaderboard, nil
 }
+
+// getWebLeaderboardFromRollups builds the web leaderboard from the daily rollups.
+// The range must start and end on day boundaries.
+func (s *Server) getWebLeaderboardFromRollups(since, until time.Time) ([]WebLeaderboardItem, error) {
+	sinceDay, untilDay := rollupDayRange(since, until)
+	totals, err := data.GetTopRollups(s.db, data.RollupKindWeb, sinceDay, untilDay, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaderboard []WebLeaderboardItem
+	for i, t := range totals {
+		item := WebLeaderboardItem{Rank: i + 1, Domain: t.Key, Count: int(t.Count), Duration: t.Duration}
+		s.enrichWebLeaderboardItem(&item)
+		leaderboard = append(leaderboard, item)
+	}
+	return leaderboard, nil
+}
+
+// enrichWebLeaderboardItem adds the cached title and icon of the domain.
+func (s *Server) enrichWebLeaderboardItem(item *WebLeaderboardItem) {
+	if meta, err := data.GetWebMetadata(s.db, item.Domain); err == nil && meta != nil {
+		item.Title = meta.Title
+		item.Icon = meta.IconURL
+	}
+}
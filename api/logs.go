@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"procguard/internal/data"
+)
+
+// handleGetLogs handles requests for the application's own log entries, newest first.
+// It accepts the following query parameters:
+// - level: the least severe level to return, one of debug, info, warn, error or fatal
+// - q: text that the message or fields of an entry must contain
+// - since: the start of the time range (e.g., "1 hour ago")
+// - until: the end of the time range (e.g., "now")
+// - limit: the page size (default data.DefaultPageSize, at most data.MaxPageSize)
+// - cursor: the next_cursor of the previous page
+func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
+	filter := data.LogFilter{
+		MinLevel: r.URL.Query().Get("level"),
+		Text:     r.URL.Query().Get("q"),
+		Since:    r.URL.Query().Get("since"),
+		Until:    r.URL.Query().Get("until"),
+	}
+	if filter.MinLevel != "" {
+		if _, err := data.ParseLogLevel(filter.MinLevel); err != nil {
+			http.Error(w, "Invalid level", http.StatusBadRequest)
+			return
+		}
+	}
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	logs, err := data.QueryLogs(s.db, filter, page)
+	if writeTimeRangeError(w, err) {
+		return
+	}
+	if errors.Is(err, data.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.Logger.Error("Error querying logs", "err", err)
+		http.Error(w, "Failed to query logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(logs); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"procguard/internal/data"
+	"time"
+)
+
+// handleGetDailyReport returns per-day usage statistics from the daily rollups.
+// It accepts the following query parameters:
+// - kind: "app", "web" or "category" (defaults to "app")
+// - since: the first day to include (e.g., "7 days ago"); empty means from the beginning
+// - until: the last day to include (e.g., "now"); empty means up to and including today
+// Both ends are widened to whole days, since rollups have a resolution of one day.
+func (s *Server) handleGetDailyReport(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = data.RollupKindApp
+	}
+	if kind != data.RollupKindApp && kind != data.RollupKindWeb && kind != data.RollupKindCategory {
+		http.Error(w, "Unknown report kind", http.StatusBadRequest)
+		return
+	}
+
+	sinceDay := ""
+	if since := r.URL.Query().Get("since"); since != "" {
+		sinceTime, err := data.ParseTime(since)
+		if err != nil {
+			http.Error(w, "Invalid 'since' time", http.StatusBadRequest)
+			return
+		}
+		sinceDay = data.DayKey(sinceTime)
+	}
+
+	untilTime := time.Now()
+	if until := r.URL.Query().Get("until"); until != "" {
+		var err error
+		untilTime, err = data.ParseTime(until)
+		if err != nil {
+			http.Error(w, "Invalid 'until' time", http.StatusBadRequest)
+			return
+		}
+	}
+	// The until day is exclusive in the rollup queries, so move to the start of the following day
+	// unless the range already ends exactly at midnight.
+	untilDayStart := data.StartOfDay(untilTime)
+	if !untilTime.Equal(untilDayStart) {
+		untilDayStart = untilDayStart.AddDate(0, 0, 1)
+	}
+
+	report, err := data.GetDailyRollups(s.db, kind, sinceDay, data.DayKey(untilDayStart))
+	if err != nil {
+		s.Logger.Error("Error getting daily report", "err", err)
+		http.Error(w, "Failed to get daily report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// csrfHeaderName is the header that carries the CSRF token of the session.
+const csrfHeaderName = "X-CSRF-Token"
+
+// allowedHosts returns the values of the Host header that address the server listening on addr:
+// the configured host and the loopback names, with the configured port. Any other Host means that the request
+// was sent to a name that only resolves to this machine by accident or by DNS rebinding.
+func allowedHosts(addr string) map[string]bool {
+	hosts := make(map[string]bool)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// The server can't listen on such an address either.
+		return hosts
+	}
+	names := []string{"127.0.0.1", "localhost", "::1"}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		names = append(names, host)
+	}
+
+	for _, name := range names {
+		hosts[strings.ToLower(net.JoinHostPort(name, port))] = true
+		if port == "80" {
+			// Browsers leave out the default port.
+			if strings.Contains(name, ":") {
+				name = "[" + name + "]"
+			}
+			hosts[strings.ToLower(name)] = true
+		}
+	}
+	return hosts
+}
+
+// isSafeMethod reports whether method only reads, so that a request made with it by another site can't change anything.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// requiresCSRFToken reports whether r is a state-changing request that an HTML form or a script on another site
+// could send without the browser asking the server first. That is every body other than JSON, including none.
+func requiresCSRFToken(r *http.Request) bool {
+	if isSafeMethod(r.Method) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err != nil || mediaType != "application/json"
+}
+
+// sameOrigin reports whether the origin of the URL in value, as sent in an Origin or Referer header,
+// is the origin that r was sent to.
+func sameOrigin(value string, r *http.Request) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" && strings.EqualFold(u.Host, r.Host)
+}
+
+// checkRequest returns why r must be rejected, or "" if it may be served.
+func (srv *Server) checkRequest(r *http.Request) string {
+	if !srv.allowedHosts[strings.ToLower(r.Host)] {
+		return "host not allowed"
+	}
+	if isSafeMethod(r.Method) {
+		return ""
+	}
+
+	// Browsers send Origin with every state-changing request. Referer is the fallback for those that don't.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !sameOrigin(origin, r) {
+			return "cross-origin request"
+		}
+	} else if referer := r.Header.Get("Referer"); referer != "" {
+		if !sameOrigin(referer, r) {
+			return "cross-origin referer"
+		}
+	} else {
+		return "missing origin"
+	}
+
+	if requiresCSRFToken(r) {
+		want := srv.CSRFToken(r)
+		got := r.Header.Get(csrfHeaderName)
+		if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			return "missing or invalid CSRF token"
+		}
+	}
+	return ""
+}
+
+// requestGuard is a middleware that rejects requests that weren't made by the GUI in a browser on this machine:
+// those addressed to another host, state-changing requests from another origin, and form posts without
+// the CSRF token of the session. Rejected requests, including those the routes refuse because of their method,
+// are logged.
+func (srv *Server) requestGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := srv.checkRequest(r); reason != "" {
+			srv.logRejectedRequest(r, reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(&methodRejectionLogger{ResponseWriter: w, srv: srv, r: r}, r)
+	})
+}
+
+// logRejectedRequest logs that r was rejected for reason.
+func (srv *Server) logRejectedRequest(r *http.Request, reason string) {
+	srv.Logger.Warn("Rejected request", "reason", reason, "method", r.Method, "path", r.URL.Path,
+		"host", r.Host, "origin", r.Header.Get("Origin"), "referer", r.Header.Get("Referer"), "remote", r.RemoteAddr)
+}
+
+// methodRejectionLogger logs the requests that the router answers with 405 Method Not Allowed,
+// which happens when a route doesn't accept the method of the request.
+type methodRejectionLogger struct {
+	http.ResponseWriter
+	srv *Server
+	r   *http.Request
+}
+
+// WriteHeader logs the request if it is rejected because of its method.
+func (l *methodRejectionLogger) WriteHeader(statusCode int) {
+	if statusCode == http.StatusMethodNotAllowed {
+		l.srv.logRejectedRequest(l.r, "method not allowed")
+	}
+	l.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (l *methodRejectionLogger) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
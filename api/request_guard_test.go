@@ -0,0 +1,204 @@
+package api
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"procguard/internal/auth"
+)
+
+func TestAllowedHosts(t *testing.T) {
+	tests := []struct {
+		addr string
+		want []string
+	}{
+		{
+			addr: "127.0.0.1:58141",
+			want: []string{"127.0.0.1:58141", "[::1]:58141", "localhost:58141"},
+		},
+		{
+			addr: "0.0.0.0:58141",
+			want: []string{"127.0.0.1:58141", "[::1]:58141", "localhost:58141"},
+		},
+		{
+			addr: "[::]:58141",
+			want: []string{"127.0.0.1:58141", "[::1]:58141", "localhost:58141"},
+		},
+		{
+			addr: "ProcGuard.Home:8080",
+			want: []string{"127.0.0.1:8080", "[::1]:8080", "localhost:8080", "procguard.home:8080"},
+		},
+		{
+			addr: "192.168.1.10:58141",
+			want: []string{"127.0.0.1:58141", "192.168.1.10:58141", "[::1]:58141", "localhost:58141"},
+		},
+		{
+			addr: ":80",
+			want: []string{"127.0.0.1", "127.0.0.1:80", "[::1]", "[::1]:80", "localhost", "localhost:80"},
+		},
+		{addr: "127.0.0.1", want: []string{}},
+		{addr: "", want: []string{}},
+	}
+	for _, tt := range tests {
+		got := slices.Sorted(maps.Keys(allowedHosts(tt.addr)))
+		if got == nil {
+			got = []string{}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("allowedHosts(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// csrfHeader is the X-CSRF-Token header that a request of TestCheckRequest sends.
+type csrfHeader int
+
+const (
+	noCSRF csrfHeader = iota
+	validCSRF
+	wrongCSRF
+)
+
+func TestCheckRequest(t *testing.T) {
+	srv := &Server{
+		sessions:     auth.NewSessionStore(sessionIdleTimeout, sessionMaxLifetime),
+		allowedHosts: allowedHosts("127.0.0.1:58141"),
+	}
+	session, err := srv.sessions.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf, _ := srv.sessions.CSRFToken(session)
+
+	const origin = "http://127.0.0.1:58141"
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		contentType string
+		origin      string
+		referer     string
+		csrf        csrfHeader
+		// noSession leaves out the session cookie.
+		noSession bool
+		want      string
+	}{
+		{name: "GET", method: "GET", host: "127.0.0.1:58141"},
+		{name: "GET by a loopback name", method: "GET", host: "LOCALHOST:58141"},
+		{name: "GET through IPv6 loopback", method: "GET", host: "[::1]:58141"},
+		{name: "GET from another site needs no origin", method: "GET", host: "127.0.0.1:58141", origin: "http://evil.example"},
+		{name: "HEAD", method: "HEAD", host: "127.0.0.1:58141"},
+		{name: "DNS rebinding", method: "GET", host: "evil.example:58141", want: "host not allowed"},
+		{name: "another port", method: "GET", host: "127.0.0.1:8080", want: "host not allowed"},
+		{name: "no port", method: "GET", host: "127.0.0.1", want: "host not allowed"},
+		{name: "no host", method: "GET", host: "", want: "host not allowed"},
+		{
+			name: "rebinding POST with a matching origin", method: "POST", host: "evil.example:58141",
+			contentType: "application/json", origin: "http://evil.example:58141", want: "host not allowed",
+		},
+
+		{name: "JSON POST", method: "POST", host: "127.0.0.1:58141", contentType: "application/json", origin: origin},
+		{
+			name: "JSON POST with parameters", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json; charset=utf-8", origin: origin,
+		},
+		{
+			name: "JSON POST with only a referer", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", referer: origin + "/settings",
+		},
+		{
+			name: "JSON POST from another site", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", origin: "http://evil.example", want: "cross-origin request",
+		},
+		{
+			name: "JSON POST from another port", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", origin: "http://127.0.0.1:8080", want: "cross-origin request",
+		},
+		{
+			name: "JSON POST from another scheme", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", origin: "https://127.0.0.1:58141", want: "cross-origin request",
+		},
+		{
+			name: "JSON POST from an opaque origin", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", origin: "null", want: "cross-origin request",
+		},
+		{
+			name: "a matching referer doesn't excuse a foreign origin", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", origin: "http://evil.example", referer: origin + "/", want: "cross-origin request",
+		},
+		{
+			name: "JSON POST with a foreign referer", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", referer: "http://evil.example/page", want: "cross-origin referer",
+		},
+		{
+			name: "JSON POST with neither origin nor referer", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json", want: "missing origin",
+		},
+
+		{
+			name: "form POST with the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			contentType: "multipart/form-data; boundary=x", origin: origin, csrf: validCSRF,
+		},
+		{
+			name: "POST without a body with the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			origin: origin, csrf: validCSRF,
+		},
+		{
+			name: "form POST without the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/x-www-form-urlencoded", origin: origin, want: "missing or invalid CSRF token",
+		},
+		{
+			name: "form POST with a wrong CSRF token", method: "POST", host: "127.0.0.1:58141",
+			contentType: "multipart/form-data; boundary=x", origin: origin, csrf: wrongCSRF, want: "missing or invalid CSRF token",
+		},
+		{
+			name: "form POST with the CSRF token but no session", method: "POST", host: "127.0.0.1:58141",
+			contentType: "multipart/form-data; boundary=x", origin: origin, csrf: validCSRF, noSession: true,
+			want: "missing or invalid CSRF token",
+		},
+		{
+			name: "text POST without the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			contentType: "text/plain", origin: origin, want: "missing or invalid CSRF token",
+		},
+		{
+			name: "POST without a body or the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			origin: origin, want: "missing or invalid CSRF token",
+		},
+		{
+			name: "malformed content type without the CSRF token", method: "POST", host: "127.0.0.1:58141",
+			contentType: "application/json;;", origin: origin, want: "missing or invalid CSRF token",
+		},
+		{
+			name: "CSRF token from another site", method: "POST", host: "127.0.0.1:58141",
+			contentType: "multipart/form-data; boundary=x", origin: "http://evil.example", csrf: validCSRF,
+			want: "cross-origin request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/test", nil)
+			r.Host = tt.host
+			for name, value := range map[string]string{"Content-Type": tt.contentType, "Origin": tt.origin, "Referer": tt.referer} {
+				if value != "" {
+					r.Header.Set(name, value)
+				}
+			}
+			switch tt.csrf {
+			case validCSRF:
+				r.Header.Set(csrfHeaderName, csrf)
+			case wrongCSRF:
+				r.Header.Set(csrfHeaderName, csrf+"x")
+			}
+			if !tt.noSession {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+			}
+
+			if got := srv.checkRequest(r); got != tt.want {
+				t.Errorf("checkRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
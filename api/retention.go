@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"procguard/internal/data"
+)
+
+// handleGetStorageReport returns the disk usage of each table in the database together with the retention policy.
+func (s *Server) handleGetStorageReport(w http.ResponseWriter, r *http.Request) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	report, err := data.GetStorageReport(s.db, cfg.Retention)
+	if err != nil {
+		s.Logger.Error("Error getting storage report", "err", err)
+		http.Error(w, "Failed to get storage report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleGetWriterStats returns the queue depth and counters of the database writer.
+func (s *Server) handleGetWriterStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data.GetWriterStats()); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleGetRetention returns the current retention policy.
+func (s *Server) handleGetRetention(w http.ResponseWriter, r *http.Request) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cfg.Retention); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleSetRetention updates the retention policy.
+// It expects a JSON request with the `raw_event_days`, `log_days`, `rollup_days` and `tamper_event_days` fields,
+// where 0 keeps data forever.
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	var req data.RetentionConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.RawEventDays < 0 || req.LogDays < 0 || req.RollupDays < 0 || req.TamperEventDays < 0 {
+		http.Error(w, "Retention periods cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	err := data.UpdateConfig(func(cfg *data.Config) error {
+		cfg.Retention = req
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -2,30 +2,106 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"procguard/internal/data"
-	"strings"
+	"strconv"
 )
 
+// legacyResultsVersion is the value of the v parameter that selects the array results returned before pagination.
+const legacyResultsVersion = "1"
+
 // handleSearch handles searches for application events.
 // It accepts the following query parameters:
-// - q: the search query string
+// - query: a structured query such as "name:chrome after:yesterday duration>10m" (see data.ParseAppQuery)
+// - q: a plain full-text query, used when query is not given; results are ranked by relevance and carry a highlighted snippet
 // - since: the start of the time range (e.g., "1 hour ago")
 // - until: the end of the time range (e.g., "now")
+// - limit: the page size (default data.DefaultPageSize, at most data.MaxPageSize)
+// - cursor: the next_cursor of the previous page
+// - v: "1" for the legacy format, an array of string arrays with every match and no pagination
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	query := strings.ToLower(r.URL.Query().Get("q"))
+	filter, ok := parseEventQuery(w, r, data.ParseAppQuery)
+	if !ok {
+		return
+	}
 	sinceStr := r.URL.Query().Get("since")
 	untilStr := r.URL.Query().Get("until")
 
-	results, err := data.SearchAppEvents(s.db, query, sinceStr, untilStr)
+	var results interface{}
+	var err error
+	if r.URL.Query().Get("v") == legacyResultsVersion {
+		results, err = data.SearchAppEvents(s.db, filter, sinceStr, untilStr)
+	} else {
+		page, ok := parsePageRequest(w, r)
+		if !ok {
+			return
+		}
+		results, err = data.QueryAppEvents(s.db, filter, sinceStr, untilStr, page)
+	}
+	if writeTimeRangeError(w, err) {
+		return
+	}
+	if errors.Is(err, data.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, data.ErrEncryptionLocked) {
+		http.Error(w, "Encrypted data is locked; log in again to unlock it", http.StatusLocked)
+		return
+	}
 	if err != nil {
-		s.Logger.Printf("Error searching logs: %v", err)
+		s.Logger.Error("Error searching logs", "err", err)
 		http.Error(w, "Failed to search logs", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(results); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// parseEventQuery builds the filter of an activity endpoint from its query parameters: a structured query in the
+// query parameter, compiled with parse, or else plain full-text search in q.
+// Syntax errors are answered with 400 Bad Request and their position, in which case it returns false.
+func parseEventQuery(w http.ResponseWriter, r *http.Request, parse func(string) (*data.EventQuery, error)) (*data.EventQuery, bool) {
+	structured := r.URL.Query().Get("query")
+	if structured == "" {
+		return data.TextQuery(r.URL.Query().Get("q")), true
+	}
+
+	filter, err := parse(structured)
+	if err != nil {
+		// The error is a *data.QueryError, whose message gives the position of the mistake.
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return filter, true
+}
+
+// writeTimeRangeError answers a request whose since or until parameter couldn't be parsed with 400 Bad Request
+// and the reason, and reports whether err was such an error.
+func writeTimeRangeError(w http.ResponseWriter, err error) bool {
+	var rangeErr *data.TimeRangeError
+	if !errors.As(err, &rangeErr) {
+		return false
+	}
+	http.Error(w, "Invalid time range: "+rangeErr.Error(), http.StatusBadRequest)
+	return true
+}
+
+// parsePageRequest reads the limit and cursor parameters of an activity endpoint.
+// An invalid limit is answered with 400 Bad Request, in which case it returns false.
+func parsePageRequest(w http.ResponseWriter, r *http.Request) (data.PageRequest, bool) {
+	page := data.PageRequest{Cursor: r.URL.Query().Get("cursor")}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return data.PageRequest{}, false
+		}
+		page.Limit = limit
 	}
+	return page, true
 }
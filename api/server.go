@@ -1,41 +1,65 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"procguard/internal/app"
+	"procguard/internal/auth"
 	"procguard/internal/data"
 	"procguard/internal/web"
 	"strings"
 	"sync"
+	"time"
+)
+
+// serverShutdownTimeout bounds how long a server waits for in-flight requests when shutting down.
+const serverShutdownTimeout = 5 * time.Second
 
-	"github.com/bi-zone/go-fileversion"
+const (
+	// sessionIdleTimeout logs a client out after it hasn't made a request for that long.
+	sessionIdleTimeout = 30 * time.Minute
+	// sessionMaxLifetime logs a client out that long after it logged in, however active it is.
+	sessionMaxLifetime = 12 * time.Hour
+	// sessionCheckInterval is how often the server looks for the last session to have expired, to lock the encrypted data.
+	sessionCheckInterval = time.Minute
 )
 
 // Server holds the dependencies for the API server, such as the database connection and the logger.
 type Server struct {
-	Logger          data.Logger
-	IsAuthenticated bool
-	Mu              sync.Mutex
-	db              *sql.DB
-	iconCache       map[string]string
-	iconCacheMu     sync.Mutex
+	Logger data.Logger
+	// sessions holds the sessions of the logged-in clients, which are identified by a cookie.
+	sessions *auth.SessionStore
+	// unlockMu keeps a login from unlocking the encrypted data while the end of the last session is locking it.
+	unlockMu sync.Mutex
+	// allowedHosts holds the values of the Host header that the server answers to: see allowedHosts.
+	allowedHosts map[string]bool
+	db           *sql.DB
+	iconCache    map[string]string
+	iconCacheMu  sync.Mutex
+	// shutdown cancels the application's root context, for example when the user uninstalls.
+	shutdown context.CancelCauseFunc
 }
 
 // NewServer creates a new Server with its dependencies.
 func NewServer(db *sql.DB) *Server {
 	return &Server{
 		Logger:    data.GetLogger(),
+		sessions:  auth.NewSessionStore(sessionIdleTimeout, sessionMaxLifetime),
 		db:        db,
 		iconCache: make(map[string]string),
 	}
 }
 
-// StartWebServer configures and starts the web server.
-func StartWebServer(addr string, registerExtraRoutes func(srv *Server, r *http.ServeMux), db *sql.DB) {
+// StartWebServer configures and runs the web server until ctx is cancelled, then shuts it down gracefully.
+// Handlers use shutdown to stop the whole application.
+func StartWebServer(ctx context.Context, addr string, registerExtraRoutes func(srv *Server, r *http.ServeMux), db *sql.DB, shutdown context.CancelCauseFunc) {
 	srv := NewServer(db)
+	srv.shutdown = shutdown
+	srv.allowedHosts = allowedHosts(addr)
 
 	r := http.NewServeMux()
 
@@ -43,19 +67,40 @@ func StartWebServer(addr string, registerExtraRoutes func(srv *Server, r *http.S
 	if registerExtraRoutes != nil {
 		registerExtraRoutes(srv, r)
 	}
+	go srv.lockWhenSessionsExpire(ctx)
 
-	if err := http.ListenAndServe(addr, srv.authMiddleware(r)); err != nil {
+	if err := ServeUntilDone(ctx, &http.Server{Addr: addr, Handler: srv.requestGuard(srv.authMiddleware(r))}); err != nil {
 		srv.Logger.Fatalf("Error running server: %v", err)
 	}
 }
 
+// ServeUntilDone runs an HTTP server until ctx is cancelled, then waits up to serverShutdownTimeout
+// for in-flight requests to finish.
+func ServeUntilDone(ctx context.Context, server *http.Server) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
 // authMiddleware is a middleware that protects all routes except for a predefined list of public routes.
 // TODO: This list of public routes is hardcoded and could be made more maintainable.
 func (srv *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		srv.Mu.Lock()
-		localIsAuthenticated := srv.IsAuthenticated
-		srv.Mu.Unlock()
+		localIsAuthenticated := srv.IsAuthenticated(r)
 
 		publicRoutes := []string{"/login", "/api/has-password", "/api/login", "/api/set-password", "/api/blocklist"}
 		isPublic := false
@@ -77,17 +122,7 @@ func (srv *Server) authMiddleware(next http.Handler) http.Handler {
 // getAppDetails retrieves details for a given application, such as its commercial name and icon.
 func (srv *Server) getAppDetails(exePath string) (string, string) {
 	// Get the commercial name from the executable's file version information.
-	info, err := fileversion.New(exePath)
-	var commercialName string
-	if err == nil {
-		commercialName = info.FileDescription()
-		if commercialName == "" {
-			commercialName = info.ProductName()
-		}
-		if commercialName == "" {
-			commercialName = info.OriginalFilename()
-		}
-	}
+	commercialName := fileCommercialName(exePath)
 
 	// If a commercial name could not be found, use the filename without the extension as a fallback.
 	if commercialName == "" {
@@ -102,10 +137,10 @@ func (srv *Server) getAppDetails(exePath string) (string, string) {
 	}
 
 	// Get the application's icon as a base64-encoded string.
-	icon, err = app.GetAppIconAsBase64(exePath)
+	icon, err := app.GetAppIconAsBase64(exePath)
 	if err != nil {
 		// Log the error but don't fail the request, as the icon is not critical.
-		srv.Logger.Printf("Failed to get icon for %s: %v", exePath, err)
+		srv.Logger.Warn("Failed to get icon", "path", exePath, "err", err)
 	}
 
 	srv.iconCacheMu.Lock()
@@ -135,7 +170,7 @@ func (srv *Server) handleAppDetails(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		srv.Logger.Printf("Error encoding response: %v", err)
+		srv.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -160,7 +195,21 @@ func (srv *Server) handleWebDetails(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(meta); err != nil {
-		srv.Logger.Printf("Error encoding response: %v", err)
+		srv.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleSchemaInfo reports the database schema version and the newest version supported by this build.
+func (srv *Server) handleSchemaInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := data.GetSchemaInfo(srv.db)
+	if err != nil {
+		http.Error(w, "Failed to get schema version", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		srv.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -182,44 +231,65 @@ func (srv *Server) handleRegisterExtension(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusOK)
 }
 
-// registerRoutes registers all the API routes for the server.
+// registerRoutes registers all the API routes for the server. Each route only accepts the method in its pattern
+// (GET also allows HEAD); requests with any other method are answered with 405 Method Not Allowed.
 func (srv *Server) registerRoutes(r *http.ServeMux) {
 	// Handlers
-	r.HandleFunc("/logout", srv.handleLogout)
+	r.HandleFunc("POST /logout", srv.handleLogout)
 
 	// API routes
-	r.HandleFunc("/api/has-password", srv.handleHasPassword)
-	r.HandleFunc("/api/login", srv.handleLogin)
-	r.HandleFunc("/api/set-password", srv.handleSetPassword)
-	r.HandleFunc("/api/search", srv.handleSearch)
-	r.HandleFunc("/api/block", srv.handleBlockApps)
-	r.HandleFunc("/api/blocklist", srv.handleGetAppBlocklist)
-	r.HandleFunc("/api/blocklist/clear", srv.handleClearAppBlocklist)
-	r.HandleFunc("/api/blocklist/save", srv.handleSaveAppBlocklist)
-	r.HandleFunc("/api/blocklist/load", srv.handleLoadAppBlocklist)
-	r.HandleFunc("/api/unblock", srv.handleUnblockApps)
-	r.HandleFunc("/api/uninstall", srv.handleUninstall)
+	r.HandleFunc("GET /api/has-password", srv.handleHasPassword)
+	r.HandleFunc("POST /api/login", srv.handleLogin)
+	r.HandleFunc("POST /api/set-password", srv.handleSetPassword)
+	r.HandleFunc("POST /api/change-password", srv.handleChangePassword)
+	r.HandleFunc("GET /api/search", srv.handleSearch)
+	r.HandleFunc("POST /api/block", srv.handleBlockApps)
+	r.HandleFunc("GET /api/blocklist", srv.handleGetAppBlocklist)
+	r.HandleFunc("POST /api/blocklist/clear", srv.handleClearAppBlocklist)
+	r.HandleFunc("GET /api/blocklist/save", srv.handleSaveAppBlocklist)
+	r.HandleFunc("POST /api/blocklist/load", srv.handleLoadAppBlocklist)
+	r.HandleFunc("POST /api/unblock", srv.handleUnblockApps)
+	r.HandleFunc("POST /api/uninstall", srv.handleUninstall)
+	r.HandleFunc("GET /api/backup", srv.handleDownloadBackup)
+	r.HandleFunc("POST /api/restore", srv.handleRestoreBackup)
 
 	// Leaderboard API routes
-	r.HandleFunc("/api/leaderboard/apps", srv.handleGetAppLeaderboard)
-	r.HandleFunc("/api/leaderboard/web", srv.handleGetWebLeaderboard)
+	r.HandleFunc("GET /api/leaderboard/apps", srv.handleGetAppLeaderboard)
+	r.HandleFunc("GET /api/leaderboard/web", srv.handleGetWebLeaderboard)
+	r.HandleFunc("GET /api/report/daily", srv.handleGetDailyReport)
 
 	// Web Blocklist API routes
-	r.HandleFunc("/api/web-blocklist", srv.handleGetWebBlocklist)
-	r.HandleFunc("/api/web-blocklist/add", srv.handleAddWebBlocklist)
-	r.HandleFunc("//api/web-blocklist/remove", srv.handleRemoveWebBlocklist)
-	r.HandleFunc("/api/web-blocklist/clear", srv.handleClearWebBlocklist)
-	r.HandleFunc("/api/web-blocklist/save", srv.handleSaveWebBlocklist)
-	r.HandleFunc("/api/web-blocklist/load", srv.handleLoadWebBlocklist)
+	r.HandleFunc("GET /api/web-blocklist", srv.handleGetWebBlocklist)
+	r.HandleFunc("POST /api/web-blocklist/add", srv.handleAddWebBlocklist)
+	r.HandleFunc("POST /api/web-blocklist/remove", srv.handleRemoveWebBlocklist)
+	r.HandleFunc("POST /api/web-blocklist/clear", srv.handleClearWebBlocklist)
+	r.HandleFunc("GET /api/web-blocklist/save", srv.handleSaveWebBlocklist)
+	r.HandleFunc("POST /api/web-blocklist/load", srv.handleLoadWebBlocklist)
 
 	// Web Log API routes
-	r.HandleFunc("/api/web-logs", srv.handleGetWebLogs)
+	r.HandleFunc("GET /api/web-logs", srv.handleGetWebLogs)
 
 	// Settings API routes
-	r.HandleFunc("/api/settings/autostart/status", srv.handleGetAutostartStatus)
-	r.HandleFunc("/api/settings/autostart/enable", srv.handleEnableAutostart)
-	r.HandleFunc("/api/settings/autostart/disable", srv.handleDisableAutostart)
-	r.HandleFunc("/api/app-details", srv.handleAppDetails)
-	r.HandleFunc("/api/web-details", srv.handleWebDetails)
-	r.HandleFunc("/api/register-extension", srv.handleRegisterExtension)
+	r.HandleFunc("GET /api/settings/autostart/status", srv.handleGetAutostartStatus)
+	r.HandleFunc("POST /api/settings/autostart/enable", srv.handleEnableAutostart)
+	r.HandleFunc("POST /api/settings/autostart/disable", srv.handleDisableAutostart)
+	r.HandleFunc("GET /api/settings/enforcement", srv.handleGetEnforcementMode)
+	r.HandleFunc("POST /api/settings/enforcement/set", srv.handleSetEnforcementMode)
+	r.HandleFunc("GET /api/settings/retention", srv.handleGetRetention)
+	r.HandleFunc("POST /api/settings/retention/set", srv.handleSetRetention)
+	r.HandleFunc("GET /api/settings/timezone", srv.handleGetTimezone)
+	r.HandleFunc("POST /api/settings/timezone/set", srv.handleSetTimezone)
+	r.HandleFunc("GET /api/settings/backup", srv.handleGetBackupSettings)
+	r.HandleFunc("POST /api/settings/backup/set", srv.handleSetBackupSettings)
+	r.HandleFunc("GET /api/settings/encryption", srv.handleGetEncryptionStatus)
+	r.HandleFunc("POST /api/settings/encryption/enable", srv.handleEnableEncryption)
+	r.HandleFunc("POST /api/settings/encryption/disable", srv.handleDisableEncryption)
+	r.HandleFunc("GET /api/storage", srv.handleGetStorageReport)
+	r.HandleFunc("GET /api/storage/writer", srv.handleGetWriterStats)
+	r.HandleFunc("GET /api/app-details", srv.handleAppDetails)
+	r.HandleFunc("GET /api/web-details", srv.handleWebDetails)
+	r.HandleFunc("POST /api/register-extension", srv.handleRegisterExtension)
+	r.HandleFunc("GET /api/schema", srv.handleSchemaInfo)
+	r.HandleFunc("GET /api/logs", srv.handleGetLogs)
+	r.HandleFunc("GET /api/tamper-events", srv.handleGetTamperEvents)
 }
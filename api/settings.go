@@ -1,5 +1,3 @@
-//go:build windows
-
 package api
 
 import (
@@ -19,7 +17,7 @@ func (s *Server) handleGetAutostartStatus(w http.ResponseWriter, r *http.Request
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"enabled": cfg.AutostartEnabled}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"procguard/internal/data"
+)
+
+// handleGetTamperEvents handles requests for the recorded changes of the policy that were made outside of ProcGuard,
+// newest first. It accepts the following query parameters:
+// - limit: the page size (default data.DefaultPageSize, at most data.MaxPageSize)
+// - cursor: the next_cursor of the previous page
+func (s *Server) handleGetTamperEvents(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := data.QueryTamperEvents(s.db, page)
+	if errors.Is(err, data.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.Logger.Error("Error querying tamper events", "err", err)
+		http.Error(w, "Failed to query tamper events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"procguard/internal/data"
+	"time"
+)
+
+// handleGetTimezone returns the configured display time zone, empty for the system's, and the current time in it.
+func (s *Server) handleGetTimezone(w http.ResponseWriter, r *http.Request) {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Timezone string    `json:"timezone"`
+		Now      time.Time `json:"now"`
+	}{
+		Timezone: cfg.Timezone,
+		Now:      time.Now().In(data.DisplayLocation()).Truncate(time.Second),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
+
+// handleSetTimezone changes the time zone that times are shown and days are counted in.
+// It expects a JSON request with a `timezone` field holding an IANA name such as "Europe/Berlin",
+// or an empty string for the system's time zone. The daily rollups are recounted in the new time zone.
+func (s *Server) handleSetTimezone(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if _, err := data.LoadTimezone(req.Timezone); err != nil {
+		http.Error(w, "Unknown time zone", http.StatusBadRequest)
+		return
+	}
+
+	err := data.UpdateConfig(func(cfg *data.Config) error {
+		cfg.Timezone = req.Timezone
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+	if err := data.SetDisplayTimezone(req.Timezone); err != nil {
+		http.Error(w, "Unknown time zone", http.StatusBadRequest)
+		return
+	}
+	if err := data.RecountRollups(r.Context()); err != nil {
+		s.Logger.Error("Failed to recount the daily rollups", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
+		s.Logger.Error("Error encoding response", "err", err)
+	}
+}
@@ -2,29 +2,26 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"procguard/internal/app"
 	"procguard/internal/auth"
 	"procguard/internal/daemon"
 	"procguard/internal/data"
-	"runtime"
+	"procguard/internal/web"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/shirou/gopsutil/v3/process"
-	"golang.org/x/sys/windows/registry"
 )
 
-const appName = "ProcGuard"
-const hostName = "com.nixuris.procguard"
+// ErrUninstall is the cause with which the root context is cancelled when the user uninstalls ProcGuard.
+var ErrUninstall = errors.New("uninstall requested")
 
 // handleUninstall handles the uninstallation of the application.
-// It performs a series of cleanup tasks in a separate goroutine and then initiates a self-deletion process.
+// After checking the password it triggers a graceful shutdown; FinishUninstall does the cleanup once it is done.
 func (s *Server) handleUninstall(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Password string `json:"password"`
@@ -45,91 +42,47 @@ func (s *Server) handleUninstall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		// Close the logger and database to release file handles before deletion.
-		s.Logger.Close()
-		if err := s.db.Close(); err != nil {
-			// We can't use the logger here, so just print to stderr.
-			fmt.Fprintf(os.Stderr, "Failed to close database: %v\n", err)
-		}
-
-		// Terminate any other running ProcGuard processes.
-		killOtherProcGuardProcesses(s.Logger)
-
-		// Unblock any files that were blocked by the application.
-		if err := unblockAll(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to unblock all files: %v\n", err)
-		}
-
-		// Perform other cleanup tasks.
-		if err := daemon.RemoveAutostart(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to remove autostart: %v\n", err)
-		}
-		if err := removeNativeHost(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to remove native host: %v\n", err)
-		}
-
-		// Initiate the self-deletion process.
-		if err := selfDelete(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to initiate self-deletion: %v\n", err)
-		}
-
-		// Exit the application to allow the self-deletion to complete.
-		os.Exit(0)
-	}()
-
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		// The logger is closed, so just print to stderr.
-		fmt.Fprintf(os.Stderr, "Error encoding response: %v\n", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
-}
 
-// selfDelete creates and executes a batch script that deletes the application files after the main process has exited.
-// This is a common technique for applications on Windows to perform self-uninstallation.
-func selfDelete() error {
-	if runtime.GOOS != "windows" {
-		return fmt.Errorf("self-deletion is currently implemented only for Windows")
+	// Shut down the application in order. The files are removed by FinishUninstall once the database is closed.
+	s.Logger.Info("Uninstall requested, shutting down")
+	if s.shutdown != nil {
+		s.shutdown(ErrUninstall)
 	}
+}
 
-	localAppData := os.Getenv("LOCALAPPDATA")
-	if localAppData == "" {
-		return fmt.Errorf("could not find LOCALAPPDATA directory")
-	}
-	appDataDir := filepath.Join(localAppData, appName)
-
-	// Create a temporary batch file in the system's temp directory.
-	tempDir := os.TempDir()
-	batchFileName := fmt.Sprintf("delete_procguard_%d.bat", time.Now().UnixNano())
-	batchFilePath := filepath.Join(tempDir, batchFileName)
-
-	// The batch script waits for a moment to ensure the main process has exited,
-	// then deletes the application's data directory and finally deletes itself.
-	batchContent := fmt.Sprintf(`
-@echo off
-timeout /t 2 /nobreak > nul
-rmdir /s /q "%s"
-del "%s"
-`, appDataDir, batchFilePath)
-
-	err := os.WriteFile(batchFilePath, []byte(batchContent), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write batch file: %w", err)
+// FinishUninstall removes everything ProcGuard installed and schedules deletion of its files.
+// It must be called after the application has shut down and released its file handles,
+// when the root context was cancelled with ErrUninstall.
+func FinishUninstall(logger data.Logger) {
+	// Terminate any other running ProcGuard processes.
+	killOtherProcGuardProcesses(logger)
+
+	// Unblock any files that were blocked by the application.
+	if err := unblockAll(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unblock all files: %v\n", err)
 	}
 
-	// Execute the batch file in a new, detached process so it can run independently of the main application.
-	cmd := exec.Command("cmd.exe", "/C", batchFilePath)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow:    true,
-		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
+	// Unlock the policy files so the data directory can be deleted.
+	if err := data.UnlockPolicyFiles(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unlock the policy files: %v\n", err)
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return fmt.Errorf("failed to start batch process: %w", err)
+	// Perform other cleanup tasks.
+	if err := daemon.RemoveAutostart(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove autostart: %v\n", err)
+	}
+	if err := web.RemoveNativeHost(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove native host: %v\n", err)
 	}
 
-	return nil
+	// Initiate the self-deletion process, which waits for this process to exit.
+	if err := selfDelete(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initiate self-deletion: %v\n", err)
+	}
 }
 
 // killOtherProcGuardProcesses finds and terminates any other running ProcGuard processes.
@@ -152,49 +105,16 @@ func killOtherProcGuardProcesses(logger data.Logger) {
 
 		if strings.HasPrefix(strings.ToLower(name), "procguard") {
 			if err := p.Kill(); err != nil {
-				logger.Printf("Failed to kill process %s: %v", name, err)
+				logger.Error("Failed to kill process", "name", name, "err", err)
 			}
 		}
 	}
 }
 
-// unblockAll restores the original names of any files that were blocked by the application.
+// unblockAll restores every executable that was disabled on disk by the application.
 func unblockAll() error {
-	list, err := data.LoadAppBlocklist()
-	if err != nil {
-		return fmt.Errorf("could not load blocklist: %w", err)
+	if err := app.RestoreAllExecutables(); err != nil {
+		return fmt.Errorf("could not restore disabled executables: %w", err)
 	}
-
-	for _, name := range list {
-		if strings.HasSuffix(name, ".blocked") {
-			newName := strings.TrimSuffix(name, ".blocked")
-			if err := os.Rename(name, newName); err != nil {
-				// Log the error but continue trying to unblock other files.
-				data.GetLogger().Printf("Failed to unblock file %s: %v", name, err)
-			}
-		}
-	}
-
-	return nil
-}
-
-// removeNativeHost removes the native messaging host configuration from the system.
-func removeNativeHost() error {
-	// Delete the registry key for the native messaging host.
-	keyPath := `SOFTWARE\Google\Chrome\NativeMessagingHosts\` + hostName
-	if err := registry.DeleteKey(registry.CURRENT_USER, keyPath); err != nil && err != registry.ErrNotExist {
-		return err
-	}
-
-	// Delete the manifest file.
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return err
-	}
-	manifestPath := filepath.Join(cacheDir, "procguard", "procguard.json")
-	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
 	return nil
 }
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package api
+
+import "fmt"
+
+// selfDelete is currently implemented only for Windows.
+func selfDelete() error {
+	return fmt.Errorf("self-deletion is currently implemented only for Windows")
+}
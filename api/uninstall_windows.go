@@ -0,0 +1,56 @@
+//go:build windows
+
+package api
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"procguard/internal/data"
+	"syscall"
+	"time"
+)
+
+// selfDelete creates and executes a batch script that deletes the application files after the main process has exited.
+// This is a common technique for applications on Windows to perform self-uninstallation.
+// The data directory is the one this instance was started with, which may have been moved by flag, environment or config file.
+func selfDelete() error {
+	appDataDir := data.Runtime().DataDir
+	if appDataDir == "" {
+		return fmt.Errorf("could not determine the data directory")
+	}
+
+	// Create a temporary batch file in the system's temp directory.
+	tempDir := os.TempDir()
+	batchFileName := fmt.Sprintf("delete_procguard_%d.bat", time.Now().UnixNano())
+	batchFilePath := filepath.Join(tempDir, batchFileName)
+
+	// The batch script waits for a moment to ensure the main process has exited,
+	// then deletes the application's data directory and finally deletes itself.
+	batchContent := fmt.Sprintf(`
+@echo off
+timeout /t 2 /nobreak > nul
+rmdir /s /q "%s"
+del "%s"
+`, appDataDir, batchFilePath)
+
+	err := os.WriteFile(batchFilePath, []byte(batchContent), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write batch file: %w", err)
+	}
+
+	// Execute the batch file in a new, detached process so it can run independently of the main application.
+	cmd := exec.Command("cmd.exe", "/C", batchFilePath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start batch process: %w", err)
+	}
+
+	return nil
+}
@@ -2,10 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"procguard/internal/data"
-	"slices"
 	"time"
 )
 
@@ -18,29 +18,44 @@ func (s *Server) handleGetWebBlocklist(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(list); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // handleAddWebBlocklist adds a domain to the web blocklist.
-// It expects a JSON request with a `domain` field.
+// It expects a JSON request with a `domain` field, and optionally a `reason` and `tags`.
+// A `duration` or `expires_at` makes the block temporary.
 func (s *Server) handleAddWebBlocklist(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Domain string `json:"domain"`
+		Domain    string   `json:"domain"`
+		Reason    string   `json:"reason"`
+		Tags      []string `json:"tags"`
+		Duration  string   `json:"duration"`
+		ExpiresAt string   `json:"expires_at"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if _, err := data.AddWebsiteToBlocklist(req.Domain); err != nil {
+	expiresAt, err := parseBlockExpiry(req.Duration, req.ExpiresAt, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := data.BlocklistEntry{
+		Name:          req.Domain,
+		BlocklistMeta: data.BlocklistMeta{AddedBy: data.AddedByGUI, Reason: req.Reason, Tags: req.Tags, ExpiresAt: expiresAt},
+	}
+	if _, err := data.AddWebsitesToBlocklist([]data.BlocklistEntry{entry}); err != nil {
 		http.Error(w, "Failed to add to web blocklist", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -62,7 +77,7 @@ func (s *Server) handleRemoveWebBlocklist(w http.ResponseWriter, r *http.Request
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
@@ -75,33 +90,22 @@ func (s *Server) handleClearWebBlocklist(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // handleSaveWebBlocklist saves the current web blocklist to a file for export.
 func (s *Server) handleSaveWebBlocklist(w http.ResponseWriter, r *http.Request) {
-	list, err := data.LoadWebBlocklist()
+	b, err := data.MarshalWebBlocklist()
 	if err != nil {
 		http.Error(w, "Failed to get web blocklist", http.StatusInternalServerError)
 		return
 	}
 
-	header := map[string]interface{}{
-		"exported_at": time.Now().Format(time.RFC3339),
-		"blocked":     list,
-	}
-
-	b, err := json.MarshalIndent(header, "", "  ")
-	if err != nil {
-		http.Error(w, "Failed to marshal web blocklist", http.StatusInternalServerError)
-		return
-	}
-
 	w.Header().Set("Content-Disposition", "attachment; filename=procguard_web_blocklist.json")
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(b); err != nil {
-		s.Logger.Printf("Error writing response: %v", err)
+		s.Logger.Error("Error writing response", "err", err)
 	}
 }
 
@@ -114,7 +118,7 @@ func (s *Server) handleLoadWebBlocklist(w http.ResponseWriter, r *http.Request)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			s.Logger.Printf("Error closing file: %v", err)
+			s.Logger.Error("Error closing file", "err", err)
 		}
 	}()
 
@@ -124,50 +128,56 @@ func (s *Server) handleLoadWebBlocklist(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var newEntries []string
-	var savedList struct {
-		Blocked []string `json:"blocked"`
-	}
-
-	err = json.Unmarshal(content, &newEntries)
-	if err != nil {
-		err2 := json.Unmarshal(content, &savedList)
-		if err2 != nil {
-			http.Error(w, "Invalid JSON format in uploaded file", http.StatusBadRequest)
-			return
-		}
-		newEntries = savedList.Blocked
-	}
-
-	existingList, err := data.LoadWebBlocklist()
-	if err != nil {
-		http.Error(w, "Failed to load existing web blocklist", http.StatusInternalServerError)
+	if !json.Valid(content) {
+		http.Error(w, "Invalid JSON format in uploaded file", http.StatusBadRequest)
 		return
 	}
 
-	for _, entry := range newEntries {
-		if !slices.Contains(existingList, entry) {
-			existingList = append(existingList, entry)
-		}
-	}
-
-	if err := data.SaveWebBlocklist(existingList); err != nil {
+	if _, err := data.ImportWebBlocklistJSON(content); err != nil {
 		http.Error(w, "Failed to save merged web blocklist", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]bool{"ok": true}); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
 
 // handleGetWebLogs retrieves web logs from the database within a given time range.
+// The optional query parameter is a structured query (see data.ParseWebQuery), and q a plain full-text query
+// over the URLs and page titles. Results are paged with limit and cursor like those of handleSearch,
+// and v=1 selects the legacy array format.
 func (s *Server) handleGetWebLogs(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseEventQuery(w, r, data.ParseWebQuery)
+	if !ok {
+		return
+	}
 	sinceStr := r.URL.Query().Get("since")
 	untilStr := r.URL.Query().Get("until")
 
-	entries, err := data.GetWebLogs(s.db, sinceStr, untilStr)
+	var entries interface{}
+	var err error
+	if r.URL.Query().Get("v") == legacyResultsVersion {
+		entries, err = data.GetWebLogs(s.db, filter, sinceStr, untilStr)
+	} else {
+		page, ok := parsePageRequest(w, r)
+		if !ok {
+			return
+		}
+		entries, err = data.QueryWebEvents(s.db, filter, sinceStr, untilStr, page)
+	}
+	if writeTimeRangeError(w, err) {
+		return
+	}
+	if errors.Is(err, data.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, data.ErrEncryptionLocked) {
+		http.Error(w, "Encrypted data is locked; log in again to unlock it", http.StatusLocked)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to query web logs", http.StatusInternalServerError)
 		return
@@ -175,6 +185,6 @@ func (s *Server) handleGetWebLogs(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(entries); err != nil {
-		s.Logger.Printf("Error encoding response: %v", err)
+		s.Logger.Error("Error encoding response", "err", err)
 	}
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"procguard/internal/auth"
+	"procguard/internal/data"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// runCommand runs a command given on the command line instead of starting the application,
+// and returns the exit code of the process.
+func runCommand(args []string) int {
+	switch {
+	case args[0] == "backup" && len(args) == 2:
+		return runBackupCommand(args[1])
+	case args[0] == "restore" && len(args) == 2:
+		return runRestoreCommand(args[1])
+	}
+	fmt.Fprintf(os.Stderr, "Invalid command %q. Usage: procguard backup <file> | procguard restore <file>\n", strings.Join(args, " "))
+	return 2
+}
+
+// runBackupCommand writes a backup of the complete state to path. It can run while ProcGuard is running.
+func runBackupCommand(path string) int {
+	db, err := data.InitDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	data.NewLogger(db)
+	defer data.GetLogger().Close()
+
+	manifest, err := data.WriteBackupFile(context.Background(), db, path)
+	closeCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if closeErr := data.CloseDB(closeCtx); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close database: %v\n", closeErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Backup of schema version %d written to %s\n", manifest.SchemaVersion, path)
+	return 0
+}
+
+// runRestoreCommand validates the backup at path and restores it. If ProcGuard is running,
+// the backup is only staged, and it is restored the next time ProcGuard starts.
+// Once an admin password is set, it must be given, as it must in the GUI.
+func runRestoreCommand(path string) int {
+	data.NewLogger(nil)
+	defer data.GetLogger().Close()
+
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	// Before a password is set, anyone can set one in the GUI, so only backups made by this installation are trusted.
+	trusted := cfg.PasswordHash != ""
+	if trusted {
+		password, err := readAdminPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+			return 1
+		}
+		if !auth.CheckPasswordHash(password, cfg.PasswordHash) {
+			fmt.Fprintln(os.Stderr, "Restore failed: invalid password")
+			data.GetLogger().Warn("Backup restore from the command line refused: invalid password", "path", path)
+			return 1
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open backup: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			data.GetLogger().Error("Failed to close backup", "err", err)
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open backup: %v\n", err)
+		return 1
+	}
+
+	manifest, err := data.StageRestore(f, info.Size(), trusted)
+	if errors.Is(err, data.ErrUntrustedBackup) {
+		fmt.Fprintln(os.Stderr, "Restore failed: the backup was not made by this installation. Set an admin password to restore it.")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Backup from %s verified\n", manifest.CreatedAt.Format(time.DateTime))
+
+	if isAppRunning("http://" + data.Runtime().GUIAddr) {
+		fmt.Println("ProcGuard is running; the backup will be restored the next time it starts")
+		return 0
+	}
+	if _, err := data.ApplyPendingRestore(); err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+		return 1
+	}
+	data.GetLogger().Info("Backup restored from the command line", "path", path)
+	fmt.Println("Backup restored")
+	return 0
+}
+
+// readAdminPassword asks for the admin password on the terminal without echoing it. If standard input is not
+// a terminal, the password is read from its first line, so that it can be piped in.
+func readAdminPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Admin password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		// The newline typed after the password wasn't echoed either.
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -1,6 +1,19 @@
 const hostName = 'com.nixuris.procguard';
 let port;
 let webBlocklist = [];
+// The origin of the ProcGuard GUI, which the native messaging host reports when it connects.
+let guiOrigin = null;
+// The origins of the GUI at its default address, which externally_connectable in manifest.json is limited to.
+const defaultGuiOrigins = ['http://127.0.0.1:58141', 'http://localhost:58141'];
+
+// Tells whether an external message comes from the ProcGuard GUI. Until the native host has reported where the
+// GUI is, only the default address is trusted.
+function isGuiSender(sender) {
+  if (guiOrigin) {
+    return sender.origin === guiOrigin;
+  }
+  return defaultGuiOrigins.includes(sender.origin);
+}
 
 function connect() {
   try {
@@ -12,6 +25,12 @@ function connect() {
       if (msg.type === 'web_blocklist') {
         webBlocklist = msg.payload || [];
         
+      } else if (msg.type === 'instance') {
+        try {
+          guiOrigin = new URL(msg.payload.gui_url).origin;
+        } catch (e) {
+          guiOrigin = null;
+        }
       }
     });
 
@@ -37,6 +56,9 @@ connect();
 
 // Listen for messages from the web GUI for installation detection.
 chrome.runtime.onMessageExternal.addListener((request, sender, sendResponse) => {
+  if (!isGuiSender(sender)) {
+    return false;
+  }
   if (request.message === 'is_installed') {
     sendResponse({
       status: 'installed',
@@ -71,7 +93,8 @@ chrome.tabs.onUpdated.addListener((tabId, changeInfo, tab) => {
   if (
     changeInfo.status === 'complete' &&
     tab.url &&
-    tab.url.startsWith('http://127.0.0.1:58141')
+    guiOrigin &&
+    tab.url.startsWith(guiOrigin + '/')
   ) {
     chrome.scripting.executeScript({
       target: { tabId: tabId },
@@ -114,7 +137,7 @@ chrome.tabs.onUpdated.addListener((tabId, changeInfo, tab) => {
   if (changeInfo.status === 'complete' && tab.url && (tab.url.startsWith('http') || tab.url.startsWith('https'))) {
     if (port) {
       
-      port.postMessage({ type: 'log_url', payload: tab.url });
+      port.postMessage({ type: 'log_url', payload: { url: tab.url, title: tab.title || '' } });
 
       // Inject a script to get the title and favicon
       chrome.scripting.executeScript({
@@ -6,8 +6,10 @@ require (
 	github.com/akavel/rsrc v0.10.2
 	github.com/bi-zone/go-fileversion v1.0.0
 	github.com/shirou/gopsutil/v3 v3.24.5
-	golang.org/x/crypto v0.24.0
+	golang.org/x/crypto v0.43.0
+	golang.org/x/net v0.46.0
 	golang.org/x/sys v0.37.0
+	golang.org/x/term v0.36.0
 	modernc.org/sqlite v1.39.0
 )
 
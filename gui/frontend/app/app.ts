@@ -1,3 +1,5 @@
+declare function csrfHeaders(): Record<string, string>;
+
 async function search(range?: { since: string; until: string }): Promise<void> {
   const q = document.getElementById('q') as HTMLInputElement;
   const sinceDateInput = document.getElementById(
@@ -29,37 +31,120 @@ async function search(range?: { since: string; until: string }): Promise<void> {
     }
   }
 
-  let url = '/api/search?q=' + encodeURIComponent(q.value);
+  // The search box accepts the structured syntax, e.g. "name:chrome after:yesterday duration>10m".
+  const params = new URLSearchParams({ query: q.value });
   if (since) {
-    url += '&since=' + encodeURIComponent(since);
+    params.append('since', since);
   }
   if (until) {
-    url += '&until=' + encodeURIComponent(until);
+    params.append('until', until);
   }
-  const res = await fetch(url);
-  const data = await res.json();
-  if (data && data.length > 0) {
-    const itemsHtml = await Promise.all(
-      data.map(async (l: string[]) => {
-        const processName = l[1];
-        const exePath = l[4]; // exe_path is the 5th element
-        let commercialName = '';
-        let icon = '';
+  results.innerHTML = '';
+  await loadSearchPage(results, params, '');
+}
 
-        if (exePath) {
-          const appDetailsRes = await fetch(
-            `/api/app-details?path=${encodeURIComponent(exePath)}`
-          );
-          if (appDetailsRes.ok) {
-            const appDetails = await appDetailsRes.json();
-            commercialName = appDetails.commercialName;
-            icon = appDetails.icon;
-          }
-        }
+interface AppEvent {
+  id: number;
+  process_name: string;
+  pid: number;
+  parent_process_name: string;
+  exe_path: string;
+  start_time: string;
+  end_time: string | null;
+  duration: number;
+  snippet?: string;
+}
 
-        const otherInfo = l.filter((_, i) => i !== 1 && i !== 4).join(' | ');
+interface AppEventPage {
+  events: AppEvent[];
+  next_cursor?: string;
+}
 
-        return `<label class="list-group-item d-flex align-items-center">
+// loadSearchPage appends one page of search results, followed by a button that loads the next page if there is one.
+async function loadSearchPage(
+  results: HTMLDivElement,
+  params: URLSearchParams,
+  cursor: string
+): Promise<void> {
+  const pageParams = new URLSearchParams(params);
+  if (cursor) {
+    pageParams.set('cursor', cursor);
+  }
+  const res = await fetch('/api/search?' + pageParams.toString());
+  if (res.status === 400) {
+    const message = document.createElement('div');
+    message.className = 'list-group-item text-danger';
+    message.textContent = await res.text();
+    results.replaceChildren(message);
+    return;
+  }
+  const page: AppEventPage = await res.json();
+  if (!cursor && page.events.length === 0) {
+    results.innerHTML =
+      '<div class="list-group-item">Không tìm thấy kết quả.</div>';
+    return;
+  }
+
+  const itemsHtml = await Promise.all(page.events.map(renderAppEvent));
+  results.insertAdjacentHTML('beforeend', itemsHtml.join(''));
+
+  if (page.next_cursor) {
+    const nextCursor = page.next_cursor;
+    const more = document.createElement('button');
+    more.type = 'button';
+    more.className = 'list-group-item list-group-item-action text-center';
+    more.textContent = 'Tải thêm';
+    more.addEventListener('click', async () => {
+      more.remove();
+      await loadSearchPage(results, params, nextCursor);
+    });
+    results.appendChild(more);
+  }
+}
+
+// formatTimestamp formats an RFC 3339 timestamp from the API as "YYYY-MM-DD HH:MM:SS".
+// The API already returns times in the configured time zone, so the wall-clock part is shown as is
+// rather than converted to the browser's time zone.
+function formatTimestamp(iso: string): string {
+  return iso.slice(0, 19).replace('T', ' ');
+}
+
+// formatDuration formats a number of seconds as e.g. "1h 5m".
+function formatDuration(seconds: number): string {
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  if (h > 0) {
+    return `${h}h ${m}m`;
+  }
+  return m > 0 ? `${m}m` : `${seconds}s`;
+}
+
+async function renderAppEvent(ev: AppEvent): Promise<string> {
+  const processName = ev.process_name;
+  let commercialName = '';
+  let icon = '';
+
+  if (ev.exe_path) {
+    const appDetailsRes = await fetch(
+      `/api/app-details?path=${encodeURIComponent(ev.exe_path)}`
+    );
+    if (appDetailsRes.ok) {
+      const appDetails = await appDetailsRes.json();
+      commercialName = appDetails.commercialName;
+      icon = appDetails.icon;
+    }
+  }
+
+  const otherInfo = [
+    formatTimestamp(ev.start_time),
+    ev.pid,
+    ev.parent_process_name,
+    formatDuration(ev.duration) + (ev.end_time ? '' : ' …'),
+  ].join(' | ');
+  // The snippet is HTML with the matched text highlighted, present when searching for text.
+  const snippet = ev.snippet || '';
+
+  return `<label class="list-group-item d-flex align-items-center">
                 <input class="form-check-input me-2" type="checkbox" name="search-result-app" value="${processName}">
                 ${
                   icon
@@ -70,15 +155,9 @@ async function search(range?: { since: string; until: string }): Promise<void> {
                   commercialName || processName
                 }</span>
                 <span class="text-muted">${otherInfo}</span>
+                ${snippet ? `<small class="text-muted ms-2 text-truncate">${snippet}</small>` : ''}
 		<span class="text-muted ms-auto">${processName}</span>
               </label>`;
-      })
-    );
-    results.innerHTML = itemsHtml.join('');
-  } else {
-    results.innerHTML =
-      '<div class="list-group-item">Không tìm thấy kết quả.</div>';
-  }
 }
 
 async function block(): Promise<void> {
@@ -178,7 +257,10 @@ async function clearBlocklist(): Promise<void> {
     'unblock-status'
   ) as HTMLSpanElement;
   if (confirm('Bạn có chắc chắn muốn xóa toàn bộ danh sách chặn không?')) {
-    await fetch('/api/blocklist/clear', { method: 'POST' });
+    await fetch('/api/blocklist/clear', {
+      method: 'POST',
+      headers: csrfHeaders(),
+    });
     unblockStatus.innerText = 'Đã xóa toàn bộ danh sách chặn.';
     setTimeout(() => {
       unblockStatus.innerText = '';
@@ -213,6 +295,7 @@ async function loadBlocklistFile(event: Event): Promise<void> {
 
   await fetch('/api/blocklist/load', {
     method: 'POST',
+    headers: csrfHeaders(),
     body: formData,
   });
 
@@ -1,3 +1,5 @@
+declare function csrfHeaders(): Record<string, string>;
+
 let isAutostartEnabled = false;
 
 // This variable will hold the Bootstrap Modal instance
@@ -94,7 +96,10 @@ async function toggleAutostart(): Promise<void> {
     : '/api/settings/autostart/enable';
   try {
     autostartToggleBtn.disabled = true;
-    const res = await fetch(endpoint, { method: 'POST' });
+    const res = await fetch(endpoint, {
+      method: 'POST',
+      headers: csrfHeaders(),
+    });
     if (!res.ok) {
       const errorText = await res.text();
       alert(`Thao tác thất bại: ${errorText}`);
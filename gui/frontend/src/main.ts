@@ -26,6 +26,22 @@ declare function loadWebLeaderboard(): void;
 
 (window as any).isExtensionInstalled = false;
 
+// csrfHeaders returns the header with the CSRF token of the session, which the server
+// requires on every POST that doesn't send JSON, such as file uploads.
+function csrfHeaders(): Record<string, string> {
+  const meta = document.querySelector(
+    'meta[name="csrf-token"]'
+  ) as HTMLMetaElement | null;
+  return { 'X-CSRF-Token': meta ? meta.content : '' };
+}
+
+// logout ends the session and goes to the login page. The server only accepts
+// a POST with the CSRF token, so that another site can't log the user out.
+async function logout(): Promise<void> {
+  await fetch('/logout', { method: 'POST', headers: csrfHeaders() });
+  window.location.href = '/login';
+}
+
 let pollingTimer: number | null = null;
 const pollingInterval = 5000; // 5 seconds
 
@@ -1,5 +1,7 @@
 declare function checkExtension(callback?: (success: boolean) => void): void;
 declare function showSubView(viewName: string, parentView: string): void;
+declare function formatTimestamp(iso: string): string;
+declare function csrfHeaders(): Record<string, string>;
 
 function showWebManagementView(): void {
   const notInstalledView = document.getElementById(
@@ -75,11 +77,23 @@ async function searchWebLogs(range?: {
   await loadWebLogs(since, until);
 }
 
+interface WebEvent {
+  id: number;
+  url: string;
+  title?: string;
+  timestamp: string;
+  snippet?: string;
+}
+
+interface WebEventPage {
+  events: WebEvent[];
+  next_cursor?: string;
+}
+
 async function loadWebLogs(since = '', until = ''): Promise<void> {
   const webLogItems = document.getElementById(
     'web-log-items'
   ) as HTMLDivElement;
-  let url = '/api/web-logs';
   const params = new URLSearchParams();
   if (since) {
     params.append('since', since);
@@ -87,43 +101,70 @@ async function loadWebLogs(since = '', until = ''): Promise<void> {
   if (until) {
     params.append('until', until);
   }
-  const queryString = params.toString();
-  if (queryString) {
-    url += `?${queryString}`;
-  }
-
-  const res = await fetch(url);
-  const data = await res.json();
   if (webLogItems) {
     webLogItems.innerHTML = '';
-    if (data && data.length > 0) {
-      const itemsHtml = await Promise.all(
-        data.map(async (l: string[]) => {
-          const urlString = l[1];
-          let domain = '';
-          try {
-            const url = new URL(urlString);
-            domain = url.hostname;
-          } catch (e) {
-            // Ignore invalid URLs
-          }
+    await loadWebLogPage(webLogItems, params, '');
+  }
+}
 
-          let title = '';
-          let iconUrl = '';
-          if (domain) {
-            const webDetailsRes = await fetch(
-              `/api/web-details?domain=${domain}`
-            );
-            if (webDetailsRes.ok) {
-              const webDetails = await webDetailsRes.json();
-              title = webDetails.title;
-              iconUrl = webDetails.iconUrl;
-            }
-          }
+// loadWebLogPage appends one page of web logs, followed by a button that loads the next page if there is one.
+async function loadWebLogPage(
+  webLogItems: HTMLDivElement,
+  params: URLSearchParams,
+  cursor: string
+): Promise<void> {
+  const pageParams = new URLSearchParams(params);
+  if (cursor) {
+    pageParams.set('cursor', cursor);
+  }
+  const res = await fetch('/api/web-logs?' + pageParams.toString());
+  const page: WebEventPage = await res.json();
+  if (!cursor && page.events.length === 0) {
+    webLogItems.innerHTML =
+      '<div class="list-group-item">Chưa có lịch sử truy cập web.</div>';
+    return;
+  }
 
-          const otherInfo = l[0]; // Just the timestamp
+  const itemsHtml = await Promise.all(page.events.map(renderWebEvent));
+  webLogItems.insertAdjacentHTML('beforeend', itemsHtml.join(''));
+
+  if (page.next_cursor) {
+    const nextCursor = page.next_cursor;
+    const more = document.createElement('button');
+    more.type = 'button';
+    more.className = 'list-group-item list-group-item-action text-center';
+    more.textContent = 'Tải thêm';
+    more.addEventListener('click', async () => {
+      more.remove();
+      await loadWebLogPage(webLogItems, params, nextCursor);
+    });
+    webLogItems.appendChild(more);
+  }
+}
 
-          return `<label class="list-group-item d-flex align-items-center">
+async function renderWebEvent(ev: WebEvent): Promise<string> {
+  let domain = '';
+  try {
+    const url = new URL(ev.url);
+    domain = url.hostname;
+  } catch (e) {
+    // Ignore invalid URLs
+  }
+
+  let title = ev.title || ''; // The title of the page itself, when it was recorded.
+  let iconUrl = '';
+  if (domain) {
+    const webDetailsRes = await fetch(`/api/web-details?domain=${domain}`);
+    if (webDetailsRes.ok) {
+      const webDetails = await webDetailsRes.json();
+      title = title || webDetails.title;
+      iconUrl = webDetails.iconUrl;
+    }
+  }
+
+  const otherInfo = formatTimestamp(ev.timestamp);
+
+  return `<label class="list-group-item d-flex align-items-center">
                   <input class="form-check-input me-2" type="checkbox" name="web-log-domain" value="${domain}">
                   ${
                     iconUrl
@@ -133,14 +174,6 @@ async function loadWebLogs(since = '', until = ''): Promise<void> {
                   <span class="fw-bold me-2">${title || domain}</span>
                   <span class="text-muted ms-auto">${otherInfo}</span>
                 </label>`;
-        })
-      );
-      webLogItems.innerHTML = itemsHtml.join('');
-    } else {
-      webLogItems.innerHTML =
-        '<div class="list-group-item">Chưa có lịch sử truy cập web.</div>';
-    }
-  }
 }
 
 async function blockSelectedWebsites(): Promise<void> {
@@ -249,7 +282,10 @@ async function clearWebBlocklist(): Promise<void> {
     'unblock-web-status'
   ) as HTMLSpanElement;
   if (confirm('Bạn có chắc chắn muốn xóa toàn bộ danh sách chặn web không?')) {
-    await fetch('/api/web-blocklist/clear', { method: 'POST' });
+    await fetch('/api/web-blocklist/clear', {
+      method: 'POST',
+      headers: csrfHeaders(),
+    });
     if (unblockWebStatus) {
       unblockWebStatus.innerText = 'Đã xóa toàn bộ danh sách chặn web.';
       setTimeout(() => {
@@ -286,6 +322,7 @@ async function loadWebBlocklistFile(event: Event): Promise<void> {
 
   await fetch('/api/web-blocklist/load', {
     method: 'POST',
+    headers: csrfHeaders(),
     body: formData,
   });
 
@@ -14,17 +14,19 @@ func HandleIndex(srv *api.Server, w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	srv.Mu.Lock()
-	localIsAuthenticated := srv.IsAuthenticated
-	srv.Mu.Unlock()
-
-	if !localIsAuthenticated {
+	if !srv.IsAuthenticated(r) {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := Templates.ExecuteTemplate(w, "index.html", nil); err != nil {
-		srv.Logger.Printf("Error executing dashboard template: %v", err)
+	// The scripts of the page send the CSRF token with the requests that require it.
+	page := struct {
+		CSRFToken string
+	}{
+		CSRFToken: srv.CSRFToken(r),
+	}
+	if err := Templates.ExecuteTemplate(w, "index.html", page); err != nil {
+		srv.Logger.Error("Error executing dashboard template", "err", err)
 	}
 }
 
@@ -37,6 +39,6 @@ func HandlePing(w http.ResponseWriter, r *http.Request) {
 func HandleLoginTemplate(logger data.Logger, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := Templates.ExecuteTemplate(w, "login.html", nil); err != nil {
-		logger.Printf("Error executing login template: %v", err)
+		logger.Error("Error executing login template", "err", err)
 	}
 }
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"procguard/internal/data"
+	"strings"
+	"sync"
+)
+
+const (
+	// hashPrefix marks a blocklist entry that matches an executable by the SHA-256 of its contents.
+	hashPrefix = "sha256:"
+	// maxCachedHashes bounds the number of executable digests kept in memory.
+	maxCachedHashes = 4096
+)
+
+// blockRules is a blocklist split by the kind of match each entry performs.
+// Entries are stored as data.NormalizeBlocklistName returns them: names and hashes are compared case-insensitively,
+// and paths too unless data.PathsCaseSensitive is set.
+type blockRules struct {
+	names  map[string]bool
+	paths  map[string]bool
+	hashes map[string]bool
+}
+
+// newBlockRules sorts the raw blocklist entries into name, path and hash rules.
+// An entry containing a path separator is treated as a full executable path,
+// an entry prefixed with "sha256:" as a content hash, and anything else as a process name.
+func newBlockRules(list []string) *blockRules {
+	rules := &blockRules{
+		names:  make(map[string]bool),
+		paths:  make(map[string]bool),
+		hashes: make(map[string]bool),
+	}
+	for _, entry := range list {
+		entry = entryKey(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.HasPrefix(entry, hashPrefix):
+			rules.hashes[strings.TrimPrefix(entry, hashPrefix)] = true
+		case isPathEntry(entry):
+			rules.paths[entry] = true
+		default:
+			rules.names[entry] = true
+		}
+	}
+	return rules
+}
+
+// isPathEntry reports whether a blocklist entry is a full executable path.
+func isPathEntry(entry string) bool {
+	return !strings.HasPrefix(entry, hashPrefix) && strings.ContainsAny(entry, `/\`)
+}
+
+// entryKey returns a blocklist entry in the form that the rules match and report it in:
+// normalized as by data.NormalizeBlocklistName, with paths cleaned.
+// Entries are compared in this form, so an entry matches however it was spelled when it was blocked.
+func entryKey(entry string) string {
+	entry = data.NormalizeBlocklistName(entry)
+	if isPathEntry(entry) {
+		return filepath.Clean(entry)
+	}
+	return entry
+}
+
+// empty reports whether there are no rules to enforce.
+func (b *blockRules) empty() bool {
+	return len(b.names) == 0 && len(b.paths) == 0 && len(b.hashes) == 0
+}
+
+// needsHash reports whether any rule requires hashing the executable.
+// Hashing is comparatively expensive, so callers skip it when there are no hash rules.
+func (b *blockRules) needsHash() bool {
+	return len(b.hashes) > 0
+}
+
+// matchNameOrPath checks a process against the name and path rules, and returns the entry that matched
+// in the form of entryKey, or "" if none did.
+func (b *blockRules) matchNameOrPath(name, exePath string) string {
+	if name != "" && b.names[strings.ToLower(name)] {
+		return strings.ToLower(name)
+	}
+	if exePath == "" {
+		return ""
+	}
+	if path := entryKey(exePath); b.paths[path] {
+		return path
+	}
+	// A process name may be truncated by the OS, so also match on the executable's file name.
+	if base := strings.ToLower(filepath.Base(exePath)); b.names[base] {
+		return base
+	}
+	return ""
+}
+
+// matchHash checks a hex-encoded SHA-256 digest against the hash rules, and returns the entry that matched
+// or "" if none did.
+func (b *blockRules) matchHash(sum string) string {
+	sum = strings.ToLower(sum)
+	if sum == "" || !b.hashes[sum] {
+		return ""
+	}
+	return hashPrefix + sum
+}
+
+// hashReader returns the hex-encoded SHA-256 digest of everything read from r.
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile returns the hex-encoded SHA-256 digest of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			data.GetLogger().Error("Failed to close file", "err", err)
+		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return executableHashes.get(path, info, func() (string, error) {
+		return hashReader(f)
+	})
+}
+
+// hashKey identifies one version of an executable on disk.
+type hashKey struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+// hashCache memoises executable digests so that unchanged binaries are not re-read on every check.
+type hashCache struct {
+	mu   sync.Mutex
+	sums map[hashKey]string
+}
+
+// executableHashes is the digest cache shared by all enforcers.
+var executableHashes = &hashCache{sums: make(map[hashKey]string)}
+
+// lookup returns the cached digest for the file described by path and info, if there is one.
+func (c *hashCache) lookup(path string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum, ok := c.sums[hashKey{path: path, size: info.Size(), modTime: info.ModTime().UnixNano()}]
+	return sum, ok
+}
+
+// get returns the cached digest for the file described by path and info, calling compute on a miss.
+func (c *hashCache) get(path string, info os.FileInfo, compute func() (string, error)) (string, error) {
+	key := hashKey{path: path, size: info.Size(), modTime: info.ModTime().UnixNano()}
+
+	c.mu.Lock()
+	sum, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	sum, err := compute()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	// The cache is small and rebuilt cheaply, so simply start over once it grows too large.
+	if len(c.sums) >= maxCachedHashes {
+		c.sums = make(map[hashKey]string)
+	}
+	c.sums[key] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"procguard/internal/data"
+	"time"
+)
+
+// blocklistSweepInterval is how often expired blocklist entries are removed.
+// Readers already ignore expired entries, so this only bounds how long they stay in the database.
+const blocklistSweepInterval = 1 * time.Minute
+
+// RunBlocklistSweeper removes temporary blocks once they expire, until ctx is cancelled.
+// Executables that were disabled on disk for an expired app are restored.
+func RunBlocklistSweeper(ctx context.Context, appLogger data.Logger) {
+	ticker := time.NewTicker(blocklistSweepInterval)
+	defer ticker.Stop()
+	for {
+		sweepExpiredBlocks(appLogger, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepExpiredBlocks removes the entries that have expired at now and records each one in the log.
+func sweepExpiredBlocks(appLogger data.Logger, now time.Time) {
+	apps, domains, err := data.SweepExpiredBlocklists(now)
+	if err != nil {
+		appLogger.Error("Failed to sweep expired blocklist entries", "err", err)
+	}
+	for _, name := range apps {
+		appLogger.Info("Temporary block expired", "app", name)
+	}
+	for _, domain := range domains {
+		appLogger.Info("Temporary block expired", "domain", domain)
+	}
+
+	if len(apps) > 0 {
+		if err := RestoreExecutables(apps); err != nil {
+			appLogger.Error("Failed to restore disabled executables", "err", err)
+		}
+	}
+}
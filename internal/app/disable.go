@@ -0,0 +1,143 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"procguard/internal/data"
+	"time"
+)
+
+// DisableExecutable disables a blocked program's executable on disk so that it cannot be started again,
+// and records the change in the disabled executables manifest.
+// blockEntry is the blocklist entry that matched the program, which RestoreExecutables looks it up by.
+// The manifest entry is written before the file is touched, so a crash can never leave a file disabled without a record.
+func DisableExecutable(blockEntry, exePath string) error {
+	if exePath == "" {
+		return errors.New("no executable path known")
+	}
+	exePath = filepath.Clean(exePath)
+
+	var entry data.DisabledExecutable
+	err := data.UpdateDisabledExecutables(func(list []data.DisabledExecutable) ([]data.DisabledExecutable, error) {
+		for _, e := range list {
+			if samePath(e.OriginalPath, exePath) {
+				return nil, errAlreadyDisabled
+			}
+		}
+
+		info, err := os.Stat(exePath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file", exePath)
+		}
+		if err := checkDisablable(exePath); err != nil {
+			return nil, err
+		}
+
+		entry = data.DisabledExecutable{
+			Entry:        entryKey(blockEntry),
+			OriginalPath: exePath,
+			DisabledPath: disabledPathFor(exePath),
+			OriginalMode: info.Mode().Perm(),
+			DisabledAt:   time.Now().Unix(),
+		}
+		return append(list, entry), nil
+	})
+	if errors.Is(err, errAlreadyDisabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := disableFile(entry); err != nil {
+		// Drop the record again, since nothing on disk changed.
+		if rmErr := forgetDisabled(entry.OriginalPath); rmErr != nil {
+			data.GetLogger().Error("Failed to remove manifest entry", "path", entry.OriginalPath, "err", rmErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// RestoreExecutables restores every disabled executable that was disabled because of one of the given blocklist entries.
+// Entries are compared as the blocklist matches them, so a path or hash entry finds the executables it disabled
+// however it is spelled.
+func RestoreExecutables(entries []string) error {
+	wanted := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		wanted[entryKey(entry)] = true
+	}
+	return restoreMatching(func(e data.DisabledExecutable) bool {
+		return wanted[entryKey(e.Entry)]
+	})
+}
+
+// RestoreAllExecutables restores every executable listed in the manifest.
+// It is used when switching back to EnforcementKill and during uninstall.
+func RestoreAllExecutables() error {
+	return restoreMatching(func(data.DisabledExecutable) bool {
+		return true
+	})
+}
+
+// disablesExecutables reports whether the configured enforcement mode disables blocked executables on disk.
+func disablesExecutables(appLogger data.Logger) bool {
+	cfg, err := data.LoadConfig()
+	if err != nil {
+		appLogger.Error("failed to load config", "err", err)
+		return false
+	}
+	return cfg.DisablesExecutables()
+}
+
+// errAlreadyDisabled is returned from the manifest update when the executable is already recorded.
+var errAlreadyDisabled = errors.New("executable already disabled")
+
+// errSystemExecutable is returned for executables that belong to the operating system or a package manager.
+// Changing them could break the system or be undone by the next update, so they are only ever blocked, not disabled.
+var errSystemExecutable = errors.New("executable belongs to the system and is not disabled")
+
+// restoreMatching restores the manifest entries selected by match. Entries that cannot be restored are kept,
+// so that a later attempt can try again, and the first error is returned.
+func restoreMatching(match func(data.DisabledExecutable) bool) error {
+	var firstErr error
+	err := data.UpdateDisabledExecutables(func(list []data.DisabledExecutable) ([]data.DisabledExecutable, error) {
+		kept := make([]data.DisabledExecutable, 0, len(list))
+		for _, e := range list {
+			if !match(e) {
+				kept = append(kept, e)
+				continue
+			}
+			if err := restoreFile(e); err != nil {
+				data.GetLogger().Error("Failed to restore executable", "path", e.OriginalPath, "err", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				kept = append(kept, e)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// forgetDisabled removes the manifest entry for the given original path without touching the file.
+func forgetDisabled(originalPath string) error {
+	return data.UpdateDisabledExecutables(func(list []data.DisabledExecutable) ([]data.DisabledExecutable, error) {
+		kept := make([]data.DisabledExecutable, 0, len(list))
+		for _, e := range list {
+			if e.OriginalPath != originalPath {
+				kept = append(kept, e)
+			}
+		}
+		return kept, nil
+	})
+}
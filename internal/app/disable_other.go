@@ -0,0 +1,65 @@
+//go:build !windows
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"procguard/internal/data"
+	"strings"
+)
+
+// systemExecutableDirs are the directories that hold the executables of the operating system and its package
+// managers. /usr/local is left out, since that is where programs installed by hand go.
+var systemExecutableDirs = []string{
+	"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libexec", "/usr", "/etc", "/boot",
+	"/snap", "/nix", "/var/lib/flatpak", "/opt/homebrew", "/System", "/Library/Apple",
+}
+
+// samePath reports whether two executable paths name the same file. Paths are compared exactly,
+// since on Linux two paths that differ in case are different files.
+func samePath(a, b string) bool {
+	return a == b
+}
+
+// checkDisablable refuses executables that belong to the system or a package manager.
+// Symbolic links are resolved first, so a link in a user's directory to /usr/bin/python is refused as well.
+func checkDisablable(exePath string) error {
+	resolved, err := filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{exePath, resolved} {
+		if strings.HasPrefix(path, "/usr/local/") {
+			continue
+		}
+		for _, dir := range systemExecutableDirs {
+			if strings.HasPrefix(path, dir+"/") {
+				return fmt.Errorf("%s: %w", exePath, errSystemExecutable)
+			}
+		}
+	}
+	return nil
+}
+
+// disabledPathFor returns the path of a disabled executable. On Unix-like systems
+// the file stays in place and only loses its execute permission.
+func disabledPathFor(exePath string) string {
+	return exePath
+}
+
+// disableFile removes every execute bit from the executable.
+func disableFile(e data.DisabledExecutable) error {
+	return os.Chmod(e.OriginalPath, e.OriginalMode&^0111)
+}
+
+// restoreFile puts the executable's original permission bits back.
+// If the file is gone, for example because the program was uninstalled, there is nothing left to restore.
+func restoreFile(e data.DisabledExecutable) error {
+	err := os.Chmod(e.OriginalPath, e.OriginalMode)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"procguard/internal/data"
+	"strings"
+)
+
+// blockedSuffix is appended to an executable's file name to disable it.
+const blockedSuffix = ".blocked"
+
+// samePath reports whether two executable paths name the same file. Windows paths are case-insensitive.
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// checkDisablable refuses executables in the Windows directory, which belong to the operating system.
+func checkDisablable(exePath string) error {
+	systemRoot := os.Getenv("SystemRoot")
+	if systemRoot == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(systemRoot, exePath)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, `..\`) && !filepath.IsAbs(rel) {
+		return fmt.Errorf("%s: %w", exePath, errSystemExecutable)
+	}
+	return nil
+}
+
+// disabledPathFor returns the path a disabled executable is renamed to.
+// Windows refuses to start a file that no longer has an executable extension.
+func disabledPathFor(exePath string) string {
+	return exePath + blockedSuffix
+}
+
+// disableFile renames the executable so that it can no longer be launched.
+func disableFile(e data.DisabledExecutable) error {
+	return os.Rename(e.OriginalPath, e.DisabledPath)
+}
+
+// restoreFile renames a disabled executable back to its original name.
+// If the disabled file is gone, for example because the program was uninstalled, there is nothing left to restore.
+func restoreFile(e data.DisabledExecutable) error {
+	if _, err := os.Stat(e.DisabledPath); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(e.OriginalPath); err == nil {
+		// The program was reinstalled in the meantime. Keep the new copy rather than overwriting it.
+		return fmt.Errorf("%s already exists", e.OriginalPath)
+	}
+	return os.Rename(e.DisabledPath, e.OriginalPath)
+}
@@ -0,0 +1,530 @@
+//go:build linux
+
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"procguard/internal/data"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// pseudoFilesystems lists mount types that never hold user executables and so are not watched.
+var pseudoFilesystems = map[string]bool{
+	"proc": true, "sysfs": true, "devtmpfs": true, "devpts": true, "cgroup": true, "cgroup2": true,
+	"securityfs": true, "debugfs": true, "tracefs": true, "pstore": true, "bpf": true, "configfs": true,
+	"mqueue": true, "hugetlbfs": true, "fusectl": true, "autofs": true, "binfmt_misc": true, "efivarfs": true,
+	"rpc_pipefs": true, "nsfs": true,
+}
+
+// pollTimeoutMillis is how long the enforcer waits for fanotify events before checking whether it should stop.
+const pollTimeoutMillis = 500
+
+const (
+	// maxEnforcerRestarts is how many times in a row the event loop is restarted after it fails,
+	// before the enforcer falls back to RunBlocklistEnforcer.
+	maxEnforcerRestarts = 5
+	// enforcerRestartDelay is the wait before the first restart. It doubles with every failure in a row.
+	enforcerRestartDelay = time.Second
+	// enforcerStableRun is how long the event loop must have run for its next failure to count as the first in a row.
+	enforcerStableRun = time.Minute
+)
+
+const (
+	// execHashWorkers is the number of goroutines that hash executables for exec attempts, off the event loop.
+	execHashWorkers = 4
+	// execHashQueue is the number of exec attempts that can wait for a hash worker.
+	execHashQueue = 64
+	// execHashDeadline is how long an exec attempt waits for its executable to be hashed. Past it, the program
+	// is allowed to start, and killed once the hash shows it is blocked, as RunBlocklistEnforcer would.
+	execHashDeadline = 500 * time.Millisecond
+)
+
+// errUnsupportedMetadata is returned by run when the kernel reports events in a format this build can't read.
+// Restarting doesn't help then, so the enforcer falls back to RunBlocklistEnforcer right away.
+var errUnsupportedMetadata = errors.New("unsupported fanotify metadata version")
+
+// ExecEnforcer holds the state of the fanotify-based pre-execution enforcer.
+type ExecEnforcer struct {
+	fd      int
+	logger  data.Logger
+	rules   atomic.Pointer[blockRules]
+	disable atomic.Bool
+	selfID  int32
+	// groupMu guards fd against the hash workers, which answer events of the descriptor the loop may be replacing.
+	groupMu sync.RWMutex
+	// hashJobs holds the exec attempts whose executable must be hashed before they can be answered.
+	hashJobs chan *pendingExec
+}
+
+// pendingExec is an exec attempt waiting for its executable to be hashed.
+type pendingExec struct {
+	// group is the fanotify descriptor the event was read from, which the answer is written to.
+	group   int
+	file    *os.File
+	info    os.FileInfo
+	exePath string
+	pid     int32
+
+	// mu serializes answering the event and closing its descriptor, which the answer refers to by number.
+	mu       sync.Mutex
+	answered bool
+}
+
+// NewExecEnforcer sets up an enforcer that denies execution of blocklisted binaries before they run.
+// It uses fanotify permission events (FAN_OPEN_EXEC_PERM), which require CAP_SYS_ADMIN.
+// If fanotify is unavailable, an error is returned and the caller should fall back to RunBlocklistEnforcer.
+func NewExecEnforcer(appLogger data.Logger) (*ExecEnforcer, error) {
+	fd, marked, err := openFanotify(appLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &ExecEnforcer{fd: fd, logger: appLogger, selfID: int32(os.Getpid()), hashJobs: make(chan *pendingExec, execHashQueue)}
+	e.refreshRules()
+
+	appLogger.Info("Pre-execution enforcer started", "mounts", marked)
+	return e, nil
+}
+
+// openFanotify creates a fanotify descriptor that reports exec attempts on every real filesystem,
+// and returns it with the number of mount points that are watched.
+func openFanotify(appLogger data.Logger) (int, int, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_CONTENT|unix.FAN_CLOEXEC|unix.FAN_UNLIMITED_QUEUE, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return -1, 0, fmt.Errorf("fanotify init: %w", err)
+	}
+
+	mounts, err := execMountPoints()
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, 0, fmt.Errorf("could not list mount points: %w", err)
+	}
+
+	marked := 0
+	for _, mnt := range mounts {
+		if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, unix.FAN_OPEN_EXEC_PERM, unix.AT_FDCWD, mnt); err != nil {
+			appLogger.Warn("Failed to watch mount for exec events", "mount", mnt, "err", err)
+			continue
+		}
+		marked++
+	}
+	if marked == 0 {
+		_ = unix.Close(fd)
+		return -1, 0, errors.New("fanotify: no mount points could be watched")
+	}
+	return fd, marked, nil
+}
+
+// Run answers exec permission events until ctx is cancelled, then closes the fanotify descriptor.
+// Closing it releases the marks, so nothing is blocked once the enforcer has stopped.
+//
+// If the event loop fails, the descriptor is closed and opened again after a delay. When that keeps failing,
+// or the kernel's events can't be read at all, enforcement falls back to RunBlocklistEnforcer, so blocked
+// programs are still killed after they start.
+func (e *ExecEnforcer) Run(ctx context.Context) {
+	go e.watchBlocklist(ctx)
+	for range execHashWorkers {
+		go e.hashWorker(ctx)
+	}
+
+	failures := 0
+	for {
+		started := time.Now()
+		err := e.run(ctx)
+		e.closeFanotify()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) >= enforcerStableRun {
+			failures = 0
+		}
+		failures++
+		if errors.Is(err, errUnsupportedMetadata) || failures > maxEnforcerRestarts {
+			e.logger.Error("Pre-execution enforcer stopped, falling back to polling", "err", err, "failures", failures)
+			RunBlocklistEnforcer(ctx, e.logger)
+			return
+		}
+
+		delay := enforcerRestartDelay << (failures - 1)
+		e.logger.Error("Pre-execution enforcer failed, restarting", "err", err, "failures", failures, "delay", delay)
+		if !e.reopen(ctx, delay) {
+			return
+		}
+	}
+}
+
+// reopen waits for delay and opens a new fanotify descriptor, retrying until it succeeds or ctx is cancelled.
+// It reports whether the enforcer can run again.
+func (e *ExecEnforcer) reopen(ctx context.Context, delay time.Duration) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		fd, marked, err := openFanotify(e.logger)
+		if err == nil {
+			e.groupMu.Lock()
+			e.fd = fd
+			e.groupMu.Unlock()
+			e.logger.Info("Pre-execution enforcer restarted", "mounts", marked)
+			return true
+		}
+		e.logger.Error("Failed to restart pre-execution enforcer", "err", err)
+		delay = min(delay*2, enforcerRestartDelay<<maxEnforcerRestarts)
+	}
+}
+
+// closeFanotify closes the fanotify descriptor. Exec attempts that were still waiting for an answer are allowed.
+func (e *ExecEnforcer) closeFanotify() {
+	e.groupMu.Lock()
+	defer e.groupMu.Unlock()
+	if err := unix.Close(e.fd); err != nil {
+		e.logger.Error("Failed to close fanotify descriptor", "err", err)
+	}
+	// Once closed, the number may be reused by any file, which a late answer must not be written to.
+	e.fd = -1
+}
+
+// execMountPoints returns the mount points of all real filesystems, read from /proc/self/mounts.
+func execMountPoints() ([]string, error) {
+	content, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var mounts []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] {
+			continue
+		}
+		// Mount points escape spaces and other special characters as octal sequences.
+		mnt := unescapeMountPath(fields[1])
+		if !seen[mnt] {
+			seen[mnt] = true
+			mounts = append(mounts, mnt)
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (e.g. "\040" for a space) used in /proc/self/mounts.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// refreshRules reloads the blocklist and enforcement mode. On failure, the previous rules stay in effect.
+func (e *ExecEnforcer) refreshRules() {
+	e.disable.Store(disablesExecutables(e.logger))
+
+	list, err := data.LoadAppBlocklist()
+	if err != nil {
+		e.logger.Error("failed to fetch blocklist", "err", err)
+		// A tampered blocklist still comes with the last list that can be trusted, which stays enforced.
+		if !errors.Is(err, data.ErrPolicyTampered) {
+			if e.rules.Load() == nil {
+				e.rules.Store(newBlockRules(nil))
+			}
+			return
+		}
+	}
+	e.rules.Store(newBlockRules(list))
+}
+
+// watchBlocklist periodically reloads the blocklist so that permission decisions never wait on disk I/O.
+func (e *ExecEnforcer) watchBlocklist(ctx context.Context) {
+	ticker := time.NewTicker(blocklistEnforceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.refreshRules()
+		}
+	}
+}
+
+// run reads permission events from the fanotify descriptor and answers each one until ctx is cancelled,
+// in which case it returns nil, or until reading events fails, in which case it returns the error.
+// Every event must be answered, otherwise the process trying to execute the file hangs.
+func (e *ExecEnforcer) run(ctx context.Context) error {
+	buf := make([]byte, 4096)
+	metaSize := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	fds := []unix.PollFd{{Fd: int32(e.fd), Events: unix.POLLIN}}
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Poll with a timeout rather than blocking in read, so cancellation is noticed promptly.
+		ready, err := unix.Poll(fds, pollTimeoutMillis)
+		if err != nil && err != unix.EINTR {
+			return fmt.Errorf("poll: %w", err)
+		}
+		if ready <= 0 {
+			continue
+		}
+
+		n, err := unix.Read(e.fd, buf)
+		if err != nil {
+			if err == unix.EINTR || err == unix.EAGAIN {
+				continue
+			}
+			return fmt.Errorf("read: %w", err)
+		}
+
+		for offset := 0; offset+metaSize <= n; {
+			var meta unix.FanotifyEventMetadata
+			if err := binary.Read(bytes.NewReader(buf[offset:offset+metaSize]), binary.NativeEndian, &meta); err != nil {
+				e.logger.Error("Failed to decode fanotify event", "err", err)
+				break
+			}
+			if meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+				return fmt.Errorf("%w: %d", errUnsupportedMetadata, meta.Vers)
+			}
+			e.handleEvent(meta)
+			if meta.Event_len == 0 {
+				break
+			}
+			offset += int(meta.Event_len)
+		}
+	}
+}
+
+// handleEvent decides whether a single exec attempt is allowed, replies to the kernel and closes the event's descriptor.
+// An executable that has to be hashed, and isn't in the cache yet, is handed to a hash worker instead,
+// so that reading a large file never holds up the other exec attempts on the system.
+func (e *ExecEnforcer) handleEvent(meta unix.FanotifyEventMetadata) {
+	if meta.Fd == unix.FAN_NOFD {
+		return
+	}
+	file := os.NewFile(uintptr(meta.Fd), "")
+
+	var entry string
+	var info os.FileInfo
+	exePath, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", meta.Fd))
+	if err == nil && meta.Pid != e.selfID {
+		entry, info = e.blockingEntry(file, exePath)
+	}
+	if info == nil {
+		e.decide(e.fd, meta.Fd, exePath, meta.Pid, entry)
+		e.closeEventFile(file)
+		return
+	}
+
+	job := &pendingExec{group: e.fd, file: file, info: info, exePath: exePath, pid: meta.Pid}
+	select {
+	case e.hashJobs <- job:
+		time.AfterFunc(execHashDeadline, func() {
+			if job.answer(e, unix.FAN_ALLOW) {
+				e.logger.Warn("Allowed execution before the executable was hashed", "path", exePath, "pid", meta.Pid)
+			}
+		})
+	default:
+		// Every worker is busy and the queue is full. Waiting would stall every exec on the system.
+		job.answer(e, unix.FAN_ALLOW)
+		e.logger.Warn("Allowed execution without hashing the executable, too many are waiting", "path", exePath, "pid", meta.Pid)
+		job.close(e)
+	}
+}
+
+// decide answers an exec attempt with a denial if entry is set and an allowance otherwise, and records a denial.
+func (e *ExecEnforcer) decide(group int, fd int32, exePath string, pid int32, entry string) {
+	response := uint32(unix.FAN_ALLOW)
+	if entry != "" {
+		response = unix.FAN_DENY
+	}
+	if err := respond(group, fd, response); err != nil {
+		e.logger.Error("Failed to answer fanotify event", "path", exePath, "err", err)
+		return
+	}
+	if response == unix.FAN_DENY {
+		e.logger.Info("Denied execution of blocked program", "path", exePath, "pid", pid)
+		e.recordBlock(entry, exePath, pid, data.BlockActionDenied)
+	}
+}
+
+// recordBlock logs a block event and, in disable mode, disables the executable.
+func (e *ExecEnforcer) recordBlock(entry, exePath string, pid int32, action string) {
+	name := filepath.Base(exePath)
+	data.LogBlockEvent(name, exePath, pid, action)
+	if e.disable.Load() {
+		// Changing the file on disk is slow, so keep it off the event loop.
+		go func() {
+			if err := DisableExecutable(entry, exePath); err != nil {
+				e.logger.Error("Failed to disable executable", "name", name, "err", err)
+			}
+		}()
+	}
+}
+
+// closeEventFile closes the descriptor of an event once it has been answered.
+func (e *ExecEnforcer) closeEventFile(file *os.File) {
+	if err := file.Close(); err != nil {
+		e.logger.Error("Failed to close fanotify event descriptor", "err", err)
+	}
+}
+
+// blockingEntry checks an executable against the current rules. It returns the entry that blocks the executable,
+// or "" if it isn't blocked. If that depends on a hash that isn't cached yet, it returns the file's info instead,
+// for a hash worker to finish the check.
+func (e *ExecEnforcer) blockingEntry(file *os.File, exePath string) (string, os.FileInfo) {
+	rules := e.rules.Load()
+	if rules == nil || rules.empty() {
+		return "", nil
+	}
+	if entry := rules.matchNameOrPath(filepath.Base(exePath), exePath); entry != "" {
+		return entry, nil
+	}
+	if !rules.needsHash() {
+		return "", nil
+	}
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil
+	}
+	if sum, ok := executableHashes.lookup(exePath, info); ok {
+		return rules.matchHash(sum), nil
+	}
+	return "", info
+}
+
+// hashWorker hashes the executables of pending exec attempts and answers them, until ctx is cancelled.
+func (e *ExecEnforcer) hashWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			// Closing the fanotify descriptor allows the attempts still queued; only their descriptors are left.
+			for {
+				select {
+				case job := <-e.hashJobs:
+					job.close(e)
+				default:
+					return
+				}
+			}
+		case job := <-e.hashJobs:
+			e.finishExec(job)
+		}
+	}
+}
+
+// finishExec hashes the executable of a pending exec attempt and answers it. If the deadline has already
+// allowed the program to start and it turns out to be blocked, the process is killed instead.
+func (e *ExecEnforcer) finishExec(job *pendingExec) {
+	defer job.close(e)
+
+	var entry string
+	sum, err := executableHashes.get(job.exePath, job.info, func() (string, error) {
+		// Read through ReadAt so the descriptor's offset is left untouched for the kernel.
+		return hashReader(io.NewSectionReader(job.file, 0, job.info.Size()))
+	})
+	if err != nil {
+		e.logger.Warn("Failed to hash executable", "path", job.exePath, "err", err)
+	} else if rules := e.rules.Load(); rules != nil {
+		entry = rules.matchHash(sum)
+	}
+
+	response := uint32(unix.FAN_ALLOW)
+	if entry != "" {
+		response = unix.FAN_DENY
+	}
+	if job.answer(e, response) {
+		if entry != "" {
+			e.logger.Info("Denied execution of blocked program", "path", job.exePath, "pid", job.pid)
+			e.recordBlock(entry, job.exePath, job.pid, data.BlockActionDenied)
+		}
+		return
+	}
+	if entry != "" {
+		e.killLate(job, entry)
+	}
+}
+
+// killLate kills a process that was allowed to start before its executable was found to be blocked,
+// provided it is still running that executable.
+func (e *ExecEnforcer) killLate(job *pendingExec, entry string) {
+	running, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", job.pid))
+	if err != nil || running != job.exePath {
+		return
+	}
+	if err := unix.Kill(int(job.pid), unix.SIGKILL); err != nil {
+		e.logger.Error("Failed to kill blocked process", "path", job.exePath, "pid", job.pid, "err", err)
+		return
+	}
+	e.logger.Info("Killed blocked process", "path", job.exePath, "pid", job.pid)
+	e.recordBlock(entry, job.exePath, job.pid, data.BlockActionKilled)
+}
+
+// answer replies to the exec attempt unless it has been answered already, and reports whether it did.
+func (p *pendingExec) answer(e *ExecEnforcer, response uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.answered {
+		return false
+	}
+	p.answered = true
+	e.groupMu.RLock()
+	defer e.groupMu.RUnlock()
+	if p.group != e.fd {
+		// The descriptor the event came from has been closed, which allowed the attempt.
+		return false
+	}
+	if err := respond(p.group, int32(p.file.Fd()), response); err != nil {
+		e.logger.Error("Failed to answer fanotify event", "path", p.exePath, "err", err)
+	}
+	return true
+}
+
+// close closes the descriptor of the exec attempt. It must only be called once the attempt can't be answered
+// any more by anyone else, or after answer, which it waits for.
+func (p *pendingExec) close(e *ExecEnforcer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.answered = true
+	e.closeEventFile(p.file)
+}
+
+// respond writes a permission decision for the given event descriptor back to the fanotify descriptor group.
+func respond(group int, fd int32, response uint32) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, unix.FanotifyResponse{Fd: fd, Response: response}); err != nil {
+		return err
+	}
+	_, err := unix.Write(group, buf.Bytes())
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package app
+
+import (
+	"context"
+	"errors"
+	"procguard/internal/data"
+)
+
+// ExecEnforcer is only implemented on Linux, where fanotify can deny execution before a program starts.
+type ExecEnforcer struct{}
+
+// NewExecEnforcer always returns an error on this platform so that the caller falls back to RunBlocklistEnforcer.
+func NewExecEnforcer(appLogger data.Logger) (*ExecEnforcer, error) {
+	return nil, errors.New("pre-execution blocking is not supported on this platform")
+}
+
+// Run does nothing on this platform.
+func (e *ExecEnforcer) Run(ctx context.Context) {}
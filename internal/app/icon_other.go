@@ -0,0 +1,8 @@
+//go:build !windows
+
+package app
+
+// GetAppIconAsBase64 returns no icon on this platform, where executables don't embed one.
+func GetAppIconAsBase64(exePath string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package app
+
+// GetProcessIntegrityLevel always returns 0 on this platform, which has no integrity levels,
+// so that no process is skipped because of its level.
+func GetProcessIntegrityLevel(pid uint32) (uint32, error) {
+	return 0, nil
+}
@@ -1,13 +1,11 @@
 package app
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"procguard/internal/data"
-	"slices"
-	"strings"
-	"syscall"
 	"time"
-	"unsafe"
 
 	"github.com/shirou/gopsutil/v3/process"
 )
@@ -15,92 +13,118 @@ import (
 const (
 	processCheckInterval     = 2 * time.Second
 	blocklistEnforceInterval = 2 * time.Second
+	// heartbeatInterval is how often the daemon records that it is still running. Sessions interrupted
+	// by a crash or power loss are closed at the last heartbeat, so at most this much usage is lost.
+	heartbeatInterval = 30 * time.Second
+	// clockJumpThreshold is how much longer than processCheckInterval the wall clock may advance between
+	// two polls before the gap is treated as the system having been suspended.
+	clockJumpThreshold = 30 * time.Second
 )
 
-var (
-	user32                       = syscall.NewLazyDLL("user32.dll")
-	procEnumWindows              = user32.NewProc("EnumWindows")
-	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
-	procIsWindowVisible          = user32.NewProc("IsWindowVisible")
-
-	enumWindowsCallback = syscall.NewCallback(func(hwnd syscall.Handle, lParam uintptr) uintptr {
-		//nolint:govet
-		params := (*enumWindowsParams)(unsafe.Pointer(lParam))
-		var windowPid uint32
-		_, _, err := procGetWindowThreadProcessId.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&windowPid)))
-		if err != syscall.Errno(0) {
-			return 1 // Continue on error
+// RunProcessEventLogger monitors process creation and termination events until ctx is cancelled.
+// It also records the daemon's heartbeats and splits sessions when the system is suspended,
+// so that neither a crash nor time spent asleep is counted as usage.
+func RunProcessEventLogger(ctx context.Context, appLogger data.Logger, db *sql.DB) {
+	// runningProcs stores the processes we are currently tracking, keyed by PID.
+	runningProcs := make(map[int32]trackedProcess)
+	// Initialize the map with currently running processes that should be tracked.
+	previous, err := data.PreviousDaemonRun(db)
+	if err != nil {
+		appLogger.Error("Failed to read the last heartbeat", "err", err)
+	} else if !previous.LastSeen.IsZero() && !previous.Stopped {
+		appLogger.Warn("The previous run did not shut down cleanly", "last_seen", previous.LastSeen)
+	}
+	initializeRunningProcs(runningProcs, db, previous.LastSeen)
+
+	runStart := time.Now()
+	data.StartHeartbeat(runStart)
+	defer func() { data.StopHeartbeat(runStart, time.Now()) }()
+
+	ticker := time.NewTicker(processCheckInterval)
+	defer ticker.Stop()
+
+	lastTick, lastBeat := runStart, runStart
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
 		}
 
-		if windowPid == params.pid {
-			if isVisible, _, _ := procIsWindowVisible.Call(uintptr(hwnd)); isVisible != 0 {
-				params.found = true
-				return 0 // Stop enumeration
-			}
+		// The monotonic clock doesn't advance while the system sleeps, so only the wall clock shows the gap.
+		gap := now.Round(0).Sub(lastTick.Round(0))
+		if gap < 0 || gap > processCheckInterval+clockJumpThreshold {
+			handleClockJump(appLogger, runningProcs, lastTick, now)
+			lastBeat = time.Time{}
+		}
+		lastTick = now
+		if now.Sub(lastBeat) >= heartbeatInterval {
+			data.RecordHeartbeat(runStart, now)
+			lastBeat = now
 		}
-		return 1 // Continue
-	})
-)
 
-type enumWindowsParams struct {
-	pid   uint32
-	found bool
-}
+		procs, err := process.Processes()
+		if err != nil {
+			appLogger.Error("Failed to get processes", "err", err)
+			continue
+		}
+
+		currentProcs := make(map[int32]bool)
+		for _, p := range procs {
+			currentProcs[p.Pid] = true
+		}
 
-// hasVisibleWindow checks if a process with the given PID has a visible window.
-func hasVisibleWindow(pid uint32) bool {
-	params := &enumWindowsParams{pid: pid, found: false}
-	_, _, err := procEnumWindows.Call(enumWindowsCallback, uintptr(unsafe.Pointer(params)))
-	if err != syscall.Errno(0) {
-		data.GetLogger().Printf("Error enumerating windows: %v", err)
+		logEndedProcesses(appLogger, db, runningProcs, currentProcs)
+		logNewProcesses(appLogger, db, runningProcs, procs)
 	}
-	return params.found
 }
 
-// StartProcessEventLogger starts a long-running goroutine that monitors process creation and termination events.
-func StartProcessEventLogger(appLogger data.Logger, db *sql.DB) {
-	go func() {
-		// runningProcs stores the PIDs of processes we are currently tracking.
-		runningProcs := make(map[int32]bool)
-		// Initialize the map with currently running processes that should be tracked.
-		initializeRunningProcs(runningProcs, db)
-
-		ticker := time.NewTicker(processCheckInterval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			procs, err := process.Processes()
-			if err != nil {
-				appLogger.Printf("Failed to get processes: %v", err)
-				continue
-			}
+// handleClockJump closes every tracked session at lastTick, the last poll before the wall clock jumped,
+// so the next poll starts new ones. A forward jump means the system was suspended, which is recorded
+// with a pair of suspend and resume markers; a backward jump means the clock was set back.
+func handleClockJump(appLogger data.Logger, runningProcs map[int32]trackedProcess, lastTick, now time.Time) {
+	if now.Round(0).Before(lastTick.Round(0)) {
+		appLogger.Warn("System clock moved backwards, restarting sessions", "from", lastTick, "to", now)
+	} else {
+		appLogger.Info("System resumed from suspend", "suspended_at", lastTick, "resumed_at", now)
+		data.RecordPowerEvent(data.PowerEventSuspend, lastTick)
+		data.RecordPowerEvent(data.PowerEventResume, now)
+	}
+	for pid, tracked := range runningProcs {
+		closeSession(pid, tracked, lastTick.Unix())
+		delete(runningProcs, pid)
+	}
+}
 
-			currentProcs := make(map[int32]bool)
-			for _, p := range procs {
-				currentProcs[p.Pid] = true
-			}
+// trackedProcess is a process session that has been logged and has not ended yet.
+type trackedProcess struct {
+	name      string
+	startTime int64
+}
 
-			logEndedProcesses(appLogger, db, runningProcs, currentProcs)
-			logNewProcesses(appLogger, db, runningProcs, procs)
-		}
-	}()
+// closeSession sets the end time of a tracked session and adds it to the daily rollups.
+// A session never ends before it started, even if the clock says otherwise.
+func closeSession(pid int32, tracked trackedProcess, endTime int64) {
+	endTime = max(endTime, tracked.startTime)
+	data.EndAppSession(pid, tracked.name, tracked.startTime, endTime)
 }
 
 // logEndedProcesses checks for processes that have terminated and updates their end time in the database.
-func logEndedProcesses(appLogger data.Logger, db *sql.DB, runningProcs, currentProcs map[int32]bool) {
-	for pid := range runningProcs {
+func logEndedProcesses(appLogger data.Logger, db *sql.DB, runningProcs map[int32]trackedProcess, currentProcs map[int32]bool) {
+	for pid, tracked := range runningProcs {
 		if !currentProcs[pid] {
-			// Process has ended. Update its end_time in the DB.
-			data.EnqueueWrite("UPDATE app_events SET end_time = ? WHERE pid = ? AND end_time IS NULL", time.Now().Unix(), pid)
+			// Process has ended. Update its end_time in the DB and add the session to the daily rollups.
+			closeSession(pid, tracked, time.Now().Unix())
 			delete(runningProcs, pid)
 		}
 	}
 }
 
 // logNewProcesses checks for new processes and logs them to the database if they should be tracked.
-func logNewProcesses(appLogger data.Logger, db *sql.DB, runningProcs map[int32]bool, procs []*process.Process) {
+func logNewProcesses(appLogger data.Logger, db *sql.DB, runningProcs map[int32]trackedProcess, procs []*process.Process) {
 	for _, p := range procs {
-		if !runningProcs[p.Pid] {
+		if _, tracked := runningProcs[p.Pid]; !tracked {
 			// This is a new process. Check if we should log it.
 			if shouldLogProcess(p) {
 				name, _ := p.Name()
@@ -112,11 +136,13 @@ func logNewProcesses(appLogger data.Logger, db *sql.DB, runningProcs map[int32]b
 
 				exePath, err := p.Exe()
 				if err != nil {
-					appLogger.Printf("Failed to get exe path for %s (pid %d): %v", name, p.Pid, err)
+					appLogger.Debug("Failed to get exe path", "name", name, "pid", p.Pid, "err", err)
 				}
-				data.EnqueueWrite("INSERT INTO app_events (process_name, pid, parent_process_name, exe_path, start_time) VALUES (?, ?, ?, ?, ?)",
-					name, p.Pid, parentName, exePath, time.Now().Unix())
-				runningProcs[p.Pid] = true
+				// The command line is only indexed for search, so failing to read it (e.g. for elevated processes) is not worth logging.
+				cmdline, _ := p.Cmdline()
+				startTime := time.Now().Unix()
+				data.LogAppEvent(name, p.Pid, parentName, exePath, cmdline, startTime)
+				runningProcs[p.Pid] = trackedProcess{name: name, startTime: startTime}
 			}
 		}
 	}
@@ -124,69 +150,122 @@ func logNewProcesses(appLogger data.Logger, db *sql.DB, runningProcs map[int32]b
 
 // initializeRunningProcs pre-populates the runningProcs map with processes
 // that are already in the database without an end_time.
-func initializeRunningProcs(runningProcs map[int32]bool, db *sql.DB) {
-	rows, err := db.Query("SELECT pid FROM app_events WHERE end_time IS NULL")
+// Sessions whose process has exited, or whose PID now belongs to a newer process, were interrupted
+// while the daemon wasn't running, so they are closed at lastSeen, the last moment it was.
+func initializeRunningProcs(runningProcs map[int32]trackedProcess, db *sql.DB, lastSeen time.Time) {
+	rows, err := db.Query("SELECT pid, process_name, start_time FROM app_events WHERE end_time IS NULL")
 	if err != nil {
 		return
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close rows: %v", err)
+			data.GetLogger().Error("Failed to close rows", "err", err)
 		}
 	}()
 
 	for rows.Next() {
 		var pid int32
-		if err := rows.Scan(&pid); err == nil {
-			// Verify the process is still running
-			if exists, _ := process.PidExists(pid); exists {
-				runningProcs[pid] = true
+		var tracked trackedProcess
+		if err := rows.Scan(&pid, &tracked.name, &tracked.startTime); err == nil {
+			// Verify the same process is still running
+			if sameProcessRunning(pid, tracked.startTime) {
+				runningProcs[pid] = tracked
 			} else {
-				// Process is not running, so it should have been marked as ended.
-				// This handles cases where the daemon was stopped abruptly.
-				data.EnqueueWrite("UPDATE app_events SET end_time = ? WHERE pid = ? AND end_time IS NULL", time.Now().Unix(), pid)
+				// Without any record of when the daemon stopped, the session can only be closed where it began.
+				closeSession(pid, tracked, lastSeen.Unix())
 			}
 		}
 	}
 }
 
-// StartBlocklistEnforcer starts a long-running goroutine that periodically checks for and kills blocked processes.
-func StartBlocklistEnforcer(appLogger data.Logger) {
-	go func() {
-		killTick := time.NewTicker(blocklistEnforceInterval)
-		defer killTick.Stop()
-		for range killTick.C {
-			list, err := data.LoadAppBlocklist()
-			if err != nil {
-				appLogger.Printf("failed to fetch blocklist: %v", err)
-				continue
-			}
-			if len(list) == 0 {
-				continue
-			}
-			procs, err := process.Processes()
-			if err != nil {
-				appLogger.Printf("Failed to get processes: %v", err)
-				continue
-			}
-			for _, p := range procs {
-				name, _ := p.Name()
-				if name == "" {
-					continue // Skip processes with no name
-				}
+// sameProcessRunning reports whether pid belongs to a running process that was created by startTime,
+// rather than to a process that reused the PID later, for example after a reboot.
+func sameProcessRunning(pid int32, startTime int64) bool {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return false
+	}
+	created, err := p.CreateTime()
+	if err != nil {
+		// The creation time of some processes can't be read; trust the PID as before.
+		return true
+	}
+	return created/1000 <= startTime
+}
 
-				// Enforce the blocklist by killing any process whose name is in the list.
-				if slices.Contains(list, strings.ToLower(name)) {
-					err := p.Kill()
-					if err != nil {
-						appLogger.Printf("failed to kill %s (pid %d): %v", name, p.Pid, err)
-					} else {
-						appLogger.Printf("killed blocked process %s (pid %d)", name, p.Pid)
-					}
-				}
+// RunBlocklistEnforcer periodically checks for and kills blocked processes until ctx is cancelled.
+func RunBlocklistEnforcer(ctx context.Context, appLogger data.Logger) {
+	killTick := time.NewTicker(blocklistEnforceInterval)
+	defer killTick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-killTick.C:
+			killBlockedProcesses(appLogger)
+		}
+	}
+}
+
+// killBlockedProcesses kills every running process whose name, path or hash is on the blocklist.
+func killBlockedProcesses(appLogger data.Logger) {
+	list, err := data.LoadAppBlocklist()
+	if err != nil {
+		appLogger.Error("failed to fetch blocklist", "err", err)
+		// A tampered blocklist still comes with the last list that can be trusted, which stays enforced.
+		if !errors.Is(err, data.ErrPolicyTampered) {
+			return
+		}
+	}
+	rules := newBlockRules(list)
+	if rules.empty() {
+		return
+	}
+	disable := disablesExecutables(appLogger)
+	procs, err := process.Processes()
+	if err != nil {
+		appLogger.Error("Failed to get processes", "err", err)
+		return
+	}
+	for _, p := range procs {
+		name, _ := p.Name()
+		if name == "" {
+			continue // Skip processes with no name
+		}
+
+		exePath, _ := p.Exe()
+		entry := blockingEntry(rules, name, exePath)
+		if entry == "" {
+			continue
+		}
+		if err := p.Kill(); err != nil {
+			appLogger.Error("Failed to kill blocked process", "name", name, "pid", p.Pid, "err", err)
+			continue
+		}
+		appLogger.Info("Killed blocked process", "name", name, "pid", p.Pid)
+		data.LogBlockEvent(name, exePath, p.Pid, data.BlockActionKilled)
+		if disable {
+			if err := DisableExecutable(entry, exePath); err != nil {
+				appLogger.Error("Failed to disable executable", "name", name, "err", err)
 			}
 		}
-	}()
+	}
+}
+
+// blockingEntry checks a running process against the blocklist rules, hashing its executable only when needed.
+// It returns the entry that blocks the process, or "" if it isn't blocked.
+func blockingEntry(rules *blockRules, name, exePath string) string {
+	if entry := rules.matchNameOrPath(name, exePath); entry != "" {
+		return entry
+	}
+	if !rules.needsHash() || exePath == "" {
+		return ""
+	}
+	sum, err := hashFile(exePath)
+	if err != nil {
+		return ""
+	}
+	return rules.matchHash(sum)
 }
 
 // shouldLogProcess determines if a process should be logged based on a set of heuristics
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package app
+
+// hasVisibleWindow always reports false on this platform, where windows can't be attributed to a process
+// without a display server. Processes are filtered by the remaining heuristics of shouldLogProcess instead.
+func hasVisibleWindow(pid uint32) bool {
+	return false
+}
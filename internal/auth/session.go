@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// sessionTokenSize is the number of random bytes in a session token.
+const sessionTokenSize = 32
+
+// SessionStore keeps the sessions of logged-in clients on the server. A client only holds the random token
+// of its session, so logging out or changing the password can end sessions without the client's cooperation.
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	// idleTimeout ends a session that hasn't been used for that long.
+	idleTimeout time.Duration
+	// maxLifetime ends a session that long after it was created, however much it is used.
+	maxLifetime time.Duration
+}
+
+// session is the server-side state of a session.
+type session struct {
+	createdAt  time.Time
+	lastUsedAt time.Time
+	// csrfToken must accompany the requests of the session that another site could forge, such as form posts.
+	// Unlike the session token, it is given to the page's scripts.
+	csrfToken string
+}
+
+// NewSessionStore creates an empty store whose sessions expire after idleTimeout without use,
+// and at the latest maxLifetime after they were created.
+func NewSessionStore(idleTimeout, maxLifetime time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions:    make(map[string]*session),
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// MaxLifetime returns how long a session lasts at most.
+func (s *SessionStore) MaxLifetime() time.Duration {
+	return s.maxLifetime
+}
+
+// randomToken returns a new random token of sessionTokenSize bytes, encoded for use in cookies and headers.
+func randomToken() (string, error) {
+	b := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create starts a new session and returns its token.
+func (s *SessionStore) Create() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Expired sessions are dropped here, so clients that never log out don't pile up.
+	now := s.pruneLocked()
+	s.sessions[token] = &session{createdAt: now, lastUsedAt: now, csrfToken: csrfToken}
+	return token, nil
+}
+
+// Validate reports whether token belongs to a session that has not expired, and records that it was used.
+func (s *SessionStore) Validate(token string) bool {
+	_, ok := s.CSRFToken(token)
+	return ok
+}
+
+// CSRFToken returns the CSRF token of the session of token, and records that the session was used.
+// It reports false if there is no such session or it has expired.
+func (s *SessionStore) CSRFToken(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if s.expired(sess, now) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	sess.lastUsedAt = now
+	return sess.csrfToken, true
+}
+
+// Revoke ends the session of token, if there is one.
+func (s *SessionStore) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// RevokeAll ends every session, for example when the password is changed.
+func (s *SessionStore) RevokeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.sessions)
+}
+
+// Active drops the sessions that have expired and returns the number of sessions left.
+func (s *SessionStore) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	return len(s.sessions)
+}
+
+// pruneLocked drops the sessions that have expired and returns the time it checked them against.
+// s.mu must be held.
+func (s *SessionStore) pruneLocked() time.Time {
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, t)
+		}
+	}
+	return now
+}
+
+// expired reports whether sess has expired at now.
+func (s *SessionStore) expired(sess *session, now time.Time) bool {
+	return now.Sub(sess.lastUsedAt) > s.idleTimeout || now.Sub(sess.createdAt) > s.maxLifetime
+}
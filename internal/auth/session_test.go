@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testIdleTimeout = 30 * time.Minute
+	testMaxLifetime = 12 * time.Hour
+)
+
+// backdate moves the creation and last use of the session of token back by created and used.
+func backdate(t *testing.T, s *SessionStore, token string, created, used time.Duration) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		t.Fatalf("no session for %q", token)
+	}
+	sess.createdAt = sess.createdAt.Add(-created)
+	sess.lastUsedAt = sess.lastUsedAt.Add(-used)
+}
+
+// createSession creates a session in s and fails the test if that doesn't work.
+func createSession(t *testing.T, s *SessionStore) string {
+	t.Helper()
+	token, err := s.Create()
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return token
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name string
+		// created and used are how long ago the session was created and last used.
+		created, used time.Duration
+		want          bool
+	}{
+		{name: "new", want: true},
+		{name: "used just before the idle timeout", created: time.Hour, used: testIdleTimeout - time.Minute, want: true},
+		{name: "idle too long", created: time.Hour, used: testIdleTimeout + time.Minute},
+		{name: "used just before the end of its lifetime", created: testMaxLifetime - time.Minute, want: true},
+		{name: "past its lifetime despite being used", created: testMaxLifetime + time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSessionStore(testIdleTimeout, testMaxLifetime)
+			token := createSession(t, s)
+			backdate(t, s, token, tt.created, tt.used)
+
+			if got := s.Validate(token); got != tt.want {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+			// An expired session is gone for good, and a valid one was just used.
+			if got := s.Validate(token); got != tt.want {
+				t.Errorf("second Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionUseDelaysIdleTimeout(t *testing.T) {
+	s := NewSessionStore(testIdleTimeout, testMaxLifetime)
+	token := createSession(t, s)
+
+	// Two idle periods that each end just before the timeout keep the session alive.
+	backdate(t, s, token, testIdleTimeout-time.Minute, testIdleTimeout-time.Minute)
+	if !s.Validate(token) {
+		t.Fatal("Validate() = false before the idle timeout")
+	}
+	backdate(t, s, token, testIdleTimeout-time.Minute, testIdleTimeout-time.Minute)
+	if !s.Validate(token) {
+		t.Error("Validate() = false although the session was used within the idle timeout")
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	s := NewSessionStore(testIdleTimeout, testMaxLifetime)
+	first, second := createSession(t, s), createSession(t, s)
+	if first == second {
+		t.Fatal("two sessions have the same token")
+	}
+
+	csrf, ok := s.CSRFToken(first)
+	if !ok || csrf == "" || csrf == first {
+		t.Errorf("CSRFToken() = %q, %v, want a token of its own", csrf, ok)
+	}
+	if other, _ := s.CSRFToken(second); other == csrf {
+		t.Error("two sessions have the same CSRF token")
+	}
+	for _, token := range []string{"", "unknown"} {
+		if s.Validate(token) {
+			t.Errorf("Validate(%q) = true", token)
+		}
+	}
+}
+
+func TestSessionRevoke(t *testing.T) {
+	s := NewSessionStore(testIdleTimeout, testMaxLifetime)
+	first, second, third := createSession(t, s), createSession(t, s), createSession(t, s)
+
+	s.Revoke(first)
+	if s.Validate(first) {
+		t.Error("revoked session is still valid")
+	}
+	if !s.Validate(second) || !s.Validate(third) {
+		t.Error("Revoke ended other sessions")
+	}
+	if got := s.Active(); got != 2 {
+		t.Errorf("Active() = %d after Revoke, want 2", got)
+	}
+
+	s.RevokeAll()
+	if s.Validate(second) || s.Validate(third) {
+		t.Error("a session is still valid after RevokeAll")
+	}
+	if got := s.Active(); got != 0 {
+		t.Errorf("Active() = %d after RevokeAll, want 0", got)
+	}
+
+	// The store keeps working after RevokeAll.
+	if token := createSession(t, s); !s.Validate(token) {
+		t.Error("a session created after RevokeAll is not valid")
+	}
+}
+
+func TestSessionActive(t *testing.T) {
+	s := NewSessionStore(testIdleTimeout, testMaxLifetime)
+	if got := s.Active(); got != 0 {
+		t.Errorf("Active() = %d for a new store, want 0", got)
+	}
+
+	idle, old, live := createSession(t, s), createSession(t, s), createSession(t, s)
+	backdate(t, s, idle, time.Hour, testIdleTimeout+time.Minute)
+	backdate(t, s, old, testMaxLifetime+time.Minute, 0)
+
+	// Sessions that expired without anyone using them again are not counted.
+	if got := s.Active(); got != 1 {
+		t.Errorf("Active() = %d, want 1", got)
+	}
+	if !s.Validate(live) {
+		t.Error("the live session was dropped")
+	}
+	s.Revoke(live)
+	if got := s.Active(); got != 0 {
+		t.Errorf("Active() = %d after the last session was revoked, want 0", got)
+	}
+}
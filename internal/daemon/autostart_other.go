@@ -0,0 +1,18 @@
+//go:build !windows
+
+package daemon
+
+import "errors"
+
+// errAutostartUnsupported is returned when autostart is enabled on a platform that doesn't implement it.
+var errAutostartUnsupported = errors.New("autostart is only supported on Windows")
+
+// EnsureAutostart is not implemented on this platform, where ProcGuard is started by the service manager instead.
+func EnsureAutostart() (string, error) {
+	return "", errAutostartUnsupported
+}
+
+// RemoveAutostart does nothing on this platform, since EnsureAutostart never sets anything up.
+func RemoveAutostart() error {
+	return nil
+}
@@ -1,3 +1,5 @@
+//go:build windows
+
 package daemon
 
 import (
@@ -28,7 +30,7 @@ func EnsureAutostart() (string, error) {
 	}
 	defer func() {
 		if err := key.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close registry key: %v", err)
+			data.GetLogger().Error("Failed to close registry key", "err", err)
 		}
 	}()
 
@@ -44,14 +46,12 @@ func EnsureAutostart() (string, error) {
 	}
 
 	// Update the config file to reflect the change in autostart status.
-	cfg, err := data.LoadConfig()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to load config to update autostart status:", err)
-	} else {
+	err = data.UpdateConfig(func(cfg *data.Config) error {
 		cfg.AutostartEnabled = true
-		if err := cfg.Save(); err != nil {
-			fmt.Fprintln(os.Stderr, "Failed to save config to update autostart status:", err)
-		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save config to update autostart status:", err)
 	}
 
 	return destPath, nil
@@ -68,7 +68,7 @@ func RemoveAutostart() error {
 	}
 	defer func() {
 		if err := key.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close registry key: %v", err)
+			data.GetLogger().Error("Failed to close registry key", "err", err)
 		}
 	}()
 
@@ -78,14 +78,12 @@ func RemoveAutostart() error {
 	}
 
 	// Update the config file to reflect the change in autostart status.
-	cfg, err := data.LoadConfig()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to load config to update autostart status:", err)
-	} else {
+	err = data.UpdateConfig(func(cfg *data.Config) error {
 		cfg.AutostartEnabled = false
-		if err := cfg.Save(); err != nil {
-			fmt.Fprintln(os.Stderr, "Failed to save config to update autostart status:", err)
-		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save config to update autostart status:", err)
 	}
 
 	return nil
@@ -121,7 +119,7 @@ func copyExecutableToAppData() (string, error) {
 	}
 	defer func() {
 		if err := sourceFile.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close source file: %v", err)
+			data.GetLogger().Error("Failed to close source file", "err", err)
 		}
 	}()
 
@@ -131,7 +129,7 @@ func copyExecutableToAppData() (string, error) {
 	}
 	defer func() {
 		if err := destFile.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close destination file: %v", err)
+			data.GetLogger().Error("Failed to close destination file", "err", err)
 		}
 	}()
 
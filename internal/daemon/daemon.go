@@ -1,16 +1,48 @@
 package daemon
 
 import (
+	"context"
 	"database/sql"
 	"procguard/internal/app"
 	"procguard/internal/data"
+	"sync"
 )
 
-// StartDaemon runs the core daemon logic as long-running background services.
-func StartDaemon(appLogger data.Logger, db *sql.DB) {
-	// Start the process event logger to monitor process creation and termination.
-	app.StartProcessEventLogger(appLogger, db)
+// StartDaemon runs the core daemon logic as long-running background services until ctx is cancelled.
+// The returned channel is closed once every service has stopped.
+func StartDaemon(ctx context.Context, appLogger data.Logger, db *sql.DB) <-chan struct{} {
+	var wg sync.WaitGroup
 
-	// Start the blocklist enforcer to kill blocked processes.
-	app.StartBlocklistEnforcer(appLogger)
+	// Lock the policy files, which are otherwise only locked again when they are saved.
+	if err := data.LockPolicyFiles(); err != nil {
+		appLogger.Warn("Failed to lock the policy files", "err", err)
+	}
+
+	// Monitor process creation and termination.
+	wg.Go(func() { app.RunProcessEventLogger(ctx, appLogger, db) })
+
+	// Prune data that is older than the configured retention periods.
+	wg.Go(func() { data.RunRetentionPruner(ctx, appLogger, db) })
+
+	// Write scheduled backups, if they are enabled.
+	wg.Go(func() { data.RunBackupScheduler(ctx, appLogger, db) })
+
+	// Remove temporary blocks once they expire.
+	wg.Go(func() { app.RunBlocklistSweeper(ctx, appLogger) })
+
+	// Prefer denying blocked programs before they start. When that is not possible
+	// (unsupported platform or insufficient privileges), kill them after launch instead.
+	if enforcer, err := app.NewExecEnforcer(appLogger); err != nil {
+		appLogger.Warn("Pre-execution enforcer unavailable, falling back to polling", "err", err)
+		wg.Go(func() { app.RunBlocklistEnforcer(ctx, appLogger) })
+	} else {
+		wg.Go(func() { enforcer.Run(ctx) })
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
 }
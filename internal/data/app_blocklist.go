@@ -2,12 +2,9 @@ package data
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"slices"
-	"strings"
 	"time"
 )
 
@@ -17,156 +14,138 @@ const appBlocklistFile = "blocklist.json"
 type AppDetails struct {
 	Name    string `json:"name"`
 	ExePath string `json:"exe_path"`
+	BlocklistMeta
+	// RemainingSeconds is how long a temporary block still applies. It is omitted for permanent blocks.
+	RemainingSeconds int64 `json:"remaining_seconds,omitempty"`
 }
 
 // GetBlockedAppsWithDetails loads the blocklist and enriches it with the latest executable path from the database.
 // This provides more context to the user in the UI.
 func GetBlockedAppsWithDetails(db *sql.DB) ([]AppDetails, error) {
-	names, err := LoadAppBlocklist()
+	entries, err := LoadAppBlocklistEntries()
 	if err != nil {
-		return nil, fmt.Errorf("could not load app blocklist names: %w", err)
+		return nil, fmt.Errorf("could not load app blocklist entries: %w", err)
 	}
 
-	if len(names) == 0 {
-		return []AppDetails{}, nil
-	}
-
-	details := make([]AppDetails, 0, len(names))
-	for _, name := range names {
-		var exePath string
+	now := time.Now()
+	details := make([]AppDetails, 0, len(entries))
+	for _, entry := range entries {
 		// Find the most recent exe_path for the given process name to show the user the location of the blocked app.
-		err := db.QueryRow("SELECT exe_path FROM app_events WHERE process_name = ? AND exe_path IS NOT NULL ORDER BY start_time DESC LIMIT 1", name).Scan(&exePath)
+		exePath, err := GetLatestExePath(db, entry.Name)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				// If no path is found in the database, we can still return the name.
-				exePath = ""
-			} else {
-				// For other errors, log them but continue building the list.
-				GetLogger().Printf("Error querying exe_path for %s: %v", name, err)
-				exePath = ""
-			}
+			// Log the error but continue building the list.
+			GetLogger().Error("Error querying exe_path", "app", entry.Name, "err", err)
 		}
-		details = append(details, AppDetails{Name: name, ExePath: exePath})
+		details = append(details, AppDetails{
+			Name:             entry.Name,
+			ExePath:          exePath,
+			BlocklistMeta:    entry.BlocklistMeta,
+			RemainingSeconds: int64(entry.Remaining(now).Seconds()),
+		})
 	}
 
 	return details, nil
 }
 
-// LoadAppBlocklist reads the blocklist file from the user's cache directory.
-// It returns a slice of strings, with all entries normalized to lowercase for case-insensitive matching.
-// If the file doesn't exist, it returns an empty list, which is not considered an error.
-func LoadAppBlocklist() ([]string, error) {
-	cacheDir, _ := os.UserCacheDir()
-	p := filepath.Join(cacheDir, "procguard", appBlocklistFile)
-
-	b, err := os.ReadFile(p)
-	if os.IsNotExist(err) {
-		return nil, nil // File not existing is not an error, just an empty list.
+// GetLatestExePath returns the most recently recorded executable path for a process name.
+// If the process has never been seen with a path, or the path is encrypted and the data is locked,
+// it returns an empty string and no error.
+func GetLatestExePath(db *sql.DB, name string) (string, error) {
+	var exePath string
+	err := db.QueryRow("SELECT exe_path FROM app_events WHERE process_name = ? COLLATE NOCASE AND exe_path IS NOT NULL AND exe_path != '' ORDER BY start_time DESC LIMIT 1", name).Scan(&exePath)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	var list []string
-	if err := json.Unmarshal(b, &list); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal blocklist: %w", err)
+	exePath, err = openField(exePath)
+	if errors.Is(err, ErrEncryptionLocked) {
+		return "", nil
 	}
+	return exePath, err
+}
 
-	// Normalize all entries to lowercase to ensure case-insensitive comparison.
-	for i := range list {
-		list[i] = strings.ToLower(list[i])
-	}
-	return list, nil
+// LoadAppBlocklist returns the names on the app blocklist.
+// All entries are normalized to lowercase for case-insensitive matching.
+func LoadAppBlocklist() ([]string, error) {
+	return appBlocklistTable.names()
 }
 
-// SaveAppBlocklist writes the given list of strings to the blocklist file.
-// It normalizes all entries to lowercase before saving to ensure consistency.
-// It also sets appropriate file permissions to secure the file.
-func SaveAppBlocklist(list []string) error {
-	// Normalize all entries to lowercase to ensure consistency.
-	for i := range list {
-		list[i] = strings.ToLower(list[i])
-	}
+// LoadAppBlocklistEntries returns every entry on the app blocklist together with its metadata.
+func LoadAppBlocklistEntries() ([]BlocklistEntry, error) {
+	return appBlocklistTable.entries()
+}
 
-	cacheDir, _ := os.UserCacheDir()
-	_ = os.MkdirAll(filepath.Join(cacheDir, "procguard"), 0755)
-	p := filepath.Join(cacheDir, "procguard", appBlocklistFile)
+// SaveAppBlocklist replaces the app blocklist with the given list of names.
+// Names that were already on the list keep their metadata.
+func SaveAppBlocklist(list []string) error {
+	return appBlocklistTable.replace(list)
+}
 
-	b, err := json.MarshalIndent(list, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal blocklist: %w", err)
-	}
-	if err := os.WriteFile(p, b, 0600); err != nil {
-		return err
-	}
+// AddAppsToBlocklist adds the given entries to the app blocklist in a single transaction
+// and returns the number of entries that were not already on it.
+func AddAppsToBlocklist(entries []BlocklistEntry) (int, error) {
+	return appBlocklistTable.add(entries)
+}
 
-	// Apply platform-specific file locking to prevent unauthorized modification.
-	return platformLock(p) // build-tag dispatch
+// RemoveAppsFromBlocklist removes the given names from the app blocklist in a single transaction
+// and returns the number of names that were on it.
+func RemoveAppsFromBlocklist(names []string) (int, error) {
+	return appBlocklistTable.remove(names)
 }
 
 // AddAppToBlocklist adds a program to the blocklist if it's not already there.
 func AddAppToBlocklist(name string) (string, error) {
-	list, err := LoadAppBlocklist()
+	added, err := AddAppsToBlocklist([]BlocklistEntry{{Name: name}})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("save: %w", err)
 	}
-
-	lowerName := strings.ToLower(name)
-	if slices.Contains(list, lowerName) {
+	if added == 0 {
 		return "exists", nil
 	}
-
-	list = append(list, lowerName)
-	if err := SaveAppBlocklist(list); err != nil {
-		return "", fmt.Errorf("save: %w", err)
-	}
-
 	return "added", nil
 }
 
 // RemoveAppFromBlocklist removes a program from the blocklist.
 func RemoveAppFromBlocklist(name string) (string, error) {
-	list, err := LoadAppBlocklist()
+	removed, err := RemoveAppsFromBlocklist([]string{name})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("save: %w", err)
 	}
-
-	lowerName := strings.ToLower(name)
-	idx := slices.Index(list, lowerName)
-	if idx == -1 {
+	if removed == 0 {
 		return "not found", nil
 	}
-
-	list = slices.Delete(list, idx, idx+1)
-	if err := SaveAppBlocklist(list); err != nil {
-		return "", fmt.Errorf("save: %w", err)
-	}
-
 	return "removed", nil
 }
 
 // ClearAppBlocklist removes all entries from the blocklist.
 func ClearAppBlocklist() error {
-	return SaveAppBlocklist([]string{})
+	return appBlocklistTable.clear()
 }
 
-// ExportAppBlocklist saves the current blocklist to a user-specified file.
-func ExportAppBlocklist(path string) error {
-	list, err := LoadAppBlocklist()
+// MarshalAppBlocklist returns the app blocklist as a JSON document for export.
+func MarshalAppBlocklist() ([]byte, error) {
+	b, err := appBlocklistTable.export()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to marshal blocklist: %w", err)
 	}
+	return b, nil
+}
 
-	header := map[string]interface{}{
-		"exported_at": time.Now().Format(time.RFC3339),
-		"blocked":     list,
-	}
+// ImportAppBlocklistJSON merges an exported app blocklist into the current one
+// and returns the number of entries that were added.
+func ImportAppBlocklistJSON(content []byte) (int, error) {
+	return appBlocklistTable.importJSON(content)
+}
 
-	b, err := json.MarshalIndent(header, "", "  ")
+// ExportAppBlocklist saves the current blocklist to a user-specified file.
+func ExportAppBlocklist(path string) error {
+	b, err := MarshalAppBlocklist()
 	if err != nil {
-		return fmt.Errorf("failed to marshal blocklist: %w", err)
+		return err
 	}
-	if err := os.WriteFile(path, b, 0644); err != nil {
+	if err := WriteFileAtomic(path, b, 0644); err != nil {
 		return fmt.Errorf("save: %w", err)
 	}
 
@@ -179,32 +158,8 @@ func ImportAppBlocklist(path string) error {
 	if err != nil {
 		return fmt.Errorf("load: %w", err)
 	}
-
-	var newEntries []string
-	var savedList struct {
-		Blocked []string `json:"blocked"`
-	}
-
-	// The imported file can be a simple list of strings or a previously exported file.
-	err = json.Unmarshal(content, &newEntries)
-	if err != nil {
-		err2 := json.Unmarshal(content, &savedList)
-		if err2 != nil {
-			return fmt.Errorf("load: invalid JSON format in %s", path)
-		}
-		newEntries = savedList.Blocked
-	}
-
-	existingList, err := LoadAppBlocklist()
-	if err != nil {
-		return err
+	if _, err := ImportAppBlocklistJSON(content); err != nil {
+		return fmt.Errorf("load %s: %w", path, err)
 	}
-
-	for _, entry := range newEntries {
-		if !slices.Contains(existingList, entry) {
-			existingList = append(existingList, entry)
-		}
-	}
-
-	return SaveAppBlocklist(existingList)
+	return nil
 }
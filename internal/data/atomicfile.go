@@ -0,0 +1,173 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lastGoodSuffix is appended to the name of a file to get the name of its last good copy.
+const lastGoodSuffix = ".bak"
+
+// fileMutexes serializes the writers of each file within this process. Other processes are kept out by the
+// advisory lock taken by lockFile, which doesn't reliably exclude two goroutines of the same process on every platform.
+var fileMutexes sync.Map
+
+// lockFile takes the advisory lock shared by every process that writes path, and returns the function that releases it.
+// The lock is held on a separate lock file next to path, because path itself is replaced on every write.
+func lockFile(path string) (func(), error) {
+	mu, _ := fileMutexes.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+	if err := lockFileHandle(f); err != nil {
+		_ = f.Close()
+		mu.(*sync.Mutex).Unlock()
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+	return func() {
+		if err := unlockFileHandle(f); err != nil {
+			log.Printf("[ERROR] Failed to unlock %s: %v", path, err)
+		}
+		if err := f.Close(); err != nil {
+			log.Printf("[ERROR] Failed to close lock file of %s: %v", path, err)
+		}
+		mu.(*sync.Mutex).Unlock()
+	}, nil
+}
+
+// WriteFileAtomic replaces the file at path with data, so that a crash leaves either the old or the new content
+// and never a partial file. The data is written to a temporary file in the same directory and flushed to disk
+// before it is renamed over path.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// Make the rename itself durable.
+	return syncDir(dir)
+}
+
+// replaceFile writes data to path atomically while holding its lock, and then keeps a copy of it as
+// the last good copy. readFileRecovering falls back to that copy if path gets corrupted or tampered with.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return replaceFileLocked(path, data, perm)
+}
+
+// replaceFileLocked is replaceFile for callers that already hold the lock of path.
+// The copy is written second, so that it holds either the new or the previous content of path.
+func replaceFileLocked(path string, data []byte, perm os.FileMode) error {
+	if err := writeFile(path, data, perm); err != nil {
+		return err
+	}
+	if err := writeFile(path+lastGoodSuffix, data, perm); err != nil {
+		return fmt.Errorf("could not keep last good copy: %w", err)
+	}
+	return nil
+}
+
+// readFileRecovering reads the file at path and checks it with valid. If it is missing or corrupted, the last good
+// copy kept by replaceFile is read instead and written back to path. It returns an error satisfying os.IsNotExist
+// if there is no file at all, and the error of valid if neither the file nor its copy is usable.
+// If valid returns ErrPolicyTampered, or a policy file was deleted, a tamper alert is raised.
+func readFileRecovering(path string, perm os.FileMode, valid func([]byte) error) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && valid(content) == nil {
+		clearTamperAlert(filepath.Base(path))
+		return content, nil
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// Another process may have repaired or rewritten the file while the lock was being taken.
+	return readFileRecoveringLocked(path, perm, valid)
+}
+
+// readFileRecoveringLocked is readFileRecovering for callers that already hold the lock of path.
+func readFileRecoveringLocked(path string, perm os.FileMode, valid func([]byte) error) ([]byte, error) {
+	content, invalid := os.ReadFile(path)
+	if invalid != nil && !os.IsNotExist(invalid) {
+		return nil, invalid
+	}
+	if invalid == nil {
+		if invalid = valid(content); invalid == nil {
+			clearTamperAlert(filepath.Base(path))
+			return content, nil
+		}
+	}
+
+	lastGood, err := os.ReadFile(path + lastGoodSuffix)
+	if os.IsNotExist(invalid) {
+		if os.IsNotExist(err) {
+			return nil, invalid
+		}
+		// Files are replaced with a rename, so once written they are never missing unless they were deleted.
+		if isPolicyFile(path) {
+			invalid = fmt.Errorf("%w: %s was deleted", ErrPolicyTampered, filepath.Base(path))
+		}
+	}
+	usable := err == nil && valid(lastGood) == nil
+	switch {
+	case errors.Is(invalid, ErrPolicyTampered) && usable:
+		raiseTamperAlert(filepath.Base(path), invalid.Error()+"; its last good copy was restored")
+		// The file is repaired, so tampering with it again is a new incident.
+		clearTamperAlert(filepath.Base(path))
+	case errors.Is(invalid, ErrPolicyTampered):
+		raiseTamperAlert(filepath.Base(path), invalid.Error())
+	case usable:
+		// This can run before the logger is set up.
+		log.Printf("[WARN] %s is missing or corrupted (%v); restoring its last good copy", path, invalid)
+	}
+	if !usable {
+		return nil, invalid
+	}
+	if err := writeFile(path, lastGood, perm); err != nil {
+		log.Printf("[ERROR] Failed to restore %s: %v", path, err)
+	}
+	return lastGood, nil
+}
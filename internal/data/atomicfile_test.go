@@ -0,0 +1,180 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFileRecovering(t *testing.T) {
+	const (
+		good      = `{"good":true}`
+		lastGood  = `{"good":"last"}`
+		corrupted = `{"good":`
+	)
+	validJSON := func(content []byte) error {
+		if !json.Valid(content) {
+			return errors.New("invalid JSON")
+		}
+		return nil
+	}
+	tamperedUnless := func(want string) func([]byte) error {
+		return func(content []byte) error {
+			if err := validJSON(content); err != nil {
+				return err
+			}
+			if string(content) != want {
+				return fmt.Errorf("%w: unexpected content", ErrPolicyTampered)
+			}
+			return nil
+		}
+	}
+	missing := "missing"
+
+	tests := []struct {
+		name string
+		// policyFile reads the settings file instead of a file that isn't part of the policy.
+		policyFile bool
+		// main and bak are the content of the file and its last good copy, or missing.
+		main, bak    string
+		valid        func([]byte) error
+		want         string
+		wantNotExist bool
+		wantTampered bool
+		// wantRestored tells that the file holds want afterwards.
+		wantRestored bool
+	}{
+		{name: "valid", main: good, bak: lastGood, valid: validJSON, want: good},
+		{name: "corrupted", main: corrupted, bak: lastGood, valid: validJSON, want: lastGood, wantRestored: true},
+		{name: "missing", main: missing, bak: lastGood, valid: validJSON, want: lastGood, wantRestored: true},
+		{name: "missing with its copy", main: missing, bak: missing, valid: validJSON, wantNotExist: true},
+		{name: "corrupted with a corrupted copy", main: corrupted, bak: corrupted, valid: validJSON},
+		{name: "corrupted without a copy", main: corrupted, bak: missing, valid: validJSON},
+		{
+			name: "tampered", policyFile: true, main: good, bak: lastGood,
+			valid: tamperedUnless(lastGood), want: lastGood, wantRestored: true,
+		},
+		{
+			name: "tampered with a tampered copy", policyFile: true, main: good, bak: good,
+			valid: tamperedUnless(lastGood), wantTampered: true,
+		},
+		{
+			name: "tampered without a copy", policyFile: true, main: good, bak: missing,
+			valid: tamperedUnless(lastGood), wantTampered: true,
+		},
+		{
+			name: "policy file deleted", policyFile: true, main: missing, bak: lastGood,
+			valid: tamperedUnless(lastGood), want: lastGood, wantRestored: true,
+		},
+		{
+			name: "policy file deleted with a tampered copy", policyFile: true, main: missing, bak: good,
+			valid: tamperedUnless(lastGood), wantTampered: true,
+		},
+		{
+			name: "policy file deleted with its copy", policyFile: true, main: missing, bak: missing,
+			valid: tamperedUnless(lastGood), wantNotExist: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := usePolicyDir(t)
+			path := filepath.Join(dir, "state.json")
+			if tt.policyFile {
+				var err error
+				if path, err = GetConfigPath(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for p, content := range map[string]string{path: tt.main, path + lastGoodSuffix: tt.bak} {
+				if content == missing {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := readFileRecovering(path, 0644, tt.valid)
+			if os.IsNotExist(err) != tt.wantNotExist {
+				t.Errorf("readFileRecovering() error = %v, want not exist %v", err, tt.wantNotExist)
+			}
+			if errors.Is(err, ErrPolicyTampered) != tt.wantTampered {
+				t.Errorf("readFileRecovering() error = %v, want tampered %v", err, tt.wantTampered)
+			}
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("readFileRecovering() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("readFileRecovering() = %q, %v, want %q", got, err, tt.want)
+			}
+			if tt.wantRestored {
+				restored, err := os.ReadFile(path)
+				if err != nil || !bytes.Equal(restored, got) {
+					t.Errorf("file holds %q, %v after recovery, want %q", restored, err, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup runs before the settings and their last good copy are deleted.
+		setup        func(t *testing.T)
+		wantTampered bool
+	}{
+		{name: "first run", setup: func(*testing.T) {}},
+		{
+			name: "after the policy key was created",
+			setup: func(t *testing.T) {
+				if _, err := policyKey(true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantTampered: true,
+		},
+		{
+			name: "after the database was signed",
+			setup: func(*testing.T) {
+				policyInstalled.Store(true)
+			},
+			wantTampered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicyDir(t)
+			tt.setup(t)
+
+			cfg, err := LoadConfig()
+			if errors.Is(err, ErrPolicyTampered) != tt.wantTampered {
+				t.Fatalf("LoadConfig() error = %v, want tampered %v", err, tt.wantTampered)
+			}
+			if !tt.wantTampered && (err != nil || cfg.PasswordHash != "") {
+				t.Errorf("LoadConfig() = %+v, %v, want the defaults", cfg, err)
+			}
+		})
+	}
+}
+
+func TestPolicyFilesIncludeLastGoodCopy(t *testing.T) {
+	usePolicyDir(t)
+	path, err := GetConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPolicyFile(path + lastGoodSuffix) {
+		t.Errorf("%s is not locked as a policy file", path+lastGoodSuffix)
+	}
+}
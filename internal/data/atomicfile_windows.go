@@ -0,0 +1,24 @@
+//go:build windows
+
+package data
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFileHandle takes an exclusive lock on f, waiting until it is available.
+func lockFileHandle(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
+
+// unlockFileHandle releases the lock taken by lockFileHandle.
+func unlockFileHandle(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
+
+// syncDir does nothing on Windows, where directories can't be flushed and renames are journaled by NTFS.
+func syncDir(string) error {
+	return nil
+}
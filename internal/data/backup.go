@@ -0,0 +1,747 @@
+package data
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupFormatVersion is the version of the archive layout written by CreateBackup.
+	backupFormatVersion = 1
+	// backupManifestName is the archive member that describes the backup.
+	backupManifestName = "manifest.json"
+	// backupDBName is the archive member that holds the database snapshot.
+	backupDBName = "procguard.db"
+	// backupFilePrefix and backupFileExt name the archives written by the backup scheduler,
+	// with a timestamp in between that sorts chronologically.
+	backupFilePrefix = "procguard-"
+	backupFileExt    = ".zip"
+	backupTimeLayout = "20060102T150405"
+	// backupCheckInterval is how often the backup scheduler checks whether a backup is due.
+	backupCheckInterval = time.Hour
+
+	// restorePendingDir holds a validated backup until it is swapped in by ApplyPendingRestore.
+	restorePendingDir = "restore-pending"
+	// restorePreviousDir keeps the state that the last restore replaced, so it can be put back by hand.
+	restorePreviousDir = "restore-previous"
+)
+
+// backupStateFiles are the files of the data directory, besides the database, that a backup contains.
+// The executables disabled on disk are deliberately left out: their manifest describes the current state of
+// the file system, and restoring an older one would lose track of how to re-enable them.
+var backupStateFiles = []string{
+	"config/settings.json",
+	// Written by the web package, which recreates it at startup.
+	"config/native-host.json",
+}
+
+// Exports of the blocklists, included for reference and for importing into another installation.
+// A restore takes the blocklists from the database snapshot instead.
+const (
+	backupAppBlocklistName = "blocklists/apps.json"
+	backupWebBlocklistName = "blocklists/web.json"
+)
+
+// backupPolicyName identifies backup manifests in their signature.
+const backupPolicyName = "backup"
+
+// ErrInvalidBackup is returned when an archive is not a complete and intact ProcGuard backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// ErrUntrustedBackup is returned when a backup that was not made by this installation is restored
+// without the authorization of the admin.
+var ErrUntrustedBackup = errors.New("backup was not made by this installation")
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// SchemaVersion is the schema version of the database snapshot.
+	SchemaVersion int          `json:"schema_version"`
+	Files         []BackupFile `json:"files"`
+	// Signature is the signature of the rest of the manifest under the policy key. Since the manifest holds
+	// the checksum of every file, it shows that the whole backup was made by this installation.
+	Signature string `json:"signature,omitempty"`
+}
+
+// signedContent returns the content that the signature of the manifest is computed over.
+func (m BackupManifest) signedContent() ([]byte, error) {
+	m.Signature = ""
+	return json.Marshal(m)
+}
+
+// verifySignature checks that the manifest was signed by this installation.
+func (m BackupManifest) verifySignature() error {
+	if m.Signature == "" {
+		return errors.New("the backup is not signed")
+	}
+	content, err := m.signedContent()
+	if err != nil {
+		return err
+	}
+	return checkPolicySignature(backupPolicyName, content, m.Signature)
+}
+
+// BackupFile is a member of a backup archive, with the checksum it is verified against on restore.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupConfig controls the scheduled backups.
+type BackupConfig struct {
+	// Enabled turns on scheduled backups.
+	Enabled bool `json:"enabled"`
+	// Dir is the directory the backups are written to. Empty means the backups directory inside the data directory.
+	Dir string `json:"dir,omitempty"`
+	// IntervalHours is the time between two backups.
+	IntervalHours int `json:"interval_hours"`
+	// Keep is the number of backups kept in Dir; older ones are deleted.
+	Keep int `json:"keep"`
+}
+
+// DefaultBackup returns the backup schedule used when the user hasn't configured one: off, daily when enabled.
+func DefaultBackup() BackupConfig {
+	return BackupConfig{IntervalHours: 24, Keep: 7}
+}
+
+// BackupDir returns the directory that scheduled backups are written to.
+func (c BackupConfig) BackupDir() (string, error) {
+	if c.Dir != "" {
+		return c.Dir, nil
+	}
+	return DataPath("backups")
+}
+
+// CreateBackup writes a backup archive of the complete state of ProcGuard to w: a consistent snapshot of the
+// database, taken with VACUUM INTO once the queued writes are flushed, the settings, the native messaging host
+// manifest and exports of both blocklists.
+func CreateBackup(ctx context.Context, db *sql.DB, w io.Writer) (BackupManifest, error) {
+	if err := Flush(ctx); err != nil {
+		return BackupManifest{}, fmt.Errorf("could not flush queued writes: %w", err)
+	}
+	dataDir, err := DataPath()
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	// The snapshot is written next to the database, which is known to have room for it.
+	tmpDir, err := os.MkdirTemp(dataDir, "backup-")
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			GetLogger().Error("Failed to remove temporary backup directory", "err", err)
+		}
+	}()
+
+	snapshot := filepath.Join(tmpDir, backupDBName)
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", snapshot); err != nil {
+		return BackupManifest{}, fmt.Errorf("could not snapshot database: %w", err)
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	manifest := BackupManifest{FormatVersion: backupFormatVersion, CreatedAt: time.Now().In(DisplayLocation()), SchemaVersion: version}
+
+	zw := zip.NewWriter(w)
+	add := func(name string, r io.Reader) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(fw, h), r)
+		if err != nil {
+			return fmt.Errorf("could not add %s to backup: %w", name, err)
+		}
+		manifest.Files = append(manifest.Files, BackupFile{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	}
+	addFile := func(name, path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				GetLogger().Error("Failed to close file", "err", err)
+			}
+		}()
+		return add(name, f)
+	}
+
+	if err := addFile(backupDBName, snapshot); err != nil {
+		return BackupManifest{}, err
+	}
+	for _, name := range backupStateFiles {
+		p := filepath.Join(dataDir, filepath.FromSlash(name))
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := addFile(name, p); err != nil {
+			return BackupManifest{}, err
+		}
+	}
+	exports := []struct {
+		name    string
+		marshal func() ([]byte, error)
+	}{
+		{backupAppBlocklistName, MarshalAppBlocklist},
+		{backupWebBlocklistName, MarshalWebBlocklist},
+	}
+	for _, export := range exports {
+		b, err := export.marshal()
+		if err != nil {
+			return BackupManifest{}, err
+		}
+		if err := add(export.name, bytes.NewReader(b)); err != nil {
+			return BackupManifest{}, err
+		}
+	}
+
+	content, err := manifest.signedContent()
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	if manifest.Signature, err = signPolicy(backupPolicyName, content); err != nil {
+		return BackupManifest{}, fmt.Errorf("could not sign backup: %w", err)
+	}
+	mw, err := zw.Create(backupManifestName)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return BackupManifest{}, err
+	}
+	return manifest, zw.Close()
+}
+
+// WriteBackupFile writes a backup archive to path. The archive only appears at path once it is complete.
+func WriteBackupFile(ctx context.Context, db *sql.DB, path string) (BackupManifest, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return BackupManifest{}, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	tmp := f.Name()
+	manifest, err := CreateBackup(ctx, db, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return BackupManifest{}, err
+	}
+	return manifest, nil
+}
+
+// StageRestore validates a backup archive of size bytes read from r and unpacks it, ready to be swapped in by
+// ApplyPendingRestore the next time ProcGuard starts. Archives that are incomplete, corrupted, or whose
+// database is newer than this build supports are rejected with an error wrapping ErrInvalidBackup.
+//
+// Backups signed by this installation are restored as they are. Any other backup replaces the policy with one
+// that was never verified, so it is only accepted if trusted is set, because the admin authorized the restore,
+// and ErrUntrustedBackup is returned otherwise. Its settings and blocklists are then signed with the policy key.
+func StageRestore(r io.ReaderAt, size int64, trusted bool) (BackupManifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+
+	manifest, err := readBackupManifest(zr)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	signed := manifest.verifySignature() == nil
+	if !signed && !trusted {
+		return BackupManifest{}, ErrUntrustedBackup
+	}
+
+	pendingDir, err := DataPath(restorePendingDir)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	stagingDir := pendingDir + ".tmp"
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return BackupManifest{}, err
+	}
+	if err := extractBackup(zr, manifest, stagingDir); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return BackupManifest{}, err
+	}
+	if err := checkDatabaseFile(filepath.Join(stagingDir, backupDBName)); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return BackupManifest{}, err
+	}
+	if !signed {
+		if err := signRestoredPolicy(stagingDir); err != nil {
+			_ = os.RemoveAll(stagingDir)
+			return BackupManifest{}, fmt.Errorf("could not sign the restored policy: %w", err)
+		}
+	}
+	// The restored settings get a last good copy of their own; the current one is moved aside with them.
+	if err := copyLastGood(filepath.Join(stagingDir, filepath.FromSlash(backupStateFiles[0])), configFileMode); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return BackupManifest{}, err
+	}
+
+	// The previous state of an earlier restore is only discarded now, so an interrupted restore can still finish.
+	if err := os.RemoveAll(pendingDir); err != nil {
+		return BackupManifest{}, err
+	}
+	previousDir, err := DataPath(restorePreviousDir)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	if err := os.RemoveAll(previousDir); err != nil {
+		return BackupManifest{}, err
+	}
+	if err := os.Rename(stagingDir, pendingDir); err != nil {
+		return BackupManifest{}, err
+	}
+	return manifest, nil
+}
+
+// readBackupManifest reads the manifest of a backup archive and checks that the archive matches it.
+func readBackupManifest(zr *zip.Reader) (BackupManifest, error) {
+	members := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		members[f.Name] = f
+	}
+	mf, ok := members[backupManifestName]
+	if !ok {
+		return BackupManifest{}, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, backupManifestName)
+	}
+	r, err := mf.Open()
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	var manifest BackupManifest
+	err = json.NewDecoder(r).Decode(&manifest)
+	_ = r.Close()
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("%w: unreadable manifest: %v", ErrInvalidBackup, err)
+	}
+
+	if manifest.FormatVersion != backupFormatVersion {
+		return BackupManifest{}, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBackup, manifest.FormatVersion)
+	}
+	if latest := latestSchemaVersion(); manifest.SchemaVersion > latest {
+		return BackupManifest{}, fmt.Errorf("%w: database schema version %d is newer than the latest supported version %d",
+			ErrInvalidBackup, manifest.SchemaVersion, latest)
+	}
+
+	hasDB := false
+	for _, file := range manifest.Files {
+		if !isBackupMember(file.Name) {
+			return BackupManifest{}, fmt.Errorf("%w: unexpected file %q", ErrInvalidBackup, file.Name)
+		}
+		if _, ok := members[file.Name]; !ok {
+			return BackupManifest{}, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, file.Name)
+		}
+		hasDB = hasDB || file.Name == backupDBName
+	}
+	if !hasDB {
+		return BackupManifest{}, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, backupDBName)
+	}
+	return manifest, nil
+}
+
+// isBackupMember reports whether name is one of the files that a backup may contain.
+// Only known names are accepted, so an archive can't write anywhere else in the data directory.
+func isBackupMember(name string) bool {
+	switch name {
+	case backupDBName, backupAppBlocklistName, backupWebBlocklistName:
+		return true
+	}
+	return containsString(backupStateFiles, name)
+}
+
+// extractBackup unpacks the database and state files of a backup into dir, verifying their checksums.
+// The blocklist exports are not needed for a restore and are skipped.
+func extractBackup(zr *zip.Reader, manifest BackupManifest, dir string) error {
+	members := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		members[f.Name] = f
+	}
+	for _, file := range manifest.Files {
+		if file.Name == backupAppBlocklistName || file.Name == backupWebBlocklistName {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := extractBackupFile(members[file.Name], file, dst); err != nil {
+			return err
+		}
+		if file.Name == backupStateFiles[0] {
+			if err := checkConfigFile(dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractBackupFile copies an archive member to dst and checks it against its manifest entry.
+func extractBackupFile(member *zip.File, file BackupFile, dst string) error {
+	r, err := member.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			GetLogger().Error("Failed to close backup member", "err", err)
+		}
+	}()
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: could not extract %s: %v", ErrInvalidBackup, file.Name, err)
+	}
+	if n != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("%w: %s does not match its checksum", ErrInvalidBackup, file.Name)
+	}
+	return nil
+}
+
+// checkConfigFile checks that a settings file from a backup can be loaded.
+func checkConfigFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, NewConfig()); err != nil {
+		return fmt.Errorf("%w: unreadable settings: %v", ErrInvalidBackup, err)
+	}
+	return nil
+}
+
+// checkDatabaseFile runs an integrity check on a database snapshot from a backup.
+func checkDatabaseFile(path string) error {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			GetLogger().Error("Failed to close backup database", "err", err)
+		}
+	}()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("%w: unreadable database: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: database is corrupted: %s", ErrInvalidBackup, result)
+	}
+	return nil
+}
+
+// signRestoredPolicy signs the settings and blocklists of a backup staged in dir with the policy key.
+// Every part of the restored policy is signed as a generation newer than any seen so far, since it replaces
+// the current policy on purpose. Databases older than the policy signatures are signed by their migration
+// instead, and those older than the generations keep the generation 0 they are migrated with.
+func signRestoredPolicy(dir string) error {
+	generations, settingsGeneration, err := signRestoredBlocklists(dir)
+	if err != nil {
+		return err
+	}
+
+	settingsPath := filepath.Join(dir, filepath.FromSlash(backupStateFiles[0]))
+	content, err := os.ReadFile(settingsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg := NewConfig()
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return fmt.Errorf("%w: unreadable settings: %v", ErrInvalidBackup, err)
+	}
+	cfg.Generation = max(cfg.Generation, settingsGeneration)
+	nextConfigGeneration(cfg)
+	cfg.PolicyGenerations = generations
+	if content, err = encodeConfig(cfg); err != nil {
+		return err
+	}
+	return os.WriteFile(settingsPath, content, configFileMode)
+}
+
+// signRestoredBlocklists signs the blocklists of the database of a backup staged in dir. It returns the generation
+// that each of them was signed as, and the highest generation of the settings recorded in the database.
+func signRestoredBlocklists(dir string) (map[string]uint64, uint64, error) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(dir, backupDBName))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			GetLogger().Error("Failed to close backup database", "err", err)
+		}
+	}()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'policy_generations'").Scan(&count); err != nil {
+		return nil, 0, err
+	}
+	if count == 0 {
+		if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'policy_signatures'").Scan(&count); err != nil {
+			return nil, 0, err
+		}
+		if count == 0 {
+			return nil, 0, nil
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, 0, err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		if err := signBlocklistsV10(tx); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, tx.Commit()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var settingsGeneration int64
+	err = tx.QueryRow("SELECT generation FROM policy_generations WHERE name = ?", configPolicyName).Scan(&settingsGeneration)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, err
+	}
+	generations := make(map[string]uint64)
+	for _, t := range []blocklistTable{appBlocklistTable, webBlocklistTable} {
+		generation, err := t.signTx(tx)
+		if err != nil {
+			return nil, 0, err
+		}
+		_, err = tx.Exec(`INSERT INTO policy_generations (name, generation) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET generation = excluded.generation`, t.table, int64(generation))
+		if err != nil {
+			return nil, 0, err
+		}
+		generations[t.table] = generation
+	}
+	return generations, uint64(settingsGeneration), tx.Commit()
+}
+
+// copyLastGood writes a copy of the file at path as its last good copy, if the file exists.
+func copyLastGood(path string, perm os.FileMode) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+lastGoodSuffix, content, perm)
+}
+
+// HasPendingRestore reports whether a restore has been staged and not yet applied.
+func HasPendingRestore() bool {
+	pendingDir, err := DataPath(restorePendingDir)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(pendingDir)
+	return err == nil
+}
+
+// ApplyPendingRestore swaps in a backup staged by StageRestore, and reports whether there was one.
+// It must run before the database is opened and while no other instance is using the data directory.
+// The state it replaces is moved to the restore-previous directory. Each file is moved with a rename,
+// and a restore that is interrupted is completed by the next call, so the state never mixes two backups.
+func ApplyPendingRestore() (bool, error) {
+	pendingDir, err := DataPath(restorePendingDir)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(pendingDir); os.IsNotExist(err) {
+		return false, nil
+	}
+	dataDir, err := DataPath()
+	if err != nil {
+		return false, err
+	}
+	previousDir, err := DataPath(restorePreviousDir)
+	if err != nil {
+		return false, err
+	}
+
+	// The database of the old state is replaced together with its WAL and shared memory files, which would
+	// otherwise be applied to the restored database. State files that the backup doesn't have are moved
+	// aside as well, so the state matches the backup exactly, and so are the last good copies of the old ones.
+	// The policy files are unlocked first, as they couldn't be moved otherwise, and locked again at startup.
+	if err := UnlockPolicyFiles(); err != nil {
+		return false, err
+	}
+	replaced := append([]string{backupDBName, backupDBName + "-wal", backupDBName + "-shm"}, backupStateFiles...)
+	for _, name := range backupStateFiles {
+		replaced = append(replaced, name+lastGoodSuffix)
+	}
+	for _, name := range replaced {
+		staged := filepath.Join(pendingDir, filepath.FromSlash(name))
+		if err := moveIfExists(filepath.Join(dataDir, filepath.FromSlash(name)), filepath.Join(previousDir, filepath.FromSlash(name))); err != nil {
+			return false, err
+		}
+		if err := moveIfExists(staged, filepath.Join(dataDir, filepath.FromSlash(name))); err != nil {
+			return false, err
+		}
+	}
+	if err := os.RemoveAll(pendingDir); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// moveIfExists renames src to dst, creating the directory of dst. It does nothing if src doesn't exist.
+func moveIfExists(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// RunBackupScheduler writes a backup to the configured directory whenever the newest one there is older than
+// the configured interval, and deletes the oldest ones beyond the configured number, until ctx is cancelled.
+func RunBackupScheduler(ctx context.Context, appLogger Logger, db *sql.DB) {
+	ticker := time.NewTicker(backupCheckInterval)
+	defer ticker.Stop()
+	for {
+		cfg, err := LoadConfig()
+		if err != nil {
+			appLogger.Error("Failed to load config for backups", "err", err)
+		} else if cfg.Backup.Enabled {
+			if err := runScheduledBackup(ctx, db, cfg.Backup, time.Now()); err != nil {
+				appLogger.Error("Scheduled backup failed", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runScheduledBackup writes a backup if one is due at now and prunes the old ones.
+func runScheduledBackup(ctx context.Context, db *sql.DB, cfg BackupConfig, now time.Time) error {
+	dir, err := cfg.BackupDir()
+	if err != nil {
+		return err
+	}
+	backups, err := scheduledBackups(dir)
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(max(cfg.IntervalHours, 1)) * time.Hour
+	if len(backups) > 0 {
+		if last, ok := scheduledBackupTime(backups[len(backups)-1]); ok && now.Sub(last) < interval {
+			return nil
+		}
+	}
+
+	name := backupFilePrefix + now.UTC().Format(backupTimeLayout) + backupFileExt
+	if _, err := WriteBackupFile(ctx, db, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	GetLogger().Info("Backup written", "path", filepath.Join(dir, name))
+	backups = append(backups, name)
+
+	keep := max(cfg.Keep, 1)
+	if len(backups) <= keep {
+		return nil
+	}
+	for _, old := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, old)); err != nil {
+			GetLogger().Warn("Failed to remove old backup", "name", old, "err", err)
+		}
+	}
+	return nil
+}
+
+// scheduledBackups returns the names of the backups written by the scheduler in dir, oldest first.
+// Other files in dir are left alone.
+func scheduledBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if _, ok := scheduledBackupTime(e.Name()); ok && !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	// The timestamps are in UTC and sort chronologically.
+	sort.Strings(names)
+	return names, nil
+}
+
+// scheduledBackupTime parses the time at which a scheduled backup was written from its name.
+func scheduledBackupTime(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, backupFilePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, backupFileExt)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, stamp)
+	return t, err == nil
+}
@@ -0,0 +1,19 @@
+package data
+
+import "time"
+
+// Block event actions describe how a blocked program was stopped.
+const (
+	// BlockActionDenied means the program was prevented from starting.
+	BlockActionDenied = "denied"
+	// BlockActionKilled means the program was terminated after it had started.
+	BlockActionKilled = "killed"
+)
+
+// LogBlockEvent records that a blocklisted program was stopped by the enforcer.
+// The write is queued on the database writer so it can be called from hot enforcement paths.
+// The executable path is encrypted if encryption is enabled.
+func LogBlockEvent(processName, exePath string, pid int32, action string) {
+	EnqueueWrite("INSERT INTO block_events (timestamp, process_name, exe_path, pid, action) VALUES (?, ?, ?, ?, ?)",
+		time.Now().Unix(), processName, sealField(exePath), pid, action)
+}
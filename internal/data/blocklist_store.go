@@ -0,0 +1,773 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources recorded in the added_by column of the blocklist tables.
+const (
+	AddedByGUI       = "gui"
+	AddedByExtension = "extension"
+	AddedByImport    = "import"
+	AddedByLegacy    = "legacy-json"
+)
+
+// errNoDB is returned when a blocklist is used before InitDB.
+var errNoDB = errors.New("database is not initialized")
+
+// BlocklistMeta is the metadata stored with every blocklist entry.
+type BlocklistMeta struct {
+	// AddedAt is the Unix time at which the entry was added.
+	AddedAt int64 `json:"added_at"`
+	// AddedBy records where the entry came from, e.g. AddedByGUI.
+	AddedBy string   `json:"added_by,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// ExpiresAt is the Unix time after which the entry no longer applies. 0 means it never expires.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// BlocklistEntry is a single app name or domain on a blocklist, together with its metadata.
+type BlocklistEntry struct {
+	Name string `json:"name"`
+	BlocklistMeta
+}
+
+// blocklistExport is the JSON document written by the export functions.
+// "blocked" holds the plain list, which older versions of ProcGuard can still import.
+type blocklistExport struct {
+	ExportedAt string           `json:"exported_at"`
+	Blocked    []string         `json:"blocked"`
+	Entries    []BlocklistEntry `json:"entries"`
+}
+
+// blocklistTable gives access to one of the blocklist tables. Both tables share the same layout
+// and only differ in the name of their key column.
+type blocklistTable struct {
+	table  string
+	column string
+}
+
+var (
+	appBlocklistTable = blocklistTable{table: "app_blocklist", column: "name"}
+	webBlocklistTable = blocklistTable{table: "web_blocklist", column: "domain"}
+)
+
+// activeEntry is a SQL condition that selects entries which have not expired at the time bound as its parameter.
+const activeEntry = "(expires_at IS NULL OR expires_at > ?)"
+
+// Remaining returns how long an expiring entry still applies at the given time, or 0 for permanent entries.
+func (m BlocklistMeta) Remaining(now time.Time) time.Duration {
+	if m.ExpiresAt == 0 {
+		return 0
+	}
+	return max(time.Unix(m.ExpiresAt, 0).Sub(now), 0)
+}
+
+// PathsCaseSensitive tells whether executable paths that only differ in case name different files on this platform.
+const PathsCaseSensitive = runtime.GOOS != "windows" && runtime.GOOS != "darwin"
+
+// NormalizeBlocklistName trims an entry and lowercases it to ensure case-insensitive matching.
+// Executable paths, which contain a path separator, keep their case where PathsCaseSensitive is set.
+func NormalizeBlocklistName(name string) string {
+	name = strings.TrimSpace(name)
+	if PathsCaseSensitive && strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// verifiedNames maps each blocklist table to the keys that names last read from it while it verified.
+var verifiedNames sync.Map
+
+// names returns the key of every entry that has not expired, in the order they were added.
+// If the table was tampered with and can't be repaired, the list is still returned for enforcement, together with
+// an error wrapping ErrPolicyTampered: it is the last list read while the table verified, or if there is none,
+// every entry of the table and of its last signed content.
+func (t blocklistTable) names() ([]string, error) {
+	db := GetDB()
+	if db == nil {
+		return nil, errNoDB
+	}
+	if err := t.verify(db); err != nil {
+		if !errors.Is(err, ErrPolicyTampered) {
+			return nil, err
+		}
+		if list, ok := verifiedNames.Load(t.table); ok {
+			return list.([]string), err
+		}
+		list, fallbackErr := t.unverifiedNames(db)
+		if fallbackErr != nil {
+			GetLogger().Error("Failed to read the unverified blocklist", "table", t.table, "err", fallbackErr)
+		}
+		return list, err
+	}
+
+	list, err := t.activeNames(db)
+	if err != nil {
+		return nil, err
+	}
+	verifiedNames.Store(t.table, list)
+	return list, nil
+}
+
+// activeNames returns the key of every entry of the table that has not expired, in the order they were added.
+func (t blocklistTable) activeNames(db *sql.DB) ([]string, error) {
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY added_at, rowid", t.column, t.table, activeEntry)
+	rows, err := db.Query(q, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	list := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		list = append(list, name)
+	}
+	return list, rows.Err()
+}
+
+// unverifiedNames returns the keys of the unexpired entries of both the table and its last signed content,
+// neither of which can be verified. Blocking what either of them holds keeps every entry that was removed
+// outside of ProcGuard in force.
+func (t blocklistTable) unverifiedNames(db *sql.DB) ([]string, error) {
+	list, err := t.activeNames(db)
+	if err != nil {
+		list = []string{}
+	}
+	seen := make(map[string]bool, len(list))
+	for _, name := range list {
+		seen[name] = true
+	}
+
+	var signed string
+	if scanErr := db.QueryRow("SELECT content FROM policy_signatures WHERE name = ?", t.table).Scan(&signed); scanErr != nil {
+		return list, errors.Join(err, scanErr)
+	}
+	var entries []signedBlocklistEntry
+	if jsonErr := json.Unmarshal([]byte(signed), &entries); jsonErr != nil {
+		return list, errors.Join(err, jsonErr)
+	}
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if seen[e.Name] || (e.ExpiresAt != nil && *e.ExpiresAt <= now) {
+			continue
+		}
+		seen[e.Name] = true
+		list = append(list, e.Name)
+	}
+	return list, err
+}
+
+// entries returns every entry that has not expired with its metadata, in the order they were added.
+func (t blocklistTable) entries() ([]BlocklistEntry, error) {
+	db := GetDB()
+	if db == nil {
+		return nil, errNoDB
+	}
+	if err := t.verify(db); err != nil {
+		return nil, err
+	}
+
+	q := fmt.Sprintf("SELECT %s, added_at, added_by, reason, tags, expires_at FROM %s WHERE %s ORDER BY added_at, rowid",
+		t.column, t.table, activeEntry)
+	rows, err := db.Query(q, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	entries := []BlocklistEntry{}
+	for rows.Next() {
+		var e BlocklistEntry
+		var addedBy, reason, tags sql.NullString
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&e.Name, &e.AddedAt, &addedBy, &reason, &tags, &expiresAt); err != nil {
+			return nil, err
+		}
+		e.AddedBy = addedBy.String
+		e.Reason = reason.String
+		e.ExpiresAt = expiresAt.Int64
+		if tags.String != "" {
+			if err := json.Unmarshal([]byte(tags.String), &e.Tags); err != nil {
+				GetLogger().Warn("Ignoring invalid tags on blocklist entry", "name", e.Name, "err", err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// add inserts the given entries in a single transaction and returns how many of them were new or extended.
+// Entries that are already on the list keep their metadata, except that a temporary block is extended
+// (or made permanent) when the new entry expires later than the existing one.
+func (t blocklistTable) add(entries []BlocklistEntry) (int, error) {
+	db := GetDB()
+	if db == nil {
+		return 0, errNoDB
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := t.verifyTx(tx); err != nil {
+		return 0, err
+	}
+	added, err := t.addTx(tx, entries)
+	if err != nil {
+		return 0, err
+	}
+	generation, err := t.signTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	return added, t.commitSigned(tx, generation)
+}
+
+// addTx inserts entries within an existing transaction.
+func (t blocklistTable) addTx(tx *sql.Tx, entries []BlocklistEntry) (int, error) {
+	// An expired entry that hasn't been swept yet is replaced rather than extended, so the new metadata is kept.
+	purge := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND expires_at <= ?", t.table, t.column)
+	q := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, added_at, added_by, reason, tags, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET expires_at = excluded.expires_at
+		WHERE %[1]s.expires_at IS NOT NULL AND (excluded.expires_at IS NULL OR excluded.expires_at > %[1]s.expires_at)`,
+		t.table, t.column)
+	stmt, err := tx.Prepare(q)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			GetLogger().Error("Failed to close statement", "err", err)
+		}
+	}()
+
+	now := time.Now().Unix()
+	added := 0
+	for _, e := range entries {
+		name := NormalizeBlocklistName(e.Name)
+		if name == "" {
+			continue
+		}
+		addedAt := e.AddedAt
+		if addedAt == 0 {
+			addedAt = now
+		}
+		var tags interface{}
+		if len(e.Tags) > 0 {
+			b, err := json.Marshal(e.Tags)
+			if err != nil {
+				return 0, err
+			}
+			tags = string(b)
+		}
+		var expiresAt interface{}
+		if e.ExpiresAt != 0 {
+			expiresAt = e.ExpiresAt
+		}
+
+		if _, err := tx.Exec(purge, name, now); err != nil {
+			return 0, err
+		}
+		res, err := stmt.Exec(name, addedAt, nullIfEmpty(e.AddedBy), nullIfEmpty(e.Reason), tags, expiresAt)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			added += int(n)
+		}
+	}
+	return added, nil
+}
+
+// remove deletes the given names in a single transaction and returns how many were on the list.
+func (t blocklistTable) remove(names []string) (int, error) {
+	db := GetDB()
+	if db == nil {
+		return 0, errNoDB
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := t.verifyTx(tx); err != nil {
+		return 0, err
+	}
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", t.table, t.column)
+	removed := 0
+	for _, name := range names {
+		res, err := tx.Exec(q, NormalizeBlocklistName(name))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			removed += int(n)
+		}
+	}
+	generation, err := t.signTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	return removed, t.commitSigned(tx, generation)
+}
+
+// replace makes the list contain exactly the given names. Entries that stay keep their metadata.
+func (t blocklistTable) replace(names []string) error {
+	db := GetDB()
+	if db == nil {
+		return errNoDB
+	}
+
+	keep := make(map[string]bool, len(names))
+	entries := make([]BlocklistEntry, 0, len(names))
+	for _, name := range names {
+		name = NormalizeBlocklistName(name)
+		if name == "" || keep[name] {
+			continue
+		}
+		keep[name] = true
+		entries = append(entries, BlocklistEntry{Name: name})
+	}
+
+	current, err := t.names()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := t.verifyTx(tx); err != nil {
+		return err
+	}
+	del := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", t.table, t.column)
+	for _, name := range current {
+		if !keep[name] {
+			if _, err := tx.Exec(del, name); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := t.addTx(tx, entries); err != nil {
+		return err
+	}
+	generation, err := t.signTx(tx)
+	if err != nil {
+		return err
+	}
+	return t.commitSigned(tx, generation)
+}
+
+// sweep deletes every entry that has expired at the given time and returns their keys.
+func (t blocklistTable) sweep(now time.Time) ([]string, error) {
+	db := GetDB()
+	if db == nil {
+		return nil, errNoDB
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := t.verifyTx(tx); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s FROM %s WHERE expires_at <= ?", t.column, t.table), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		expired = append(expired, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE expires_at <= ?", t.table), now.Unix()); err != nil {
+		return nil, err
+	}
+	generation, err := t.signTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return expired, t.commitSigned(tx, generation)
+}
+
+// SweepExpiredBlocklists removes every app and web blocklist entry that has expired at the given time
+// and returns the names and domains that were removed.
+func SweepExpiredBlocklists(now time.Time) (apps, domains []string, err error) {
+	if apps, err = appBlocklistTable.sweep(now); err != nil {
+		return nil, nil, fmt.Errorf("app blocklist: %w", err)
+	}
+	if domains, err = webBlocklistTable.sweep(now); err != nil {
+		return apps, nil, fmt.Errorf("web blocklist: %w", err)
+	}
+	return apps, domains, nil
+}
+
+// clear removes every entry.
+func (t blocklistTable) clear() error {
+	db := GetDB()
+	if db == nil {
+		return errNoDB
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := t.verifyTx(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", t.table)); err != nil {
+		return err
+	}
+	generation, err := t.signTx(tx)
+	if err != nil {
+		return err
+	}
+	return t.commitSigned(tx, generation)
+}
+
+// export returns the list as an indented JSON document suitable for ImportAppBlocklist or ImportWebBlocklist.
+func (t blocklistTable) export() ([]byte, error) {
+	entries, err := t.entries()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+
+	doc := blocklistExport{
+		ExportedAt: time.Now().In(DisplayLocation()).Format(time.RFC3339),
+		Blocked:    names,
+		Entries:    entries,
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// importJSON merges a JSON blocklist into the table and returns the number of new entries.
+// It accepts a plain list of strings, an export from an older version ({"blocked": [...]})
+// and an export from this version, whose metadata is preserved.
+func (t blocklistTable) importJSON(content []byte) (int, error) {
+	var entries []BlocklistEntry
+
+	var plain []string
+	if err := json.Unmarshal(content, &plain); err == nil {
+		for _, name := range plain {
+			entries = append(entries, BlocklistEntry{Name: name, BlocklistMeta: BlocklistMeta{AddedBy: AddedByImport}})
+		}
+	} else {
+		var doc blocklistExport
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return 0, fmt.Errorf("invalid JSON format: %w", err)
+		}
+		if len(doc.Entries) > 0 {
+			entries = doc.Entries
+		} else {
+			for _, name := range doc.Blocked {
+				entries = append(entries, BlocklistEntry{Name: name, BlocklistMeta: BlocklistMeta{AddedBy: AddedByImport}})
+			}
+		}
+	}
+
+	return t.add(entries)
+}
+
+// migrateLegacyJSON moves the entries of a blocklist JSON file from an older version into the table,
+// then renames the file so that the migration only happens once.
+func (t blocklistTable) migrateLegacyJSON(fileName string) error {
+	p, err := DataPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil // Nothing to migrate.
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []string
+	if err := json.Unmarshal(content, &list); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", fileName, err)
+	}
+	entries := make([]BlocklistEntry, 0, len(list))
+	for _, name := range list {
+		entries = append(entries, BlocklistEntry{Name: name, BlocklistMeta: BlocklistMeta{AddedBy: AddedByLegacy}})
+	}
+	if _, err := t.add(entries); err != nil {
+		return err
+	}
+
+	return os.Rename(p, p+".migrated")
+}
+
+// migrateLegacyBlocklists imports the JSON blocklist files used by older versions into the database.
+// It is called on every start, and is a no-op once the files have been migrated.
+func migrateLegacyBlocklists() error {
+	if err := appBlocklistTable.migrateLegacyJSON(appBlocklistFile); err != nil {
+		return fmt.Errorf("app blocklist: %w", err)
+	}
+	if err := webBlocklistTable.migrateLegacyJSON(webBlocklistFile); err != nil {
+		return fmt.Errorf("web blocklist: %w", err)
+	}
+	return nil
+}
+
+// signedBlocklistEntry is a row of a blocklist table as it is signed: every column exactly as it is stored.
+type signedBlocklistEntry struct {
+	Name      string  `json:"name"`
+	AddedAt   int64   `json:"added_at"`
+	AddedBy   *string `json:"added_by"`
+	Reason    *string `json:"reason"`
+	Tags      *string `json:"tags"`
+	ExpiresAt *int64  `json:"expires_at"`
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// content returns every row of the table, expired or not, as the JSON document that is signed.
+func (t blocklistTable) content(q sqlQueryer) ([]byte, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT %s, added_at, added_by, reason, tags, expires_at FROM %s ORDER BY %s",
+		t.column, t.table, t.column))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	entries := []signedBlocklistEntry{}
+	for rows.Next() {
+		var e signedBlocklistEntry
+		if err := rows.Scan(&e.Name, &e.AddedAt, &e.AddedBy, &e.Reason, &e.Tags, &e.ExpiresAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// signTx stores the content of the table together with its signature, within the transaction that changed it,
+// and returns the generation it was signed as, which is above every generation of the table seen so far.
+// The stored content is the last verified version of the table, which verifyTx falls back to.
+func (t blocklistTable) signTx(tx *sql.Tx) (uint64, error) {
+	content, err := t.content(tx)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	err = tx.QueryRow("SELECT generation FROM policy_signatures WHERE name = ?", t.table).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	generation := max(uint64(current), policyGeneration(t.table)) + 1
+	signature, err := signPolicy(t.table, generationPayload(generation, content))
+	if err != nil {
+		return 0, fmt.Errorf("could not sign %s: %w", t.table, err)
+	}
+	_, err = tx.Exec(`INSERT INTO policy_signatures (name, content, signature, generation) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET content = excluded.content, signature = excluded.signature,
+			generation = excluded.generation`,
+		t.table, string(content), signature, int64(generation))
+	if err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+// commitSigned commits a transaction in which signTx signed the table as generation, and records the generation
+// in the settings, so that the table can't be rolled back to an older signed version with the database alone.
+func (t blocklistTable) commitSigned(tx *sql.Tx, generation uint64) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notePolicyGeneration(t.table, generation)
+	err := UpdateConfig(func(c *Config) error {
+		if c.PolicyGenerations == nil {
+			c.PolicyGenerations = make(map[string]uint64)
+		}
+		c.PolicyGenerations[t.table] = max(c.PolicyGenerations[t.table], generation)
+		return nil
+	})
+	if err != nil {
+		GetLogger().Error("Failed to record the blocklist generation in the settings", "table", t.table, "err", err)
+	}
+	return nil
+}
+
+// verifiedSignatures maps each blocklist table to the last signature in policy_signatures that matched its content,
+// so that the signature is only checked again once it changes.
+var verifiedSignatures sync.Map
+
+// checkSignature compares the table with its last verified content. It returns that content and whether the
+// table matches it, or an error wrapping ErrPolicyTampered if the stored content doesn't match its signature
+// or is older than a generation of the table seen before.
+func (t blocklistTable) checkSignature(q sqlQueryer) ([]byte, bool, error) {
+	var signed, signature string
+	var generation int64
+	err := q.QueryRow("SELECT content, signature, generation FROM policy_signatures WHERE name = ?", t.table).
+		Scan(&signed, &signature, &generation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, fmt.Errorf("%w: %s has no signature", ErrPolicyTampered, t.table)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if latest := policyGeneration(t.table); uint64(generation) < latest {
+		return nil, false, fmt.Errorf("%w: %s is signed as generation %d, older than generation %d",
+			ErrPolicyTampered, t.table, generation, latest)
+	}
+	if verified, ok := verifiedSignatures.Load(t.table); !ok || verified != signature {
+		payload := generationPayload(uint64(generation), []byte(signed))
+		if err := checkPolicySignature(t.table, payload, signature); err != nil {
+			return nil, false, err
+		}
+		notePolicyGeneration(t.table, uint64(generation))
+		// A new signature that verifies means that the policy was changed by ProcGuard, after which
+		// a change made outside of it is a new incident.
+		verifiedSignatures.Store(t.table, signature)
+		clearTamperAlert(t.table)
+	}
+	current, err := t.content(q)
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(signed), string(current) == signed, nil
+}
+
+// verify checks the table before it is read, and refuses changes made outside of ProcGuard with verifyTx.
+// Only a table that doesn't match its signature takes a write transaction.
+func (t blocklistTable) verify(db *sql.DB) error {
+	if _, matches, err := t.checkSignature(db); err == nil && matches {
+		return nil
+	}
+
+	// The table and its signature may have been read on either side of a change, so the check is repeated
+	// in a transaction, which can also put the verified content back.
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err := t.verifyTx(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// verifyTx checks the table within tx, before it is changed. If it was changed outside of ProcGuard, the change is
+// refused: the last verified content is written back and a tamper alert is raised. If there is no verified content,
+// the alert is raised and an error wrapping ErrPolicyTampered is returned.
+// The alert names the refused content, so the same change made over and over is reported once.
+func (t blocklistTable) verifyTx(tx *sql.Tx) error {
+	signed, matches, err := t.checkSignature(tx)
+	if errors.Is(err, ErrPolicyTampered) {
+		raiseTamperAlert(t.table, err.Error())
+	}
+	if err != nil || matches {
+		return err
+	}
+
+	refused, err := t.content(tx)
+	if err != nil {
+		return err
+	}
+	var entries []signedBlocklistEntry
+	if err := json.Unmarshal(signed, &entries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", t.table)); err != nil {
+		return err
+	}
+	q := fmt.Sprintf("INSERT INTO %s (%s, added_at, added_by, reason, tags, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		t.table, t.column)
+	for _, e := range entries {
+		if _, err := tx.Exec(q, e.Name, e.AddedAt, e.AddedBy, e.Reason, e.Tags, e.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	sum := sha256.Sum256(refused)
+	raiseTamperAlert(t.table, fmt.Sprintf("the table was changed outside of ProcGuard (content %x); "+
+		"its last verified content was restored", sum[:8]))
+	return nil
+}
+
+// nullIfEmpty stores empty strings as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
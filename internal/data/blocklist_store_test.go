@@ -0,0 +1,163 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// setTestBlocklist makes the app blocklist hold exactly names, signed as a new generation.
+func setTestBlocklist(t *testing.T, db *sql.DB, names ...string) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec("DELETE FROM app_blocklist"); err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]BlocklistEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, BlocklistEntry{Name: name})
+	}
+	if _, err := appBlocklistTable.addTx(tx, entries); err != nil {
+		t.Fatal(err)
+	}
+	generation, err := appBlocklistTable.signTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appBlocklistTable.commitSigned(tx, generation); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signedRow is a row of the policy_signatures table.
+type signedRow struct {
+	content, signature string
+	generation         int64
+}
+
+// readSignedRow returns the signature row of the app blocklist.
+func readSignedRow(t *testing.T, db *sql.DB) signedRow {
+	t.Helper()
+	var row signedRow
+	err := db.QueryRow("SELECT content, signature, generation FROM policy_signatures WHERE name = 'app_blocklist'").
+		Scan(&row.content, &row.signature, &row.generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+// execTest runs statements that change the database outside of ProcGuard.
+func execTest(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlocklistNamesTampered(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the database, which holds a.exe and b.exe, outside of ProcGuard.
+		tamper func(t *testing.T, db *sql.DB)
+		// restart forgets the last list read while the table verified.
+		restart      bool
+		want         []string
+		wantTampered bool
+	}{
+		{
+			name: "entry deleted",
+			tamper: func(t *testing.T, db *sql.DB) {
+				execTest(t, db, "DELETE FROM app_blocklist WHERE name = 'b.exe'")
+			},
+			want: []string{"a.exe", "b.exe"},
+		},
+		{
+			name: "entry added",
+			tamper: func(t *testing.T, db *sql.DB) {
+				execTest(t, db, "INSERT INTO app_blocklist (name, added_at) VALUES ('c.exe', 4102444800)")
+			},
+			want: []string{"a.exe", "b.exe"},
+		},
+		{
+			name: "rolled back to an older signed version",
+			tamper: func(t *testing.T, db *sql.DB) {
+				rollBackBlocklist(t, db)
+			},
+			want:         []string{"a.exe", "b.exe"},
+			wantTampered: true,
+		},
+		{
+			name: "rolled back to an older signed version before a restart",
+			tamper: func(t *testing.T, db *sql.DB) {
+				rollBackBlocklist(t, db)
+			},
+			restart:      true,
+			want:         []string{"a.exe"},
+			wantTampered: true,
+		},
+		{
+			name: "signature forged and entry deleted before a restart",
+			tamper: func(t *testing.T, db *sql.DB) {
+				execTest(t, db, "UPDATE policy_signatures SET signature = 'forged' WHERE name = 'app_blocklist'")
+				execTest(t, db, "DELETE FROM app_blocklist WHERE name = 'b.exe'")
+			},
+			restart:      true,
+			want:         []string{"a.exe", "b.exe"},
+			wantTampered: true,
+		},
+		{
+			name: "signature deleted and entry added before a restart",
+			tamper: func(t *testing.T, db *sql.DB) {
+				execTest(t, db, "DELETE FROM policy_signatures WHERE name = 'app_blocklist'")
+				execTest(t, db, "INSERT INTO app_blocklist (name, added_at) VALUES ('c.exe', 4102444800)")
+			},
+			restart:      true,
+			want:         []string{"a.exe", "b.exe", "c.exe"},
+			wantTampered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			setTestBlocklist(t, db, "a.exe", "b.exe")
+			if _, err := LoadAppBlocklist(); err != nil {
+				t.Fatalf("LoadAppBlocklist before tampering: %v", err)
+			}
+
+			tt.tamper(t, db)
+			if tt.restart {
+				openTestDB(t)
+			}
+			got, err := LoadAppBlocklist()
+			if errors.Is(err, ErrPolicyTampered) != tt.wantTampered {
+				t.Errorf("LoadAppBlocklist() error = %v, want tampered %v", err, tt.wantTampered)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAppBlocklist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rollBackBlocklist puts back the signed app blocklist that held only a.exe, after it was changed to hold b.exe too.
+func rollBackBlocklist(t *testing.T, db *sql.DB) {
+	t.Helper()
+	setTestBlocklist(t, db, "a.exe")
+	old := readSignedRow(t, db)
+	setTestBlocklist(t, db, "a.exe", "b.exe")
+	if _, err := LoadAppBlocklist(); err != nil {
+		t.Fatal(err)
+	}
+	execTest(t, db, "DELETE FROM app_blocklist WHERE name = 'b.exe'")
+	execTest(t, db, "UPDATE policy_signatures SET content = ?, signature = ?, generation = ? WHERE name = 'app_blocklist'",
+		old.content, old.signature, old.generation)
+	execTest(t, db, "UPDATE policy_generations SET generation = ? WHERE name = 'app_blocklist'", old.generation)
+}
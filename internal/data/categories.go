@@ -0,0 +1,127 @@
+package data
+
+import "strings"
+
+// CategoryOther is used for apps and domains that don't belong to any known category.
+const CategoryOther = "other"
+
+// appCategories maps well-known executable names (lowercase) to a usage category.
+var appCategories = map[string]string{
+	// Browsers
+	"chrome.exe":  "browser",
+	"msedge.exe":  "browser",
+	"firefox.exe": "browser",
+	"opera.exe":   "browser",
+	"brave.exe":   "browser",
+	"coccoc.exe":  "browser",
+
+	// Communication
+	"discord.exe":   "communication",
+	"zalo.exe":      "communication",
+	"telegram.exe":  "communication",
+	"teams.exe":     "communication",
+	"ms-teams.exe":  "communication",
+	"slack.exe":     "communication",
+	"skype.exe":     "communication",
+	"zoom.exe":      "communication",
+	"messenger.exe": "communication",
+
+	// Games and game launchers
+	"steam.exe":              "games",
+	"epicgameslauncher.exe":  "games",
+	"riotclientservices.exe": "games",
+	"leagueclient.exe":       "games",
+	"robloxplayerbeta.exe":   "games",
+	"minecraft.exe":          "games",
+	"javaw.exe":              "games",
+	"battle.net.exe":         "games",
+
+	// Entertainment
+	"spotify.exe": "entertainment",
+	"vlc.exe":     "entertainment",
+	"netflix.exe": "entertainment",
+
+	// Productivity
+	"winword.exe":  "productivity",
+	"excel.exe":    "productivity",
+	"powerpnt.exe": "productivity",
+	"onenote.exe":  "productivity",
+	"outlook.exe":  "productivity",
+	"notepad.exe":  "productivity",
+	"acrord32.exe": "productivity",
+
+	// Development
+	"code.exe":            "development",
+	"devenv.exe":          "development",
+	"idea64.exe":          "development",
+	"pycharm64.exe":       "development",
+	"windowsterminal.exe": "development",
+}
+
+// domainCategories maps well-known registrable domains to a usage category.
+var domainCategories = map[string]string{
+	// Social media
+	"facebook.com":  "social",
+	"instagram.com": "social",
+	"tiktok.com":    "social",
+	"twitter.com":   "social",
+	"x.com":         "social",
+	"reddit.com":    "social",
+	"threads.net":   "social",
+
+	// Video and streaming
+	"youtube.com":  "video",
+	"netflix.com":  "video",
+	"twitch.tv":    "video",
+	"bilibili.com": "video",
+
+	// Games
+	"roblox.com":       "games",
+	"poki.com":         "games",
+	"miniclip.com":     "games",
+	"steampowered.com": "games",
+
+	// Communication
+	"messenger.com":   "communication",
+	"discord.com":     "communication",
+	"zalo.me":         "communication",
+	"mail.google.com": "communication",
+
+	// Education and reference
+	"wikipedia.org":   "education",
+	"khanacademy.org": "education",
+	"duolingo.com":    "education",
+	"coursera.org":    "education",
+
+	// Productivity
+	"docs.google.com":  "productivity",
+	"drive.google.com": "productivity",
+	"office.com":       "productivity",
+	"notion.so":        "productivity",
+	"github.com":       "productivity",
+}
+
+// AppCategory returns the usage category of a process, or CategoryOther if it is unknown.
+func AppCategory(processName string) string {
+	if category, ok := appCategories[strings.ToLower(processName)]; ok {
+		return category
+	}
+	return CategoryOther
+}
+
+// DomainCategory returns the usage category of a domain, or CategoryOther if it is unknown.
+// Subdomains inherit the category of their parent domain, so "m.youtube.com" is "video".
+func DomainCategory(domain string) string {
+	domain = strings.TrimPrefix(strings.ToLower(domain), "www.")
+	for domain != "" {
+		if category, ok := domainCategories[domain]; ok {
+			return category
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return CategoryOther
+}
@@ -2,8 +2,19 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
-	"path/filepath"
+	"sync"
+)
+
+// Enforcement modes control what happens to a program once it is on the blocklist.
+const (
+	// EnforcementKill stops blocked programs from running but leaves their files untouched.
+	EnforcementKill = "kill"
+	// EnforcementDisable additionally disables a blocked program's executable on disk.
+	EnforcementDisable = "disable"
 )
 
 // Config defines the structure of the application's configuration file.
@@ -13,67 +24,312 @@ type Config struct {
 	AutostartEnabled bool `json:"autostart_enabled,omitempty"`
 	// PasswordHash stores the bcrypt hash of the GUI password.
 	PasswordHash string `json:"password_hash,omitempty"`
+	// EnforcementMode is either EnforcementKill or EnforcementDisable. An empty value means EnforcementKill.
+	EnforcementMode string `json:"enforcement_mode,omitempty"`
+	// Retention controls how long recorded activity is kept.
+	Retention RetentionConfig `json:"retention"`
+	// Timezone is the IANA name of the time zone that times are shown and days are counted in, such as "Europe/Berlin".
+	// An empty value means the time zone of the system.
+	Timezone string `json:"timezone,omitempty"`
+	// Encryption holds the keys that protect sensitive columns of the activity database.
+	Encryption EncryptionConfig `json:"encryption"`
+	// Backup controls the scheduled backups.
+	Backup BackupConfig `json:"backup"`
+	// Generation is raised every time the file is saved, so that an older signed copy put back in its place is refused.
+	Generation uint64 `json:"generation,omitempty"`
+	// PolicyGenerations holds the generation of each blocklist as it was last signed, so that an older signed
+	// version put back in the database is refused even if the policy_generations table was rolled back with it.
+	PolicyGenerations map[string]uint64 `json:"policy_generations,omitempty"`
+	// Signature is the signature of the rest of the file under the policy key, so that edits made
+	// outside of ProcGuard are refused.
+	Signature string `json:"signature,omitempty"`
+}
+
+// EncryptionConfig holds the keys of the column encryption, none of which reveal the data on their own.
+// Values are sealed to PublicKey, so they can be written while nobody is logged in. Reading them takes the secret
+// that the private key is derived from, which is only stored wrapped with a key derived from the admin password.
+type EncryptionConfig struct {
+	// Enabled tells whether new values of sensitive columns are encrypted. While it is false and the keys are still set,
+	// existing values are being decrypted.
+	Enabled bool `json:"enabled"`
+	// PublicKey is the base64 X25519 public key that values are sealed to.
+	PublicKey string `json:"public_key,omitempty"`
+	// Salt is the base64 Argon2id salt of the key that wraps the secret.
+	Salt string `json:"salt,omitempty"`
+	// WrappedSecret is the base64 secret from which the private key and the hash key are derived, sealed with AES-GCM.
+	WrappedSecret string `json:"wrapped_secret,omitempty"`
+}
+
+// RetentionConfig controls how many days each kind of recorded data is kept before it is pruned.
+// A value of 0 keeps the data forever.
+type RetentionConfig struct {
+	// RawEventDays applies to app_events, web_events and block_events.
+	RawEventDays int `json:"raw_event_days"`
+	// LogDays applies to the logs and heartbeats tables.
+	LogDays int `json:"log_days"`
+	// RollupDays applies to aggregated daily statistics.
+	RollupDays int `json:"rollup_days"`
+	// TamperEventDays applies to tamper_events, which are kept forever by default: they are the record of attempts
+	// to get around the policy, and pruning them along with the logs would let an attempt age out of sight.
+	// It is left out of settings that don't set it, so that they keep matching the signatures written before it existed.
+	TamperEventDays int `json:"tamper_event_days,omitempty"`
+}
+
+// DefaultRetention returns the retention policy used when the user hasn't configured one.
+func DefaultRetention() RetentionConfig {
+	return RetentionConfig{
+		RawEventDays: 90,
+		LogDays:      14,
+		RollupDays:   0,
+	}
+}
+
+// DisablesExecutables reports whether blocked executables should be disabled on disk.
+func (c *Config) DisablesExecutables() bool {
+	return c.EnforcementMode == EnforcementDisable
 }
 
 // NewConfig creates a new Config with default values.
 func NewConfig() *Config {
-	return &Config{}
+	return &Config{
+		Retention: DefaultRetention(),
+		Backup:    DefaultBackup(),
+	}
 }
 
-// GetConfigPath returns the path to the configuration file, which is stored in the user's cache directory.
+// GetConfigPath returns the path to the configuration file, which is stored in the data directory.
 func GetConfigPath() (string, error) {
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(cacheDir, "procguard", "config", "settings.json"), nil
+	return DataPath("config", "settings.json")
 }
 
+// configFileMode is the permission of the configuration file.
+const configFileMode = 0644
+
+// configPolicyName identifies the configuration file in its signature.
+const configPolicyName = "settings"
+
+var (
+	// verifiedConfigMu guards verifiedConfig.
+	verifiedConfigMu sync.Mutex
+	// verifiedConfig is the content of the configuration file as this process last verified or wrote it.
+	verifiedConfig []byte
+)
+
 // LoadConfig reads the configuration file from the user's cache directory.
-// If the file doesn't exist, it returns a new default configuration, making the application resilient.
+// If the file doesn't exist before the policy is first signed, it returns a new default configuration, making
+// the application resilient. From then on, a missing file is restored from its last good copy, and if that is
+// missing too, it is reported as tampering.
+// If the file is corrupted or doesn't match its signature, the last good copy kept by Save is used and restored.
+// If that copy was tampered with as well, the configuration last verified by this process is written back.
 func LoadConfig() (*Config, error) {
 	path, err := GetConfigPath()
 	if err != nil {
 		return nil, err
 	}
+	content, err := readFileRecovering(path, configFileMode, validConfig)
+	err = configMissing(err)
+	if lastVerified, ok := lastVerifiedConfig(err); ok {
+		content, err = lastVerified, nil
+		if err := replaceFile(path, content, configFileMode); err != nil {
+			log.Printf("[ERROR] Failed to restore %s: %v", path, err)
+		}
+	}
+	return parseConfigFile(content, err)
+}
+
+// configMissing turns the error of a configuration file that doesn't exist, nor its last good copy, into an error
+// wrapping ErrPolicyTampered once the policy has been signed, since the file was deleted, and raises a tamper alert.
+// Before that, the file just hasn't been written yet. Other errors are returned as they are.
+func configMissing(err error) error {
+	if !os.IsNotExist(err) || !policyKeyExpected() {
+		return err
+	}
+	raiseTamperAlert("settings.json", "the settings and their last good copy were deleted")
+	return fmt.Errorf("%w: the settings and their last good copy are missing", ErrPolicyTampered)
+}
+
+// rememberVerifiedConfig keeps content, which was verified or written by this process, for lastVerifiedConfig.
+func rememberVerifiedConfig(content []byte) {
+	verifiedConfigMu.Lock()
+	defer verifiedConfigMu.Unlock()
+	verifiedConfig = content
+}
+
+// lastVerifiedConfig returns the configuration last verified by this process if err tells that neither the file
+// nor its last good copy could be verified, so that the policy in force stays in force. Nothing is returned
+// if that configuration is older than a generation seen since.
+func lastVerifiedConfig(err error) ([]byte, bool) {
+	if !errors.Is(err, ErrPolicyTampered) {
+		return nil, false
+	}
+	verifiedConfigMu.Lock()
+	content := verifiedConfig
+	verifiedConfigMu.Unlock()
+	if content == nil {
+		return nil, false
+	}
+	var c Config
+	if json.Unmarshal(content, &c) != nil || c.Generation < policyGeneration(configPolicyName) {
+		return nil, false
+	}
+	return content, true
+}
 
-	content, err := os.ReadFile(path)
+// parseConfigFile parses the content of the configuration file, as returned with its error by readFileRecovering.
+func parseConfigFile(content []byte, err error) (*Config, error) {
 	if os.IsNotExist(err) {
-		// If the config file doesn't exist, create a new one with default values.
-		// This makes the application more robust on first run or if the file is deleted.
+		// On first run the config file doesn't exist yet, so start from default values.
+		// Once the policy has been signed, configMissing reports a missing file as tampering instead.
 		return NewConfig(), nil
 	}
 	if err != nil {
+		// If neither the file nor its last good copy is valid, it's better to return an error
+		// than to proceed with a potentially broken configuration.
 		return nil, err
 	}
 
-	var config Config
-	if err := json.Unmarshal(content, &config); err != nil {
-		// If the file is corrupted or invalid, it's better to return an error
-		// than to proceed with a potentially broken configuration.
+	// Start from the defaults so that settings missing from older config files keep sensible values.
+	config := NewConfig()
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	rememberVerifiedConfig(content)
+	return config, nil
+}
+
+// validConfig checks that content is a configuration file that LoadConfig can use.
+func validConfig(content []byte) error {
+	if err := json.Unmarshal(content, NewConfig()); err != nil {
+		return err
+	}
+	return verifyConfig(content)
+}
+
+// encodeConfig returns the content of the configuration file for c, signed with the policy key.
+func encodeConfig(c *Config) ([]byte, error) {
+	unsigned := *c
+	unsigned.Signature = ""
+	content, err := json.MarshalIndent(unsigned, "", "  ")
+	if err != nil {
 		return nil, err
 	}
+	if unsigned.Signature, err = signPolicy(configPolicyName, content); err != nil {
+		return nil, fmt.Errorf("could not sign the configuration: %w", err)
+	}
+	return json.MarshalIndent(unsigned, "", "  ")
+}
+
+// verifyConfig checks the signature of the content of a configuration file, which is computed over the file
+// as encodeConfig writes it without the signature, and that it is not older than the newest generation seen.
+// Files written before the policy was first signed have no signature, and are only accepted until then:
+// see policyKeyExpected. The generations of the blocklists recorded in a verified file are noted.
+func verifyConfig(content []byte) error {
+	var c Config
+	if err := json.Unmarshal(content, &c); err != nil {
+		return err
+	}
+	signature := c.Signature
+	if signature == "" {
+		if !policyKeyExpected() {
+			return nil
+		}
+		return fmt.Errorf("%w: the settings are not signed", ErrPolicyTampered)
+	}
+	c.Signature = ""
+	unsigned, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := checkPolicySignature(configPolicyName, unsigned, signature); err != nil {
+		return err
+	}
+	if latest := policyGeneration(configPolicyName); c.Generation < latest {
+		return fmt.Errorf("%w: the settings are generation %d, older than generation %d",
+			ErrPolicyTampered, c.Generation, latest)
+	}
+	notePolicyGeneration(configPolicyName, c.Generation)
+	for name, generation := range c.PolicyGenerations {
+		notePolicyGeneration(name, generation)
+	}
+	return nil
+}
+
+// signUnsignedConfig signs a configuration file written before the policy was first signed, creating the key.
+// It must run before anything else creates the key, since unsigned files are only accepted until then.
+// Once the policy has been signed, an unsigned file is never signed again: it is refused as tampered with.
+func signUnsignedConfig() error {
+	if policyKeyExpected() {
+		return nil
+	}
+	return UpdateConfig(func(*Config) error { return nil })
+}
+
+// nextConfigGeneration raises the generation of c above every generation of the configuration seen so far.
+func nextConfigGeneration(c *Config) {
+	c.Generation = max(c.Generation, policyGeneration(configPolicyName)) + 1
+}
 
-	return &config, nil
+// configSaved notes the generation of the configuration that was just written as content.
+func configSaved(c *Config, content []byte) {
+	notePolicyGeneration(configPolicyName, c.Generation)
+	rememberVerifiedConfig(content)
 }
 
-// Save writes the current configuration to the configuration file.
+// Save signs the current configuration and writes it to the configuration file. The file is replaced atomically,
+// under a lock shared with the other ProcGuard processes, and a copy is kept as its last good copy.
+// Changes that depend on the current configuration should use UpdateConfig instead, so they can't overwrite
+// a change made in the meantime by another process.
 func (c *Config) Save() error {
 	path, err := GetConfigPath()
 	if err != nil {
 		return err
 	}
-
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	nextConfigGeneration(c)
+	content, err := encodeConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(path, content, configFileMode); err != nil {
 		return err
 	}
+	configSaved(c, content)
+	return nil
+}
 
-	// Marshal the config to JSON with indentation for readability.
-	content, err := json.MarshalIndent(c, "", "  ")
+// UpdateConfig loads the configuration, passes it to fn and saves it, all while holding the lock of the
+// configuration file, so that concurrent updates from this or another process are not lost.
+// If fn returns an error, nothing is saved and the error is returned.
+func UpdateConfig(fn func(*Config) error) error {
+	path, err := GetConfigPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(path)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// Write the content to the file with read/write permissions for the current user only.
-	return os.WriteFile(path, content, 0644)
+	content, err := readFileRecoveringLocked(path, configFileMode, validConfig)
+	err = configMissing(err)
+	if lastVerified, ok := lastVerifiedConfig(err); ok {
+		// It is written back with the update below.
+		content, err = lastVerified, nil
+	}
+	cfg, err := parseConfigFile(content, err)
+	if err != nil {
+		return err
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	nextConfigGeneration(cfg)
+	if content, err = encodeConfig(cfg); err != nil {
+		return err
+	}
+	if err := replaceFileLocked(path, content, configFileMode); err != nil {
+		return err
+	}
+	configSaved(cfg, content)
+	return nil
 }
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -12,18 +13,14 @@ import (
 )
 
 var (
-	globalDB *sql.DB
-	dbOnce   sync.Once
-	writeCh  chan WriteRequest
+	globalDB   *sql.DB
+	dbOnce     sync.Once
+	writeCh    chan WriteRequest
+	stopWriter context.CancelFunc
+	writerDone chan struct{}
 )
 
-// WriteRequest represents a request to write to the database.
-type WriteRequest struct {
-	Query string
-	Args  []interface{}
-}
-
-// InitDB initializes the database, creating the necessary tables and indexes if they don't exist.
+// InitDB initializes the database and brings its schema up to date by applying any pending migrations.
 // This function should be called once on application startup.
 func InitDB() (*sql.DB, error) {
 	var err error
@@ -33,12 +30,48 @@ func InitDB() (*sql.DB, error) {
 			return
 		}
 
-		if err = createSchema(globalDB); err != nil {
-			err = fmt.Errorf("could not create schema: %w", err)
+		// Whether the policy has been signed before, and the newest generation of each part of it, must be known
+		// before the settings are read: they decide whether the settings may be unsigned or are too old.
+		if err := loadPolicyState(globalDB); err != nil {
+			log.Printf("[ERROR] Failed to read the state of the policy: %v", err)
+		}
+		// Settings written by older versions must be signed before a migration creates the policy key.
+		if err := signUnsignedConfig(); err != nil {
+			log.Printf("[ERROR] Failed to sign the configuration: %v", err)
+		}
+		// The settings hold the generation that each blocklist was last signed as.
+		if _, err := LoadConfig(); err != nil {
+			log.Printf("[ERROR] Failed to load the configuration: %v", err)
+		}
+
+		if err = migrate(globalDB); err != nil {
+			err = fmt.Errorf("could not migrate schema: %w", err)
+			return
+		}
+
+		// Older versions kept the blocklists in JSON files; move them into the database.
+		if err := migrateLegacyBlocklists(); err != nil {
+			log.Printf("[ERROR] Failed to migrate legacy blocklists: %v", err)
+		}
+
+		// The display time zone may have been changed in settings.json while ProcGuard wasn't running.
+		if err := recountRollups(globalDB); err != nil {
+			log.Printf("[ERROR] Failed to recount the daily rollups: %v", err)
 		}
 
-		writeCh = make(chan WriteRequest, 100) // Buffered channel
-		go StartDatabaseWriter(globalDB)
+		writeCh = make(chan WriteRequest, writeQueueSize)
+
+		// The writer has its own lifetime, because it must keep running until every other subsystem
+		// has stopped and queued its last writes. CloseDB stops it.
+		var writerCtx context.Context
+		writerCtx, stopWriter = context.WithCancel(context.Background())
+		writerDone = make(chan struct{})
+		go func() {
+			defer close(writerDone)
+			StartDatabaseWriter(writerCtx, globalDB)
+		}()
+		recordPendingTamperEvents()
+		recordPendingPolicyGenerations()
 	})
 	if err != nil {
 		return nil, err
@@ -46,22 +79,25 @@ func InitDB() (*sql.DB, error) {
 	return globalDB, nil
 }
 
-// StartDatabaseWriter starts a goroutine that listens for write requests on the writeCh channel
-// and executes them sequentially against the database.
-func StartDatabaseWriter(db *sql.DB) {
-	for req := range writeCh {
-		_, err := db.Exec(req.Query, req.Args...)
-		if err != nil {
-			// If we can't write to the DB, log the failure.
-			// We can't use the normal logger here as it might create a deadlock.
-			log.Printf("[ERROR] Failed to execute write request: %v", err)
-		}
+// CloseDB writes out everything that is still queued, stops the database writer and closes the database.
+// It should be called once, after every other subsystem has stopped.
+func CloseDB(ctx context.Context) error {
+	if globalDB == nil {
+		return nil
 	}
-}
 
-// EnqueueWrite sends a write request to the database writer channel.
-func EnqueueWrite(query string, args ...interface{}) {
-	writeCh <- WriteRequest{Query: query, Args: args}
+	flushErr := Flush(ctx)
+	stopWriter()
+	select {
+	case <-writerDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := globalDB.Close(); err != nil {
+		return fmt.Errorf("could not close database: %w", err)
+	}
+	return flushErr
 }
 
 // OpenDB opens a connection to the SQLite database.
@@ -75,13 +111,17 @@ func GetDB() *sql.DB {
 	return globalDB
 }
 
+// GetDBPath returns the path to the SQLite database file, which is stored in the data directory.
+func GetDBPath() (string, error) {
+	return DataPath("procguard.db")
+}
+
 // openAndConfigureDB is a helper function that handles the common logic for opening and configuring the database connection.
 func openAndConfigureDB() (*sql.DB, error) {
-	cacheDir, err := os.UserCacheDir()
+	dbPath, err := GetDBPath()
 	if err != nil {
-		return nil, fmt.Errorf("could not get user cache dir: %w", err)
+		return nil, err
 	}
-	dbPath := filepath.Join(cacheDir, "procguard", "procguard.db")
 
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, fmt.Errorf("could not create database directory: %w", err)
@@ -89,61 +129,14 @@ func openAndConfigureDB() (*sql.DB, error) {
 
 	// Enable Write-Ahead Logging (WAL) mode. WAL allows for higher concurrency by separating read and write operations,
 	// which is beneficial for this application where the daemon is constantly writing and the API server is reading.
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", dbPath))
+	// The busy timeout makes concurrent writers wait for the lock instead of failing, and starting transactions
+	// with BEGIN IMMEDIATE avoids deadlocks when a transaction that has read data later needs to write.
+	// secure_delete zeroes the content of deleted and overwritten rows, so that values pruned by retention or
+	// replaced by their encrypted form don't linger in free pages of the file.
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=secure_delete(ON)&_txlock=immediate", dbPath)
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("could not open database: %w", err)
 	}
 	return db, nil
 }
-
-// createSchema defines and executes the SQL statements to create the database schema.
-func createSchema(db *sql.DB) error {
-	schema := `
-	-- app_events stores information about running processes.
-	CREATE TABLE IF NOT EXISTS app_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		process_name TEXT NOT NULL,
-		pid INTEGER NOT NULL,
-		parent_process_name TEXT,
-		exe_path TEXT,
-		start_time INTEGER NOT NULL,
-		end_time INTEGER
-	);
-
-	-- Indexes to speed up queries on app_events.
-	CREATE INDEX IF NOT EXISTS idx_app_events_start_time ON app_events (start_time);
-	CREATE INDEX IF NOT EXISTS idx_app_events_end_time ON app_events (end_time);
-	CREATE INDEX IF NOT EXISTS idx_app_events_pid ON app_events (pid);
-
-	-- web_events stores the URLs of visited websites.
-	CREATE TABLE IF NOT EXISTS web_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT NOT NULL,
-		timestamp INTEGER NOT NULL
-	);
-
-	-- Index to speed up queries on web_events.
-	CREATE INDEX IF NOT EXISTS idx_web_events_timestamp ON web_events (timestamp);
-
-	-- logs stores application logs.
-	CREATE TABLE IF NOT EXISTS logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp INTEGER NOT NULL,
-		level TEXT NOT NULL,
-		message TEXT NOT NULL
-	);
-
-	-- Index to speed up queries on logs.
-	CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs (timestamp);
-
-	-- web_metadata stores cached metadata for websites (title, icon).
-	CREATE TABLE IF NOT EXISTS web_metadata (
-		domain TEXT PRIMARY KEY,
-		title TEXT,
-		icon_url TEXT,
-		timestamp INTEGER NOT NULL
-	);
-	`
-	_, err := db.Exec(schema)
-	return err
-}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	disabledExecutablesFile = "disabled_executables.json"
+	// disabledExecutablesMode is the permission of the manifest, which only the current user may read.
+	disabledExecutablesMode = 0600
+)
+
+// DisabledExecutable records how a blocked executable was disabled on disk, so that it can be restored exactly.
+type DisabledExecutable struct {
+	// Entry is the blocklist entry that caused the executable to be disabled: a process name, a path or
+	// a "sha256:" hash, normalized as the blocklist stores it. It is kept under "name" for older manifests.
+	Entry string `json:"name"`
+	// OriginalPath is where the executable lived before it was disabled.
+	OriginalPath string `json:"original_path"`
+	// DisabledPath is where the executable lives while disabled. It equals OriginalPath when only the mode changed.
+	DisabledPath string `json:"disabled_path"`
+	// OriginalMode holds the file's permission bits before they were changed.
+	OriginalMode os.FileMode `json:"original_mode"`
+	// DisabledAt is the Unix time at which the executable was disabled.
+	DisabledAt int64 `json:"disabled_at"`
+}
+
+// getDisabledExecutablesPath returns the path of the disabled executables manifest in the data directory.
+func getDisabledExecutablesPath() (string, error) {
+	return DataPath(disabledExecutablesFile)
+}
+
+// LoadDisabledExecutables reads the disabled executables manifest.
+// If the file doesn't exist, it returns an empty list, which is not considered an error.
+func LoadDisabledExecutables() ([]DisabledExecutable, error) {
+	p, err := getDisabledExecutablesPath()
+	if err != nil {
+		return nil, err
+	}
+	return parseDisabledExecutables(readFileRecovering(p, disabledExecutablesMode, validDisabledExecutables))
+}
+
+// UpdateDisabledExecutables loads the manifest, passes it to fn and saves whatever fn returns.
+// The whole cycle holds the lock of the manifest, shared with the other ProcGuard processes,
+// so fn can safely change files on disk and record the change in one step.
+// If fn returns an error, the manifest is left unchanged.
+func UpdateDisabledExecutables(fn func([]DisabledExecutable) ([]DisabledExecutable, error)) error {
+	p, err := getDisabledExecutablesPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	list, err := parseDisabledExecutables(readFileRecoveringLocked(p, disabledExecutablesMode, validDisabledExecutables))
+	if err != nil {
+		return err
+	}
+	list, err = fn(list)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal disabled executables: %w", err)
+	}
+	return replaceFileLocked(p, b, disabledExecutablesMode)
+}
+
+// parseDisabledExecutables parses the manifest, as returned with its error by readFileRecovering.
+func parseDisabledExecutables(b []byte, err error) ([]DisabledExecutable, error) {
+	if os.IsNotExist(err) {
+		return []DisabledExecutable{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disabled executables: %w", err)
+	}
+
+	var list []DisabledExecutable
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal disabled executables: %w", err)
+	}
+	return list, nil
+}
+
+// validDisabledExecutables checks that b is a manifest that LoadDisabledExecutables can use.
+func validDisabledExecutables(b []byte) error {
+	var list []DisabledExecutable
+	return json.Unmarshal(b, &list)
+}
@@ -0,0 +1,667 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// encryptedPrefix marks the values sealed by sealField. The rest of the value is base64.
+	encryptedPrefix = "enc1:"
+	// isEncrypted is the SQL condition, with %s standing for a column, that is true for encrypted values.
+	// GLOB is used because, unlike LIKE, it is case-sensitive.
+	isEncrypted = "%s GLOB 'enc1:*'"
+
+	// The Argon2id parameters of the key that wraps the secret, as recommended by RFC 9106 for constrained memory.
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	// encryptionKeySize is the size of the secret and of every key derived from it.
+	encryptionKeySize = 32
+	// fieldHashSize is the number of bytes of the HMAC that the hash columns keep.
+	fieldHashSize = 16
+	// sweepBatchSize is the number of rows read at a time when existing values are encrypted or decrypted.
+	sweepBatchSize = 500
+)
+
+// ErrEncryptionLocked is returned when encrypted values are read before the admin has logged in.
+var ErrEncryptionLocked = errors.New("encrypted data is locked; log in to unlock it")
+
+// ErrWrongPassword is returned when the password doesn't unwrap the encryption secret.
+var ErrWrongPassword = errors.New("wrong password")
+
+// sealedColumn is a column that holds sensitive values, which are encrypted while encryption is enabled.
+type sealedColumn struct {
+	table, column string
+	// hash is the column that holds a keyed hash of each encrypted value, so that it can be found by equality.
+	// It is empty for columns that are only ever searched by substring.
+	hash string
+	// normalize maps equal values to the same hash input. It may be nil.
+	normalize func(string) string
+	// digest is a column that holds an unkeyed digest of part of each value, which would give the value away once
+	// it is encrypted. It is cleared when a value is encrypted and filled in by digestOf when it is decrypted.
+	digest   string
+	digestOf func(string) string
+}
+
+// Sealed columns that are written outside of sweeps.
+var (
+	sealedExePath = sealedColumn{table: "app_events", column: "exe_path", hash: "exe_path_hash", normalize: normalizePathForHash}
+	sealedCmdline = sealedColumn{table: "app_events", column: "cmdline"}
+	sealedURL     = sealedColumn{table: "web_events", column: "url", hash: "url_hash", digest: "query_hash", digestOf: urlQueryHash}
+	sealedTitle   = sealedColumn{table: "web_events", column: "title"}
+	sealedURLPath = sealedColumn{table: "web_events", column: "path"}
+	sealedBlocked = sealedColumn{table: "block_events", column: "exe_path"}
+)
+
+// sealedColumns lists every column that holds sensitive values.
+var sealedColumns = []sealedColumn{sealedExePath, sealedCmdline, sealedURL, sealedTitle, sealedURLPath, sealedBlocked}
+
+// urlQueryHash returns the query hash of a URL, which web_events keeps in query_hash.
+func urlQueryHash(url string) string {
+	return ParseURLComponents(url).QueryHash
+}
+
+// normalizePathForHash makes paths that Windows considers equal hash the same.
+func normalizePathForHash(path string) string {
+	return strings.ToLower(strings.ReplaceAll(path, `\`, "/"))
+}
+
+// encryptionKeys are the keys derived from the secret, which are only in memory while the data is unlocked.
+type encryptionKeys struct {
+	private *ecdh.PrivateKey
+	hashKey []byte
+}
+
+// encryptionState is the in-memory state of the column encryption, loaded from Config.Encryption on first use.
+type encryptionState struct {
+	mu      sync.RWMutex
+	loaded  bool
+	enabled bool
+	// public is nil if no keys have been set up.
+	public *ecdh.PublicKey
+	// keys is nil while the data is locked.
+	keys *encryptionKeys
+	// sweeping is held while existing values are encrypted or decrypted, so that sweeps run one at a time.
+	sweeping sync.Mutex
+}
+
+var encryption encryptionState
+
+// EncryptionStatus describes the state of the column encryption.
+type EncryptionStatus struct {
+	// Enabled tells whether new values of sensitive columns are encrypted.
+	Enabled bool `json:"enabled"`
+	// Locked tells whether encrypted values can't be read until the admin logs in.
+	Locked bool `json:"locked"`
+}
+
+// GetEncryptionStatus returns the state of the column encryption.
+func GetEncryptionStatus() EncryptionStatus {
+	encryption.load()
+	encryption.mu.RLock()
+	defer encryption.mu.RUnlock()
+	return EncryptionStatus{Enabled: encryption.enabled, Locked: encryption.public != nil && encryption.keys == nil}
+}
+
+// load reads the public state of the encryption from the config file, once.
+func (e *encryptionState) load() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.loaded {
+		return
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		// Try again on the next call. Until then values are written as they are, like before encryption was enabled.
+		return
+	}
+	if cfg.Encryption.PublicKey != "" {
+		public, err := decodePublicKey(cfg.Encryption.PublicKey)
+		if err != nil {
+			GetLogger().Error("Ignoring invalid encryption key in config", "err", err)
+			e.loaded = true
+			return
+		}
+		e.public = public
+		e.enabled = cfg.Encryption.Enabled
+	}
+	e.loaded = true
+}
+
+// EnableEncryption starts encrypting sensitive columns with a key protected by password, which must be the
+// admin password. Values recorded so far are encrypted in the background.
+func EnableEncryption(db *sql.DB, password string) error {
+	encryption.load()
+	var keys *encryptionKeys
+	err := UpdateConfig(func(cfg *Config) error {
+		if cfg.Encryption.PublicKey != "" {
+			// Encryption was disabled but its values haven't all been decrypted yet; keep using the same keys.
+			secret, err := unwrapSecret(cfg.Encryption, password)
+			if err != nil {
+				return err
+			}
+			if keys, err = deriveEncryptionKeys(secret); err != nil {
+				return err
+			}
+		} else {
+			secret := make([]byte, encryptionKeySize)
+			if _, err := rand.Read(secret); err != nil {
+				return err
+			}
+			var err error
+			if keys, err = deriveEncryptionKeys(secret); err != nil {
+				return err
+			}
+			salt, wrapped, err := wrapSecret(secret, password)
+			if err != nil {
+				return err
+			}
+			cfg.Encryption.PublicKey = base64.StdEncoding.EncodeToString(keys.private.PublicKey().Bytes())
+			cfg.Encryption.Salt, cfg.Encryption.WrappedSecret = salt, wrapped
+		}
+		cfg.Encryption.Enabled = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	encryption.mu.Lock()
+	encryption.enabled, encryption.public, encryption.keys = true, keys.private.PublicKey(), keys
+	encryption.mu.Unlock()
+	go encryption.sweep(db)
+	return nil
+}
+
+// DisableEncryption stops encrypting sensitive columns. password must be the admin password.
+// Encrypted values are decrypted in the background, after which the keys are deleted.
+func DisableEncryption(db *sql.DB, password string) error {
+	encryption.load()
+	var keys *encryptionKeys
+	err := UpdateConfig(func(cfg *Config) error {
+		if cfg.Encryption.PublicKey == "" {
+			return nil
+		}
+		secret, err := unwrapSecret(cfg.Encryption, password)
+		if err != nil {
+			return err
+		}
+		if keys, err = deriveEncryptionKeys(secret); err != nil {
+			return err
+		}
+		cfg.Encryption.Enabled = false
+		return nil
+	})
+	if err != nil || keys == nil {
+		return err
+	}
+
+	encryption.mu.Lock()
+	encryption.enabled, encryption.keys = false, keys
+	encryption.mu.Unlock()
+	go encryption.sweep(db)
+	return nil
+}
+
+// UnlockEncryption makes encrypted values readable with the admin password, which has already been checked,
+// until LockEncryption is called. It also finishes any work left over from before, such as hashing the values
+// that were recorded while the data was locked.
+func UnlockEncryption(db *sql.DB, password string) error {
+	encryption.load()
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Encryption.PublicKey == "" {
+		return nil
+	}
+
+	secret, err := unwrapSecret(cfg.Encryption, password)
+	if err != nil {
+		return err
+	}
+	keys, err := deriveEncryptionKeys(secret)
+	if err != nil {
+		return err
+	}
+	encryption.mu.Lock()
+	encryption.keys = keys
+	encryption.mu.Unlock()
+	go encryption.sweep(db)
+	return nil
+}
+
+// LockEncryption forgets the keys that UnlockEncryption derived. New values are still encrypted.
+func LockEncryption() {
+	encryption.mu.Lock()
+	encryption.keys = nil
+	encryption.mu.Unlock()
+}
+
+// RewrapEncryptionKey protects the encryption secret in cfg with a new admin password. The secret itself doesn't
+// change, so nothing has to be re-encrypted. It should be called when the password changes, and cfg saved
+// together with the new password hash, so that the key always opens with the password that logs in.
+func RewrapEncryptionKey(cfg *Config, oldPassword, newPassword string) error {
+	if cfg.Encryption.PublicKey == "" {
+		return nil
+	}
+
+	secret, err := unwrapSecret(cfg.Encryption, oldPassword)
+	if err != nil {
+		return err
+	}
+	salt, wrapped, err := wrapSecret(secret, newPassword)
+	if err != nil {
+		return err
+	}
+	cfg.Encryption.Salt, cfg.Encryption.WrappedSecret = salt, wrapped
+	return nil
+}
+
+// deriveEncryptionKeys derives the private key and the hash key from the secret.
+func deriveEncryptionKeys(secret []byte) (*encryptionKeys, error) {
+	seed, err := hkdf.Key(sha256.New, secret, nil, "procguard column encryption x25519", encryptionKeySize)
+	if err != nil {
+		return nil, err
+	}
+	private, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	hashKey, err := hkdf.Key(sha256.New, secret, nil, "procguard column hash", encryptionKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionKeys{private: private, hashKey: hashKey}, nil
+}
+
+// passwordKey derives the key that wraps the secret from the admin password.
+func passwordKey(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, encryptionKeySize)
+}
+
+// wrapSecret seals the secret with a key derived from password and a new salt. Both are returned as base64.
+func wrapSecret(secret []byte, password string) (string, string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", "", err
+	}
+	wrapped, err := sealAESGCM(passwordKey(password, salt), secret, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// unwrapSecret opens the secret wrapped by wrapSecret. It returns ErrWrongPassword if password doesn't open it.
+func unwrapSecret(cfg EncryptionConfig, password string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(cfg.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption salt: %w", err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(cfg.WrappedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped encryption secret: %w", err)
+	}
+	secret, err := openAESGCM(passwordKey(password, salt), wrapped, nil)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return secret, nil
+}
+
+// decodePublicKey parses a base64 X25519 public key.
+func decodePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// sealAESGCM encrypts plaintext with AES-256-GCM under a random nonce, which is prepended to the result.
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts a result of sealAESGCM.
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// valueKey derives the AES key of one value from an X25519 shared secret and both public keys.
+func valueKey(shared []byte, ephemeral, public *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), public.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, "procguard column value", encryptionKeySize)
+}
+
+// sealField encrypts a value for storage in a sealed column if encryption is enabled, and returns it unchanged otherwise.
+// Each value is encrypted with its own key, agreed between a new ephemeral X25519 key and the public key,
+// so only the public key is needed to write. The ephemeral public key is stored before the AES-GCM ciphertext.
+func sealField(value string) string {
+	encryption.load()
+	encryption.mu.RLock()
+	enabled, public := encryption.enabled, encryption.public
+	encryption.mu.RUnlock()
+	if !enabled || public == nil || value == "" {
+		return value
+	}
+
+	sealed, err := sealWithPublicKey(public, value)
+	if err != nil {
+		// Storing nothing is better than storing the value in the clear.
+		GetLogger().Error("Failed to encrypt value", "err", err)
+		return ""
+	}
+	return sealed
+}
+
+// sealWithPublicKey encrypts value to public, in the format described at sealField.
+func sealWithPublicKey(public *ecdh.PublicKey, value string) (string, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(public)
+	if err != nil {
+		return "", err
+	}
+	key, err := valueKey(shared, ephemeral.PublicKey(), public)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(key, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(append(ephemeral.PublicKey().Bytes(), ciphertext...)), nil
+}
+
+// openField returns the plaintext of a value read from a sealed column. Values that aren't encrypted are returned as they are.
+// It returns ErrEncryptionLocked if the value is encrypted and the data hasn't been unlocked.
+func openField(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	encryption.mu.RLock()
+	keys := encryption.keys
+	encryption.mu.RUnlock()
+	if keys == nil {
+		return "", ErrEncryptionLocked
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(rest)
+	if err != nil || len(raw) < 32 {
+		return "", errors.New("malformed encrypted value")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:32])
+	if err != nil {
+		return "", err
+	}
+	shared, err := keys.private.ECDH(ephemeral)
+	if err != nil {
+		return "", err
+	}
+	key, err := valueKey(shared, ephemeral, keys.private.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openAESGCM(key, raw[32:], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// fieldHash returns the keyed hash stored in the hash column of col for value, or nil if there is none:
+// when encryption is disabled, the data is locked, or the value is empty.
+func fieldHash(col sealedColumn, value string) interface{} {
+	encryption.load()
+	encryption.mu.RLock()
+	enabled, keys := encryption.enabled, encryption.keys
+	encryption.mu.RUnlock()
+	if !enabled || keys == nil || col.hash == "" || value == "" {
+		return nil
+	}
+	return keyedHash(keys, col, value)
+}
+
+// lookupHash returns the keyed hash to look value up by in the hash column of col, and whether there is one.
+// Unlike fieldHash, it also works while encryption is being disabled, as long as encrypted values remain.
+func lookupHash(col sealedColumn, value string) (string, bool) {
+	encryption.mu.RLock()
+	keys := encryption.keys
+	encryption.mu.RUnlock()
+	if keys == nil || col.hash == "" {
+		return "", false
+	}
+	return keyedHash(keys, col, value), true
+}
+
+// keyedHash computes the HMAC-SHA256 of a normalized value, truncated to fieldHashSize bytes.
+func keyedHash(keys *encryptionKeys, col sealedColumn, value string) string {
+	if col.normalize != nil {
+		value = col.normalize(value)
+	}
+	mac := hmac.New(sha256.New, keys.hashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:fieldHashSize])
+}
+
+// encryptionConfigured reports whether any keys have been set up, so sealed columns may hold encrypted values.
+func encryptionConfigured() bool {
+	encryption.load()
+	encryption.mu.RLock()
+	defer encryption.mu.RUnlock()
+	return encryption.public != nil
+}
+
+// sweep brings the values already in the database in line with the state of the encryption: it encrypts values
+// recorded in the clear while encryption is enabled, hashes values that were encrypted while the data was locked,
+// and decrypts every value once encryption is disabled, after which it deletes the keys.
+func (e *encryptionState) sweep(db *sql.DB) {
+	e.sweeping.Lock()
+	defer e.sweeping.Unlock()
+
+	e.mu.RLock()
+	enabled, public, keys := e.enabled, e.public, e.keys
+	e.mu.RUnlock()
+	if public == nil || (!enabled && keys == nil) {
+		return
+	}
+
+	encrypted := 0
+	for _, col := range sealedColumns {
+		var err error
+		switch {
+		case enabled:
+			var n int
+			n, err = sweepColumn(db, col, fmt.Sprintf("NOT "+isEncrypted+" AND %s != ''", col.column, col.column), func(value string) (string, error) {
+				return sealWithPublicKey(public, value)
+			})
+			encrypted += n
+			if err == nil && keys != nil && col.hash != "" {
+				_, err = sweepColumn(db, col, fmt.Sprintf(isEncrypted+" AND %s IS NULL", col.column, col.hash), nil)
+			}
+		default:
+			_, err = sweepColumn(db, col, fmt.Sprintf(isEncrypted, col.column), openField)
+		}
+		if err != nil {
+			GetLogger().Error("Failed to update encrypted column", "table", col.table, "column", col.column, "err", err)
+			return
+		}
+	}
+	if enabled {
+		if encrypted > 0 {
+			purgePlaintext()
+		}
+		return
+	}
+
+	// The keys can only go once every encrypted value has been written back in the clear.
+	if err := Flush(context.Background()); err != nil {
+		GetLogger().Error("Failed to flush decrypted values", "err", err)
+		return
+	}
+	for _, col := range sealedColumns {
+		var remaining int
+		q := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE "+isEncrypted, col.table, col.column)
+		if err := db.QueryRow(q).Scan(&remaining); err != nil || remaining > 0 {
+			GetLogger().Warn("Encrypted values remain, keeping the keys", "table", col.table, "column", col.column, "count", remaining, "err", err)
+			return
+		}
+	}
+	if err := clearEncryptionKeys(); err != nil {
+		GetLogger().Error("Failed to delete encryption keys", "err", err)
+		return
+	}
+	GetLogger().Info("Encryption disabled and all values decrypted")
+}
+
+// purgePlaintext removes what is left in the database file of values that have just been encrypted.
+// Overwritten rows are zeroed as they are freed, since every connection sets secure_delete, but the full-text
+// indexes only mark the tokens of the old values as deleted until their segments are merged, and the write-ahead
+// log keeps copies of the old pages until it is checkpointed. The writes are queued after the sweep's updates.
+func purgePlaintext() {
+	EnqueueWrite("INSERT INTO app_events_fts (app_events_fts) VALUES ('optimize')")
+	EnqueueWrite("INSERT INTO web_events_fts (web_events_fts) VALUES ('optimize')")
+	EnqueueStandaloneWrite("VACUUM")
+	EnqueueStandaloneWrite("PRAGMA wal_checkpoint(TRUNCATE)")
+}
+
+// sweepColumn rewrites the values of col that match cond with convert, along with their hashes and digests, in batches,
+// and returns the number of values it rewrote. If convert is nil, only the hashes are filled in. The updates go
+// through the database writer and only apply if the value hasn't changed in the meantime.
+func sweepColumn(db *sql.DB, col sealedColumn, cond string, convert func(string) (string, error)) (int, error) {
+	selectQuery := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ? AND %s ORDER BY rowid LIMIT ?", col.column, col.table, cond)
+	var sets []string
+	switch {
+	case convert == nil:
+		sets = []string{col.hash}
+	default:
+		sets = []string{col.column}
+		if col.hash != "" {
+			sets = append(sets, col.hash)
+		}
+		if col.digest != "" {
+			sets = append(sets, col.digest)
+		}
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ? AND %s = ?", col.table, strings.Join(sets, " = ?, "), col.column)
+
+	var lastID int64
+	converted := 0
+	for {
+		type row struct {
+			id    int64
+			value string
+		}
+		var batch []row
+		rows, err := db.Query(selectQuery, lastID, sweepBatchSize)
+		if err != nil {
+			return converted, err
+		}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.value); err != nil {
+				_ = rows.Close()
+				return converted, err
+			}
+			batch = append(batch, r)
+		}
+		if err := rows.Close(); err != nil {
+			return converted, err
+		}
+
+		for _, r := range batch {
+			plaintext, err := openField(r.value)
+			if err != nil {
+				return converted, err
+			}
+			if convert == nil {
+				EnqueueWrite(updateQuery, fieldHash(col, plaintext), r.id, r.value)
+				lastID = r.id
+				continue
+			}
+			value, err := convert(r.value)
+			if err != nil {
+				return converted, err
+			}
+			args := []interface{}{value}
+			if col.hash != "" {
+				args = append(args, fieldHash(col, plaintext))
+			}
+			if col.digest != "" {
+				var digest interface{}
+				if !strings.HasPrefix(value, encryptedPrefix) {
+					digest = nullIfEmpty(col.digestOf(value))
+				}
+				args = append(args, digest)
+			}
+			EnqueueWrite(updateQuery, append(args, r.id, r.value)...)
+			converted++
+			lastID = r.id
+		}
+		if len(batch) < sweepBatchSize {
+			return converted, nil
+		}
+	}
+}
+
+// clearEncryptionKeys deletes the keys from the config and from memory once nothing is encrypted with them.
+func clearEncryptionKeys() error {
+	encryption.mu.Lock()
+	defer encryption.mu.Unlock()
+	if encryption.enabled {
+		return nil // Enabled again in the meantime.
+	}
+	err := UpdateConfig(func(cfg *Config) error {
+		cfg.Encryption = EncryptionConfig{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encryption.public, encryption.keys = nil, nil
+	return nil
+}
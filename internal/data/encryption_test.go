@@ -0,0 +1,304 @@
+package data
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor calls done until it returns true, failing the test if that takes too long, since sweeps run in the background.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// webEventURL returns the url and url_hash columns of a row of web_events.
+func webEventURL(t *testing.T, db *sql.DB, id int64) (string, sql.NullString) {
+	t.Helper()
+	var url string
+	var hash sql.NullString
+	if err := db.QueryRow("SELECT url, url_hash FROM web_events WHERE id = ?", id).Scan(&url, &hash); err != nil {
+		t.Fatal(err)
+	}
+	return url, hash
+}
+
+// useTestEncryption starts the test with encryption off and turns it off again, keys included, when the test ends.
+func useTestEncryption(t *testing.T) {
+	t.Helper()
+	forgetEncryption()
+	t.Cleanup(func() {
+		encryption.sweeping.Lock()
+		defer encryption.sweeping.Unlock()
+		if err := UpdateConfig(func(cfg *Config) error {
+			cfg.Encryption = EncryptionConfig{}
+			return nil
+		}); err != nil {
+			t.Errorf("UpdateConfig: %v", err)
+		}
+		forgetEncryption()
+	})
+}
+
+func TestSealField(t *testing.T) {
+	db := openTestDB(t)
+	useTestEncryption(t)
+
+	if got := sealField("secret"); got != "secret" {
+		t.Errorf("sealField() before encryption was enabled = %q, want the value unchanged", got)
+	}
+	if err := EnableEncryption(db, "password"); err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "path", value: `C:\Program Files\Game\game.exe`},
+		{name: "url", value: "https://example.com/search?q=caf%C3%A9"},
+		{name: "unicode", value: "Café – 日本語"},
+		{name: "prefix", value: encryptedPrefix},
+		{name: "single byte", value: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed := sealField(tt.value)
+			if !strings.HasPrefix(sealed, encryptedPrefix) || sealed == tt.value {
+				t.Fatalf("sealField(%q) = %q, want it encrypted", tt.value, sealed)
+			}
+			if again := sealField(tt.value); again == sealed {
+				t.Errorf("sealField(%q) gave the same ciphertext twice", tt.value)
+			}
+			got, err := openField(sealed)
+			if err != nil {
+				t.Fatalf("openField: %v", err)
+			}
+			if got != tt.value {
+				t.Errorf("openField(sealField(%q)) = %q", tt.value, got)
+			}
+		})
+	}
+
+	if got := sealField(""); got != "" {
+		t.Errorf("sealField(\"\") = %q, want it empty", got)
+	}
+	if got, err := openField("plain"); err != nil || got != "plain" {
+		t.Errorf("openField(\"plain\") = %q, %v, want the value unchanged", got, err)
+	}
+	if _, err := openField(encryptedPrefix + "!!"); err == nil || errors.Is(err, ErrEncryptionLocked) {
+		t.Errorf("openField() of a malformed value = %v, want an error", err)
+	}
+
+	// Leave the values of the shared database in the clear for the tests that follow.
+	if err := DisableEncryption(db, "password"); err != nil {
+		t.Fatalf("DisableEncryption: %v", err)
+	}
+	waitFor(t, "the keys to be deleted", func() bool {
+		return !encryptionConfigured()
+	})
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	useTestEncryption(t)
+
+	const url = "https://example.com/private?q=secret"
+	queryHash := ParseURLComponents(url).QueryHash
+	res, err := db.Exec("INSERT INTO web_events (url, timestamp, query_hash) VALUES (?, ?, ?)", url, time.Now().Unix(), queryHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DELETE FROM web_events WHERE id = ?", id); err != nil {
+			t.Errorf("DELETE: %v", err)
+		}
+	})
+
+	// Enabling encryption encrypts and hashes the values recorded so far.
+	if err := EnableEncryption(db, "old password"); err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+	if got, want := GetEncryptionStatus(), (EncryptionStatus{Enabled: true}); got != want {
+		t.Errorf("GetEncryptionStatus() = %+v, want %+v", got, want)
+	}
+	var sealed string
+	waitFor(t, "the existing value to be encrypted", func() bool {
+		var hash sql.NullString
+		sealed, hash = webEventURL(t, db, id)
+		return strings.HasPrefix(sealed, encryptedPrefix) && hash.Valid
+	})
+	if _, hash := webEventURL(t, db, id); hash.String != fieldHash(sealedURL, url) {
+		t.Errorf("url_hash = %q, want %q", hash.String, fieldHash(sealedURL, url))
+	}
+	if got, err := openField(sealed); err != nil || got != url {
+		t.Errorf("openField() = %q, %v, want %q", got, err, url)
+	}
+	if got := queryString(t, db, "SELECT query_hash FROM web_events WHERE id = ?", id); got != "" {
+		t.Errorf("query_hash of an encrypted URL = %q, want it cleared", got)
+	}
+
+	// Locked, values are still encrypted but can't be read.
+	LockEncryption()
+	if got, want := GetEncryptionStatus(), (EncryptionStatus{Enabled: true, Locked: true}); got != want {
+		t.Errorf("GetEncryptionStatus() = %+v, want %+v", got, want)
+	}
+	if _, err := openField(sealed); !errors.Is(err, ErrEncryptionLocked) {
+		t.Errorf("openField() while locked = %v, want ErrEncryptionLocked", err)
+	}
+	if got := sealField(url); !strings.HasPrefix(got, encryptedPrefix) {
+		t.Errorf("sealField() while locked = %q, want it encrypted", got)
+	}
+
+	unlockTests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{name: "wrong password", password: "new password", wantErr: ErrWrongPassword},
+		{name: "empty password", password: "", wantErr: ErrWrongPassword},
+		{name: "right password", password: "old password"},
+	}
+	for _, tt := range unlockTests {
+		t.Run("unlock with "+tt.name, func(t *testing.T) {
+			LockEncryption()
+			if err := UnlockEncryption(db, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnlockEncryption() = %v, want %v", err, tt.wantErr)
+			}
+			got, err := openField(sealed)
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, ErrEncryptionLocked):
+				t.Errorf("openField() after a failed unlock = %q, %v, want ErrEncryptionLocked", got, err)
+			case tt.wantErr == nil && (err != nil || got != url):
+				t.Errorf("openField() = %q, %v, want %q", got, err, url)
+			}
+		})
+	}
+
+	// A new password opens the same secret, so nothing has to be encrypted again.
+	err = UpdateConfig(func(cfg *Config) error {
+		return RewrapEncryptionKey(cfg, "wrong password", "new password")
+	})
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("RewrapEncryptionKey() with the wrong password = %v, want ErrWrongPassword", err)
+	}
+	err = UpdateConfig(func(cfg *Config) error {
+		return RewrapEncryptionKey(cfg, "old password", "new password")
+	})
+	if err != nil {
+		t.Fatalf("RewrapEncryptionKey: %v", err)
+	}
+	// After a restart the state comes from the configuration, locked until the admin logs in.
+	forgetEncryption()
+	if got, want := GetEncryptionStatus(), (EncryptionStatus{Enabled: true, Locked: true}); got != want {
+		t.Errorf("GetEncryptionStatus() after a restart = %+v, want %+v", got, want)
+	}
+	if err := UnlockEncryption(db, "old password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("UnlockEncryption() with the old password = %v, want ErrWrongPassword", err)
+	}
+	if err := UnlockEncryption(db, "new password"); err != nil {
+		t.Fatalf("UnlockEncryption() with the new password: %v", err)
+	}
+	if got, err := openField(sealed); err != nil || got != url {
+		t.Errorf("openField() after rewrapping = %q, %v, want %q", got, err, url)
+	}
+
+	// Disabling encryption decrypts every value, then deletes the keys.
+	if err := DisableEncryption(db, "old password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("DisableEncryption() with the old password = %v, want ErrWrongPassword", err)
+	}
+	if err := DisableEncryption(db, "new password"); err != nil {
+		t.Fatalf("DisableEncryption: %v", err)
+	}
+	waitFor(t, "the keys to be deleted", func() bool {
+		return GetEncryptionStatus() == EncryptionStatus{} && !encryptionConfigured()
+	})
+	if got, _ := webEventURL(t, db, id); got != url {
+		t.Errorf("url after disabling encryption = %q, want %q", got, url)
+	}
+	if got := queryString(t, db, "SELECT query_hash FROM web_events WHERE id = ?", id); got != queryHash {
+		t.Errorf("query_hash after disabling encryption = %q, want %q", got, queryHash)
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Encryption != (EncryptionConfig{}) {
+		t.Errorf("Encryption config after disabling = %+v, want it empty", cfg.Encryption)
+	}
+	if got := sealField(url); got != url {
+		t.Errorf("sealField() after disabling = %q, want the value unchanged", got)
+	}
+}
+
+func TestEncryptionLeavesNoPlaintext(t *testing.T) {
+	db := openTestDB(t)
+	useTestEncryption(t)
+
+	// canary only appears in the values that are encrypted, so no trace of it may be left in the files.
+	const canary = "zqxcanary"
+	res, err := db.Exec("INSERT INTO web_events (url, title, path, timestamp) VALUES (?, ?, ?, ?)",
+		"https://example.com/"+canary+"path?q="+canary+"query", canary+"title", "/"+canary+"path", time.Now().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		execTest(t, db, "DELETE FROM web_events WHERE id = ?", id)
+	})
+	dbPath, err := GetDBPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	containsCanary := func() bool {
+		for _, path := range []string{dbPath, dbPath + "-wal"} {
+			content, err := os.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if bytes.Contains(content, []byte(canary)) {
+				return true
+			}
+		}
+		return false
+	}
+	if !containsCanary() {
+		t.Fatal("the values aren't in the database file before they are encrypted")
+	}
+
+	if err := EnableEncryption(db, "password"); err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+	waitFor(t, "the values to be encrypted", func() bool {
+		sealed, _ := webEventURL(t, db, id)
+		return strings.HasPrefix(sealed, encryptedPrefix)
+	})
+	// The full-text index, the free pages and the write-ahead log are cleaned up after the values are encrypted.
+	waitFor(t, "the plaintext to be gone from the database files", func() bool {
+		return !containsCanary()
+	})
+
+	if err := DisableEncryption(db, "password"); err != nil {
+		t.Fatalf("DisableEncryption: %v", err)
+	}
+	waitFor(t, "the keys to be deleted", func() bool {
+		return !encryptionConfigured()
+	})
+}
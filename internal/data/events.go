@@ -0,0 +1,426 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultPageSize is the number of events returned per page when the caller doesn't ask for a limit.
+	DefaultPageSize = 100
+	// MaxPageSize is the largest page that can be requested.
+	MaxPageSize = 1000
+)
+
+// ErrInvalidCursor is returned when a pagination cursor is malformed or belongs to a different kind of query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AppEvent is a process that was started while ProcGuard was running.
+type AppEvent struct {
+	ID          int64     `json:"id"`
+	ProcessName string    `json:"process_name"`
+	PID         int32     `json:"pid"`
+	ParentName  string    `json:"parent_process_name"`
+	ExePath     string    `json:"exe_path"`
+	Cmdline     string    `json:"cmdline,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+	// EndTime is nil while the process is still running.
+	EndTime *time.Time `json:"end_time"`
+	// Duration is how long the process ran, in seconds, or has been running so far.
+	Duration int64 `json:"duration"`
+	// Snippet is an HTML excerpt with the matched text highlighted, set when searching for free text.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// WebEvent is a page visit reported by the browser extension.
+type WebEvent struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Snippet is an HTML excerpt with the matched text highlighted, set when searching for free text.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// AppEventPage is a page of app events. NextCursor is empty on the last page.
+type AppEventPage struct {
+	Events     []AppEvent `json:"events"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// WebEventPage is a page of web events. NextCursor is empty on the last page.
+type WebEventPage struct {
+	Events     []WebEvent `json:"events"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// PageRequest selects a page of results.
+type PageRequest struct {
+	// Limit is the maximum number of events to return. Zero means DefaultPageSize, and it is capped at MaxPageSize.
+	Limit int
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	Cursor string
+}
+
+// pageCursor is the position after the last event of a page.
+// Results in chronological order continue after the (time, ID) of that event, so events recorded in the meantime
+// don't shift the following pages. Results ranked by relevance, whose order can change as events are added,
+// continue at an offset instead.
+type pageCursor struct {
+	Time   int64 `json:"t,omitempty"`
+	ID     int64 `json:"id,omitempty"`
+	Offset int   `json:"o,omitempty"`
+	Ranked bool  `json:"r,omitempty"`
+}
+
+// encode returns the opaque string form of c.
+func (c pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor returned by encode. ranked tells whether the query orders by relevance,
+// as a cursor of one kind can't continue the other.
+func decodeCursor(s string, ranked bool) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Ranked != ranked || c.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// pageLimit returns the effective page size of req, or -1 for "no limit" when all is set.
+func (req PageRequest) pageLimit(all bool) int {
+	switch {
+	case all:
+		return -1
+	case req.Limit <= 0:
+		return DefaultPageSize
+	case req.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return req.Limit
+}
+
+// TimeRangeError is returned when the since or until parameter of a query can't be parsed.
+type TimeRangeError struct {
+	// Param is the name of the parameter, "since" or "until".
+	Param string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *TimeRangeError) Error() string {
+	return fmt.Sprintf("could not parse '%s' time: %v", e.Param, e.Err)
+}
+
+// Unwrap returns the error returned by ParseTime.
+func (e *TimeRangeError) Unwrap() error {
+	return e.Err
+}
+
+// ParseTimeRange parses the since and until parameters of an activity query. Empty values give zero times.
+// A value that ParseTime rejects gives a *TimeRangeError.
+func ParseTimeRange(since, until string) (time.Time, time.Time, error) {
+	var sinceTime, untilTime time.Time
+	var err error
+
+	if since != "" {
+		sinceTime, err = ParseTime(since)
+		if err != nil {
+			return time.Time{}, time.Time{}, &TimeRangeError{Param: "since", Err: err}
+		}
+	}
+
+	if until != "" {
+		untilTime, err = ParseTime(until)
+		if err != nil {
+			return time.Time{}, time.Time{}, &TimeRangeError{Param: "until", Err: err}
+		}
+	}
+	return sinceTime, untilTime, nil
+}
+
+// QueryAppEvents returns a page of app events that match filter, which may be nil, and that were running
+// between since and until. Its free text is matched against the full-text index of process names, parent names,
+// executable paths and command lines, and results are ranked by relevance; otherwise they are returned newest first.
+func QueryAppEvents(db *sql.DB, filter *EventQuery, since, until string, page PageRequest) (AppEventPage, error) {
+	return queryAppEvents(db, filter, since, until, page, false)
+}
+
+// queryAppEvents implements QueryAppEvents. If all is set, every matching event is returned on a single page.
+func queryAppEvents(db *sql.DB, filter *EventQuery, since, until string, page PageRequest, all bool) (AppEventPage, error) {
+	sinceTime, untilTime, err := ParseTimeRange(since, until)
+	if err != nil {
+		return AppEventPage{}, err
+	}
+
+	match := filter.ftsMatch()
+	cursor, err := decodeCursor(page.Cursor, match != "")
+	if err != nil {
+		return AppEventPage{}, err
+	}
+	limit := page.pageLimit(all)
+
+	// Build the SQL query dynamically based on the provided filters.
+	var q string
+	args := make([]interface{}, 0)
+	if match != "" {
+		q = `SELECT e.id, e.process_name, e.pid, e.parent_process_name, e.exe_path, e.cmdline, e.start_time, e.end_time,
+				snippet(app_events_fts, -1, char(2), char(3), '…', ?)
+			FROM app_events_fts JOIN app_events e ON e.id = app_events_fts.rowid
+			WHERE app_events_fts MATCH ?`
+		args = append(args, snippetLength, match)
+	} else {
+		q = `SELECT e.id, e.process_name, e.pid, e.parent_process_name, e.exe_path, e.cmdline, e.start_time, e.end_time, ''
+			FROM app_events e WHERE 1=1`
+	}
+
+	q += filter.where()
+	args = append(args, filter.queryArgs()...)
+
+	// The time-based filtering logic includes processes that were running within the specified time window.
+	if !sinceTime.IsZero() {
+		// A process is considered within the window if it ended after the 'since' time, or if it hasn't ended yet.
+		q += " AND (e.end_time IS NULL OR e.end_time >= ?)"
+		args = append(args, sinceTime.Unix())
+	}
+
+	if !untilTime.IsZero() {
+		// A process is considered within the window if it started before the 'until' time.
+		q += " AND e.start_time <= ?"
+		args = append(args, untilTime.Unix())
+	}
+
+	offset := 0
+	if match != "" {
+		// Process names weigh more than paths and command lines, which mention many unrelated words.
+		q += " ORDER BY bm25(app_events_fts, 10.0, 5.0, 2.0, 1.0), e.start_time DESC, e.id DESC"
+		if cursor != nil {
+			offset = cursor.Offset
+		}
+	} else {
+		if cursor != nil {
+			q += " AND (e.start_time < ? OR (e.start_time = ? AND e.id < ?))"
+			args = append(args, cursor.Time, cursor.Time, cursor.ID)
+		}
+		q += " ORDER BY e.start_time DESC, e.id DESC"
+	}
+	// One extra row tells whether there is a next page.
+	q += " LIMIT ? OFFSET ?"
+	args = append(args, queryLimit(limit), offset)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return AppEventPage{}, fmt.Errorf("database query failed: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	now := time.Now()
+	result := AppEventPage{Events: []AppEvent{}}
+	for rows.Next() {
+		var ev AppEvent
+		var parentName, exePath, cmdline sql.NullString
+		var startTime int64
+		var endTime sql.NullInt64
+		var snippet string
+		if err := rows.Scan(&ev.ID, &ev.ProcessName, &ev.PID, &parentName, &exePath, &cmdline, &startTime, &endTime, &snippet); err != nil {
+			return AppEventPage{}, err
+		}
+
+		ev.ParentName = parentName.String
+		if ev.ExePath, err = openField(exePath.String); err != nil {
+			return AppEventPage{}, err
+		}
+		if ev.Cmdline, err = openField(cmdline.String); err != nil {
+			return AppEventPage{}, err
+		}
+		ev.StartTime = localTime(startTime)
+		end := now
+		if endTime.Valid {
+			end = localTime(endTime.Int64)
+			ev.EndTime = &end
+		}
+		ev.Duration = max(0, int64(end.Sub(ev.StartTime)/time.Second))
+		if match != "" {
+			ev.Snippet = highlightSnippet(snippet)
+		}
+		result.Events = append(result.Events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return AppEventPage{}, err
+	}
+
+	if limit >= 0 && len(result.Events) > limit {
+		result.Events = result.Events[:limit]
+		last := result.Events[limit-1]
+		next := pageCursor{Time: last.StartTime.Unix(), ID: last.ID}
+		if match != "" {
+			next = pageCursor{Offset: offset + limit, Ranked: true}
+		}
+		result.NextCursor = next.encode()
+	}
+	return result, nil
+}
+
+// QueryWebEvents returns a page of web events that match filter, which may be nil, between since and until.
+// If filter has free text, only pages whose URL or title match it are returned, ranked by relevance;
+// otherwise they are returned newest first.
+func QueryWebEvents(db *sql.DB, filter *EventQuery, since, until string, page PageRequest) (WebEventPage, error) {
+	return queryWebEvents(db, filter, since, until, page, false)
+}
+
+// queryWebEvents implements QueryWebEvents. If all is set, every matching event is returned on a single page.
+func queryWebEvents(db *sql.DB, filter *EventQuery, since, until string, page PageRequest, all bool) (WebEventPage, error) {
+	sinceTime, untilTime, err := ParseTimeRange(since, until)
+	if err != nil {
+		return WebEventPage{}, err
+	}
+
+	match := filter.ftsMatch()
+	cursor, err := decodeCursor(page.Cursor, match != "")
+	if err != nil {
+		return WebEventPage{}, err
+	}
+	limit := page.pageLimit(all)
+
+	// Build the SQL query dynamically based on the provided filters.
+	var q string
+	args := make([]interface{}, 0)
+	if match != "" {
+		q = `SELECT e.id, e.url, e.title, e.timestamp, snippet(web_events_fts, -1, char(2), char(3), '…', ?)
+			FROM web_events_fts JOIN web_events e ON e.id = web_events_fts.rowid
+			WHERE web_events_fts MATCH ?`
+		args = append(args, snippetLength, match)
+	} else {
+		q = "SELECT e.id, e.url, e.title, e.timestamp, '' FROM web_events e WHERE 1=1"
+	}
+
+	q += filter.where()
+	args = append(args, filter.queryArgs()...)
+
+	if !sinceTime.IsZero() {
+		q += " AND e.timestamp >= ?"
+		args = append(args, sinceTime.Unix())
+	}
+
+	if !untilTime.IsZero() {
+		q += " AND e.timestamp <= ?"
+		args = append(args, untilTime.Unix())
+	}
+
+	offset := 0
+	if match != "" {
+		// A match in the title says more about the page than one somewhere in the URL.
+		q += " ORDER BY bm25(web_events_fts, 1.0, 3.0), e.timestamp DESC, e.id DESC"
+		if cursor != nil {
+			offset = cursor.Offset
+		}
+	} else {
+		if cursor != nil {
+			q += " AND (e.timestamp < ? OR (e.timestamp = ? AND e.id < ?))"
+			args = append(args, cursor.Time, cursor.Time, cursor.ID)
+		}
+		q += " ORDER BY e.timestamp DESC, e.id DESC"
+	}
+	// One extra row tells whether there is a next page.
+	q += " LIMIT ? OFFSET ?"
+	args = append(args, queryLimit(limit), offset)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return WebEventPage{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	result := WebEventPage{Events: []WebEvent{}}
+	for rows.Next() {
+		var ev WebEvent
+		var title sql.NullString
+		var timestamp int64
+		var snippet string
+		var url string
+		if err := rows.Scan(&ev.ID, &url, &title, &timestamp, &snippet); err != nil {
+			return WebEventPage{}, err
+		}
+		if ev.URL, err = openField(url); err != nil {
+			return WebEventPage{}, err
+		}
+		if ev.Title, err = openField(title.String); err != nil {
+			return WebEventPage{}, err
+		}
+		ev.Timestamp = localTime(timestamp)
+		if match != "" {
+			ev.Snippet = highlightSnippet(snippet)
+		}
+		result.Events = append(result.Events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return WebEventPage{}, err
+	}
+
+	if limit >= 0 && len(result.Events) > limit {
+		result.Events = result.Events[:limit]
+		last := result.Events[limit-1]
+		next := pageCursor{Time: last.Timestamp.Unix(), ID: last.ID}
+		if match != "" {
+			next = pageCursor{Offset: offset + limit, Ranked: true}
+		}
+		result.NextCursor = next.encode()
+	}
+	return result, nil
+}
+
+// queryLimit returns the LIMIT for a page of limit events: one more, to detect the next page, or -1 for no limit.
+func queryLimit(limit int) int {
+	if limit < 0 {
+		return -1
+	}
+	return limit + 1
+}
+
+// LogAppEvent records the start of a process. Its executable path and command line are encrypted if encryption is enabled.
+func LogAppEvent(name string, pid int32, parentName, exePath, cmdline string, startTime int64) {
+	EnqueueWrite("INSERT INTO app_events (process_name, pid, parent_process_name, exe_path, cmdline, start_time, exe_path_hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		name, pid, parentName, sealField(exePath), sealField(cmdline), startTime, fieldHash(sealedExePath, exePath))
+}
+
+// LogWebEvent records a page visit and credits it to the daily rollups. Its URL, title and path are encrypted
+// if encryption is enabled; the scheme, host and domain are kept in the clear for the statistics.
+func LogWebEvent(url, title string, timestamp int64) {
+	c := ParseURLComponents(url)
+	sealedURLValue, sealedTitle, sealedPath, urlHash := sealField(url), sealField(title), sealField(c.Path), fieldHash(sealedURL, url)
+	if sealedURLValue != url {
+		// The query hash would let the encrypted query be guessed.
+		c.QueryHash = ""
+	}
+	// The rollups credit the previous page view, which they read from web_events, so both are written together.
+	enqueueTx(func(tx *sql.Tx) error {
+		if err := recordWebVisit(tx, c.Host, timestamp); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO web_events (url, title, timestamp, scheme, host, domain, path, query_hash, url_hash)
+			VALUES (?, NULLIF(?, ''), ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`,
+			sealedURLValue, sealedTitle, timestamp, c.Scheme, c.Host, c.Domain, sealedPath, c.QueryHash, urlHash)
+		return err
+	})
+}
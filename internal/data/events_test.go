@@ -0,0 +1,184 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		ranked bool
+		want   *pageCursor
+	}{
+		{name: "first page", cursor: "", want: nil},
+		{name: "chronological", cursor: pageCursor{Time: 1790812800, ID: 42}.encode(), want: &pageCursor{Time: 1790812800, ID: 42}},
+		{name: "ranked", cursor: pageCursor{Offset: 100, Ranked: true}.encode(), ranked: true, want: &pageCursor{Offset: 100, Ranked: true}},
+		{name: "ranked cursor for a chronological query", cursor: pageCursor{Offset: 100, Ranked: true}.encode()},
+		{name: "chronological cursor for a ranked query", cursor: pageCursor{Time: 1790812800, ID: 42}.encode(), ranked: true},
+		{name: "negative offset", cursor: pageCursor{Offset: -1, Ranked: true}.encode(), ranked: true},
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "not JSON", cursor: "bm90IGpzb24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor, tt.ranked)
+			wantErr := tt.want == nil && tt.cursor != ""
+			if wantErr {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Errorf("decodeCursor(%q) = %+v, %v, want ErrInvalidCursor", tt.cursor, got, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCursor(%q) = %+v, %v, want %+v", tt.cursor, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		all   bool
+		want  int
+	}{
+		{limit: 0, want: DefaultPageSize},
+		{limit: -5, want: DefaultPageSize},
+		{limit: 1, want: 1},
+		{limit: MaxPageSize, want: MaxPageSize},
+		{limit: MaxPageSize + 1, want: MaxPageSize},
+		{limit: 10, all: true, want: -1},
+	}
+	for _, tt := range tests {
+		if got := (PageRequest{Limit: tt.limit}).pageLimit(tt.all); got != tt.want {
+			t.Errorf("PageRequest{Limit: %d}.pageLimit(%v) = %d, want %d", tt.limit, tt.all, got, tt.want)
+		}
+	}
+}
+
+// pageTestTime is when the events of the pagination tests were recorded, long before any other test's events,
+// so that a time range selects them alone.
+const pageTestTime = 1000000000
+
+// insertPageTestEvent records a page visit at pageTestTime plus offset seconds and returns its ID.
+func insertPageTestEvent(t *testing.T, db *sql.DB, offset int64) int64 {
+	t.Helper()
+	res, err := db.Exec("INSERT INTO web_events (url, title, timestamp) VALUES (?, ?, ?)",
+		"https://example.com/"+strconv.FormatInt(offset, 10), "pagetest", pageTestTime+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		execTest(t, db, "DELETE FROM web_events WHERE id = ?", id)
+	})
+	return id
+}
+
+// webEventPages follows the cursors of QueryWebEvents from cursor to the last page and returns the IDs in order,
+// and the number of pages.
+func webEventPages(t *testing.T, db *sql.DB, filter *EventQuery, limit int, cursor string) ([]int64, int) {
+	t.Helper()
+	since, until := strconv.Itoa(pageTestTime), strconv.Itoa(pageTestTime+1000)
+	ids := []int64{}
+	pages := 0
+	for {
+		page, err := QueryWebEvents(db, filter, since, until, PageRequest{Limit: limit, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("QueryWebEvents: %v", err)
+		}
+		pages++
+		if len(page.Events) > limit {
+			t.Fatalf("page of %d events, want at most %d", len(page.Events), limit)
+		}
+		for _, ev := range page.Events {
+			ids = append(ids, ev.ID)
+		}
+		if page.NextCursor == "" {
+			return ids, pages
+		}
+		if pages > 100 {
+			t.Fatal("the cursors don't come to an end")
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestQueryWebEventsPages(t *testing.T) {
+	db := openTestDB(t)
+	forgetEncryption()
+	t.Cleanup(forgetEncryption)
+
+	// Events that share a time are ordered by ID, so none of them is skipped or repeated at a page boundary.
+	var want []int64
+	for _, offset := range []int64{0, 1, 2, 2, 2, 3} {
+		want = append([]int64{insertPageTestEvent(t, db, offset)}, want...)
+	}
+
+	tests := []struct {
+		limit     int
+		wantPages int
+	}{
+		{limit: 1, wantPages: 6},
+		{limit: 2, wantPages: 3},
+		{limit: 4, wantPages: 2},
+		{limit: 6, wantPages: 1},
+		{limit: 10, wantPages: 1},
+	}
+	for _, tt := range tests {
+		t.Run("limit "+strconv.Itoa(tt.limit), func(t *testing.T) {
+			ids, pages := webEventPages(t, db, nil, tt.limit, "")
+			if !reflect.DeepEqual(ids, want) {
+				t.Errorf("IDs = %v, want %v", ids, want)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("%d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+
+	t.Run("events recorded between pages", func(t *testing.T) {
+		first, err := QueryWebEvents(db, nil, strconv.Itoa(pageTestTime), strconv.Itoa(pageTestTime+1000), PageRequest{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		insertPageTestEvent(t, db, 4)
+		rest, _ := webEventPages(t, db, nil, 2, first.NextCursor)
+		if !reflect.DeepEqual(rest, want[2:]) {
+			t.Errorf("IDs after the first page = %v, want %v", rest, want[2:])
+		}
+	})
+
+	t.Run("ranked by relevance", func(t *testing.T) {
+		filter, err := ParseWebQuery("pagetest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, _ := webEventPages(t, db, filter, 2, "")
+		// The ranking orders them however it likes, but each must turn up on exactly one page.
+		got := append([]int64(nil), ids...)
+		sort.Slice(got, func(i, j int) bool { return got[i] > got[j] })
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("IDs = %v, want each of %v once", ids, want)
+		}
+
+		// A cursor of a ranked query can't continue a chronological one.
+		page, err := QueryWebEvents(db, filter, strconv.Itoa(pageTestTime), strconv.Itoa(pageTestTime+1000), PageRequest{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = QueryWebEvents(db, nil, "", "", PageRequest{Limit: 2, Cursor: page.NextCursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("QueryWebEvents() with a ranked cursor and no search = %v, want ErrInvalidCursor", err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Markers that snippet() puts around matched terms. Control characters never appear in the indexed text,
+// so they survive HTML escaping and can then be replaced with <mark> tags.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// snippetLength is the maximum number of tokens in a search snippet.
+const snippetLength = 12
+
+// ftsMatchQuery turns user input into an FTS5 MATCH expression.
+// Every word becomes a quoted prefix query, so "chro" matches "chrome.exe" and characters that have a meaning
+// in the FTS5 syntax (quotes, colons, dashes, parentheses) can't cause syntax errors. Text in double quotes is
+// matched as an exact phrase, and an uppercase OR between two terms matches either of them; all other terms
+// must match. It returns an empty string if the input contains nothing searchable.
+func ftsMatchQuery(input string) string {
+	return ftsMatchTerms(splitSearchTerms(input))
+}
+
+// ftsMatchTerms builds an FTS5 MATCH expression from words and phrases, as described for ftsMatchQuery.
+func ftsMatchTerms(tokens []searchTerm) string {
+	var terms []string
+	pendingOr := false
+	for _, tok := range tokens {
+		if tok.text == "OR" && !tok.quoted {
+			pendingOr = len(terms) > 0
+			continue
+		}
+		if !strings.ContainsFunc(tok.text, isWordRune) {
+			continue
+		}
+
+		term := `"` + strings.ReplaceAll(tok.text, `"`, `""`) + `"`
+		if !tok.quoted {
+			term += "*"
+		}
+		if pendingOr {
+			term = "OR " + term
+			pendingOr = false
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchTerm is a word or quoted phrase of a search query.
+type searchTerm struct {
+	text   string
+	quoted bool
+}
+
+// splitSearchTerms splits a search query on whitespace, keeping text in double quotes together.
+// An unterminated quote extends to the end of the input.
+func splitSearchTerms(input string) []searchTerm {
+	var terms []searchTerm
+	var cur strings.Builder
+	quoted := false
+	flush := func(wasQuoted bool) {
+		if cur.Len() > 0 {
+			terms = append(terms, searchTerm{text: cur.String(), quoted: wasQuoted})
+			cur.Reset()
+		}
+	}
+	for _, r := range input {
+		switch {
+		case r == '"':
+			flush(quoted)
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush(false)
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush(quoted)
+	return terms
+}
+
+// highlightSnippet converts a snippet returned by snippet() into HTML, with matched terms wrapped in <mark>.
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetStart, "<mark>")
+	return strings.ReplaceAll(escaped, snippetEnd, "</mark>")
+}
+
+// isWordRune reports whether r is indexed by the full-text tokenizer, which treats everything else as a separator.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
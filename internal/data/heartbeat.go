@@ -0,0 +1,69 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Power event kinds recorded in the power_events table.
+const (
+	// PowerEventSuspend marks the last moment the daemon was running before the system slept or hibernated.
+	PowerEventSuspend = "suspend"
+	// PowerEventResume marks the moment the daemon noticed that the system was running again.
+	PowerEventResume = "resume"
+)
+
+// DaemonRun describes the previous run of the daemon, as recorded by its heartbeats.
+type DaemonRun struct {
+	// LastSeen is the last moment the daemon is known to have been running: its last heartbeat,
+	// or a later event that it recorded. It is zero if nothing has been recorded yet.
+	LastSeen time.Time
+	// Stopped tells whether the run ended with a clean shutdown, as opposed to a crash or power loss.
+	Stopped bool
+}
+
+// PreviousDaemonRun reads what the heartbeats table knows about the most recent run of the daemon.
+// It should be called before StartHeartbeat, which begins a new run.
+func PreviousDaemonRun(db *sql.DB) (DaemonRun, error) {
+	var run DaemonRun
+	var lastBeat, stoppedAt sql.NullInt64
+	err := db.QueryRow("SELECT last_beat, stopped_at FROM heartbeats ORDER BY started_at DESC LIMIT 1").Scan(&lastBeat, &stoppedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return DaemonRun{}, err
+	}
+	run.Stopped = stoppedAt.Valid
+
+	// Sessions recorded between two heartbeats prove that the daemon was still running. They also stand in
+	// for the heartbeats of databases that were written before heartbeats existed.
+	var lastEvent sql.NullInt64
+	err = db.QueryRow("SELECT MAX(t) FROM (SELECT MAX(start_time) AS t FROM app_events UNION ALL SELECT MAX(end_time) FROM app_events)").Scan(&lastEvent)
+	if err != nil {
+		return DaemonRun{}, err
+	}
+
+	lastSeen := max(lastBeat.Int64, lastEvent.Int64)
+	if lastSeen > 0 {
+		run.LastSeen = time.Unix(lastSeen, 0)
+	}
+	return run, nil
+}
+
+// StartHeartbeat records the start of a daemon run at start, which also serves as its first heartbeat.
+func StartHeartbeat(start time.Time) {
+	EnqueueWrite("INSERT OR REPLACE INTO heartbeats (started_at, last_beat) VALUES (?, ?)", start.Unix(), start.Unix())
+}
+
+// RecordHeartbeat records that the daemon run that began at start was still running at t.
+func RecordHeartbeat(start, t time.Time) {
+	EnqueueWrite("UPDATE heartbeats SET last_beat = ? WHERE started_at = ?", t.Unix(), start.Unix())
+}
+
+// StopHeartbeat records that the daemon run that began at start shut down cleanly at t.
+func StopHeartbeat(start, t time.Time) {
+	EnqueueWrite("UPDATE heartbeats SET last_beat = ?, stopped_at = ? WHERE started_at = ?", t.Unix(), t.Unix(), start.Unix())
+}
+
+// RecordPowerEvent records a suspend or resume of the system at t.
+func RecordPowerEvent(kind string, t time.Time) {
+	EnqueueWrite("INSERT INTO power_events (kind, timestamp) VALUES (?, ?)", kind, t.Unix())
+}
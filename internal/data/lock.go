@@ -1,17 +1,104 @@
-//go:build windows
-
 package data
 
 import (
-	"os/exec"
+	"errors"
+	"fmt"
+	"log"
+	"os"
 )
 
-// platformLock sets file permissions on Windows to restrict write access.
-// This is a security measure to prevent unauthorized modification of the blocklist files.
-// It uses the `icacls` command to:
-// 1. Disable inheritance of ACLs from the parent directory (`/inheritance:d`).
-// 2. Grant the current user write permissions (`/grant:r`, where `r` means replace).
-// 3. Remove the `Everyone` group's permissions, effectively locking the file to the current user.
-func platformLock(path string) error {
-	return exec.Command("icacls", path, "/inheritance:d", "/grant:r", "%USERNAME%:(W)", "/remove", "Everyone").Run()
+// policyFiles returns the paths of the files that hold the policy, its last good copy and the key it is signed with.
+// They are kept locked with platformLock and only unlocked while ProcGuard writes them.
+func policyFiles() []string {
+	var paths []string
+	if path, err := GetConfigPath(); err == nil {
+		paths = append(paths, path, path+lastGoodSuffix)
+	}
+	if path, err := policyKeyPath(); err == nil {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// isPolicyFile reports whether path is one of the policy files.
+func isPolicyFile(path string) bool {
+	for _, policyFile := range policyFiles() {
+		if path == policyFile {
+			return true
+		}
+	}
+	return false
+}
+
+// policyDir returns the path of the directory that holds the policy files.
+func policyDir() (string, error) {
+	return DataPath("config")
+}
+
+// writeFile replaces the file at path with WriteFileAtomic. A policy file is unlocked for the write
+// and locked again afterwards, also when the write fails, since it then still holds its previous content.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if !isPolicyFile(path) {
+		return WriteFileAtomic(path, data, perm)
+	}
+	if fileExists(path) {
+		if err := platformUnlock(path); err != nil {
+			return fmt.Errorf("could not unlock %s: %w", path, err)
+		}
+	}
+	err := WriteFileAtomic(path, data, perm)
+	if fileExists(path) {
+		if lockErr := platformLock(path); lockErr != nil {
+			// This can run before the logger is set up.
+			log.Printf("[WARN] Failed to lock %s: %v", path, lockErr)
+		}
+	}
+	return err
+}
+
+// fileExists reports whether there is a file at path. The lock commands of some platforms
+// don't report a missing file in a way that can be told apart from other failures.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// LockPolicyFiles locks every policy file that exists, including those written before they were locked
+// on every save or restored from a backup, and their directory.
+func LockPolicyFiles() error {
+	var errs []error
+	if dir, err := policyDir(); err == nil && fileExists(dir) {
+		if err := platformLockDir(dir); err != nil {
+			errs = append(errs, fmt.Errorf("could not lock %s: %w", dir, err))
+		}
+	}
+	for _, path := range policyFiles() {
+		if !fileExists(path) {
+			continue
+		}
+		if err := platformLock(path); err != nil {
+			errs = append(errs, fmt.Errorf("could not lock %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UnlockPolicyFiles unlocks every policy file and their directory, so that they can be moved or deleted,
+// for example when a backup is restored or ProcGuard is uninstalled.
+func UnlockPolicyFiles() error {
+	var errs []error
+	if dir, err := policyDir(); err == nil && fileExists(dir) {
+		if err := platformUnlockDir(dir); err != nil {
+			errs = append(errs, fmt.Errorf("could not unlock %s: %w", dir, err))
+		}
+	}
+	for _, path := range policyFiles() {
+		if !fileExists(path) {
+			continue
+		}
+		if err := platformUnlock(path); err != nil {
+			errs = append(errs, fmt.Errorf("could not unlock %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
 }
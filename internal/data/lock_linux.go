@@ -0,0 +1,114 @@
+//go:build linux
+
+package data
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsImmutableFlag is FS_IMMUTABLE_FL from linux/fs.h, which golang.org/x/sys/unix doesn't define.
+const fsImmutableFlag = 0x00000010
+
+// platformLock restricts write access to a policy file on Linux.
+//
+// When ProcGuard runs as root, the file is given to root, loses the write bits of its group and others,
+// and gets the immutable attribute (FS_IOC_SETFLAGS with FS_IMMUTABLE_FL), which keeps even root from
+// changing, replacing or deleting it until the attribute is cleared. On filesystems without the attribute,
+// ownership and mode bits still apply.
+//
+// Without root, neither ownership nor the attribute can be changed, so the fallback only removes every
+// write bit. This stops accidental edits and tools that honour the mode, but the owner can restore the bits,
+// and anyone who can write to the directory can still replace the file.
+func platformLock(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode().Perm()
+
+	if os.Geteuid() != 0 {
+		return os.Chmod(path, mode&^0222)
+	}
+	if err := os.Chown(path, 0, 0); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode&^0022); err != nil {
+		return err
+	}
+	return setImmutable(path, true)
+}
+
+// platformLockDir does nothing on Linux. A file can be deleted or replaced by anyone who can write to its directory,
+// but the immutable attribute set by platformLock forbids that too, and the directory can't lose its write bits
+// without keeping ProcGuard from replacing the files. Without root, the policy files can still be replaced.
+func platformLockDir(path string) error {
+	return nil
+}
+
+// platformUnlockDir does nothing on Linux: see platformLockDir.
+func platformUnlockDir(path string) error {
+	return nil
+}
+
+// platformUnlock lifts the restrictions of platformLock so the file can be written again.
+// Ownership is left to root, since only root could have locked the file that way.
+func platformUnlock(path string) error {
+	if os.Geteuid() == 0 {
+		if err := setImmutable(path, false); err != nil {
+			return err
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode().Perm()|0200)
+}
+
+// setImmutable sets or clears the immutable attribute of the file at path. Filesystems that don't
+// support file attributes are ignored.
+func setImmutable(path string, immutable bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// This can run before the logger is set up.
+		if err := f.Close(); err != nil {
+			log.Printf("[ERROR] Failed to close %s: %v", path, err)
+		}
+	}()
+
+	fd := int(f.Fd())
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		if attributesUnsupported(err) {
+			return nil
+		}
+		return fmt.Errorf("could not read the attributes of %s: %w", path, err)
+	}
+	updated := flags &^ fsImmutableFlag
+	if immutable {
+		updated |= fsImmutableFlag
+	}
+	if updated == flags {
+		return nil
+	}
+	if err := unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, int(updated)); err != nil {
+		if attributesUnsupported(err) {
+			return nil
+		}
+		return fmt.Errorf("could not set the attributes of %s: %w", path, err)
+	}
+	return nil
+}
+
+// attributesUnsupported reports whether err means that the filesystem has no file attributes.
+func attributesUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL)
+}
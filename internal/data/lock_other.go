@@ -0,0 +1,23 @@
+//go:build !windows && !linux
+
+package data
+
+// platformLock does nothing on platforms without a lock implementation.
+func platformLock(path string) error {
+	return nil
+}
+
+// platformUnlock does nothing on platforms without a lock implementation.
+func platformUnlock(path string) error {
+	return nil
+}
+
+// platformLockDir does nothing on platforms without a lock implementation.
+func platformLockDir(path string) error {
+	return nil
+}
+
+// platformUnlockDir does nothing on platforms without a lock implementation.
+func platformUnlockDir(path string) error {
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package data
+
+import (
+	"os/exec"
+)
+
+// everyoneSID is the well-known SID of the Everyone group, which icacls accepts in place of a name
+// so the commands work on every display language of Windows.
+const everyoneSID = "*S-1-1-0"
+
+// platformLock sets file permissions on Windows to restrict write access.
+// This is a security measure to prevent unauthorized modification of the policy files.
+// It uses the `icacls` command to deny write and delete access to the `Everyone` group, which includes the owner.
+// The owner keeps the right to change the permissions, so ProcGuard can lift the lock with platformUnlock
+// before it writes the file.
+//
+// This is a speed bump, not a security boundary: since the owner keeps WRITE_DAC, any process running as the
+// account that owns the file can remove the deny entry the same way. Only running ProcGuard under an account
+// that the user can't act as would make the lock binding. Deletion through the directory is handled by
+// platformLockDir.
+func platformLock(path string) error {
+	return exec.Command("icacls", path, "/deny", everyoneSID+":(W,D)").Run()
+}
+
+// platformUnlock removes the deny entry added by platformLock.
+func platformUnlock(path string) error {
+	return exec.Command("icacls", path, "/remove:d", everyoneSID).Run()
+}
+
+// platformLockDir denies the right to delete children (FILE_DELETE_CHILD) on the directory of the policy files
+// to the `Everyone` group. That right on the parent directory lets a file be deleted or replaced whatever the
+// file's own permissions say, which would get around platformLock. Files that grant delete access themselves,
+// such as a policy file unlocked by platformUnlock, can still be replaced. The entry is not inherited.
+func platformLockDir(path string) error {
+	return exec.Command("icacls", path, "/deny", everyoneSID+":(DC)").Run()
+}
+
+// platformUnlockDir removes the deny entry added by platformLockDir.
+func platformUnlockDir(path string) error {
+	return exec.Command("icacls", path, "/remove:d", everyoneSID).Run()
+}
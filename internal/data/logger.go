@@ -1,78 +1,214 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Logger defines the interface for the application's logger.
+// LevelFatal is the level of messages logged by Fatalf, above slog.LevelError.
+const LevelFatal = slog.Level(12)
 
+// Logger defines the interface for the application's logger.
+// Debug, Info, Warn and Error take a message followed by alternating keys and values, like log/slog.
+// Printf and Println log at the INFO level and are kept for messages that have no fields.
 type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
 	Printf(format string, v ...interface{})
 	Fatalf(format string, v ...interface{})
 	Println(v ...interface{})
 	Close()
 }
 
+// LevelName returns the name under which a level is stored in the logs table: DEBUG, INFO, WARN, ERROR or FATAL.
+func LevelName(level slog.Level) string {
+	if level >= LevelFatal {
+		return "FATAL"
+	}
+	return level.String()
+}
+
+// ParseLogLevel parses a level name such as "debug", "info", "warn", "error" or "fatal", ignoring case.
+func ParseLogLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "fatal") {
+		return LevelFatal, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
 // multiLogger is an implementation of the Logger interface that writes logs to multiple destinations:
 // a log file and a SQLite database. This provides redundancy and flexible log analysis.
 type multiLogger struct {
-	db     *sql.DB
-	file   *os.File
-	logger *log.Logger
-	mu     sync.Mutex
+	logger *slog.Logger
+	file   *rotatingFile
+}
+
+// Debug logs a message with the DEBUG level.
+func (l *multiLogger) Debug(msg string, args ...interface{}) {
+	l.logger.Debug(msg, args...)
+}
+
+// Info logs a message with the INFO level.
+func (l *multiLogger) Info(msg string, args ...interface{}) {
+	l.logger.Info(msg, args...)
+}
+
+// Warn logs a message with the WARN level.
+func (l *multiLogger) Warn(msg string, args ...interface{}) {
+	l.logger.Warn(msg, args...)
+}
+
+// Error logs a message with the ERROR level.
+func (l *multiLogger) Error(msg string, args ...interface{}) {
+	l.logger.Error(msg, args...)
 }
 
 // Printf formats and logs a message with the INFO level.
 func (l *multiLogger) Printf(format string, v ...interface{}) {
-	l.write("INFO", fmt.Sprintf(format, v...))
+	l.logger.Info(fmt.Sprintf(format, v...))
 }
 
 // Fatalf formats and logs a message with the FATAL level, then exits the application.
 func (l *multiLogger) Fatalf(format string, v ...interface{}) {
-	l.write("FATAL", fmt.Sprintf(format, v...))
+	l.logger.Log(context.Background(), LevelFatal, fmt.Sprintf(format, v...))
+	l.Close()
 	os.Exit(1)
 }
 
 // Println logs a message with the INFO level.
 func (l *multiLogger) Println(v ...interface{}) {
-	l.write("INFO", fmt.Sprintln(v...))
+	l.logger.Info(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
 }
 
 // Close safely closes the logger's resources (the log file and the database connection).
 // This should be called before the application exits to ensure all logs are written.
 func (l *multiLogger) Close() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	if l.file != nil {
-		if err := l.file.Close(); err != nil {
-			// If we can't close the file, there's not much we can do other than log it to stderr.
-			log.Printf("Failed to close log file: %v", err)
+	// Stopping database writes prevents them after the database has been closed elsewhere.
+	logToDB.Store(false)
+	if err := l.file.Close(); err != nil {
+		// If we can't close the file, there's not much we can do other than log it to stderr.
+		log.Printf("Failed to close log file: %v", err)
+	}
+}
+
+// logToDB is set while log records should also be written to the logs table.
+var logToDB atomic.Bool
+
+// logHandler is a slog.Handler that writes each record to the log file, as text, and to the logs table.
+type logHandler struct {
+	file  slog.Handler
+	level slog.Leveler
+	// attrs are the fields added with WithAttrs, with the current group as a key prefix.
+	attrs  []slog.Attr
+	prefix string
+}
+
+// newLogHandler returns a handler that writes records at level or above to w and to the logs table.
+func newLogHandler(w *rotatingFile, level slog.Leveler) *logHandler {
+	file := slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if l, ok := a.Value.Any().(slog.Level); ok {
+					return slog.String(slog.LevelKey, LevelName(l))
+				}
+			}
+			return a
+		},
+	})
+	return &logHandler{file: file, level: level}
+}
+
+// Enabled reports whether records at level are logged.
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes r to the log file, then queues it for the logs table.
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.file.Handle(ctx, r)
+
+	if logToDB.Load() {
+		fields := make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+		for _, a := range h.attrs {
+			addLogField(fields, "", a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			addLogField(fields, h.prefix, a)
+			return true
+		})
+		var encoded interface{}
+		if len(fields) > 0 {
+			if b, err := json.Marshal(fields); err == nil {
+				encoded = string(b)
+			}
 		}
-		l.file = nil
+		// Logging must never block, so the entry is only kept in the file if the write queue is full.
+		TryEnqueueWrite("INSERT INTO logs (timestamp, level, message, fields) VALUES (?, ?, ?, ?)",
+			r.Time.Unix(), LevelName(r.Level), r.Message, encoded)
 	}
-	// Setting the db to nil prevents further writes to the database after it has been closed elsewhere.
-	l.db = nil
+	return err
 }
 
-// write is an internal method that writes a log message to all configured destinations.
-func (l *multiLogger) write(level, message string) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// WithAttrs returns a handler that adds attrs to every record.
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.file = h.file.WithAttrs(attrs)
+	h2.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
 
-	// Write to the log file first.
-	if l.logger != nil {
-		l.logger.Printf("[%s] %s", level, message)
+// WithGroup returns a handler that nests the fields of later records under name.
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
 	}
+	h2 := *h
+	h2.file = h.file.WithGroup(name)
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
 
-	// Then, write to the database.
-	if l.db != nil {
-		EnqueueWrite("INSERT INTO logs (timestamp, level, message) VALUES (?, ?, ?)", time.Now().Unix(), level, message)
+// addLogField adds a to fields, flattening groups into dotted keys. Errors are stored as their message,
+// since they usually don't marshal to anything useful.
+func addLogField(fields map[string]interface{}, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addLogField(fields, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	switch val := v.Any().(type) {
+	case error:
+		fields[prefix+a.Key] = val.Error()
+	case fmt.Stringer:
+		fields[prefix+a.Key] = val.String()
+	default:
+		fields[prefix+a.Key] = val
 	}
 }
 
@@ -83,17 +219,20 @@ var once sync.Once
 // It uses a sync.Once to ensure that the logger is only initialized once, making it safe for concurrent use.
 func NewLogger(db *sql.DB) {
 	once.Do(func() {
-		cacheDir, err := os.UserCacheDir()
-		if err != nil {
-			log.Fatalf("Failed to get user cache dir: %v", err)
+		cfg := Runtime()
+		if err := os.MkdirAll(filepath.Dir(cfg.LogPath), 0755); err != nil {
+			log.Fatalf("Failed to create log directory: %v", err)
 		}
-		logPath := filepath.Join(cacheDir, "procguard", "procguard.log")
-		file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		file, err := openRotatingFile(cfg.LogPath)
 		if err != nil {
 			log.Fatalf("Failed to open log file: %v", err)
 		}
-		fileLogger := log.New(file, "", log.LstdFlags)
-		defaultLogger = &multiLogger{db: db, file: file, logger: fileLogger, mu: sync.Mutex{}}
+		level, err := ParseLogLevel(cfg.LogLevel)
+		if err != nil {
+			level = slog.LevelInfo
+		}
+		logToDB.Store(db != nil)
+		defaultLogger = &multiLogger{logger: slog.New(newLogHandler(file, level)), file: file}
 	})
 }
 
@@ -101,3 +240,168 @@ func NewLogger(db *sql.DB) {
 func GetLogger() Logger {
 	return defaultLogger
 }
+
+const (
+	// logMaxSize is the size at which the log file is rotated.
+	logMaxSize = 10 << 20
+	// logMaxBackups is the number of rotated log files kept next to the current one.
+	logMaxBackups = 10
+	// logMaxAge is how long rotated log files are kept.
+	logMaxAge = 30 * 24 * time.Hour
+	// logBackupLayout is the timestamp appended to the name of rotated log files; it sorts chronologically.
+	// The nanoseconds keep two rotations within a second, by this or another process, from picking the same name.
+	logBackupLayout = "20060102T150405.000000000"
+	// legacyLogBackupLayout is the timestamp of files rotated by earlier versions, which are still pruned.
+	legacyLogBackupLayout = "20060102T150405"
+)
+
+// rotatingFile is a log file that is rotated when it reaches logMaxSize or when a new day starts,
+// so that each file covers at most one day. Rotated files are named after the time of rotation
+// and deleted once there are more than logMaxBackups of them or they are older than logMaxAge.
+type rotatingFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+	// day is the day of the last write, as returned by DayKey.
+	day string
+}
+
+// openRotatingFile opens the log file at path for appending, creating it if needed.
+func openRotatingFile(path string) (*rotatingFile, error) {
+	f := &rotatingFile{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at f.path and picks up its size and the day it was last written.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	f.day = DayKey(info.ModTime())
+	if f.size == 0 {
+		f.day = DayKey(time.Now())
+	}
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if it is full or from an earlier day.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := time.Now()
+	if f.size > 0 && (f.size+int64(len(p)) > logMaxSize || DayKey(now) != f.day) {
+		if err := f.rotate(now); err != nil {
+			// Keep writing to the current file; the next write tries again.
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.day = DayKey(now)
+	return n, err
+}
+
+// rotate moves the current log file aside and starts a new one, then deletes old rotated files.
+// If another process writing the same log has rotated it already, the file it started is used instead.
+func (f *rotatingFile) rotate(now time.Time) error {
+	rotatedElsewhere := false
+	if current, err := f.file.Stat(); err == nil {
+		onDisk, err := os.Stat(f.path)
+		rotatedElsewhere = err != nil || !os.SameFile(current, onDisk)
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	var renameErr error
+	if !rotatedElsewhere {
+		renameErr = renameLogBackup(f.path, now)
+	}
+	// Reopen even if the rename failed, e.g. because another process has the file open on Windows.
+	if err := f.open(); err != nil {
+		f.file = nil
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	pruneLogBackups(f.path, now)
+	return nil
+}
+
+// renameLogBackup moves the log file at path to a backup named after now. Rename replaces an existing file
+// on most systems, so a name that is taken already gets a counter rather than overwriting an earlier backup.
+func renameLogBackup(path string, now time.Time) error {
+	backup := path + "." + now.Format(logBackupLayout)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return os.Rename(path, backup)
+		}
+		if i > 100 {
+			return fmt.Errorf("no free name for the rotated log file %s", backup)
+		}
+		backup = fmt.Sprintf("%s.%s-%d", path, now.Format(logBackupLayout), i)
+	}
+}
+
+// logBackupTime returns when the rotated log file with the given suffix was rotated, and whether it is one.
+func logBackupTime(suffix string) (time.Time, bool) {
+	suffix, _, _ = strings.Cut(suffix, "-")
+	for _, layout := range []string{logBackupLayout, legacyLogBackupLayout} {
+		if t, err := time.ParseInLocation(layout, suffix, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Close closes the log file. Later writes fail with os.ErrClosed.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// pruneLogBackups deletes rotated log files beyond logMaxBackups or older than logMaxAge.
+func pruneLogBackups(path string, now time.Time) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		if _, ok := logBackupTime(strings.TrimPrefix(m, path+".")); ok {
+			backups = append(backups, m)
+		}
+	}
+	// The timestamps sort chronologically, so the oldest backups come first.
+	for i, backup := range backups {
+		tooMany := i < len(backups)-logMaxBackups
+		rotatedAt, _ := logBackupTime(strings.TrimPrefix(backup, path+"."))
+		if tooMany || now.Sub(rotatedAt) > logMaxAge {
+			if err := os.Remove(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to remove old log file %s: %v\n", backup, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// logFiles returns the contents of the rotated copies of the log file at path, oldest first, and then its own.
+func logFiles(t *testing.T, path string) []string {
+	t.Helper()
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(backups)
+	var contents []string
+	for _, p := range append(backups, path) {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	return contents
+}
+
+// writeLog writes s to f and fails the test if that doesn't work.
+func writeLog(t *testing.T, f *rotatingFile, s string) {
+	t.Helper()
+	if _, err := f.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "procguard.log")
+	now := time.Now()
+
+	first, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+	second, err := openRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+
+	// Two rotations at the same instant keep both files.
+	writeLog(t, first, "a\n")
+	first.mu.Lock()
+	if err := first.rotate(now); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	first.mu.Unlock()
+	writeLog(t, first, "b\n")
+	first.mu.Lock()
+	if err := first.rotate(now); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	first.mu.Unlock()
+
+	// The second writer's file was rotated by the first, so it moves on to the new file instead of rotating that.
+	second.mu.Lock()
+	if err := second.rotate(now); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	second.mu.Unlock()
+	writeLog(t, first, "c\n")
+	writeLog(t, second, "d\n")
+
+	want := []string{"a\n", "b\n", "c\nd\n"}
+	if got := logFiles(t, path); !slices.Equal(got, want) {
+		t.Errorf("log files = %q, want %q", got, want)
+	}
+}
+
+func TestLogBackupTime(t *testing.T) {
+	tests := []struct {
+		suffix string
+		want   time.Time
+		ok     bool
+	}{
+		{suffix: "20261019T120000.000000001", want: time.Date(2026, 10, 19, 12, 0, 0, 1, time.Local), ok: true},
+		{suffix: "20261019T120000.000000001-2", want: time.Date(2026, 10, 19, 12, 0, 0, 1, time.Local), ok: true},
+		{suffix: "20261019T120000", want: time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local), ok: true},
+		{suffix: "lock"},
+		{suffix: "bak"},
+	}
+	for _, tt := range tests {
+		got, ok := logBackupTime(tt.suffix)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("logBackupTime(%q) = %v, %v, want %v, %v", tt.suffix, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// logLevelNames are the names stored in the level column of the logs table, from least to most severe.
+var logLevelNames = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError, LevelFatal}
+
+// LogEntry is a record of the logs table.
+type LogEntry struct {
+	ID        int64                  `json:"id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogPage is a page of log entries, newest first.
+type LogPage struct {
+	Entries []LogEntry `json:"entries"`
+	// NextCursor continues with the following page. It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// LogFilter selects the log entries returned by QueryLogs.
+type LogFilter struct {
+	// MinLevel is the least severe level returned, such as "warn". Empty means every level.
+	MinLevel string
+	// Text matches entries whose message or fields contain it, ignoring case.
+	Text string
+	// Since and Until bound the time of the entries and accept anything ParseTime does.
+	Since string
+	Until string
+}
+
+// QueryLogs returns a page of the log entries that match filter, newest first.
+func QueryLogs(db *sql.DB, filter LogFilter, page PageRequest) (LogPage, error) {
+	sinceTime, untilTime, err := ParseTimeRange(filter.Since, filter.Until)
+	if err != nil {
+		return LogPage{}, err
+	}
+	cursor, err := decodeCursor(page.Cursor, false)
+	if err != nil {
+		return LogPage{}, err
+	}
+	limit := page.pageLimit(false)
+
+	q := "SELECT id, timestamp, level, message, fields FROM logs WHERE 1=1"
+	args := make([]interface{}, 0)
+
+	if filter.MinLevel != "" {
+		minLevel, err := ParseLogLevel(filter.MinLevel)
+		if err != nil {
+			return LogPage{}, err
+		}
+		var names []string
+		for _, level := range logLevelNames {
+			if level >= minLevel {
+				names = append(names, "?")
+				args = append(args, LevelName(level))
+			}
+		}
+		q += " AND level IN (" + strings.Join(names, ", ") + ")"
+	}
+
+	if filter.Text != "" {
+		pattern := likePattern(filter.Text)
+		q += ` AND (message LIKE ? ESCAPE '\' OR fields LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+
+	if !sinceTime.IsZero() {
+		q += " AND timestamp >= ?"
+		args = append(args, sinceTime.Unix())
+	}
+
+	if !untilTime.IsZero() {
+		q += " AND timestamp <= ?"
+		args = append(args, untilTime.Unix())
+	}
+
+	if cursor != nil {
+		q += " AND (timestamp < ? OR (timestamp = ? AND id < ?))"
+		args = append(args, cursor.Time, cursor.Time, cursor.ID)
+	}
+	q += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, queryLimit(limit))
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return LogPage{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	result := LogPage{Entries: []LogEntry{}}
+	for rows.Next() {
+		var entry LogEntry
+		var timestamp int64
+		var fields sql.NullString
+		if err := rows.Scan(&entry.ID, &timestamp, &entry.Level, &entry.Message, &fields); err != nil {
+			return LogPage{}, err
+		}
+		entry.Timestamp = localTime(timestamp)
+		if fields.Valid {
+			// Entries with unreadable fields are still worth showing.
+			_ = json.Unmarshal([]byte(fields.String), &entry.Fields)
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return LogPage{}, err
+	}
+
+	if len(result.Entries) > limit {
+		result.Entries = result.Entries[:limit]
+		last := result.Entries[limit-1]
+		result.NextCursor = pageCursor{Time: last.Timestamp.Unix(), ID: last.ID}.encode()
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDataDir is the data directory of the database opened by openTestDB. InitDB opens a single database per process,
+// so the tests that need it share it.
+var testDataDir string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "procguard-data-test-*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testDataDir = dir
+	SetRuntimeConfig(RuntimeConfig{DataDir: dir, LogPath: filepath.Join(dir, "procguard.log")})
+	NewLogger(nil)
+
+	code := m.Run()
+
+	SetRuntimeConfig(RuntimeConfig{DataDir: dir})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := CloseDB(ctx); err != nil {
+		log.Printf("CloseDB: %v", err)
+	}
+	cancel()
+	if err := UnlockPolicyFiles(); err != nil {
+		log.Printf("UnlockPolicyFiles: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("RemoveAll: %v", err)
+	}
+	os.Exit(code)
+}
+
+// openTestDB returns the shared test database, with the data directory pointed back at it and the policy state
+// read again, as it is at startup.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	SetRuntimeConfig(RuntimeConfig{DataDir: testDataDir})
+	resetPolicyState()
+	db, err := InitDB()
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	if err := loadPolicyState(db); err != nil {
+		t.Fatalf("loadPolicyState: %v", err)
+	}
+	if _, err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// This file holds the signing of the blocklists by migration 10 as it was released. Migrations must give the same
+// result whenever they run, so it doesn't share any code with the signing done today, which may change.
+// Don't edit it.
+
+// v10SignedBlocklistEntry is a row of a blocklist table as schema version 10 signed it.
+type v10SignedBlocklistEntry struct {
+	Name      string  `json:"name"`
+	AddedAt   int64   `json:"added_at"`
+	AddedBy   *string `json:"added_by"`
+	Reason    *string `json:"reason"`
+	Tags      *string `json:"tags"`
+	ExpiresAt *int64  `json:"expires_at"`
+}
+
+// signBlocklistsV10 signs the content of both blocklist tables, as they are when the policy is first signed.
+func signBlocklistsV10(tx *sql.Tx) error {
+	for _, t := range []struct{ table, column string }{{"app_blocklist", "name"}, {"web_blocklist", "domain"}} {
+		content, err := v10BlocklistContent(tx, t.table, t.column)
+		if err != nil {
+			return err
+		}
+		signature, err := signPolicy(t.table, content)
+		if err != nil {
+			return fmt.Errorf("could not sign %s: %w", t.table, err)
+		}
+		_, err = tx.Exec(`INSERT INTO policy_signatures (name, content, signature) VALUES (?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET content = excluded.content, signature = excluded.signature`,
+			t.table, string(content), signature)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// v10BlocklistContent returns every row of a blocklist table as the JSON document that schema version 10 signed.
+func v10BlocklistContent(tx *sql.Tx, table, column string) ([]byte, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT %s, added_at, added_by, reason, tags, expires_at FROM %s ORDER BY %s",
+		column, table, column))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			logMigrationWarning("Failed to close rows", "err", err)
+		}
+	}()
+
+	entries := []v10SignedBlocklistEntry{}
+	for rows.Next() {
+		var e v10SignedBlocklistEntry
+		if err := rows.Scan(&e.Name, &e.AddedAt, &e.AddedBy, &e.Reason, &e.Tags, &e.ExpiresAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
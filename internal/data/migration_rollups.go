@@ -0,0 +1,174 @@
+package data
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This file holds the backfill of migration 3 as it was released. Migrations must give the same result
+// whenever they run, so it doesn't share any code with the rollups that are recorded today, which may change.
+// Don't edit it; fix rollups that were computed differently with a new migration instead.
+
+// v3DayLayout is the format of the day column of the rollup tables in schema version 3.
+const v3DayLayout = "2006-01-02"
+
+// v3MaxWebVisitDuration caps the time attributed to a single page view in schema version 3.
+const v3MaxWebVisitDuration = 5 * time.Minute
+
+// v3DomainFromURL returns the lowercase host name of a URL without its port, as schema version 3 keyed domains.
+func v3DomainFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// v3DayKey returns the day that t falls on in the time zone of the system, which schema version 3 counted days in.
+func v3DayKey(t time.Time) string {
+	return t.In(time.Local).Format(v3DayLayout)
+}
+
+// v3DaySegment is the part of a session that falls on a single day.
+type v3DaySegment struct {
+	day      string
+	duration int64
+}
+
+// v3SplitByDay splits the interval [start, end) into per-day segments in the time zone of the system.
+func v3SplitByDay(start, end int64) []v3DaySegment {
+	if end < start {
+		end = start
+	}
+	var segments []v3DaySegment
+	from := time.Unix(start, 0).In(time.Local)
+	to := time.Unix(end, 0)
+	for {
+		next := time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, time.Local)
+		if !next.Before(to) {
+			segments = append(segments, v3DaySegment{day: v3DayKey(from), duration: int64(to.Sub(from).Seconds())})
+			return segments
+		}
+		segments = append(segments, v3DaySegment{day: v3DayKey(from), duration: int64(next.Sub(from).Seconds())})
+		from = next
+	}
+}
+
+// v3WebVisitDuration returns the number of seconds credited to a page view that was followed by another one.
+func v3WebVisitDuration(start, next int64) int64 {
+	duration := next - start
+	if duration < 0 {
+		return 0
+	}
+	if limit := int64(v3MaxWebVisitDuration.Seconds()); duration > limit {
+		return limit
+	}
+	return duration
+}
+
+// backfillRollupsV3 fills the rollup tables created by migration 3 from the raw events.
+func backfillRollupsV3(tx *sql.Tx) error {
+	type key struct{ day, name string }
+	type catKey struct{ day, kind, category string }
+	type usage struct{ duration, count int64 }
+
+	apps := make(map[key]*usage)
+	domains := make(map[key]*usage)
+	categories := make(map[catKey]*usage)
+	add := func(m map[key]*usage, k key, duration, count int64) {
+		if m[k] == nil {
+			m[k] = &usage{}
+		}
+		m[k].duration += duration
+		m[k].count += count
+	}
+	addCategory := func(k catKey, duration, count int64) {
+		if categories[k] == nil {
+			categories[k] = &usage{}
+		}
+		categories[k].duration += duration
+		categories[k].count += count
+	}
+
+	rows, err := tx.Query("SELECT process_name, start_time, end_time FROM app_events WHERE end_time IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var start, end int64
+		if err := rows.Scan(&name, &start, &end); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		// Categories are reference data rather than logic, so the current lists are used.
+		category := AppCategory(name)
+		for i, seg := range v3SplitByDay(start, end) {
+			var sessions int64
+			if i == 0 {
+				sessions = 1
+			}
+			add(apps, key{seg.day, name}, seg.duration, sessions)
+			addCategory(catKey{seg.day, "app", category}, seg.duration, sessions)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query("SELECT url, timestamp FROM web_events ORDER BY timestamp, id")
+	if err != nil {
+		return err
+	}
+	var prevDomain string
+	var prevTimestamp int64
+	for rows.Next() {
+		var rawURL string
+		var timestamp int64
+		if err := rows.Scan(&rawURL, &timestamp); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		domain := v3DomainFromURL(rawURL)
+		if domain == "" {
+			continue
+		}
+		if prevDomain != "" {
+			if duration := v3WebVisitDuration(prevTimestamp, timestamp); duration > 0 {
+				day := v3DayKey(time.Unix(prevTimestamp, 0))
+				add(domains, key{day, prevDomain}, duration, 0)
+				addCategory(catKey{day, "web", DomainCategory(prevDomain)}, duration, 0)
+			}
+		}
+		day := v3DayKey(time.Unix(timestamp, 0))
+		add(domains, key{day, domain}, 0, 1)
+		addCategory(catKey{day, "web", DomainCategory(domain)}, 0, 1)
+		prevDomain, prevTimestamp = domain, timestamp
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	// Each key is unique, so plain inserts are enough.
+	for k, u := range apps {
+		if _, err := tx.Exec("INSERT INTO daily_app_stats (day, process_name, duration_seconds, session_count) VALUES (?, ?, ?, ?)",
+			k.day, k.name, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	for k, u := range domains {
+		if _, err := tx.Exec("INSERT INTO daily_domain_stats (day, domain, duration_seconds, visit_count) VALUES (?, ?, ?, ?)",
+			k.day, k.name, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	for k, u := range categories {
+		if _, err := tx.Exec("INSERT INTO daily_category_stats (day, kind, category, duration_seconds, event_count) VALUES (?, ?, ?, ?, ?)",
+			k.day, k.kind, k.category, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
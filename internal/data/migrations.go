@@ -0,0 +1,630 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSchemaBackups is the number of pre-migration database backups kept next to the database.
+const maxSchemaBackups = 3
+
+// migration is a single, ordered change to the database schema.
+// Each migration runs in its own transaction together with the update of PRAGMA user_version,
+// so the schema version always matches the migrations that were actually applied.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+	// upNoTx replaces up for a change that SQLite can't make inside a transaction, such as VACUUM.
+	// It runs on a single connection that then records the version, so it must be safe to run again
+	// if the process stops before that.
+	upNoTx func(conn *sql.Conn) error
+}
+
+// migrations lists every schema change in order. Versions must be consecutive and start at 1.
+// Never edit a migration that has been released; add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		// Databases created before migrations existed already have these tables, hence IF NOT EXISTS.
+		up: execMigration(`
+		-- app_events stores information about running processes.
+		CREATE TABLE IF NOT EXISTS app_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			process_name TEXT NOT NULL,
+			pid INTEGER NOT NULL,
+			parent_process_name TEXT,
+			exe_path TEXT,
+			start_time INTEGER NOT NULL,
+			end_time INTEGER
+		);
+
+		-- Indexes to speed up queries on app_events.
+		CREATE INDEX IF NOT EXISTS idx_app_events_start_time ON app_events (start_time);
+		CREATE INDEX IF NOT EXISTS idx_app_events_end_time ON app_events (end_time);
+		CREATE INDEX IF NOT EXISTS idx_app_events_pid ON app_events (pid);
+
+		-- web_events stores the URLs of visited websites.
+		CREATE TABLE IF NOT EXISTS web_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			timestamp INTEGER NOT NULL
+		);
+
+		-- Index to speed up queries on web_events.
+		CREATE INDEX IF NOT EXISTS idx_web_events_timestamp ON web_events (timestamp);
+
+		-- logs stores application logs.
+		CREATE TABLE IF NOT EXISTS logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			level TEXT NOT NULL,
+			message TEXT NOT NULL
+		);
+
+		-- Index to speed up queries on logs.
+		CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON logs (timestamp);
+
+		-- web_metadata stores cached metadata for websites (title, icon).
+		CREATE TABLE IF NOT EXISTS web_metadata (
+			domain TEXT PRIMARY KEY,
+			title TEXT,
+			icon_url TEXT,
+			timestamp INTEGER NOT NULL
+		);
+		`),
+	},
+	{
+		version:     2,
+		description: "block events",
+		up: execMigration(`
+		-- block_events stores every time the enforcer stopped a blocklisted program.
+		CREATE TABLE IF NOT EXISTS block_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			process_name TEXT NOT NULL,
+			exe_path TEXT,
+			pid INTEGER,
+			action TEXT NOT NULL
+		);
+
+		-- Index to speed up queries on block_events.
+		CREATE INDEX IF NOT EXISTS idx_block_events_timestamp ON block_events (timestamp);
+		`),
+	},
+	{
+		version:     3,
+		description: "daily rollups",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			-- daily_app_stats stores the total usage of each app per day.
+			CREATE TABLE daily_app_stats (
+				day TEXT NOT NULL,
+				process_name TEXT NOT NULL,
+				duration_seconds INTEGER NOT NULL DEFAULT 0,
+				session_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (day, process_name)
+			);
+
+			-- daily_domain_stats stores the total usage of each domain per day.
+			CREATE TABLE daily_domain_stats (
+				day TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				duration_seconds INTEGER NOT NULL DEFAULT 0,
+				visit_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (day, domain)
+			);
+
+			-- daily_category_stats stores the total usage of each app or web category per day.
+			CREATE TABLE daily_category_stats (
+				day TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				category TEXT NOT NULL,
+				duration_seconds INTEGER NOT NULL DEFAULT 0,
+				event_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (day, kind, category)
+			);
+			`)
+			if err != nil {
+				return err
+			}
+			return backfillRollupsV3(tx)
+		},
+	},
+	{
+		version:     4,
+		description: "blocklist tables",
+		// Entries from the JSON files used by older versions are imported by migrateLegacyBlocklists.
+		up: execMigration(`
+		-- app_blocklist stores blocked process names (lowercase) and their metadata.
+		CREATE TABLE app_blocklist (
+			name TEXT PRIMARY KEY,
+			added_at INTEGER NOT NULL,
+			added_by TEXT,
+			reason TEXT,
+			tags TEXT,
+			expires_at INTEGER
+		);
+
+		-- web_blocklist stores blocked domains (lowercase) and their metadata.
+		CREATE TABLE web_blocklist (
+			domain TEXT PRIMARY KEY,
+			added_at INTEGER NOT NULL,
+			added_by TEXT,
+			reason TEXT,
+			tags TEXT,
+			expires_at INTEGER
+		);
+		`),
+	},
+	{
+		version:     5,
+		description: "full-text search",
+		up: execMigration(`
+		-- The command line of each process and the title of each visited page, recorded from now on.
+		ALTER TABLE app_events ADD COLUMN cmdline TEXT;
+		ALTER TABLE web_events ADD COLUMN title TEXT;
+
+		-- Full-text indexes over app_events and web_events. They are external-content tables,
+		-- so the text is stored only once and the triggers below keep the indexes in sync.
+		CREATE VIRTUAL TABLE app_events_fts USING fts5(
+			process_name, parent_process_name, exe_path, cmdline,
+			content='app_events', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+		);
+		CREATE VIRTUAL TABLE web_events_fts USING fts5(
+			url, title,
+			content='web_events', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+		);
+
+		CREATE TRIGGER app_events_fts_insert AFTER INSERT ON app_events BEGIN
+			INSERT INTO app_events_fts (rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES (new.id, new.process_name, new.parent_process_name, new.exe_path, new.cmdline);
+		END;
+		CREATE TRIGGER app_events_fts_delete AFTER DELETE ON app_events BEGIN
+			INSERT INTO app_events_fts (app_events_fts, rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES ('delete', old.id, old.process_name, old.parent_process_name, old.exe_path, old.cmdline);
+		END;
+		-- Only changes to indexed columns touch the index; setting end_time doesn't.
+		CREATE TRIGGER app_events_fts_update AFTER UPDATE OF process_name, parent_process_name, exe_path, cmdline ON app_events BEGIN
+			INSERT INTO app_events_fts (app_events_fts, rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES ('delete', old.id, old.process_name, old.parent_process_name, old.exe_path, old.cmdline);
+			INSERT INTO app_events_fts (rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES (new.id, new.process_name, new.parent_process_name, new.exe_path, new.cmdline);
+		END;
+
+		CREATE TRIGGER web_events_fts_insert AFTER INSERT ON web_events BEGIN
+			INSERT INTO web_events_fts (rowid, url, title) VALUES (new.id, new.url, new.title);
+		END;
+		CREATE TRIGGER web_events_fts_delete AFTER DELETE ON web_events BEGIN
+			INSERT INTO web_events_fts (web_events_fts, rowid, url, title) VALUES ('delete', old.id, old.url, old.title);
+		END;
+		CREATE TRIGGER web_events_fts_update AFTER UPDATE OF url, title ON web_events BEGIN
+			INSERT INTO web_events_fts (web_events_fts, rowid, url, title) VALUES ('delete', old.id, old.url, old.title);
+			INSERT INTO web_events_fts (rowid, url, title) VALUES (new.id, new.url, new.title);
+		END;
+
+		-- Index the events recorded before this migration.
+		INSERT INTO app_events_fts (app_events_fts) VALUES ('rebuild');
+		INSERT INTO web_events_fts (web_events_fts) VALUES ('rebuild');
+		`),
+	},
+	{
+		version:     6,
+		description: "URL components",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			-- The parts of each visited URL, filled in by ParseURLComponents when the event is recorded.
+			ALTER TABLE web_events ADD COLUMN scheme TEXT;
+			ALTER TABLE web_events ADD COLUMN host TEXT;
+			ALTER TABLE web_events ADD COLUMN domain TEXT;
+			ALTER TABLE web_events ADD COLUMN path TEXT;
+			ALTER TABLE web_events ADD COLUMN query_hash TEXT;
+			`)
+			if err != nil {
+				return err
+			}
+			if err := backfillURLComponents(tx); err != nil {
+				return err
+			}
+			// The indexes are created after the backfill, which is faster than updating them row by row.
+			_, err = tx.Exec(`
+			-- Indexes to speed up queries and leaderboards by site.
+			CREATE INDEX idx_web_events_host_timestamp ON web_events (host, timestamp);
+			CREATE INDEX idx_web_events_domain_timestamp ON web_events (domain, timestamp);
+			`)
+			return err
+		},
+	},
+	{
+		version:     7,
+		description: "structured logs",
+		up: execMigration(`
+		-- The key-value fields of each log message, as a JSON object.
+		ALTER TABLE logs ADD COLUMN fields TEXT;
+
+		-- Index to speed up filtering logs by level.
+		CREATE INDEX idx_logs_level_timestamp ON logs (level, timestamp);
+		`),
+	},
+	{
+		version:     8,
+		description: "daemon heartbeats and power events",
+		up: execMigration(`
+		-- One row per run of the daemon. last_beat is updated periodically while it runs, so sessions
+		-- interrupted by a crash or power loss can be closed at the last moment the daemon was alive.
+		-- stopped_at is only set when the daemon shuts down cleanly.
+		CREATE TABLE heartbeats (
+			started_at INTEGER PRIMARY KEY,
+			last_beat INTEGER NOT NULL,
+			stopped_at INTEGER
+		);
+
+		-- Suspend and resume markers, detected as jumps of the wall clock between two polls.
+		CREATE TABLE power_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX idx_power_events_timestamp ON power_events (timestamp);
+		`),
+	},
+	{
+		version:     9,
+		description: "column encryption",
+		up: execMigration(`
+		-- Keyed hashes of encrypted executable paths and URLs, so they can still be looked up by equality.
+		ALTER TABLE app_events ADD COLUMN exe_path_hash TEXT;
+		ALTER TABLE web_events ADD COLUMN url_hash TEXT;
+		CREATE INDEX idx_app_events_exe_path_hash ON app_events (exe_path_hash);
+		CREATE INDEX idx_web_events_url_hash ON web_events (url_hash);
+
+		-- Encrypted values must not end up in the full-text indexes, where they would only add noise.
+		-- The delete statements use the same expressions, so they remove exactly what was indexed.
+		DROP TRIGGER app_events_fts_insert;
+		DROP TRIGGER app_events_fts_delete;
+		DROP TRIGGER app_events_fts_update;
+		DROP TRIGGER web_events_fts_insert;
+		DROP TRIGGER web_events_fts_delete;
+		DROP TRIGGER web_events_fts_update;
+
+		CREATE TRIGGER app_events_fts_insert AFTER INSERT ON app_events BEGIN
+			INSERT INTO app_events_fts (rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES (new.id, new.process_name, new.parent_process_name,
+				CASE WHEN new.exe_path GLOB 'enc1:*' THEN NULL ELSE new.exe_path END,
+				CASE WHEN new.cmdline GLOB 'enc1:*' THEN NULL ELSE new.cmdline END);
+		END;
+		CREATE TRIGGER app_events_fts_delete AFTER DELETE ON app_events BEGIN
+			INSERT INTO app_events_fts (app_events_fts, rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES ('delete', old.id, old.process_name, old.parent_process_name,
+				CASE WHEN old.exe_path GLOB 'enc1:*' THEN NULL ELSE old.exe_path END,
+				CASE WHEN old.cmdline GLOB 'enc1:*' THEN NULL ELSE old.cmdline END);
+		END;
+		CREATE TRIGGER app_events_fts_update AFTER UPDATE OF process_name, parent_process_name, exe_path, cmdline ON app_events BEGIN
+			INSERT INTO app_events_fts (app_events_fts, rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES ('delete', old.id, old.process_name, old.parent_process_name,
+				CASE WHEN old.exe_path GLOB 'enc1:*' THEN NULL ELSE old.exe_path END,
+				CASE WHEN old.cmdline GLOB 'enc1:*' THEN NULL ELSE old.cmdline END);
+			INSERT INTO app_events_fts (rowid, process_name, parent_process_name, exe_path, cmdline)
+			VALUES (new.id, new.process_name, new.parent_process_name,
+				CASE WHEN new.exe_path GLOB 'enc1:*' THEN NULL ELSE new.exe_path END,
+				CASE WHEN new.cmdline GLOB 'enc1:*' THEN NULL ELSE new.cmdline END);
+		END;
+
+		CREATE TRIGGER web_events_fts_insert AFTER INSERT ON web_events BEGIN
+			INSERT INTO web_events_fts (rowid, url, title)
+			VALUES (new.id,
+				CASE WHEN new.url GLOB 'enc1:*' THEN NULL ELSE new.url END,
+				CASE WHEN new.title GLOB 'enc1:*' THEN NULL ELSE new.title END);
+		END;
+		CREATE TRIGGER web_events_fts_delete AFTER DELETE ON web_events BEGIN
+			INSERT INTO web_events_fts (web_events_fts, rowid, url, title)
+			VALUES ('delete', old.id,
+				CASE WHEN old.url GLOB 'enc1:*' THEN NULL ELSE old.url END,
+				CASE WHEN old.title GLOB 'enc1:*' THEN NULL ELSE old.title END);
+		END;
+		CREATE TRIGGER web_events_fts_update AFTER UPDATE OF url, title ON web_events BEGIN
+			INSERT INTO web_events_fts (web_events_fts, rowid, url, title)
+			VALUES ('delete', old.id,
+				CASE WHEN old.url GLOB 'enc1:*' THEN NULL ELSE old.url END,
+				CASE WHEN old.title GLOB 'enc1:*' THEN NULL ELSE old.title END);
+			INSERT INTO web_events_fts (rowid, url, title)
+			VALUES (new.id,
+				CASE WHEN new.url GLOB 'enc1:*' THEN NULL ELSE new.url END,
+				CASE WHEN new.title GLOB 'enc1:*' THEN NULL ELSE new.title END);
+		END;
+		`),
+	},
+	{
+		version:     10,
+		description: "policy signatures and tamper events",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+			-- The last verified content of each blocklist table, as signed with the policy key.
+			-- Changes of a table that don't match it are refused and the content is written back.
+			CREATE TABLE policy_signatures (
+				name TEXT PRIMARY KEY,
+				content TEXT NOT NULL,
+				signature TEXT NOT NULL
+			);
+
+			-- Changes of the policy that were made outside of ProcGuard.
+			CREATE TABLE tamper_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp INTEGER NOT NULL,
+				target TEXT NOT NULL,
+				detail TEXT
+			);
+			CREATE INDEX idx_tamper_events_timestamp ON tamper_events (timestamp);
+			`)
+			if err != nil {
+				return err
+			}
+			// The blocklists are trusted as they are when they are first signed.
+			return signBlocklistsV10(tx)
+		},
+	},
+	{
+		version:     11,
+		description: "incremental auto-vacuum",
+		// The mode only takes effect after a full VACUUM, which compacts the database once.
+		// From then on, the retention pruner returns freed pages to the file system a few at a time.
+		upNoTx: func(conn *sql.Conn) error {
+			if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(context.Background(), "VACUUM")
+			return err
+		},
+	},
+	{
+		version:     12,
+		description: "rollup time zone",
+		// Without a row, the rollups are recounted once in the display time zone at the next start.
+		up: execMigration(`
+		-- The time zone that the daily rollups were counted in. They are recounted when the display time zone changes.
+		CREATE TABLE rollup_state (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			timezone TEXT NOT NULL
+		);
+		`),
+	},
+	{
+		version:     13,
+		description: "policy generations",
+		// Signatures made so far are generation 0, which signs the content alone.
+		up: execMigration(`
+		ALTER TABLE policy_signatures ADD COLUMN generation INTEGER NOT NULL DEFAULT 0;
+
+		-- The highest generation of each part of the policy that has been signed or verified. A signed copy
+		-- older than that was put back to undo a change, and is refused.
+		CREATE TABLE policy_generations (
+			name TEXT PRIMARY KEY,
+			generation INTEGER NOT NULL
+		);
+		`),
+	},
+	{
+		version:     14,
+		description: "no query hashes of encrypted URLs",
+		// The unkeyed query hash would let an encrypted query be guessed, so it is only kept for URLs in the clear.
+		up: execMigration(`
+		UPDATE web_events SET query_hash = NULL WHERE url GLOB 'enc1:*';
+		`),
+	},
+}
+
+// SchemaInfo describes the schema version of the database.
+type SchemaInfo struct {
+	// Version is the version recorded in the database.
+	Version int `json:"version"`
+	// Latest is the newest version this build knows how to migrate to.
+	Latest int `json:"latest"`
+}
+
+// execMigration returns a migration step that executes the given SQL statements.
+func execMigration(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// latestSchemaVersion returns the version of the newest migration.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// GetSchemaInfo reports the schema version of the database and the newest version supported by this build.
+func GetSchemaInfo(db *sql.DB) (SchemaInfo, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return SchemaInfo{}, err
+	}
+	return SchemaInfo{Version: version, Latest: latestSchemaVersion()}, nil
+}
+
+// schemaVersion reads PRAGMA user_version, which SQLite stores in the database header.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies every migration newer than the database's current schema version.
+// Before touching an existing database it writes a backup copy, so a failed upgrade can be rolled back by hand.
+// The GUI and the native messaging host both open the database, so the upgrade runs under a lock shared by every
+// process: the backup and the migrations that run outside a transaction must not run twice at the same time.
+func migrate(db *sql.DB) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	if current == latest {
+		return nil
+	}
+
+	dbPath, err := GetDBPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(dbPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have upgraded the database while this one waited for the lock.
+	current, err = schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if current > 0 || hasTables(db) {
+		if err := backupBeforeMigrate(db, current); err != nil {
+			return fmt.Errorf("could not back up database before migrating: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a single migration and records its version in one transaction.
+// The version is read again inside the transaction, so a migration that has been applied in the meantime is skipped.
+func applyMigration(db *sql.DB, m migration) error {
+	if m.upNoTx != nil {
+		return applyMigrationNoTx(db, m)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var current int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+	if current >= m.version {
+		return nil
+	}
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	// PRAGMA statements cannot take bound parameters, but the version is an integer we control.
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyMigrationNoTx runs a migration that can't run inside a transaction, then records its version.
+func applyMigrationNoTx(db *sql.DB, m migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logMigrationWarning("Failed to close connection", "err", err)
+		}
+	}()
+
+	// The migration lock keeps other processes from upgrading the database while this runs.
+	var current int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+	if current >= m.version {
+		return nil
+	}
+	if err := m.upNoTx(conn); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", m.version))
+	return err
+}
+
+// hasTables reports whether the database already contains any tables, i.e. whether it predates versioned migrations.
+func hasTables(db *sql.DB) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// backupBeforeMigrate writes a consistent copy of the database next to it using VACUUM INTO,
+// then removes all but the newest maxSchemaBackups backups.
+func backupBeforeMigrate(db *sql.DB, version int) error {
+	dbPath, err := GetDBPath()
+	if err != nil {
+		return err
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", dbPath, version, time.Now().Format("20060102T150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return err
+	}
+
+	pruneSchemaBackups(dbPath)
+	return nil
+}
+
+// pruneSchemaBackups deletes the oldest pre-migration backups beyond maxSchemaBackups.
+func pruneSchemaBackups(dbPath string) {
+	matches, err := filepath.Glob(dbPath + ".v*.bak")
+	if err != nil || len(matches) <= maxSchemaBackups {
+		return
+	}
+
+	// The timestamp after the last dash sorts chronologically, whereas the version before it may differ in length.
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i][strings.LastIndex(matches[i], "-"):] < matches[j][strings.LastIndex(matches[j], "-"):]
+	})
+	for _, old := range matches[:len(matches)-maxSchemaBackups] {
+		if err := os.Remove(old); err != nil {
+			logMigrationWarning("Failed to remove old database backup", "path", old, "err", err)
+		}
+	}
+}
+
+// logMigrationWarning logs a problem that doesn't stop a migration. Migrations run before the logger is set up
+// when the database is opened at startup, so the standard logger is used until it is.
+func logMigrationWarning(msg string, args ...any) {
+	if logger := GetLogger(); logger != nil {
+		logger.Warn(msg, args...)
+		return
+	}
+	log.Printf("[WARN] %s %v", msg, args)
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// migrationTestTime is when the events seeded by the migration tests happened, at noon so they fall on a single day.
+var migrationTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+
+// openMigrationTestDB opens a new database in a new data directory.
+func openMigrationTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	usePolicyDir(t)
+	db, err := openAndConfigureDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return db
+}
+
+// migrateTo applies the migrations up to and including version, as the releases that stopped there did.
+func migrateTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+	for _, m := range migrations {
+		if m.version > version {
+			return
+		}
+		if err := applyMigration(db, m); err != nil {
+			t.Fatalf("migration %d: %v", m.version, err)
+		}
+	}
+}
+
+// seedMigrationTestDB inserts an app session, a page view and a blocklist entry with the columns of schema version 1.
+func seedMigrationTestDB(t *testing.T, db *sql.DB) {
+	t.Helper()
+	start := migrationTestTime.Unix()
+	execTest(t, db, "INSERT INTO app_events (process_name, pid, exe_path, start_time, end_time) VALUES ('a.exe', 1, '/bin/a.exe', ?, ?)",
+		start, start+60)
+	execTest(t, db, "INSERT INTO web_events (url, timestamp) VALUES ('https://www.example.com/page', ?)", start)
+}
+
+// schemaObjects describes the schema of db: the columns of every table, and the names of its indexes and triggers.
+func schemaObjects(t *testing.T, db *sql.DB) map[string][]string {
+	t.Helper()
+	rows, err := db.Query("SELECT type, name FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY type, name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type object struct{ kind, name string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.name); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := make(map[string][]string)
+	for _, o := range objects {
+		key := o.kind + " " + o.name
+		if o.kind != "table" {
+			schema[key] = nil
+			continue
+		}
+		columns, err := db.Query(fmt.Sprintf("SELECT name, type, \"notnull\", pk FROM pragma_table_info('%s')", o.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for columns.Next() {
+			var name, kind string
+			var notNull, pk int
+			if err := columns.Scan(&name, &kind, &notNull, &pk); err != nil {
+				t.Fatal(err)
+			}
+			schema[key] = append(schema[key], fmt.Sprintf("%s %s notnull=%d pk=%d", name, kind, notNull, pk))
+		}
+		if err := columns.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(schema[key])
+	}
+	return schema
+}
+
+// queryInt returns the single integer that query selects.
+func queryInt(t *testing.T, db *sql.DB, query string, args ...interface{}) int64 {
+	t.Helper()
+	var n sql.NullInt64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n.Int64
+}
+
+func TestMigrate(t *testing.T) {
+	fresh := openMigrationTestDB(t)
+	if err := migrate(fresh); err != nil {
+		t.Fatalf("migrate empty database: %v", err)
+	}
+	if version := queryInt(t, fresh, "PRAGMA user_version"); version != int64(latestSchemaVersion()) {
+		t.Fatalf("empty database migrated to version %d, want %d", version, latestSchemaVersion())
+	}
+	want := schemaObjects(t, fresh)
+
+	tests := []struct {
+		name string
+		// version is the schema version the database is at before it is migrated.
+		version int
+		// legacy creates the tables of version 1 without recording a version, as before migrations existed.
+		legacy bool
+	}{
+		{name: "legacy tables", legacy: true},
+	}
+	for _, m := range migrations[:len(migrations)-1] {
+		tests = append(tests, struct {
+			name    string
+			version int
+			legacy  bool
+		}{name: fmt.Sprintf("version %d", m.version), version: m.version})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openMigrationTestDB(t)
+			if tt.legacy {
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := migrations[0].up(tx); err != nil {
+					t.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				migrateTo(t, db, 1)
+			}
+			seedMigrationTestDB(t, db)
+			migrateTo(t, db, tt.version)
+
+			if err := migrate(db); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if version := queryInt(t, db, "PRAGMA user_version"); version != int64(latestSchemaVersion()) {
+				t.Errorf("migrated to version %d, want %d", version, latestSchemaVersion())
+			}
+			if got := schemaObjects(t, db); !reflect.DeepEqual(got, want) {
+				for key := range want {
+					if !reflect.DeepEqual(got[key], want[key]) {
+						t.Errorf("%s = %v, want %v as in a new database", key, got[key], want[key])
+					}
+				}
+				for key := range got {
+					if _, ok := want[key]; !ok {
+						t.Errorf("unexpected %s", key)
+					}
+				}
+			}
+			if n := queryInt(t, db, "SELECT COUNT(*) FROM app_events WHERE process_name = 'a.exe'"); n != 1 {
+				t.Errorf("%d app events after migrating, want 1", n)
+			}
+			if n := queryInt(t, db, "SELECT SUM(duration_seconds) FROM daily_app_stats WHERE process_name = 'a.exe'"); n != 60 {
+				t.Errorf("a.exe was used for %d seconds according to the rollups, want 60", n)
+			}
+			if host := queryString(t, db, "SELECT host FROM web_events"); host != "www.example.com" {
+				t.Errorf("host of the web event = %q, want %q", host, "www.example.com")
+			}
+			if n := queryInt(t, db, "SELECT COUNT(*) FROM policy_signatures WHERE generation = 0"); n != 2 {
+				t.Errorf("%d blocklists signed as generation 0, want 2", n)
+			}
+			for _, table := range []blocklistTable{appBlocklistTable, webBlocklistTable} {
+				if _, matches, err := table.checkSignature(db); err != nil || !matches {
+					t.Errorf("%s doesn't verify after migrating: matches %v, %v", table.table, matches, err)
+				}
+			}
+			if result := queryString(t, db, "PRAGMA integrity_check"); result != "ok" {
+				t.Errorf("integrity_check = %q", result)
+			}
+		})
+	}
+}
+
+// queryString returns the single string that query selects.
+func queryString(t *testing.T, db *sql.DB, query string, args ...interface{}) string {
+	t.Helper()
+	var s sql.NullString
+	if err := db.QueryRow(query, args...).Scan(&s); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return s.String
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	first := openMigrationTestDB(t)
+	migrateTo(t, first, 1)
+	seedMigrationTestDB(t, first)
+	second, err := openAndConfigureDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := second.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+
+	// Both the GUI and the native messaging host upgrade the database when they start.
+	errs := make(chan error, 2)
+	for _, db := range []*sql.DB{first, second} {
+		go func() {
+			errs <- migrate(db)
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+	}
+	if version := queryInt(t, first, "PRAGMA user_version"); version != int64(latestSchemaVersion()) {
+		t.Errorf("migrated to version %d, want %d", version, latestSchemaVersion())
+	}
+	if n := queryInt(t, first, "SELECT SUM(duration_seconds) FROM daily_app_stats WHERE process_name = 'a.exe'"); n != 60 {
+		t.Errorf("a.exe was used for %d seconds according to the rollups, want 60", n)
+	}
+
+	// A migration that has already been applied is skipped rather than run again.
+	want := schemaObjects(t, first)
+	for _, m := range migrations {
+		if err := applyMigration(first, m); err != nil {
+			t.Errorf("applying migration %d again: %v", m.version, err)
+		}
+	}
+	if got := schemaObjects(t, first); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema changed by applying the migrations again")
+	}
+	if n := queryInt(t, first, "SELECT SUM(duration_seconds) FROM daily_app_stats WHERE process_name = 'a.exe'"); n != 60 {
+		t.Errorf("a.exe was used for %d seconds after applying the migrations again, want 60", n)
+	}
+}
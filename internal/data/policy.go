@@ -0,0 +1,443 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// policyKeySize is the size in bytes of the key that signs the policy.
+const policyKeySize = 32
+
+// ErrPolicyTampered is returned when a part of the policy doesn't match its signature, which means that
+// it was changed outside of ProcGuard, and there is no verified copy of it left to fall back to.
+var ErrPolicyTampered = errors.New("policy has been tampered with")
+
+var (
+	// policyKeyMu guards policyKeyCache.
+	policyKeyMu sync.Mutex
+	// policyKeyCache holds the policy key once it has been read, so a running instance keeps verifying
+	// with the right key even if the key file is replaced.
+	policyKeyCache []byte
+	// policyInstalled is set once the database shows that the policy has been signed before,
+	// after which a missing policy key means that it was deleted.
+	policyInstalled atomic.Bool
+)
+
+// policyKeyPath returns the path of the file that holds the policy key.
+func policyKeyPath() (string, error) {
+	return DataPath("config", "policy.key")
+}
+
+// hasPolicyKey reports whether the policy key has been created. Policy files written before that are unsigned.
+func hasPolicyKey() bool {
+	policyKeyMu.Lock()
+	cached := policyKeyCache != nil
+	policyKeyMu.Unlock()
+	if cached {
+		return true
+	}
+	path, err := policyKeyPath()
+	return err == nil && fileExists(path)
+}
+
+// policyKeyExpected reports whether the policy has been signed before, so that the policy key must exist.
+// Apart from the key itself, this is told by the signatures in the database and in the settings file
+// or its last good copy, which all have to be removed for ProcGuard to start over without the key.
+func policyKeyExpected() bool {
+	return hasPolicyKey() || policyInstalled.Load() || signedSettingsExist()
+}
+
+// signedSettingsExist reports whether the settings file or its last good copy carries a signature.
+func signedSettingsExist() bool {
+	path, err := GetConfigPath()
+	if err != nil {
+		return false
+	}
+	for _, p := range []string{path, path + lastGoodSuffix} {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var signed struct {
+			Signature string `json:"signature"`
+		}
+		if json.Unmarshal(content, &signed) == nil && signed.Signature != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// policyKey returns the key that the policy is signed with. If there is none yet, it is created when create is set,
+// and an error satisfying os.IsNotExist is returned otherwise. A key is never created once the policy has been
+// signed: a missing key then means that it was deleted, which is reported, and an error wrapping
+// ErrPolicyTampered is returned. The key is stored in protected storage: see protectPolicyKey.
+func policyKey(create bool) ([]byte, error) {
+	policyKeyMu.Lock()
+	defer policyKeyMu.Unlock()
+
+	path, err := policyKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if policyKeyCache != nil {
+		if create && !fileExists(path) {
+			// The key was deleted while ProcGuard was running. It is written back before it signs anything,
+			// so that the policy can still be verified after a restart.
+			raiseTamperAlert(filepath.Base(path), "the policy key was deleted; it was restored")
+			unlock, err := lockFile(path)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+			if err := writePolicyKey(path, policyKeyCache); err != nil {
+				return nil, err
+			}
+		}
+		return policyKeyCache, nil
+	}
+
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	stored, err := readPolicyKey(path)
+	if err == nil {
+		key, err := unprotectPolicyKey(stored)
+		if err != nil {
+			return nil, fmt.Errorf("could not unprotect the policy key: %w", err)
+		}
+		if len(key) != policyKeySize {
+			return nil, fmt.Errorf("policy key has %d bytes instead of %d", len(key), policyKeySize)
+		}
+		policyKeyCache = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if policyInstalled.Load() || signedSettingsExist() {
+		raiseTamperAlert(filepath.Base(path), "the policy key is missing although the policy has been signed with it")
+		return nil, fmt.Errorf("%w: the policy key is missing", ErrPolicyTampered)
+	}
+	if !create {
+		return nil, err
+	}
+
+	key := make([]byte, policyKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := writePolicyKey(path, key); err != nil {
+		return nil, err
+	}
+	policyKeyCache = key
+	return key, nil
+}
+
+// readPolicyKey reads the key file at path once checkPolicyKeyFile has accepted who can read and replace it.
+func readPolicyKey(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// This can run before the logger is set up.
+		if err := f.Close(); err != nil {
+			log.Printf("[ERROR] Failed to close %s: %v", path, err)
+		}
+	}()
+	// The file that was opened is checked, so it can't be swapped for another one after the check.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPolicyKeyFile(path, info); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// writePolicyKey protects key and writes it to the key file at path.
+func writePolicyKey(path string, key []byte) error {
+	stored, err := protectPolicyKey(key)
+	if err != nil {
+		return fmt.Errorf("could not protect the policy key: %w", err)
+	}
+	return writeFile(path, stored, 0600)
+}
+
+// policyMAC computes the HMAC-SHA256 of content under key. name is included, so that the signature
+// of one part of the policy can't be passed off as the signature of another.
+func policyMAC(key []byte, name string, content []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(content)
+	return mac.Sum(nil)
+}
+
+// signPolicy returns the base64 signature of the part of the policy called name, creating the policy key if needed.
+func signPolicy(name string, content []byte) (string, error) {
+	key, err := policyKey(true)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(policyMAC(key, name, content)), nil
+}
+
+// checkPolicySignature checks the signature made by signPolicy. It returns an error wrapping ErrPolicyTampered
+// if the signature doesn't match, or if there is no policy key to check it with.
+func checkPolicySignature(name string, content []byte, signature string) error {
+	key, err := policyKey(false)
+	if err != nil {
+		return fmt.Errorf("%w: the policy key is unavailable: %v", ErrPolicyTampered, err)
+	}
+	want, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(want, policyMAC(key, name, content)) {
+		return fmt.Errorf("%w: %s doesn't match its signature", ErrPolicyTampered, name)
+	}
+	return nil
+}
+
+// generationPayload returns what is signed for content at the given generation. Generation 0 is the content alone,
+// as it was signed before generations were counted, so those signatures stay valid.
+func generationPayload(generation uint64, content []byte) []byte {
+	if generation == 0 {
+		return content
+	}
+	return append([]byte(strconv.FormatUint(generation, 10)+"\n"), content...)
+}
+
+var (
+	// policyGenerationsMu guards policyGenerations and policyGenerationsReady.
+	policyGenerationsMu sync.Mutex
+	// policyGenerations maps each part of the policy to the highest generation of it that has been seen.
+	// Every signed change raises the generation, so a signed copy older than that is an old version put back
+	// to undo a change, and is refused.
+	policyGenerations = map[string]uint64{}
+	// policyGenerationsReady is set once the database writer has been started, after which the generations
+	// are also recorded in the policy_generations table.
+	policyGenerationsReady bool
+)
+
+// policyGeneration returns the highest generation of the part of the policy called name that has been seen.
+func policyGeneration(name string) uint64 {
+	policyGenerationsMu.Lock()
+	defer policyGenerationsMu.Unlock()
+	return policyGenerations[name]
+}
+
+// notePolicyGeneration records that generation of the part of the policy called name has been verified or signed.
+func notePolicyGeneration(name string, generation uint64) {
+	policyGenerationsMu.Lock()
+	defer policyGenerationsMu.Unlock()
+	if generation <= policyGenerations[name] {
+		return
+	}
+	policyGenerations[name] = generation
+	if policyGenerationsReady {
+		recordPolicyGeneration(name, generation)
+	}
+}
+
+// recordPolicyGeneration queues raising the generation of name in the policy_generations table.
+func recordPolicyGeneration(name string, generation uint64) {
+	EnqueueWrite(`INSERT INTO policy_generations (name, generation) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET generation = MAX(generation, excluded.generation)`, name, int64(generation))
+}
+
+// loadPolicyState reads what the database knows about the policy before anything is verified or signed:
+// whether it has been signed, and the highest generation of each part of it.
+// Tables that older schemas don't have yet are skipped.
+func loadPolicyState(db *sql.DB) error {
+	var signed int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'policy_signatures'").Scan(&signed)
+	if err != nil {
+		return err
+	}
+	if signed == 0 {
+		return nil
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM policy_signatures").Scan(&signed); err != nil {
+		return err
+	}
+	if signed > 0 {
+		policyInstalled.Store(true)
+	}
+
+	var tables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'policy_generations'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return err
+	}
+	rows, err := db.Query("SELECT name, generation FROM policy_generations")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("[ERROR] Failed to close rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var name string
+		var generation int64
+		if err := rows.Scan(&name, &generation); err != nil {
+			return err
+		}
+		notePolicyGeneration(name, uint64(generation))
+	}
+	return rows.Err()
+}
+
+// recordPendingPolicyGenerations records the generations seen before the database writer was started,
+// and lets the following ones be recorded right away.
+func recordPendingPolicyGenerations() {
+	policyGenerationsMu.Lock()
+	defer policyGenerationsMu.Unlock()
+	policyGenerationsReady = true
+	for name, generation := range policyGenerations {
+		recordPolicyGeneration(name, generation)
+	}
+}
+
+// TamperEvent records that a part of the policy was changed outside of ProcGuard.
+type TamperEvent struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Target is the part of the policy that was changed, such as "settings.json" or "app_blocklist".
+	Target string `json:"target"`
+	Detail string `json:"detail"`
+}
+
+// TamperEventPage is a page of tamper events, newest first.
+type TamperEventPage struct {
+	Events []TamperEvent `json:"events"`
+	// NextCursor continues with the following page. It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+var (
+	// tamperAlerts maps the targets that have been reported and not verified since to the detail they were
+	// reported with, so that a lasting mismatch is only reported once and a different one is reported again.
+	tamperAlerts sync.Map
+	// pendingTamperEvents holds the events raised before the database writer was started, until InitDB records them.
+	pendingTamperEvents   []TamperEvent
+	pendingTamperEventsMu sync.Mutex
+	// tamperEventsReady is set once the database writer has been started. It is guarded by pendingTamperEventsMu.
+	tamperEventsReady bool
+)
+
+// raiseTamperAlert reports that target was changed outside of ProcGuard: it is logged as an error and recorded
+// in the tamper_events table. The alert is raised once for each detail until target verifies again.
+func raiseTamperAlert(target, detail string) {
+	if reported, ok := tamperAlerts.Swap(target, detail); ok && reported == detail {
+		return
+	}
+	// This can run before the logger is set up.
+	if logger := GetLogger(); logger != nil {
+		logger.Error("Policy tampering detected", "target", target, "detail", detail)
+	} else {
+		log.Printf("[ERROR] Policy tampering detected in %s: %s", target, detail)
+	}
+
+	event := TamperEvent{Timestamp: time.Now(), Target: target, Detail: detail}
+	pendingTamperEventsMu.Lock()
+	defer pendingTamperEventsMu.Unlock()
+	if !tamperEventsReady {
+		pendingTamperEvents = append(pendingTamperEvents, event)
+		return
+	}
+	recordTamperEvent(event)
+}
+
+// clearTamperAlert is called when target verifies, so that it is reported again if it is tampered with later.
+func clearTamperAlert(target string) {
+	tamperAlerts.Delete(target)
+}
+
+// recordPendingTamperEvents records the tamper events raised before the database writer was started,
+// and lets the following ones be recorded right away.
+func recordPendingTamperEvents() {
+	pendingTamperEventsMu.Lock()
+	defer pendingTamperEventsMu.Unlock()
+	tamperEventsReady = true
+	for _, event := range pendingTamperEvents {
+		recordTamperEvent(event)
+	}
+	pendingTamperEvents = nil
+}
+
+// recordTamperEvent queues the insertion of event into the tamper_events table.
+func recordTamperEvent(event TamperEvent) {
+	EnqueueWrite("INSERT INTO tamper_events (timestamp, target, detail) VALUES (?, ?, ?)",
+		event.Timestamp.Unix(), event.Target, event.Detail)
+}
+
+// QueryTamperEvents returns a page of the recorded tamper events, newest first.
+func QueryTamperEvents(db *sql.DB, page PageRequest) (TamperEventPage, error) {
+	cursor, err := decodeCursor(page.Cursor, false)
+	if err != nil {
+		return TamperEventPage{}, err
+	}
+	limit := page.pageLimit(false)
+
+	q := "SELECT id, timestamp, target, detail FROM tamper_events"
+	args := make([]interface{}, 0)
+	if cursor != nil {
+		q += " WHERE (timestamp < ? OR (timestamp = ? AND id < ?))"
+		args = append(args, cursor.Time, cursor.Time, cursor.ID)
+	}
+	q += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, queryLimit(limit))
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return TamperEventPage{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	result := TamperEventPage{Events: []TamperEvent{}}
+	for rows.Next() {
+		var event TamperEvent
+		var timestamp int64
+		var detail sql.NullString
+		if err := rows.Scan(&event.ID, &timestamp, &event.Target, &detail); err != nil {
+			return TamperEventPage{}, err
+		}
+		event.Timestamp = localTime(timestamp)
+		event.Detail = detail.String
+		result.Events = append(result.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return TamperEventPage{}, err
+	}
+
+	if len(result.Events) > limit {
+		result.Events = result.Events[:limit]
+		last := result.Events[limit-1]
+		result.NextCursor = pageCursor{Time: last.Timestamp.Unix(), ID: last.ID}.encode()
+	}
+	return result, nil
+}
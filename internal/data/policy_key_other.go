@@ -0,0 +1,53 @@
+//go:build !windows
+
+package data
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// unprivilegedKeyWarning makes sure that running without root is warned about only once.
+var unprivilegedKeyWarning sync.Once
+
+// protectPolicyKey returns the policy key as it is stored. There is no protected storage for it on this platform,
+// so it is kept in a file that only root can read: see checkPolicyKeyFile.
+func protectPolicyKey(key []byte) ([]byte, error) {
+	return key, nil
+}
+
+// unprotectPolicyKey returns the policy key stored by protectPolicyKey.
+func unprotectPolicyKey(stored []byte) ([]byte, error) {
+	return stored, nil
+}
+
+// checkPolicyKeyFile checks that the key file described by info is out of reach of other accounts before it is trusted.
+//
+// Running as root, the key is written with mode 0600 and is then owned by root, so a key file owned by another
+// account was put there by that account, which knows the key and could have signed anything with it; and one that
+// others can read may have been copied. Both are reported, and an error wrapping ErrPolicyTampered is returned.
+//
+// Without root, the key belongs to the account that runs ProcGuard, which can read it and forge signatures.
+// There is no way around that, so it is only warned about.
+func checkPolicyKeyFile(path string, info os.FileInfo) error {
+	if os.Geteuid() != 0 {
+		unprivilegedKeyWarning.Do(func() {
+			// This can run before the logger is set up.
+			log.Printf("[WARN] ProcGuard is not running as root, so the account that runs it can read %s and forge the policy signatures", path)
+		})
+		return nil
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+		raiseTamperAlert(filepath.Base(path), fmt.Sprintf("the policy key is owned by uid %d instead of root", stat.Uid))
+		return fmt.Errorf("%w: the policy key is owned by uid %d instead of root", ErrPolicyTampered, stat.Uid)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		raiseTamperAlert(filepath.Base(path), fmt.Sprintf("the policy key has mode %#o, so others can read it", perm))
+		return fmt.Errorf("%w: the policy key has mode %#o", ErrPolicyTampered, perm)
+	}
+	return nil
+}
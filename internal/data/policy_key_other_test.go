@@ -0,0 +1,60 @@
+//go:build !windows
+
+package data
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPolicyKeyFileOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the key file is only checked when running as root")
+	}
+	tests := []struct {
+		name string
+		// change alters the key file after it has been written.
+		change       func(path string) error
+		wantTampered bool
+	}{
+		{
+			name:   "as written",
+			change: func(path string) error { return nil },
+		},
+		{
+			name:         "owned by another account",
+			change:       func(path string) error { return os.Chown(path, 1000, 1000) },
+			wantTampered: true,
+		},
+		{
+			name:         "readable by others",
+			change:       func(path string) error { return os.Chmod(path, 0644) },
+			wantTampered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicyDir(t)
+			if _, err := policyKey(true); err != nil {
+				t.Fatalf("policyKey(true): %v", err)
+			}
+			path, err := policyKeyPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := UnlockPolicyFiles(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.change(path); err != nil {
+				t.Fatal(err)
+			}
+			resetPolicyState()
+
+			_, err = policyKey(false)
+			if tampered := errors.Is(err, ErrPolicyTampered); tampered != tt.wantTampered || (!tampered && err != nil) {
+				t.Errorf("policyKey(false) = %v, want tampered: %v", err, tt.wantTampered)
+			}
+		})
+	}
+}
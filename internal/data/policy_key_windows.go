@@ -0,0 +1,51 @@
+//go:build windows
+
+package data
+
+import (
+	"errors"
+	"log"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// protectPolicyKey encrypts the policy key with DPAPI (CryptProtectData), which ties it to the Windows account
+// that ProcGuard runs as. Copying the key file to another account or machine doesn't reveal the key.
+func protectPolicyKey(key []byte) ([]byte, error) {
+	return dpapi(key, func(in, out *windows.DataBlob) error {
+		return windows.CryptProtectData(in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, out)
+	})
+}
+
+// unprotectPolicyKey decrypts a policy key encrypted by protectPolicyKey.
+func unprotectPolicyKey(stored []byte) ([]byte, error) {
+	return dpapi(stored, func(in, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, out)
+	})
+}
+
+// checkPolicyKeyFile does nothing on Windows, where the key can only be decrypted by the account that encrypted it.
+func checkPolicyKeyFile(path string, info os.FileInfo) error {
+	return nil
+}
+
+// dpapi passes data to a DPAPI function and returns a copy of its output, freeing the buffer allocated by Windows.
+func dpapi(data []byte, call func(in, out *windows.DataBlob) error) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data")
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := call(&in, &out); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// This can run before the logger is set up.
+		if _, err := windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data))); err != nil {
+			log.Printf("[ERROR] Failed to free DPAPI buffer: %v", err)
+		}
+	}()
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,254 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// usePolicyDir points the data directory at a new temporary directory and forgets everything this process
+// knew about the policy of the previous one.
+func usePolicyDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	SetRuntimeConfig(RuntimeConfig{DataDir: dir})
+	resetPolicyState()
+	// The policy files are immutable when the tests run as root, which would keep TempDir from removing them.
+	t.Cleanup(func() {
+		SetRuntimeConfig(RuntimeConfig{DataDir: dir})
+		if err := UnlockPolicyFiles(); err != nil {
+			t.Errorf("UnlockPolicyFiles: %v", err)
+		}
+		resetPolicyState()
+	})
+	return dir
+}
+
+// resetPolicyState forgets the policy key, the generations and the alerts of the current process, as a restart does.
+func resetPolicyState() {
+	policyKeyMu.Lock()
+	policyKeyCache = nil
+	policyKeyMu.Unlock()
+	policyInstalled.Store(false)
+	policyGenerationsMu.Lock()
+	policyGenerations = map[string]uint64{}
+	policyGenerationsMu.Unlock()
+	rememberVerifiedConfig(nil)
+	verifiedSignatures.Clear()
+	verifiedNames.Clear()
+	tamperAlerts.Clear()
+}
+
+// saveTestConfig saves cfg as the configuration file and returns what was written.
+func saveTestConfig(t *testing.T, cfg *Config) []byte {
+	t.Helper()
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	path, err := GetConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// unsignedConfig returns the content of a configuration file as versions before the policy signatures wrote it.
+func unsignedConfig(t *testing.T) []byte {
+	t.Helper()
+	content, err := json.MarshalIndent(NewConfig(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// forgetPolicyKey deletes the policy key from disk and from memory.
+func forgetPolicyKey(t *testing.T) {
+	t.Helper()
+	path, err := policyKeyPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UnlockPolicyFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	policyKeyMu.Lock()
+	policyKeyCache = nil
+	policyKeyMu.Unlock()
+}
+
+func TestVerifyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the data directory and returns the content to verify.
+		setup        func(t *testing.T) []byte
+		wantTampered bool
+	}{
+		{
+			name:  "unsigned before the policy was signed",
+			setup: unsignedConfig,
+		},
+		{
+			name: "unsigned once the key exists",
+			setup: func(t *testing.T) []byte {
+				if _, err := policyKey(true); err != nil {
+					t.Fatal(err)
+				}
+				return unsignedConfig(t)
+			},
+			wantTampered: true,
+		},
+		{
+			name: "unsigned with the key deleted after the database was signed",
+			setup: func(t *testing.T) []byte {
+				policyInstalled.Store(true)
+				return unsignedConfig(t)
+			},
+			wantTampered: true,
+		},
+		{
+			name: "unsigned with the key deleted while the last good copy is signed",
+			setup: func(t *testing.T) []byte {
+				saveTestConfig(t, NewConfig())
+				forgetPolicyKey(t)
+				return unsignedConfig(t)
+			},
+			wantTampered: true,
+		},
+		{
+			name: "signed",
+			setup: func(t *testing.T) []byte {
+				return saveTestConfig(t, NewConfig())
+			},
+		},
+		{
+			name: "signed and edited",
+			setup: func(t *testing.T) []byte {
+				content := saveTestConfig(t, &Config{PasswordHash: "hash"})
+				var edited map[string]interface{}
+				if err := json.Unmarshal(content, &edited); err != nil {
+					t.Fatal(err)
+				}
+				delete(edited, "password_hash")
+				content, err := json.MarshalIndent(edited, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return content
+			},
+			wantTampered: true,
+		},
+		{
+			name: "signed with the key deleted",
+			setup: func(t *testing.T) []byte {
+				content := saveTestConfig(t, NewConfig())
+				forgetPolicyKey(t)
+				return content
+			},
+			wantTampered: true,
+		},
+		{
+			name: "signed with another key",
+			setup: func(t *testing.T) []byte {
+				content := saveTestConfig(t, NewConfig())
+				forgetPolicyKey(t)
+				usePolicyDir(t)
+				if _, err := policyKey(true); err != nil {
+					t.Fatal(err)
+				}
+				return content
+			},
+			wantTampered: true,
+		},
+		{
+			name: "signed as an older generation",
+			setup: func(t *testing.T) []byte {
+				cfg := NewConfig()
+				old := saveTestConfig(t, cfg)
+				saveTestConfig(t, cfg)
+				return old
+			},
+			wantTampered: true,
+		},
+		{
+			name: "signed as an older generation of the database",
+			setup: func(t *testing.T) []byte {
+				content := saveTestConfig(t, NewConfig())
+				notePolicyGeneration(configPolicyName, policyGeneration(configPolicyName)+1)
+				return content
+			},
+			wantTampered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicyDir(t)
+			err := verifyConfig(tt.setup(t))
+			if got := errors.Is(err, ErrPolicyTampered); got != tt.wantTampered {
+				t.Errorf("verifyConfig() = %v, want tampered %v", err, tt.wantTampered)
+			}
+			if !tt.wantTampered && err != nil {
+				t.Errorf("verifyConfig() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestPolicyKeyIsNotRecreated(t *testing.T) {
+	usePolicyDir(t)
+	saveTestConfig(t, NewConfig())
+	forgetPolicyKey(t)
+
+	if _, err := policyKey(true); !errors.Is(err, ErrPolicyTampered) {
+		t.Fatalf("policyKey(true) = %v, want ErrPolicyTampered", err)
+	}
+	if hasPolicyKey() {
+		t.Error("a new policy key was created")
+	}
+	if err := signUnsignedConfig(); err != nil {
+		t.Fatalf("signUnsignedConfig: %v", err)
+	}
+	if hasPolicyKey() {
+		t.Error("signUnsignedConfig created a new policy key")
+	}
+}
+
+func TestLoadConfigKeepsLastVerified(t *testing.T) {
+	usePolicyDir(t)
+	saveTestConfig(t, &Config{PasswordHash: "hash"})
+	path, err := GetConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UnlockPolicyFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + lastGoodSuffix} {
+		if err := os.WriteFile(p, unsignedConfig(t), configFileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.PasswordHash != "hash" {
+		t.Errorf("PasswordHash = %q, want the last verified %q", cfg.PasswordHash, "hash")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyConfig(content); err != nil {
+		t.Errorf("the last verified configuration was not written back: %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EventQuery is a search over app or web events, compiled into parameterized SQL.
+// It is built by ParseAppQuery, ParseWebQuery or TextQuery and consumed by SearchAppEvents and GetWebLogs,
+// whose queries refer to the searched table as "e".
+type EventQuery struct {
+	// conditions are SQL expressions that must all be true for an event to match.
+	conditions []string
+	args       []interface{}
+	// match is the FTS5 expression built from the free-text terms, empty if there are none.
+	match string
+}
+
+// QueryError is a syntax error in a search query.
+type QueryError struct {
+	// Pos is the 1-based position, in characters, of the offending part of the query.
+	Pos int
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+// TextQuery returns a query that matches text against the full-text index only, without any field syntax.
+func TextQuery(text string) *EventQuery {
+	return &EventQuery{match: ftsMatchQuery(text)}
+}
+
+// where returns the conditions of q to append to a WHERE clause, each preceded by AND.
+func (q *EventQuery) where() string {
+	if q == nil || len(q.conditions) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(q.conditions, " AND ")
+}
+
+// queryArgs returns the arguments of the conditions returned by where.
+func (q *EventQuery) queryArgs() []interface{} {
+	if q == nil {
+		return nil
+	}
+	return q.args
+}
+
+// ftsMatch returns the FTS5 expression of the free-text terms of q.
+func (q *EventQuery) ftsMatch() string {
+	if q == nil {
+		return ""
+	}
+	return q.match
+}
+
+// Comparison operators that can follow a field name.
+const (
+	opHas = ":"
+	opEq  = "="
+	opLt  = "<"
+	opLe  = "<="
+	opGt  = ">"
+	opGe  = ">="
+)
+
+// queryField compiles a field:value term into a condition.
+type queryField struct {
+	// ops lists the operators the field accepts.
+	ops []string
+	// compile returns the condition for op and value. Errors are reported at the position of the value.
+	compile func(op, value string, now time.Time) (string, []interface{}, error)
+}
+
+// appQueryFields are the fields that can be used when searching app events.
+var appQueryFields = map[string]queryField{
+	"name":     textField("e.process_name"),
+	"parent":   textField("e.parent_process_name"),
+	"exe":      pathField(sealedExePath),
+	"cmdline":  sealedTextField(sealedCmdline),
+	"pid":      intField("e.pid"),
+	"after":    timeField("e.start_time", opGe),
+	"before":   timeField("e.start_time", opLe),
+	"duration": durationField("COALESCE(e.end_time, ?) - e.start_time"),
+}
+
+// webQueryFields are the fields that can be used when searching web events.
+var webQueryFields = map[string]queryField{
+	"url":    sealedTextField(sealedURL),
+	"title":  sealedTextField(sealedTitle),
+	"domain": domainField(),
+	"host":   hostField(),
+	"scheme": exactField("e.scheme"),
+	"after":  timeField("e.timestamp", opGe),
+	"before": timeField("e.timestamp", opLe),
+}
+
+// ParseAppQuery compiles a search query over app events, such as
+//
+//	name:chrome parent:explorer exe:~/Downloads/* after:yesterday before:"2026-10-01 12:00" duration>10m
+//
+// Terms are separated by spaces and must all match. A term is either field:value or, for numeric fields,
+// field followed by =, <, <=, > or >= and a value; anything else is searched for in the full-text index.
+// Values containing spaces can be quoted, * and ? are wildcards, and a leading - negates a term.
+// The fields are name, parent, exe, cmdline, pid, after, before and duration.
+// Syntax errors are returned as a *QueryError.
+func ParseAppQuery(input string) (*EventQuery, error) {
+	return parseEventQuery(input, appQueryFields, "app_events_fts")
+}
+
+// ParseWebQuery compiles a search query over web events with the same syntax as ParseAppQuery.
+// The fields are url, title, domain (the site including its subdomains), host, scheme, after and before.
+func ParseWebQuery(input string) (*EventQuery, error) {
+	return parseEventQuery(input, webQueryFields, "web_events_fts")
+}
+
+// queryToken is a term of a search query.
+type queryToken struct {
+	// pos is the byte offset of the token in the query, and valuePos the one of its value.
+	pos, valuePos int
+	negated       bool
+	field, op     string
+	value         string
+	quoted        bool
+}
+
+// parseEventQuery compiles input using fields. ftsTable is the full-text index that free text is matched against.
+func parseEventQuery(input string, fields map[string]queryField, ftsTable string) (*EventQuery, error) {
+	tokens, err := tokenizeQuery(input, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	q := &EventQuery{}
+	var text []searchTerm
+	for _, tok := range tokens {
+		posErr := func(offset int, format string, args ...interface{}) error {
+			return &QueryError{Pos: utf8.RuneCountInString(input[:offset]) + 1, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		if tok.field == "" {
+			if !tok.negated {
+				text = append(text, searchTerm{text: tok.value, quoted: tok.quoted})
+				continue
+			}
+			match := ftsMatchTerms([]searchTerm{{text: tok.value, quoted: tok.quoted}})
+			if match == "" {
+				return nil, posErr(tok.pos, "nothing to search for in %q", tok.value)
+			}
+			q.conditions = append(q.conditions, "e.id NOT IN (SELECT rowid FROM "+ftsTable+" WHERE "+ftsTable+" MATCH ?)")
+			q.args = append(q.args, match)
+			continue
+		}
+
+		field := fields[tok.field]
+		if !containsString(field.ops, tok.op) {
+			return nil, posErr(tok.pos, "field %q does not support %q", tok.field, tok.op)
+		}
+		if tok.value == "" {
+			return nil, posErr(tok.valuePos, "missing value for %q", tok.field)
+		}
+		cond, args, err := field.compile(tok.op, tok.value, now)
+		if err != nil {
+			return nil, posErr(tok.valuePos, "%v", err)
+		}
+		if tok.negated {
+			// A field that is NULL can't equal the value, so it matches the negation.
+			cond = "NOT COALESCE(" + cond + ", 0)"
+		}
+		q.conditions = append(q.conditions, cond)
+		q.args = append(q.args, args...)
+	}
+
+	q.match = ftsMatchTerms(text)
+	return q, nil
+}
+
+// tokenizeQuery splits input into terms. A word is a field term if it starts with the name of one of fields
+// followed by an operator. Words that look like a field term but name an unknown field are rejected,
+// except for drive letters and URL schemes such as C:\ and https://, which are searched as free text.
+func tokenizeQuery(input string, fields map[string]queryField) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for {
+		for i < len(input) && isSpaceByte(input[i]) {
+			i++
+		}
+		if i >= len(input) {
+			return tokens, nil
+		}
+
+		tok := queryToken{pos: i}
+		if input[i] == '-' && i+1 < len(input) && !isSpaceByte(input[i+1]) {
+			tok.negated = true
+			i++
+		}
+
+		// Look for a field name followed by an operator.
+		j := i
+		for j < len(input) && isFieldByte(input[j]) {
+			j++
+		}
+		if j > i && j < len(input) {
+			if op := queryOperator(input[j:]); op != "" {
+				name := strings.ToLower(input[i:j])
+				rest := input[j+len(op):]
+				if _, ok := fields[name]; ok {
+					tok.field, tok.op = name, op
+					i = j + len(op)
+				} else if op != opHas || !(strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, `\`)) {
+					return nil, &QueryError{
+						Pos: utf8.RuneCountInString(input[:tok.pos]) + 1,
+						Msg: fmt.Sprintf("unknown field %q (known fields: %s)", name, strings.Join(fieldNames(fields), ", ")),
+					}
+				}
+			}
+		}
+
+		tok.valuePos = i
+		if i < len(input) && input[i] == '"' {
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, &QueryError{Pos: utf8.RuneCountInString(input[:i]) + 1, Msg: "unterminated quote"}
+			}
+			tok.value, tok.quoted = input[i+1:i+1+end], true
+			i += end + 2
+		} else {
+			start := i
+			for i < len(input) && !isSpaceByte(input[i]) {
+				i++
+			}
+			tok.value = input[start:i]
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// queryOperator returns the operator at the start of s, or an empty string if there is none.
+func queryOperator(s string) string {
+	for _, op := range []string{opLe, opGe, opHas, opEq, opLt, opGt} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// isSpaceByte reports whether b separates the terms of a query.
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// isFieldByte reports whether b can be part of a field name.
+func isFieldByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// fieldNames returns the names of fields in alphabetical order.
+func fieldNames(fields map[string]queryField) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// textField matches column against a value. Without wildcards the value may appear anywhere in the column;
+// with them, it must match the whole column. Matching is case-insensitive.
+func textField(column string) queryField {
+	return queryField{
+		ops: []string{opHas, opEq},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			return column + ` LIKE ? ESCAPE '\'`, []interface{}{likePattern(value)}, nil
+		},
+	}
+}
+
+// sealedTextField matches a sealed column like textField. See sealedCondition for encrypted values.
+func sealedTextField(col sealedColumn) queryField {
+	field := textField("e." + col.column)
+	return queryField{
+		ops: field.ops,
+		compile: func(op, value string, now time.Time) (string, []interface{}, error) {
+			cond, args, err := field.compile(op, value, now)
+			if err != nil {
+				return "", nil, err
+			}
+			cond, args = sealedCondition(col, cond, args, value)
+			return cond, args, nil
+		},
+	}
+}
+
+// pathField matches a sealed column against a file path like textField. Forward and back slashes are equivalent,
+// and a leading ~ stands for the home directory of the current user. See sealedCondition for encrypted values.
+func pathField(col sealedColumn) queryField {
+	return queryField{
+		ops: []string{opHas, opEq},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			value = strings.ReplaceAll(value, `\`, "/")
+			if value == "~" || strings.HasPrefix(value, "~/") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return "", nil, fmt.Errorf("could not expand ~: %w", err)
+				}
+				value = strings.ReplaceAll(home, `\`, "/") + value[1:]
+			}
+			cond, args := sealedCondition(col, `REPLACE(e.`+col.column+`, '\', '/') LIKE ? ESCAPE '\'`, []interface{}{likePattern(value)}, value)
+			return cond, args, nil
+		},
+	}
+}
+
+// sealedCondition restricts cond, a condition on the sealed column col, to the values stored in the clear,
+// since encrypted values can't be matched by pattern. Instead, while the data is unlocked, an encrypted value
+// matches a value without wildcards that equals it exactly, which is looked up by its keyed hash.
+func sealedCondition(col sealedColumn, cond string, args []interface{}, value string) (string, []interface{}) {
+	if !encryptionConfigured() {
+		return cond, args
+	}
+	cond = "(NOT " + fmt.Sprintf(isEncrypted, "e."+col.column) + " AND " + cond + ")"
+	if strings.ContainsAny(value, "*?") {
+		return cond, args
+	}
+	if hash, ok := lookupHash(col, value); ok {
+		cond = "(" + cond + " OR e." + col.hash + " = ?)"
+		args = append(args, hash)
+	}
+	return cond, args
+}
+
+// likePattern converts a search value into a LIKE pattern. * and ? become % and _, and the pattern is anchored
+// only if it contains a wildcard; otherwise it matches anywhere. Other LIKE special characters are escaped with \.
+func likePattern(value string) string {
+	var b strings.Builder
+	wildcard := strings.ContainsAny(value, "*?")
+	if !wildcard {
+		b.WriteByte('%')
+	}
+	for _, r := range value {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if !wildcard {
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+// domainField matches web events on a site, including its subdomains. Registrable domains such as
+// example.co.uk are looked up in the domain column, others in the host column. Wildcards match the host.
+func domainField() queryField {
+	return queryField{
+		ops: []string{opHas, opEq},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			domain := strings.ToLower(strings.Trim(value, "."))
+			switch {
+			case strings.ContainsAny(domain, "*?"):
+				return `e.host LIKE ? ESCAPE '\'`, []interface{}{likePattern(domain)}, nil
+			case RegistrableDomain(domain) == domain:
+				return "e.domain = ?", []interface{}{domain}, nil
+			}
+			return `(e.host = ? OR e.host LIKE ? ESCAPE '\')`, []interface{}{domain, likePattern("*." + domain)}, nil
+		},
+	}
+}
+
+// hostField matches web events on an exact host name, or on a pattern if the value contains wildcards.
+func hostField() queryField {
+	return queryField{
+		ops: []string{opHas, opEq},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			host := strings.ToLower(strings.Trim(value, "."))
+			if strings.ContainsAny(host, "*?") {
+				return `e.host LIKE ? ESCAPE '\'`, []interface{}{likePattern(host)}, nil
+			}
+			return "e.host = ?", []interface{}{host}, nil
+		},
+	}
+}
+
+// exactField matches column against a value, ignoring case.
+func exactField(column string) queryField {
+	return queryField{
+		ops: []string{opHas, opEq},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			return column + " = ?", []interface{}{strings.ToLower(value)}, nil
+		},
+	}
+}
+
+// intField compares an integer column with a value.
+func intField(column string) queryField {
+	return queryField{
+		ops: []string{opHas, opEq, opLt, opLe, opGt, opGe},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("%q is not a number", value)
+			}
+			return column + " " + sqlOperator(op) + " ?", []interface{}{n}, nil
+		},
+	}
+}
+
+// timeField compares a Unix timestamp column with a time parsed by ParseTime, using cmp.
+func timeField(column, cmp string) queryField {
+	return queryField{
+		ops: []string{opHas},
+		compile: func(op, value string, _ time.Time) (string, []interface{}, error) {
+			t, err := ParseTime(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid time %q", value)
+			}
+			return column + " " + cmp + " ?", []interface{}{t.Unix()}, nil
+		},
+	}
+}
+
+// durationField compares a duration in seconds with a value such as 10m, 1h30m or 2d.
+// expr takes the current time as its first parameter, so that events that are still running count until now.
+func durationField(expr string) queryField {
+	return queryField{
+		ops: []string{opEq, opLt, opLe, opGt, opGe},
+		compile: func(op, value string, now time.Time) (string, []interface{}, error) {
+			d, err := parseQueryDuration(value)
+			if err != nil {
+				return "", nil, err
+			}
+			return "(" + expr + ") " + sqlOperator(op) + " ?", []interface{}{now.Unix(), int64(d / time.Second)}, nil
+		},
+	}
+}
+
+// parseQueryDuration parses a duration such as 90s, 10m or 1h30m, with d as an additional unit for days.
+func parseQueryDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use units such as s, m, h or d)", value)
+	}
+	return d, nil
+}
+
+// sqlOperator returns the SQL comparison operator for a query operator.
+func sqlOperator(op string) string {
+	if op == opHas {
+		return "="
+	}
+	return op
+}
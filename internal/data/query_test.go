@@ -0,0 +1,214 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// forgetEncryption makes the encryption state be read again from the configuration, as it is at startup.
+func forgetEncryption() {
+	encryption.mu.Lock()
+	encryption.loaded, encryption.enabled, encryption.public, encryption.keys = false, false, nil, nil
+	encryption.mu.Unlock()
+}
+
+// useDisplayTimezone makes DisplayLocation return the time zone named name until the test ends.
+func useDisplayTimezone(t *testing.T, name string) {
+	t.Helper()
+	previous := displayLocation.Load()
+	if err := SetDisplayTimezone(name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		displayLocation.Store(previous)
+	})
+}
+
+// queryNow stands for the current time in the expected arguments of a query.
+type queryNow struct{}
+
+// sameQueryArgs reports whether got equals want, where a queryNow in want matches a Unix time close to now.
+func sameQueryArgs(got, want []interface{}) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if _, ok := want[i].(queryNow); ok {
+			unix, ok := got[i].(int64)
+			if !ok || time.Since(time.Unix(unix, 0)).Abs() > time.Minute {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type queryTest struct {
+	input          string
+	wantConditions []string
+	wantArgs       []interface{}
+	wantMatch      string
+	// wantPos is the position of the expected syntax error, 0 if the query is valid.
+	wantPos int
+}
+
+func runQueryTests(t *testing.T, parse func(string) (*EventQuery, error), tests []queryTest) {
+	t.Helper()
+	usePolicyDir(t)
+	forgetEncryption()
+	t.Cleanup(forgetEncryption)
+	useDisplayTimezone(t, "UTC")
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := parse(tt.input)
+			if tt.wantPos != 0 {
+				var qerr *QueryError
+				if !errors.As(err, &qerr) {
+					t.Fatalf("parse(%q) = %v, want a QueryError", tt.input, err)
+				}
+				if qerr.Pos != tt.wantPos {
+					t.Errorf("parse(%q) error at position %d (%v), want %d", tt.input, qerr.Pos, qerr, tt.wantPos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse(%q): %v", tt.input, err)
+			}
+			if len(q.conditions) != 0 || len(tt.wantConditions) != 0 {
+				if !reflect.DeepEqual(q.conditions, tt.wantConditions) {
+					t.Errorf("conditions = %q, want %q", q.conditions, tt.wantConditions)
+				}
+			}
+			if !sameQueryArgs(q.args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", q.args, tt.wantArgs)
+			}
+			if q.match != tt.wantMatch {
+				t.Errorf("match = %q, want %q", q.match, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestParseAppQuery(t *testing.T) {
+	runQueryTests(t, ParseAppQuery, []queryTest{
+		{input: ""},
+		{input: "chrome", wantMatch: `"chrome"*`},
+		{input: `"exact phrase" word`, wantMatch: `"exact phrase" "word"*`},
+		{input: "a OR b", wantMatch: `"a"* OR "b"*`},
+		{input: `C:\Windows`, wantMatch: `"C:\Windows"*`},
+		{input: "https://example.com", wantMatch: `"https://example.com"*`},
+		{
+			input:          "name:chrome",
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"%chrome%"},
+		},
+		{
+			input:          "NAME:Chrome",
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"%Chrome%"},
+		},
+		{
+			input:          "name=chr*me",
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"chr%me"},
+		},
+		{
+			input:          `name:"google chrome"`,
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"%google chrome%"},
+		},
+		{
+			input:          "name:50%_off",
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{`%50\%\_off%`},
+		},
+		{
+			input:          "-name:chrome",
+			wantConditions: []string{`NOT COALESCE(e.process_name LIKE ? ESCAPE '\', 0)`},
+			wantArgs:       []interface{}{"%chrome%"},
+		},
+		{
+			input:          "exe:/usr/bin/*",
+			wantConditions: []string{`REPLACE(e.exe_path, '\', '/') LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"/usr/bin/%"},
+		},
+		{
+			input:          "pid>100",
+			wantConditions: []string{"e.pid > ?"},
+			wantArgs:       []interface{}{int64(100)},
+		},
+		{
+			input:          "duration>=10m",
+			wantConditions: []string{"(COALESCE(e.end_time, ?) - e.start_time) >= ?"},
+			wantArgs:       []interface{}{queryNow{}, int64(600)},
+		},
+		{
+			input:          "duration>2d",
+			wantConditions: []string{"(COALESCE(e.end_time, ?) - e.start_time) > ?"},
+			wantArgs:       []interface{}{queryNow{}, int64(172800)},
+		},
+		{
+			input:          "after:2026-10-01",
+			wantConditions: []string{"e.start_time >= ?"},
+			wantArgs:       []interface{}{int64(1790812800)},
+		},
+		{
+			input:          "name:chrome -spam",
+			wantConditions: []string{`e.process_name LIKE ? ESCAPE '\'`, "e.id NOT IN (SELECT rowid FROM app_events_fts WHERE app_events_fts MATCH ?)"},
+			wantArgs:       []interface{}{"%chrome%", `"spam"*`},
+		},
+		{input: "foo:bar", wantPos: 1},
+		{input: "café name:x foo:y", wantPos: 13},
+		{input: "name:", wantPos: 6},
+		{input: `name:"abc`, wantPos: 6},
+		{input: "pid:abc", wantPos: 5},
+		{input: "duration:10m", wantPos: 1},
+		{input: "after:nonsense", wantPos: 7},
+		{input: `-"!!"`, wantPos: 1},
+	})
+}
+
+func TestParseWebQuery(t *testing.T) {
+	runQueryTests(t, ParseWebQuery, []queryTest{
+		{input: "news", wantMatch: `"news"*`},
+		{
+			input:          "domain:example.com",
+			wantConditions: []string{"e.domain = ?"},
+			wantArgs:       []interface{}{"example.com"},
+		},
+		{
+			input:          "domain:www.example.co.uk",
+			wantConditions: []string{`(e.host = ? OR e.host LIKE ? ESCAPE '\')`},
+			wantArgs:       []interface{}{"www.example.co.uk", "%.www.example.co.uk"},
+		},
+		{
+			input:          "domain:*.example.com",
+			wantConditions: []string{`e.host LIKE ? ESCAPE '\'`},
+			wantArgs:       []interface{}{"%.example.com"},
+		},
+		{
+			input:          "host:WWW.Example.com.",
+			wantConditions: []string{"e.host = ?"},
+			wantArgs:       []interface{}{"www.example.com"},
+		},
+		{
+			input:          "scheme:HTTPS",
+			wantConditions: []string{"e.scheme = ?"},
+			wantArgs:       []interface{}{"https"},
+		},
+		{
+			input:          "title:bar -url:baz",
+			wantConditions: []string{`e.title LIKE ? ESCAPE '\'`, `NOT COALESCE(e.url LIKE ? ESCAPE '\', 0)`},
+			wantArgs:       []interface{}{"%bar%", "%baz%"},
+		},
+		{input: "pid:1", wantPos: 1},
+		{input: "name:chrome", wantPos: 1},
+	})
+}
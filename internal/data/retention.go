@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// retentionInterval is how often expired rows are pruned.
+	retentionInterval = 1 * time.Hour
+	// pruneBatchSize is the number of rows deleted per write request, so the writer is never blocked for long.
+	pruneBatchSize = 1000
+	// incrementalVacuumPages is the number of free pages returned to the file system after each pruning run.
+	incrementalVacuumPages = 2000
+)
+
+// retentionRule describes how expired rows are found in one table.
+type retentionRule struct {
+	table string
+	// expired is a SQL condition that selects rows older than the cutoff, which is bound as its only parameter.
+	// Rows for which it is not true, for example because a timestamp is still NULL, are kept.
+	expired string
+	// cutoff converts the cutoff time into the value compared by expired.
+	cutoff func(time.Time) interface{}
+	days   func(RetentionConfig) int
+}
+
+// unixCutoff compares against columns holding Unix timestamps.
+func unixCutoff(t time.Time) interface{} { return t.Unix() }
+
+// dayCutoff compares against the day column of the rollup tables.
+func dayCutoff(t time.Time) interface{} { return DayKey(t) }
+
+// retentionRules lists every table that is subject to a retention policy.
+var retentionRules = []retentionRule{
+	// Sessions are only pruned once they have ended, so running apps are never lost.
+	{table: "app_events", expired: "end_time < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.RawEventDays }},
+	{table: "web_events", expired: "timestamp < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.RawEventDays }},
+	{table: "block_events", expired: "timestamp < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.RawEventDays }},
+	{table: "logs", expired: "timestamp < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.LogDays }},
+	{table: "tamper_events", expired: "timestamp < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.TamperEventDays }},
+	// The current run's last heartbeat is always recent, so only past runs are pruned.
+	{table: "heartbeats", expired: "last_beat < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.LogDays }},
+	{table: "power_events", expired: "timestamp < ?", cutoff: unixCutoff, days: func(c RetentionConfig) int { return c.RawEventDays }},
+	{table: "daily_app_stats", expired: "day < ?", cutoff: dayCutoff, days: func(c RetentionConfig) int { return c.RollupDays }},
+	{table: "daily_domain_stats", expired: "day < ?", cutoff: dayCutoff, days: func(c RetentionConfig) int { return c.RollupDays }},
+	{table: "daily_category_stats", expired: "day < ?", cutoff: dayCutoff, days: func(c RetentionConfig) int { return c.RollupDays }},
+}
+
+// TableSize reports how much space a table or index takes up in the database file.
+type TableSize struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Pages int64  `json:"pages"`
+}
+
+// StorageReport summarizes the disk usage of the database.
+type StorageReport struct {
+	// FileBytes is the size of the database file plus its write-ahead log.
+	FileBytes int64 `json:"file_bytes"`
+	// FreeBytes is the space inside the file that is unused and can be reclaimed by vacuuming.
+	FreeBytes int64           `json:"free_bytes"`
+	Tables    []TableSize     `json:"tables"`
+	Retention RetentionConfig `json:"retention"`
+}
+
+// RunRetentionPruner deletes rows older than the configured retention periods until ctx is cancelled.
+// All deletes go through the database writer, so pruning never competes with it for the write lock.
+func RunRetentionPruner(ctx context.Context, appLogger Logger, db *sql.DB) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		cfg, err := LoadConfig()
+		if err != nil {
+			appLogger.Error("Failed to load config for retention", "err", err)
+		} else {
+			PruneExpiredData(appLogger, db, cfg.Retention, time.Now())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneExpiredData queues batched deletes for every row that is older than its retention period,
+// followed by an incremental vacuum to return the freed pages to the file system.
+func PruneExpiredData(appLogger Logger, db *sql.DB, retention RetentionConfig, now time.Time) {
+	pruned := false
+	for _, rule := range retentionRules {
+		days := rule.days(retention)
+		if days <= 0 {
+			continue // Keep forever.
+		}
+		cutoff := rule.cutoff(now.AddDate(0, 0, -days))
+
+		var expired int
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", rule.table, rule.expired)
+		if err := db.QueryRow(countQuery, cutoff).Scan(&expired); err != nil {
+			appLogger.Error("Failed to count expired rows", "table", rule.table, "err", err)
+			continue
+		}
+		if expired == 0 {
+			continue
+		}
+
+		// Rows that expire while the batches are queued are picked up by the next run, so the count is only an upper bound.
+		deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s LIMIT %d)",
+			rule.table, rule.table, rule.expired, pruneBatchSize)
+		for remaining := expired; remaining > 0; remaining -= pruneBatchSize {
+			EnqueueWrite(deleteQuery, cutoff)
+		}
+		appLogger.Info("Pruning expired rows", "table", rule.table, "rows", expired, "days", days)
+		pruned = true
+	}
+
+	if pruned {
+		EnqueueStandaloneWrite(fmt.Sprintf("PRAGMA incremental_vacuum(%d)", incrementalVacuumPages))
+	}
+}
+
+// GetStorageReport returns the size of every table and index in the database, largest first.
+func GetStorageReport(db *sql.DB, retention RetentionConfig) (*StorageReport, error) {
+	report := &StorageReport{Tables: []TableSize{}, Retention: retention}
+
+	rows, err := db.Query("SELECT name, SUM(pgsize), COUNT(*) FROM dbstat GROUP BY name ORDER BY SUM(pgsize) DESC")
+	if err != nil {
+		return nil, fmt.Errorf("could not query table sizes: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	for rows.Next() {
+		var t TableSize
+		if err := rows.Scan(&t.Name, &t.Bytes, &t.Pages); err != nil {
+			return nil, err
+		}
+		report.Tables = append(report.Tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var freePages, pageSize int64
+	if err := db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return nil, err
+	}
+	report.FreeBytes = freePages * pageSize
+
+	if dbPath, err := GetDBPath(); err == nil {
+		for _, p := range []string{dbPath, dbPath + "-wal"} {
+			if info, err := os.Stat(p); err == nil {
+				report.FileBytes += info.Size()
+			}
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,416 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// dayLayout is the format of the day column in the rollup tables. It sorts chronologically as text.
+	dayLayout = "2006-01-02"
+	// maxWebVisitDuration caps the time attributed to a single page view, since the browser
+	// only reports when a page is opened and not when the user stops looking at it.
+	maxWebVisitDuration = 5 * time.Minute
+)
+
+// Rollup kinds select between the per-app, per-domain and per-category statistics.
+// The app and web kinds are also stored in the kind column of daily_category_stats.
+const (
+	RollupKindApp      = "app"
+	RollupKindWeb      = "web"
+	RollupKindCategory = "category"
+)
+
+// upsertAppRollup adds one session segment to the daily per-app statistics.
+const upsertAppRollup = `
+	INSERT INTO daily_app_stats (day, process_name, duration_seconds, session_count)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(day, process_name) DO UPDATE SET
+		duration_seconds = duration_seconds + excluded.duration_seconds,
+		session_count = session_count + excluded.session_count`
+
+// upsertDomainRollup adds visits and viewing time to the daily per-domain statistics.
+const upsertDomainRollup = `
+	INSERT INTO daily_domain_stats (day, domain, duration_seconds, visit_count)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(day, domain) DO UPDATE SET
+		duration_seconds = duration_seconds + excluded.duration_seconds,
+		visit_count = visit_count + excluded.visit_count`
+
+// upsertCategoryRollup adds usage to the daily per-category statistics.
+const upsertCategoryRollup = `
+	INSERT INTO daily_category_stats (day, kind, category, duration_seconds, event_count)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(day, kind, category) DO UPDATE SET
+		duration_seconds = duration_seconds + excluded.duration_seconds,
+		event_count = event_count + excluded.event_count`
+
+// RollupTotal is the aggregated usage of a single app, domain or category over a range of days.
+type RollupTotal struct {
+	Key      string `json:"key"`
+	Duration int64  `json:"duration"`
+	Count    int64  `json:"count"`
+}
+
+// DailyRollup is the usage of a single app, domain or category on one day.
+type DailyRollup struct {
+	Day      string `json:"day"`
+	Key      string `json:"key"`
+	Category string `json:"category,omitempty"`
+	Duration int64  `json:"duration"`
+	Count    int64  `json:"count"`
+}
+
+// DayKey returns the rollup day that the given time falls on in the display time zone.
+func DayKey(t time.Time) string {
+	return t.In(DisplayLocation()).Format(dayLayout)
+}
+
+// StartOfDay returns midnight at the beginning of the day that t falls on in the display time zone.
+// Days are not always 24 hours long, so durations must be measured between such boundaries rather than added to them.
+func StartOfDay(t time.Time) time.Time {
+	t = t.In(DisplayLocation())
+	return dayStart(t.Year(), t.Month(), t.Day(), t.Location())
+}
+
+// startOfNextDay returns midnight at the beginning of the day after the one t falls on in the display time zone.
+func startOfNextDay(t time.Time) time.Time {
+	t = t.In(DisplayLocation())
+	return dayStart(t.Year(), t.Month(), t.Day()+1, t.Location())
+}
+
+// dayStart returns the first instant of a day in loc. Out-of-range days and months are normalized like time.Date does.
+// In zones where a DST change skips midnight, time.Date picks an instant on the previous day,
+// so the day starts when that change takes effect instead.
+func dayStart(year int, month time.Month, day int, loc *time.Location) time.Time {
+	start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if noon := time.Date(year, month, day, 12, 0, 0, 0, loc); start.Day() != noon.Day() {
+		_, start = start.ZoneBounds()
+	}
+	return start
+}
+
+// IsDayAligned reports whether a time range starts and ends exactly on day boundaries and covers only completed days,
+// in which case it can be answered from the daily rollups instead of the raw events. A zero since means "from the beginning".
+func IsDayAligned(since, until time.Time) bool {
+	if until.IsZero() || !until.Equal(StartOfDay(until)) || until.After(StartOfDay(time.Now())) {
+		return false
+	}
+	return since.IsZero() || since.Equal(StartOfDay(since))
+}
+
+// daySegment is the part of a session that falls on a single day.
+type daySegment struct {
+	day      string
+	duration int64
+}
+
+// splitByDay splits the interval [start, end) into per-day segments, so that sessions running past midnight
+// are attributed to each day they cover.
+func splitByDay(start, end int64) []daySegment {
+	if end < start {
+		end = start
+	}
+	var segments []daySegment
+	from := time.Unix(start, 0)
+	to := time.Unix(end, 0)
+	for {
+		next := startOfNextDay(from)
+		if !next.Before(to) {
+			segments = append(segments, daySegment{day: DayKey(from), duration: int64(to.Sub(from).Seconds())})
+			return segments
+		}
+		segments = append(segments, daySegment{day: DayKey(from), duration: int64(next.Sub(from).Seconds())})
+		from = next
+	}
+}
+
+// EndAppSession sets the end time of a process session and adds the session to the daily app and category rollups.
+// The session counts once, on the day it started; its duration is split across every day it covered.
+// Both happen in one transaction, so a recount of the rollups sees the session either completely or not at all.
+func EndAppSession(pid int32, processName string, start, end int64) {
+	enqueueTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE app_events SET end_time = ? WHERE pid = ? AND end_time IS NULL", end, pid); err != nil {
+			return err
+		}
+		category := AppCategory(processName)
+		for i, seg := range splitByDay(start, end) {
+			sessions := 0
+			if i == 0 {
+				sessions = 1
+			}
+			if _, err := tx.Exec(upsertAppRollup, seg.day, processName, seg.duration, sessions); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(upsertCategoryRollup, seg.day, RollupKindApp, category, seg.duration, sessions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recordWebVisit updates the daily domain and category rollups for a page view on host, which must be run
+// before the view is inserted into web_events. The previous page view, which is the last one recorded,
+// is closed at the same time and credited with the time until this one, up to maxWebVisitDuration.
+func recordWebVisit(tx *sql.Tx, host string, timestamp int64) error {
+	if host == "" {
+		return nil
+	}
+
+	var prevHost string
+	var prevTimestamp int64
+	err := tx.QueryRow("SELECT host, timestamp FROM web_events WHERE host != '' ORDER BY id DESC LIMIT 1").Scan(&prevHost, &prevTimestamp)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if prevHost != "" {
+		if duration := webVisitDuration(prevTimestamp, timestamp); duration > 0 {
+			day := DayKey(time.Unix(prevTimestamp, 0))
+			if _, err := tx.Exec(upsertDomainRollup, day, prevHost, duration, 0); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(upsertCategoryRollup, day, RollupKindWeb, DomainCategory(prevHost), duration, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	day := DayKey(time.Unix(timestamp, 0))
+	if _, err := tx.Exec(upsertDomainRollup, day, host, 0, 1); err != nil {
+		return err
+	}
+	_, err = tx.Exec(upsertCategoryRollup, day, RollupKindWeb, DomainCategory(host), 0, 1)
+	return err
+}
+
+// webVisitDuration returns the number of seconds credited to a page view that was followed by another one.
+func webVisitDuration(start, next int64) int64 {
+	duration := next - start
+	if duration < 0 {
+		return 0
+	}
+	if limit := int64(maxWebVisitDuration.Seconds()); duration > limit {
+		return limit
+	}
+	return duration
+}
+
+// GetTopRollups returns the apps or domains with the most usage between two days, most used first.
+// sinceDay is inclusive and untilDay exclusive; an empty sinceDay means "from the beginning".
+func GetTopRollups(db *sql.DB, kind string, sinceDay, untilDay string, limit int) ([]RollupTotal, error) {
+	var q string
+	switch kind {
+	case RollupKindApp:
+		q = "SELECT process_name, SUM(duration_seconds), SUM(session_count) AS count FROM daily_app_stats WHERE day >= ? AND day < ? GROUP BY process_name"
+	case RollupKindWeb:
+		q = "SELECT domain, SUM(duration_seconds), SUM(visit_count) AS count FROM daily_domain_stats WHERE day >= ? AND day < ? GROUP BY domain"
+	default:
+		return nil, fmt.Errorf("unknown rollup kind %q", kind)
+	}
+	q += " ORDER BY count DESC LIMIT ?"
+
+	rows, err := db.Query(q, sinceDay, untilDay, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	var totals []RollupTotal
+	for rows.Next() {
+		var t RollupTotal
+		if err := rows.Scan(&t.Key, &t.Duration, &t.Count); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// GetDailyRollups returns the per-day statistics of one kind between two days.
+// sinceDay is inclusive and untilDay exclusive.
+func GetDailyRollups(db *sql.DB, kind string, sinceDay, untilDay string) ([]DailyRollup, error) {
+	var q string
+	switch kind {
+	case RollupKindApp:
+		q = "SELECT day, process_name, '', duration_seconds, session_count FROM daily_app_stats WHERE day >= ? AND day < ? ORDER BY day, duration_seconds DESC"
+	case RollupKindWeb:
+		q = "SELECT day, domain, '', duration_seconds, visit_count FROM daily_domain_stats WHERE day >= ? AND day < ? ORDER BY day, duration_seconds DESC"
+	case RollupKindCategory:
+		q = "SELECT day, kind, category, duration_seconds, event_count FROM daily_category_stats WHERE day >= ? AND day < ? ORDER BY day, kind, duration_seconds DESC"
+	default:
+		return nil, fmt.Errorf("unknown rollup kind %q", kind)
+	}
+
+	rows, err := db.Query(q, sinceDay, untilDay)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			GetLogger().Error("Failed to close rows", "err", err)
+		}
+	}()
+
+	rollups := []DailyRollup{}
+	for rows.Next() {
+		var r DailyRollup
+		if err := rows.Scan(&r.Day, &r.Key, &r.Category, &r.Duration, &r.Count); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
+
+// backfillRollups fills the rollup tables from the raw events with the current rules. The tables must be empty.
+func backfillRollups(tx *sql.Tx) error {
+	type key struct{ day, name string }
+	type catKey struct{ day, kind, category string }
+	type usage struct{ duration, count int64 }
+
+	apps := make(map[key]*usage)
+	domains := make(map[key]*usage)
+	categories := make(map[catKey]*usage)
+	add := func(m map[key]*usage, k key, duration, count int64) {
+		if m[k] == nil {
+			m[k] = &usage{}
+		}
+		m[k].duration += duration
+		m[k].count += count
+	}
+	addCategory := func(k catKey, duration, count int64) {
+		if categories[k] == nil {
+			categories[k] = &usage{}
+		}
+		categories[k].duration += duration
+		categories[k].count += count
+	}
+
+	rows, err := tx.Query("SELECT process_name, start_time, end_time FROM app_events WHERE end_time IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var start, end int64
+		if err := rows.Scan(&name, &start, &end); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		category := AppCategory(name)
+		for i, seg := range splitByDay(start, end) {
+			var sessions int64
+			if i == 0 {
+				sessions = 1
+			}
+			add(apps, key{seg.day, name}, seg.duration, sessions)
+			addCategory(catKey{seg.day, RollupKindApp, category}, seg.duration, sessions)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	// Page views are credited in the order they were recorded, like recordWebVisit does. The host is used
+	// rather than the URL, which may be encrypted.
+	rows, err = tx.Query("SELECT host, timestamp FROM web_events WHERE host != '' ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var prevDomain string
+	var prevTimestamp int64
+	for rows.Next() {
+		var domain string
+		var timestamp int64
+		if err := rows.Scan(&domain, &timestamp); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if prevDomain != "" {
+			if duration := webVisitDuration(prevTimestamp, timestamp); duration > 0 {
+				day := DayKey(time.Unix(prevTimestamp, 0))
+				add(domains, key{day, prevDomain}, duration, 0)
+				addCategory(catKey{day, RollupKindWeb, DomainCategory(prevDomain)}, duration, 0)
+			}
+		}
+		day := DayKey(time.Unix(timestamp, 0))
+		add(domains, key{day, domain}, 0, 1)
+		addCategory(catKey{day, RollupKindWeb, DomainCategory(domain)}, 0, 1)
+		prevDomain, prevTimestamp = domain, timestamp
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for k, u := range apps {
+		if _, err := tx.Exec(upsertAppRollup, k.day, k.name, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	for k, u := range domains {
+		if _, err := tx.Exec(upsertDomainRollup, k.day, k.name, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	for k, u := range categories {
+		if _, err := tx.Exec(upsertCategoryRollup, k.day, k.kind, k.category, u.duration, u.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecountRollups recounts the daily rollups if they were counted in a time zone other than the display time zone,
+// and waits until that is done or ctx is done. It is called after the display time zone changes.
+func RecountRollups(ctx context.Context) error {
+	enqueueTx(recountRollupsTx)
+	return Flush(ctx)
+}
+
+// recountRollups recounts the daily rollups if they were counted in a time zone other than the display time zone.
+// It runs at startup, before the database writer is started.
+func recountRollups(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := recountRollupsTx(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// recountRollupsTx rebuilds the rollup tables from the raw events in the display time zone, unless rollup_state
+// says that they were already counted in it. Days are keyed by their date in that zone, so rollups counted
+// in another zone would attribute the hours around midnight to the wrong day.
+func recountRollupsTx(tx *sql.Tx) error {
+	zone := DisplayLocation().String()
+	var countedIn string
+	err := tx.QueryRow("SELECT timezone FROM rollup_state WHERE id = 1").Scan(&countedIn)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if countedIn == zone {
+		return nil
+	}
+
+	for _, table := range []string{"daily_app_stats", "daily_domain_stats", "daily_category_stats"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	if err := backfillRollups(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO rollup_state (id, timezone) VALUES (1, ?) ON CONFLICT(id) DO UPDATE SET timezone = excluded.timezone", zone)
+	return err
+}
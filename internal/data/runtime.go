@@ -0,0 +1,198 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultGUIAddr is the address of the web GUI and its API.
+	DefaultGUIAddr = "127.0.0.1:58141"
+	// DefaultIPCAddr is the address of the internal API used by the native messaging host.
+	DefaultIPCAddr = "127.0.0.1:58142"
+)
+
+// Environment variables that override the runtime configuration. Flags take precedence over them.
+const (
+	EnvConfigFile = "PROCGUARD_CONFIG"
+	EnvDataDir    = "PROCGUARD_DATA_DIR"
+	EnvGUIAddr    = "PROCGUARD_GUI_ADDR"
+	EnvIPCAddr    = "PROCGUARD_IPC_ADDR"
+	EnvLogPath    = "PROCGUARD_LOG_PATH"
+	EnvLogLevel   = "PROCGUARD_LOG_LEVEL"
+)
+
+// RuntimeConfig describes where an instance keeps its files and which addresses it listens on.
+// Unlike Config, which holds user settings stored inside the data directory, it is resolved at startup
+// from a config file, environment variables and flags, so several isolated instances can run side by side.
+type RuntimeConfig struct {
+	DataDir string `json:"data_dir"`
+	GUIAddr string `json:"gui_addr"`
+	IPCAddr string `json:"ipc_addr"`
+	LogPath string `json:"log_path"`
+	// LogLevel is the minimum level of the messages that are logged: debug, info, warn or error.
+	LogLevel string `json:"log_level"`
+	// Args are the arguments left after the flags, which name a command such as "backup".
+	Args []string `json:"-"`
+}
+
+var (
+	runtimeConfig     RuntimeConfig
+	runtimeConfigOnce sync.Once
+	runtimeConfigMu   sync.RWMutex
+)
+
+// ResolveRuntimeConfig builds the runtime configuration from, in increasing order of precedence,
+// the defaults, a JSON config file (--config or PROCGUARD_CONFIG), environment variables and flags.
+// It returns flag.ErrHelp if the help flag was given.
+func ResolveRuntimeConfig(args []string, getenv func(string) string) (RuntimeConfig, error) {
+	var fromFlags RuntimeConfig
+	var configFile string
+	fs := flag.NewFlagSet("procguard", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "path to a JSON runtime config file (env "+EnvConfigFile+")")
+	fs.StringVar(&fromFlags.DataDir, "data-dir", "", "directory for the database, settings and logs (env "+EnvDataDir+")")
+	fs.StringVar(&fromFlags.GUIAddr, "gui-addr", "", "address of the web GUI (env "+EnvGUIAddr+", default "+DefaultGUIAddr+")")
+	fs.StringVar(&fromFlags.IPCAddr, "ipc-addr", "", "address of the internal API (env "+EnvIPCAddr+", default "+DefaultIPCAddr+")")
+	fs.StringVar(&fromFlags.LogPath, "log-path", "", "path of the log file (env "+EnvLogPath+", default <data-dir>/procguard.log)")
+	fs.StringVar(&fromFlags.LogLevel, "log-level", "", "minimum level of logged messages: debug, info, warn or error (env "+EnvLogLevel+", default info)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: procguard [flags] [command]
+
+Commands:
+  backup <file>   write a backup of the database, settings and blocklists to file
+  restore <file>  restore a backup, replacing the current state
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return RuntimeConfig{}, err
+	}
+
+	var cfg RuntimeConfig
+	if configFile == "" {
+		configFile = getenv(EnvConfigFile)
+	}
+	if configFile != "" {
+		fromFile, err := readRuntimeConfigFile(configFile)
+		if err != nil {
+			return RuntimeConfig{}, err
+		}
+		cfg.merge(fromFile)
+	}
+	cfg.merge(RuntimeConfig{
+		DataDir:  getenv(EnvDataDir),
+		GUIAddr:  getenv(EnvGUIAddr),
+		IPCAddr:  getenv(EnvIPCAddr),
+		LogPath:  getenv(EnvLogPath),
+		LogLevel: getenv(EnvLogLevel),
+	})
+	cfg.merge(fromFlags)
+
+	if cfg.DataDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return RuntimeConfig{}, fmt.Errorf("could not get user cache dir: %w", err)
+		}
+		cfg.DataDir = filepath.Join(cacheDir, "procguard")
+	}
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		return RuntimeConfig{}, fmt.Errorf("invalid data dir: %w", err)
+	}
+	cfg.DataDir = dataDir
+	if cfg.GUIAddr == "" {
+		cfg.GUIAddr = DefaultGUIAddr
+	}
+	if cfg.IPCAddr == "" {
+		cfg.IPCAddr = DefaultIPCAddr
+	}
+	if cfg.LogPath == "" {
+		cfg.LogPath = filepath.Join(cfg.DataDir, "procguard.log")
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "info"
+	}
+	if _, err := ParseLogLevel(cfg.LogLevel); err != nil {
+		return RuntimeConfig{}, err
+	}
+	cfg.Args = fs.Args()
+	return cfg, nil
+}
+
+// merge overrides the fields of c with the non-empty fields of other.
+func (c *RuntimeConfig) merge(other RuntimeConfig) {
+	if other.DataDir != "" {
+		c.DataDir = other.DataDir
+	}
+	if other.GUIAddr != "" {
+		c.GUIAddr = other.GUIAddr
+	}
+	if other.IPCAddr != "" {
+		c.IPCAddr = other.IPCAddr
+	}
+	if other.LogPath != "" {
+		c.LogPath = other.LogPath
+	}
+	if other.LogLevel != "" {
+		c.LogLevel = other.LogLevel
+	}
+}
+
+// readRuntimeConfigFile reads a runtime config file. Unknown keys are rejected so that typos don't go unnoticed.
+func readRuntimeConfigFile(path string) (RuntimeConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return RuntimeConfig{}, fmt.Errorf("could not read runtime config: %w", err)
+	}
+
+	var cfg RuntimeConfig
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return RuntimeConfig{}, fmt.Errorf("invalid runtime config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// SetRuntimeConfig sets the runtime configuration of this process. It must be called before InitDB and NewLogger.
+func SetRuntimeConfig(cfg RuntimeConfig) {
+	runtimeConfigOnce.Do(func() {}) // Prevent Runtime from replacing cfg with the defaults.
+	runtimeConfigMu.Lock()
+	defer runtimeConfigMu.Unlock()
+	runtimeConfig = cfg
+}
+
+// Runtime returns the runtime configuration of this process.
+// If SetRuntimeConfig was never called, it is resolved from the environment and the defaults.
+func Runtime() RuntimeConfig {
+	runtimeConfigOnce.Do(func() {
+		cfg, err := ResolveRuntimeConfig(nil, os.Getenv)
+		if err != nil {
+			// Paths built from an empty data dir are rejected by dataPath.
+			fmt.Fprintf(os.Stderr, "Failed to resolve runtime config: %v\n", err)
+		}
+		runtimeConfigMu.Lock()
+		runtimeConfig = cfg
+		runtimeConfigMu.Unlock()
+	})
+	runtimeConfigMu.RLock()
+	defer runtimeConfigMu.RUnlock()
+	return runtimeConfig
+}
+
+// DataPath returns the path of a file inside the data directory.
+func DataPath(elem ...string) (string, error) {
+	dir := Runtime().DataDir
+	if dir == "" {
+		return "", fmt.Errorf("data directory is not configured")
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
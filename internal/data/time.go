@@ -2,40 +2,233 @@ package data
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// ParseTime is a helper function that parses a string into a time.Time object.
-// It supports both relative time strings (e.g., "now", "1 hour ago") and absolute time strings in specific layouts.
+// absoluteTimeLayouts are the layouts accepted for absolute times without a time zone, interpreted in the display time zone.
+var absoluteTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// minEpochDigits is the number of digits an epoch needs, so that a bare year such as "2026" is never read as one.
+// Nine digits reach back to 1973.
+const minEpochDigits = 9
+
+// relativeTimePattern matches relative times such as "90 minutes ago", "3d", "-2w", "+1h" and "in 2 hours".
+// A leading + or "in" points to the future; anything else points to the past.
+var relativeTimePattern = regexp.MustCompile(`^(in\s+|[+-])?(\d+(?:\.\d+)?)\s*([a-z]+)(\s+ago)?$`)
+
+// timeUnits maps the unit names accepted in relative times to a duration, or to a number of months for
+// calendar units, which AddDate handles so that "1 month ago" lands on the same day of the previous month.
+var timeUnits = map[string]struct {
+	d      time.Duration
+	months int
+}{
+	"s": {d: time.Second}, "sec": {d: time.Second}, "secs": {d: time.Second}, "second": {d: time.Second}, "seconds": {d: time.Second},
+	"m": {d: time.Minute}, "min": {d: time.Minute}, "mins": {d: time.Minute}, "minute": {d: time.Minute}, "minutes": {d: time.Minute},
+	"h": {d: time.Hour}, "hr": {d: time.Hour}, "hrs": {d: time.Hour}, "hour": {d: time.Hour}, "hours": {d: time.Hour},
+	"d": {d: 24 * time.Hour}, "day": {d: 24 * time.Hour}, "days": {d: 24 * time.Hour},
+	"w": {d: 7 * 24 * time.Hour}, "wk": {d: 7 * 24 * time.Hour}, "week": {d: 7 * 24 * time.Hour}, "weeks": {d: 7 * 24 * time.Hour},
+	"mo": {months: 1}, "month": {months: 1}, "months": {months: 1},
+	"y": {months: 12}, "yr": {months: 12}, "year": {months: 12}, "years": {months: 12},
+}
+
+// weekdays maps weekday names and their three-letter abbreviations to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseTime is a helper function that parses a string into a time.Time object. It accepts:
+//   - "now", "today", "yesterday" and "tomorrow";
+//   - relative times such as "1 hour ago", "90 minutes ago", "3d", "-2w", "1h30m", "+1h" and "in 2 days";
+//     a bare amount means that long ago;
+//   - calendar words such as "last monday", "last week", "start of month" and "end of year",
+//     where weeks start on Monday;
+//   - RFC 3339 times with an offset, such as "2026-10-01T12:00:00+02:00";
+//   - times in the display time zone in the layouts "2006-01-02 15:04:05", "2006-01-02 15:04" and "2006-01-02",
+//     with either a space or a T, and the start of a month or year as "2006-01" or "2006";
+//   - Unix epoch numbers of at least nine digits, in seconds or, above 10^11, in milliseconds.
 func ParseTime(input string) (time.Time, error) {
-	now := time.Now()
-	lowerInput := strings.ToLower(strings.TrimSpace(input))
+	return parseTimeAt(input, time.Now())
+}
 
-	// Handle relative time strings.
-	switch lowerInput {
-	case "now":
-		return now, nil
-	case "1 hour ago":
-		return now.Add(-1 * time.Hour), nil
-	case "24 hours ago":
-		return now.Add(-24 * time.Hour), nil
-	case "7 days ago":
-		return now.AddDate(0, 0, -7), nil
+// parseTimeAt parses input like ParseTime, relative to now.
+func parseTimeAt(input string, now time.Time) (time.Time, error) {
+	trimmed := strings.TrimSpace(input)
+	lowerInput := strings.Join(strings.Fields(strings.ToLower(trimmed)), " ")
+	if lowerInput == "" {
+		return time.Time{}, fmt.Errorf("could not parse time: empty input")
+	}
+
+	if t, ok := parseCalendarWords(lowerInput, now); ok {
+		return t, nil
 	}
 
-	// Handle absolute time strings.
-	layouts := []string{
-		"2006-01-02 15:04:05",
-		"2006-01-02T15:04",
+	if t, err := time.Parse(time.RFC3339Nano, trimmed); err == nil {
+		return t, nil
+	}
+	for _, layout := range absoluteTimeLayouts {
+		if t, err := time.ParseInLocation(layout, trimmed, DisplayLocation()); err == nil {
+			return t, nil
+		}
 	}
 
-	for _, layout := range layouts {
-		parsedTime, err := time.ParseInLocation(layout, input, time.Local)
-		if err == nil {
-			return parsedTime, nil
+	if epoch, err := strconv.ParseInt(lowerInput, 10, 64); err == nil && len(strings.TrimPrefix(lowerInput, "-")) >= minEpochDigits {
+		if epoch > 1e11 || epoch < -1e11 {
+			return time.UnixMilli(epoch).In(DisplayLocation()), nil
 		}
+		return localTime(epoch), nil
+	}
+
+	if t, ok := parseRelativeTime(lowerInput, now); ok {
+		return t, nil
 	}
 
 	return time.Time{}, fmt.Errorf("could not parse time: %s", input)
 }
+
+// parseCalendarWords handles named days and the start or end of calendar periods.
+// input must be lowercase with single spaces.
+func parseCalendarWords(input string, now time.Time) (time.Time, bool) {
+	today := StartOfDay(now)
+	switch input {
+	case "now":
+		return now, true
+	case "today":
+		return today, true
+	case "yesterday":
+		return shiftDay(today, 0, 0, -1), true
+	case "tomorrow":
+		return shiftDay(today, 0, 0, 1), true
+	}
+
+	if rest, ok := strings.CutPrefix(input, "start of "); ok {
+		return startOfPeriod(strings.TrimPrefix(rest, "this "), now)
+	}
+	if rest, ok := strings.CutPrefix(input, "end of "); ok {
+		// The end of a period is the start of the next one, which makes it usable as an exclusive bound.
+		rest = strings.TrimPrefix(rest, "this ")
+		start, ok := startOfPeriod(rest, now)
+		if !ok {
+			return time.Time{}, false
+		}
+		switch rest {
+		case "day", "today":
+			return shiftDay(start, 0, 0, 1), true
+		case "week":
+			return shiftDay(start, 0, 0, 7), true
+		case "month":
+			return shiftDay(start, 0, 1, 0), true
+		default:
+			return shiftDay(start, 1, 0, 0), true
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(input, "last "); ok {
+		if wd, ok := weekdays[rest]; ok {
+			// The most recent such day before today, so on a Monday "last monday" is a week ago.
+			days := (int(today.Weekday()) - int(wd) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			return shiftDay(today, 0, 0, -days), true
+		}
+		start, ok := startOfPeriod(rest, now)
+		if !ok {
+			return time.Time{}, false
+		}
+		switch rest {
+		case "week":
+			return shiftDay(start, 0, 0, -7), true
+		case "month":
+			return shiftDay(start, 0, -1, 0), true
+		case "year":
+			return shiftDay(start, -1, 0, 0), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// startOfPeriod returns the start of the day, week, month or year that now falls in.
+func startOfPeriod(period string, now time.Time) (time.Time, bool) {
+	today := StartOfDay(now)
+	switch period {
+	case "day", "today":
+		return today, true
+	case "week":
+		// time.Weekday counts from Sunday; weeks start on Monday.
+		return shiftDay(today, 0, 0, -((int(today.Weekday()) + 6) % 7)), true
+	case "month":
+		return dayStart(today.Year(), today.Month(), 1, today.Location()), true
+	case "year":
+		return dayStart(today.Year(), time.January, 1, today.Location()), true
+	}
+	return time.Time{}, false
+}
+
+// shiftDay returns the start of the day that is the given number of years, months and days after the day start falls on.
+func shiftDay(start time.Time, years, months, days int) time.Time {
+	return dayStart(start.Year()+years, start.Month()+time.Month(months), start.Day()+days, start.Location())
+}
+
+// parseRelativeTime handles amounts of time before or after now. input must be lowercase with single spaces.
+func parseRelativeTime(input string, now time.Time) (time.Time, bool) {
+	future := false
+	if m := relativeTimePattern.FindStringSubmatch(input); m != nil {
+		direction, amount, unitName, ago := m[1], m[2], m[3], m[4]
+		unit, ok := timeUnits[unitName]
+		if !ok || (ago != "" && (direction == "+" || strings.HasPrefix(direction, "in"))) {
+			return time.Time{}, false
+		}
+		future = direction == "+" || strings.HasPrefix(direction, "in")
+
+		n, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if unit.months > 0 {
+			// Calendar units only make sense in whole numbers.
+			if n != float64(int(n)) {
+				return time.Time{}, false
+			}
+			months := int(n) * unit.months
+			if !future {
+				months = -months
+			}
+			return now.AddDate(0, months, 0), true
+		}
+		d := time.Duration(n * float64(unit.d))
+		if !future {
+			d = -d
+		}
+		return now.Add(d), true
+	}
+
+	// Compound durations such as "1h30m", optionally signed.
+	rest := strings.TrimSuffix(input, " ago")
+	if sign := rest[0]; sign == '+' || sign == '-' {
+		future = sign == '+' && rest == input
+		rest = rest[1:]
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !future {
+		d = -d
+	}
+	return now.Add(d), true
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeAt(t *testing.T) {
+	useDisplayTimezone(t, "Europe/Berlin")
+	berlin := DisplayLocation()
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, berlin)
+	}
+	// A Wednesday, three days after the clocks went back from summer time.
+	now := at(2026, time.October, 28, 15, 30)
+
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: "now", want: now},
+		{input: "  NOW ", want: now},
+		{input: "today", want: at(2026, time.October, 28, 0, 0)},
+		{input: "yesterday", want: at(2026, time.October, 27, 0, 0)},
+		{input: "tomorrow", want: at(2026, time.October, 29, 0, 0)},
+		{input: "start of week", want: at(2026, time.October, 26, 0, 0)},
+		{input: "start of this month", want: at(2026, time.October, 1, 0, 0)},
+		{input: "end of day", want: at(2026, time.October, 29, 0, 0)},
+		{input: "end of week", want: at(2026, time.November, 2, 0, 0)},
+		{input: "end of month", want: at(2026, time.November, 1, 0, 0)},
+		{input: "end of year", want: at(2027, time.January, 1, 0, 0)},
+		{input: "last week", want: at(2026, time.October, 19, 0, 0)},
+		{input: "last month", want: at(2026, time.September, 1, 0, 0)},
+		{input: "last year", want: at(2025, time.January, 1, 0, 0)},
+		{input: "last monday", want: at(2026, time.October, 26, 0, 0)},
+		{input: "last wed", want: at(2026, time.October, 21, 0, 0)},
+		{input: "2026-10-01T12:00:00+02:00", want: time.Date(2026, time.October, 1, 10, 0, 0, 0, time.UTC)},
+		{input: "2026-10-01 12:00:05", want: time.Date(2026, time.October, 1, 12, 0, 5, 0, berlin)},
+		{input: "2026-10-01T12:00", want: at(2026, time.October, 1, 12, 0)},
+		{input: "2026-12-01", want: at(2026, time.December, 1, 0, 0)},
+		{input: "2026-11", want: at(2026, time.November, 1, 0, 0)},
+		{input: "2026", want: at(2026, time.January, 1, 0, 0)},
+		{input: "123456789", want: time.Date(1973, time.November, 29, 21, 33, 9, 0, time.UTC)},
+		{input: "1790812800", want: time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{input: "1790812800500", want: time.Date(2026, time.October, 1, 0, 0, 0, 5e8, time.UTC)},
+		{input: "90 minutes ago", want: at(2026, time.October, 28, 14, 0)},
+		{input: "3d", want: now.Add(-72 * time.Hour)},
+		{input: "-2w", want: now.Add(-14 * 24 * time.Hour)},
+		{input: "+1h", want: at(2026, time.October, 28, 16, 30)},
+		{input: "in 2 hours", want: at(2026, time.October, 28, 17, 30)},
+		{input: "1.5h", want: at(2026, time.October, 28, 14, 0)},
+		{input: "1 month ago", want: at(2026, time.September, 28, 15, 30)},
+		{input: "2 years ago", want: at(2024, time.October, 28, 15, 30)},
+		{input: "1h30m", want: at(2026, time.October, 28, 14, 0)},
+		{input: "1h30m ago", want: at(2026, time.October, 28, 14, 0)},
+		{input: "+1h30m", want: at(2026, time.October, 28, 17, 0)},
+		{input: "", wantErr: true},
+		{input: "   ", wantErr: true},
+		{input: "soon", wantErr: true},
+		{input: "last fortnight", wantErr: true},
+		{input: "1.5 months ago", wantErr: true},
+		{input: "in 2 hours ago", wantErr: true},
+		{input: "3 parsecs ago", wantErr: true},
+		{input: "2026-13-01", wantErr: true},
+		{input: "12345", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseTimeAt(tt.input, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTimeAt(%q) = %v, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTimeAt(%q): %v", tt.input, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimeAt(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+	// Windows has no time zone database of its own, so the one built into Go is embedded.
+	_ "time/tzdata"
+)
+
+// displayLocation caches the time zone loaded from Config.Timezone. It is nil until first used.
+var displayLocation atomic.Pointer[time.Location]
+
+// LoadTimezone returns the time zone named by an IANA name such as "Europe/Berlin", or "UTC".
+// An empty name, or "Local", means the time zone of the system.
+func LoadTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// DisplayLocation returns the time zone that times are shown in and that days are counted in:
+// the configured Config.Timezone, or the time zone of the system if none is set.
+// Timestamps themselves are always stored as Unix times, which don't depend on it.
+func DisplayLocation() *time.Location {
+	if loc := displayLocation.Load(); loc != nil {
+		return loc
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		// Try again on the next call rather than sticking with a guess.
+		return time.Local
+	}
+	loc, err := LoadTimezone(cfg.Timezone)
+	if err != nil {
+		// This can run during migrations, before the logger is set up.
+		log.Printf("[WARN] Ignoring invalid time zone in config: %v", err)
+		loc = time.Local
+	}
+	displayLocation.Store(loc)
+	return loc
+}
+
+// SetDisplayTimezone makes DisplayLocation return the time zone named name from now on.
+// The caller is responsible for saving the name to Config.Timezone.
+func SetDisplayTimezone(name string) error {
+	loc, err := LoadTimezone(name)
+	if err != nil {
+		return err
+	}
+	displayLocation.Store(loc)
+	return nil
+}
+
+// localTime converts a Unix time from the database to a time in the display time zone,
+// which is how it is rendered in API responses: RFC 3339 with that zone's offset.
+func localTime(unix int64) time.Time {
+	return time.Unix(unix, 0).In(DisplayLocation())
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// backfillBatchSize is the number of web events whose URL components are filled in per query.
+const backfillBatchSize = 1000
+
+// URLComponents are the parts of a visited URL that web_events stores next to it, so that queries by site
+// don't have to take the URL apart in SQL.
+type URLComponents struct {
+	// Scheme is the lowercase scheme, such as "https" or "file".
+	Scheme string
+	// Host is the lowercase host name without userinfo or port. It is empty for URLs without one, like file:///C:/x.
+	Host string
+	// Domain is the registrable domain of Host, such as "example.co.uk" for "www.example.co.uk".
+	// IP addresses and single-label hosts like "localhost" are their own domain.
+	Domain string
+	// Path is the path of the URL, without query or fragment.
+	Path string
+	// QueryHash identifies the query string without storing it a second time. It is empty if there is none.
+	// It is an unkeyed digest, which is only stored while the URL itself is in the clear; see sealedURL.
+	QueryHash string
+}
+
+// ParseURLComponents splits a URL into the components stored in web_events.
+// A URL that can't be parsed gives empty components; it is still recorded, just not attributed to any site.
+func ParseURLComponents(rawURL string) URLComponents {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return URLComponents{}
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	c := URLComponents{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   host,
+		Domain: RegistrableDomain(host),
+		Path:   u.EscapedPath(),
+	}
+	if u.Opaque != "" {
+		c.Path = u.Opaque
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		c.QueryHash = hex.EncodeToString(sum[:8])
+	}
+	return c
+}
+
+// RegistrableDomain returns the registrable domain of a lowercase host name: the public suffix plus one label,
+// as listed in the Public Suffix List. IP addresses, single-label hosts and public suffixes are their own domain.
+func RegistrableDomain(host string) string {
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// backfillURLComponents fills the URL component columns of web events recorded before they existed.
+// The events are read in batches by ID, so a long history is never held in memory at once.
+func backfillURLComponents(tx *sql.Tx) error {
+	stmt, err := tx.Prepare("UPDATE web_events SET scheme = ?, host = ?, domain = ?, path = ?, query_hash = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			logMigrationWarning("Failed to close statement", "err", err)
+		}
+	}()
+
+	type event struct {
+		id  int64
+		url string
+	}
+	var lastID int64
+	for {
+		rows, err := tx.Query("SELECT id, url FROM web_events WHERE id > ? ORDER BY id LIMIT ?", lastID, backfillBatchSize)
+		if err != nil {
+			return err
+		}
+		var events []event
+		for rows.Next() {
+			var ev event
+			if err := rows.Scan(&ev.id, &ev.url); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			events = append(events, ev)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		for _, ev := range events {
+			c := ParseURLComponents(ev.url)
+			if _, err := stmt.Exec(c.Scheme, c.Host, c.Domain, c.Path, nullIfEmpty(c.QueryHash), ev.id); err != nil {
+				return err
+			}
+			lastID = ev.id
+		}
+		if len(events) < backfillBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package data
+
+import "testing"
+
+func TestParseURLComponents(t *testing.T) {
+	tests := []struct {
+		url  string
+		want URLComponents
+		// wantQuery tells whether the URL has a query, which only gives a hash.
+		wantQuery bool
+	}{
+		{
+			url:       "https://www.example.co.uk/a/b?q=1#top",
+			want:      URLComponents{Scheme: "https", Host: "www.example.co.uk", Domain: "example.co.uk", Path: "/a/b"},
+			wantQuery: true,
+		},
+		{
+			url:  "  HTTP://User:pw@News.Example.COM.:8080/Caf%C3%A9  ",
+			want: URLComponents{Scheme: "http", Host: "news.example.com", Domain: "example.com", Path: "/Caf%C3%A9"},
+		},
+		{
+			url:  "http://127.0.0.1:58141/settings",
+			want: URLComponents{Scheme: "http", Host: "127.0.0.1", Domain: "127.0.0.1", Path: "/settings"},
+		},
+		{
+			url:  "http://[::1]/",
+			want: URLComponents{Scheme: "http", Host: "::1", Domain: "::1", Path: "/"},
+		},
+		{
+			url:  "file:///C:/Users/me/page.html",
+			want: URLComponents{Scheme: "file", Path: "/C:/Users/me/page.html"},
+		},
+		{
+			url:  "mailto:someone@example.com",
+			want: URLComponents{Scheme: "mailto", Path: "someone@example.com"},
+		},
+		{
+			url:  "https://example.com?",
+			want: URLComponents{Scheme: "https", Host: "example.com", Domain: "example.com"},
+		},
+		{url: "http://exa mple.com/", want: URLComponents{}},
+		{url: "", want: URLComponents{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got := ParseURLComponents(tt.url)
+			if tt.wantQuery != (got.QueryHash != "") {
+				t.Errorf("QueryHash = %q, want one: %v", got.QueryHash, tt.wantQuery)
+			}
+			got.QueryHash = ""
+			if got != tt.want {
+				t.Errorf("ParseURLComponents(%q) = %+v, want %+v", tt.url, got, tt.want)
+			}
+		})
+	}
+
+	// The hash tells queries apart without storing them, regardless of the rest of the URL.
+	a, b := ParseURLComponents("https://a.example/?q=1"), ParseURLComponents("https://b.example/x?q=1")
+	if a.QueryHash != b.QueryHash {
+		t.Errorf("QueryHash of the same query = %q and %q, want them equal", a.QueryHash, b.QueryHash)
+	}
+	if c := ParseURLComponents("https://a.example/?q=2"); c.QueryHash == a.QueryHash {
+		t.Errorf("QueryHash of different queries = %q, want them to differ", c.QueryHash)
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "example.com", want: "example.com"},
+		{host: "www.example.com", want: "example.com"},
+		{host: "a.b.c.example.com", want: "example.com"},
+		{host: "www.example.co.uk", want: "example.co.uk"},
+		{host: "shop.example.com.au", want: "example.com.au"},
+		{host: "www.city.kawasaki.jp", want: "city.kawasaki.jp"},
+		{host: "someone.github.io", want: "someone.github.io"},
+		{host: "docs.someone.github.io", want: "someone.github.io"},
+		{host: "example.verylongunknowntld", want: "example.verylongunknowntld"},
+		{host: "co.uk", want: "co.uk"},
+		{host: "com", want: "com"},
+		{host: "localhost", want: "localhost"},
+		{host: "192.168.1.10", want: "192.168.1.10"},
+		{host: "2001:db8::1", want: "2001:db8::1"},
+		{host: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := RegistrableDomain(tt.host); got != tt.want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
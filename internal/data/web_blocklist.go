@@ -2,12 +2,8 @@ package data
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
-	"slices"
-	"strings"
+	"time"
 )
 
 const webBlocklistFile = "web_blocklist.json"
@@ -17,131 +13,112 @@ type WebBlocklistDetails struct {
 	Domain  string `json:"domain"`
 	Title   string `json:"title"`
 	IconURL string `json:"iconUrl"`
+	BlocklistMeta
+	// RemainingSeconds is how long a temporary block still applies. It is omitted for permanent blocks.
+	RemainingSeconds int64 `json:"remaining_seconds,omitempty"`
 }
 
 // GetBlockedWebsitesWithDetails loads the web blocklist and enriches it with metadata from the database.
 func GetBlockedWebsitesWithDetails(db *sql.DB) ([]WebBlocklistDetails, error) {
-	domains, err := LoadWebBlocklist()
+	entries, err := LoadWebBlocklistEntries()
 	if err != nil {
-		return nil, fmt.Errorf("could not load web blocklist domains: %w", err)
+		return nil, fmt.Errorf("could not load web blocklist entries: %w", err)
 	}
 
-	if len(domains) == 0 {
-		return []WebBlocklistDetails{}, nil
-	}
-
-	details := make([]WebBlocklistDetails, 0, len(domains))
-	for _, domain := range domains {
-		meta, err := GetWebMetadata(db, domain)
-		if err != nil {
-			GetLogger().Printf("Error querying web metadata for %s: %v", domain, err)
-			details = append(details, WebBlocklistDetails{Domain: domain})
-			continue
+	now := time.Now()
+	details := make([]WebBlocklistDetails, 0, len(entries))
+	for _, entry := range entries {
+		detail := WebBlocklistDetails{
+			Domain:           entry.Name,
+			BlocklistMeta:    entry.BlocklistMeta,
+			RemainingSeconds: int64(entry.Remaining(now).Seconds()),
 		}
-		if meta != nil {
-			details = append(details, WebBlocklistDetails{
-				Domain:  domain,
-				Title:   meta.Title,
-				IconURL: meta.IconURL,
-			})
-		} else {
-			details = append(details, WebBlocklistDetails{Domain: domain})
+		meta, err := GetWebMetadata(db, entry.Name)
+		if err != nil {
+			GetLogger().Error("Error querying web metadata", "domain", entry.Name, "err", err)
+		} else if meta != nil {
+			detail.Title = meta.Title
+			detail.IconURL = meta.IconURL
 		}
+		details = append(details, detail)
 	}
 
 	return details, nil
 }
 
-// LoadWebBlocklist reads the web blocklist file from the user's cache directory.
-// It returns a slice of strings, with all entries normalized to lowercase for case-insensitive matching.
-// If the file doesn't exist, it returns an empty list, which is not considered an error.
+// LoadWebBlocklist returns the domains on the web blocklist.
+// All entries are normalized to lowercase for case-insensitive matching.
 func LoadWebBlocklist() ([]string, error) {
-	cacheDir, _ := os.UserCacheDir()
-	p := filepath.Join(cacheDir, "procguard", webBlocklistFile)
-
-	// If the blocklist file doesn't exist, return an empty list.
-	b, err := os.ReadFile(p)
-	if os.IsNotExist(err) {
-		return []string{}, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	var list []string
-	if err := json.Unmarshal(b, &list); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal web blocklist: %w", err)
-	}
+	return webBlocklistTable.names()
+}
 
-	// Normalize all entries to lowercase for case-insensitive comparison.
-	for i := range list {
-		list[i] = strings.ToLower(list[i])
-	}
-	return list, nil
+// LoadWebBlocklistEntries returns every entry on the web blocklist together with its metadata.
+func LoadWebBlocklistEntries() ([]BlocklistEntry, error) {
+	return webBlocklistTable.entries()
 }
 
-// SaveWebBlocklist writes the given list of strings to the web blocklist file.
-// It normalizes all entries to lowercase before saving to ensure consistency.
+// SaveWebBlocklist replaces the web blocklist with the given list of domains.
+// Domains that were already on the list keep their metadata.
 func SaveWebBlocklist(list []string) error {
-	// Normalize all entries to lowercase to ensure consistency.
-	for i := range list {
-		list[i] = strings.ToLower(list[i])
-	}
-
-	cacheDir, _ := os.UserCacheDir()
-	_ = os.MkdirAll(filepath.Join(cacheDir, "procguard"), 0755)
-	p := filepath.Join(cacheDir, "procguard", webBlocklistFile)
+	return webBlocklistTable.replace(list)
+}
 
-	// Marshal the list to JSON with indentation for readability.
-	b, err := json.MarshalIndent(list, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal web blocklist: %w", err)
-	}
-	return os.WriteFile(p, b, 0600)
+// AddWebsitesToBlocklist adds the given entries to the web blocklist in a single transaction
+// and returns the number of entries that were not already on it.
+func AddWebsitesToBlocklist(entries []BlocklistEntry) (int, error) {
+	return webBlocklistTable.add(entries)
 }
 
 // AddWebsiteToBlocklist adds a domain to the web blocklist if it's not already there.
 func AddWebsiteToBlocklist(domain string) (string, error) {
-	list, err := LoadWebBlocklist()
+	return addWebsite(BlocklistEntry{Name: domain})
+}
+
+// addWebsite adds a single entry and reports whether it was new, like AddWebsiteToBlocklist.
+func addWebsite(entry BlocklistEntry) (string, error) {
+	added, err := AddWebsitesToBlocklist([]BlocklistEntry{entry})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("save: %w", err)
 	}
-
-	lowerDomain := strings.ToLower(domain)
-	if slices.Contains(list, lowerDomain) {
+	if added == 0 {
 		return "exists", nil
 	}
-
-	list = append(list, lowerDomain)
-	if err := SaveWebBlocklist(list); err != nil {
-		return "", fmt.Errorf("save: %w", err)
-	}
-
 	return "added", nil
 }
 
+// AddWebsiteToBlocklistBy adds a domain to the web blocklist, recording where the request came from.
+func AddWebsiteToBlocklistBy(domain, addedBy string) (string, error) {
+	return addWebsite(BlocklistEntry{Name: domain, BlocklistMeta: BlocklistMeta{AddedBy: addedBy}})
+}
+
 // RemoveWebsiteFromBlocklist removes a domain from the web blocklist.
 func RemoveWebsiteFromBlocklist(domain string) (string, error) {
-	list, err := LoadWebBlocklist()
+	removed, err := webBlocklistTable.remove([]string{domain})
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("save: %w", err)
 	}
-
-	lowerDomain := strings.ToLower(domain)
-	idx := slices.Index(list, lowerDomain)
-	if idx == -1 {
+	if removed == 0 {
 		return "not found", nil
 	}
-
-	list = slices.Delete(list, idx, idx+1)
-	if err := SaveWebBlocklist(list); err != nil {
-		return "", fmt.Errorf("save: %w", err)
-	}
-
 	return "removed", nil
 }
 
 // ClearWebBlocklist removes all entries from the web blocklist.
 func ClearWebBlocklist() error {
-	return SaveWebBlocklist([]string{})
+	return webBlocklistTable.clear()
+}
+
+// MarshalWebBlocklist returns the web blocklist as a JSON document for export.
+func MarshalWebBlocklist() ([]byte, error) {
+	b, err := webBlocklistTable.export()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal web blocklist: %w", err)
+	}
+	return b, nil
+}
+
+// ImportWebBlocklistJSON merges an exported web blocklist into the current one
+// and returns the number of entries that were added.
+func ImportWebBlocklistJSON(content []byte) (int, error) {
+	return webBlocklistTable.importJSON(content)
 }
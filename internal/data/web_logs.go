@@ -2,66 +2,25 @@ package data
 
 import (
 	"database/sql"
-	"time"
 )
 
-// GetWebLogs retrieves web logs from the database within a given time range.
+// GetWebLogs retrieves web logs from the database within a given time range and returns them all at once,
+// in the legacy array format. New code should use QueryWebEvents, which pages through typed results.
 // It returns a slice of string slices, where each inner slice represents a row with the following format:
-// [Timestamp, URL]
-func GetWebLogs(db *sql.DB, since, until string) ([][]string, error) {
-	var sinceTime, untilTime time.Time
-	var err error
-
-	if since != "" {
-		sinceTime, err = ParseTime(since)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if until != "" {
-		untilTime, err = ParseTime(until)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Build the SQL query dynamically based on the provided time filters.
-	q := "SELECT url, timestamp FROM web_events WHERE 1=1"
-	args := make([]interface{}, 0)
-
-	if !sinceTime.IsZero() {
-		q += " AND timestamp >= ?"
-		args = append(args, sinceTime.Unix())
-	}
-
-	if !untilTime.IsZero() {
-		q += " AND timestamp <= ?"
-		args = append(args, untilTime.Unix())
-	}
-
-	q += " ORDER BY timestamp DESC"
-
-	rows, err := db.Query(q, args...)
+// [Timestamp, URL, Title], followed by an HTML snippet with the matches highlighted when filter has free text.
+func GetWebLogs(db *sql.DB, filter *EventQuery, since, until string) ([][]string, error) {
+	page, err := queryWebEvents(db, filter, since, until, PageRequest{}, true)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			GetLogger().Printf("Failed to close rows: %v", err)
-		}
-	}()
 
 	var entries [][]string
-	for rows.Next() {
-		var url string
-		var timestamp int64
-		if err := rows.Scan(&url, &timestamp); err != nil {
-			continue
+	for _, ev := range page.Events {
+		entry := []string{ev.Timestamp.Format(legacyTimeLayout), ev.URL, ev.Title}
+		if filter.ftsMatch() != "" {
+			entry = append(entry, ev.Snippet)
 		}
-		timestampStr := time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
-		entries = append(entries, []string{timestampStr, url})
+		entries = append(entries, entry)
 	}
-
 	return entries, nil
 }
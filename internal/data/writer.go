@@ -0,0 +1,368 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// writeQueueSize is the number of write requests that can be queued before callers have to wait.
+	writeQueueSize = 1000
+	// maxBatchSize is the maximum number of statements committed in a single transaction.
+	maxBatchSize = 200
+	// batchWindow is how long the writer keeps collecting statements after the first one of a batch arrives.
+	batchWindow = 200 * time.Millisecond
+	// enqueueTimeout is how long EnqueueWrite waits for space in a full queue before dropping the write.
+	enqueueTimeout = 5 * time.Second
+)
+
+// WriteRequest represents a request to write to the database.
+type WriteRequest struct {
+	Query string
+	Args  []interface{}
+	// standalone requests run outside of a transaction, for statements such as VACUUM that cannot run inside one.
+	standalone bool
+	// fn, if set, replaces Query: it runs in a transaction of its own, for writes that depend on what they read.
+	fn func(tx *sql.Tx) error
+	// flushed is set on the marker queued by Flush, and is closed once every earlier request has been written.
+	flushed chan struct{}
+}
+
+// WriterStats reports the state of the database writer.
+type WriterStats struct {
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+	// Written is the number of statements that were executed successfully.
+	Written int64 `json:"written"`
+	// Failed is the number of statements that returned an error.
+	Failed int64 `json:"failed"`
+	// Batches is the number of transactions committed.
+	Batches       int64 `json:"batches"`
+	LastBatchSize int64 `json:"last_batch_size"`
+	// Overflows is the number of times a caller found the queue full.
+	Overflows int64 `json:"overflows"`
+	// Dropped is the number of writes that were discarded because the queue stayed full.
+	Dropped int64 `json:"dropped"`
+}
+
+// writerMetrics holds the counters reported by GetWriterStats.
+var writerMetrics struct {
+	written, failed, batches, lastBatchSize, overflows, dropped atomic.Int64
+}
+
+// writerState tells whether the writer has stopped, after which new writes are dropped instead of queued.
+// Senders hold the read lock from checking stopped until their request is in the queue, so once the writer has set
+// stopped under the write lock, every request that made it into the queue is there and no other can follow.
+var writerState struct {
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// describe returns what a request does, for log messages.
+func (req WriteRequest) describe() string {
+	switch {
+	case req.fn != nil:
+		return "write transaction"
+	case req.flushed != nil:
+		return "flush"
+	default:
+		return "write: " + req.Query
+	}
+}
+
+// StartDatabaseWriter listens for write requests on the writeCh channel and executes them against the database
+// until ctx is cancelled. Requests that arrive close together are committed in a single transaction,
+// which is much cheaper than one implicit transaction per statement.
+// On cancellation, whatever is still queued is written before it returns.
+func StartDatabaseWriter(ctx context.Context, db *sql.DB) {
+	batch := make([]WriteRequest, 0, maxBatchSize)
+	for {
+		var req WriteRequest
+		select {
+		case <-ctx.Done():
+			drainWriteQueue(db)
+			return
+		case req = <-writeCh:
+		}
+		batch = append(batch[:0], req)
+
+		// Keep collecting until the batch is full or the window closes. A flush ends the batch early,
+		// so Flush never has to wait for the window.
+		timer := time.NewTimer(batchWindow)
+	collect:
+		for len(batch) < maxBatchSize && req.flushed == nil {
+			select {
+			case req = <-writeCh:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		writeBatch(db, batch)
+	}
+}
+
+// drainWriteQueue stops the writer from accepting requests, then writes every request that was queued before,
+// in batches of at most maxBatchSize.
+func drainWriteQueue(db *sql.DB) {
+	// Senders waiting for space in a full queue hold the read lock, so the queue is emptied while the lock is taken.
+	stopped := make(chan struct{})
+	go func() {
+		writerState.mu.Lock()
+		writerState.stopped = true
+		writerState.mu.Unlock()
+		close(stopped)
+	}()
+
+	batch := make([]WriteRequest, 0, maxBatchSize)
+	add := func(req WriteRequest) {
+		batch = append(batch, req)
+		if len(batch) == maxBatchSize {
+			writeBatch(db, batch)
+			batch = batch[:0]
+		}
+	}
+	for waiting := true; waiting; {
+		select {
+		case req := <-writeCh:
+			add(req)
+		case <-stopped:
+			waiting = false
+		}
+	}
+	// Nothing can be queued any more, so whatever is left is the rest of the queue.
+	for {
+		select {
+		case req := <-writeCh:
+			add(req)
+		default:
+			writeBatch(db, batch)
+			return
+		}
+	}
+}
+
+// writeBatch executes a batch of requests in order, then releases any Flush waiting on it.
+func writeBatch(db *sql.DB, batch []WriteRequest) {
+	start := 0
+	for i, req := range batch {
+		switch {
+		case req.standalone:
+			execInTx(db, batch[start:i])
+			execEach(db, batch[i:i+1])
+			start = i + 1
+		case req.fn != nil:
+			execInTx(db, batch[start:i])
+			execFunc(db, req.fn)
+			start = i + 1
+		}
+	}
+	execInTx(db, batch[start:])
+
+	for _, req := range batch {
+		if req.flushed != nil {
+			close(req.flushed)
+		}
+	}
+}
+
+// execInTx executes the statements of reqs in a single transaction.
+// If any statement fails, the transaction is rolled back and the statements are retried one by one,
+// so a single bad statement doesn't cost the rest of the batch.
+func execInTx(db *sql.DB, reqs []WriteRequest) {
+	n := 0
+	for _, req := range reqs {
+		if req.Query != "" {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	err := func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, req := range reqs {
+			if req.Query == "" {
+				continue
+			}
+			if _, err := tx.Exec(req.Query, req.Args...); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		// We can't use the normal logger here as it might create a deadlock.
+		log.Printf("[ERROR] Failed to commit batch of %d writes, retrying individually: %v", n, err)
+		execEach(db, reqs)
+		return
+	}
+
+	writerMetrics.written.Add(int64(n))
+	writerMetrics.batches.Add(1)
+	writerMetrics.lastBatchSize.Store(int64(n))
+}
+
+// execEach executes the statements of reqs one at a time, each in its own implicit transaction.
+func execEach(db *sql.DB, reqs []WriteRequest) {
+	for _, req := range reqs {
+		if req.Query == "" {
+			continue
+		}
+		if _, err := db.Exec(req.Query, req.Args...); err != nil {
+			// If we can't write to the DB, log the failure.
+			// We can't use the normal logger here as it might create a deadlock.
+			log.Printf("[ERROR] Failed to execute write request: %v", err)
+			writerMetrics.failed.Add(1)
+			continue
+		}
+		writerMetrics.written.Add(1)
+	}
+}
+
+// execFunc runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise.
+func execFunc(db *sql.DB, fn func(tx *sql.Tx) error) {
+	err := func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		// We can't use the normal logger here as it might create a deadlock.
+		log.Printf("[ERROR] Failed to execute write transaction: %v", err)
+		writerMetrics.failed.Add(1)
+		return
+	}
+	writerMetrics.written.Add(1)
+	writerMetrics.batches.Add(1)
+	writerMetrics.lastBatchSize.Store(1)
+}
+
+// EnqueueWrite sends a write request to the database writer.
+// When the queue is full it waits up to enqueueTimeout for space, then drops the write so callers never hang.
+func EnqueueWrite(query string, args ...interface{}) {
+	enqueue(WriteRequest{Query: query, Args: args})
+}
+
+// EnqueueStandaloneWrite queues a statement that must run outside of a transaction, such as VACUUM.
+func EnqueueStandaloneWrite(query string) {
+	enqueue(WriteRequest{Query: query, standalone: true})
+}
+
+// enqueueTx queues fn to run in a transaction of its own on the writer, in order with every other write.
+// It is for writes that must see the effect of the writes queued before them.
+func enqueueTx(fn func(tx *sql.Tx) error) {
+	enqueue(WriteRequest{fn: fn})
+}
+
+// TryEnqueueWrite queues a write request without waiting. It returns false, and drops the write,
+// if the queue is full. It is meant for callers like the logger that must never block.
+func TryEnqueueWrite(query string, args ...interface{}) bool {
+	writerState.mu.RLock()
+	defer writerState.mu.RUnlock()
+	if writerState.stopped {
+		writerMetrics.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case writeCh <- WriteRequest{Query: query, Args: args}:
+		return true
+	default:
+		writerMetrics.overflows.Add(1)
+		writerMetrics.dropped.Add(1)
+		return false
+	}
+}
+
+// enqueue sends req to the writer, applying backpressure when the queue is full.
+func enqueue(req WriteRequest) {
+	writerState.mu.RLock()
+	defer writerState.mu.RUnlock()
+	if writerState.stopped {
+		writerMetrics.dropped.Add(1)
+		log.Printf("[ERROR] Database writer has stopped, dropping %s", req.describe())
+		return
+	}
+
+	select {
+	case writeCh <- req:
+		return
+	default:
+	}
+
+	writerMetrics.overflows.Add(1)
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case writeCh <- req:
+	case <-timer.C:
+		writerMetrics.dropped.Add(1)
+		log.Printf("[ERROR] Database write queue is full, dropping %s", req.describe())
+	}
+}
+
+// Flush waits until every write queued before the call has been committed, or until ctx is done.
+// It should be called before the database is closed, so queued writes aren't lost at exit.
+func Flush(ctx context.Context) error {
+	done, err := queueFlush(ctx)
+	if err != nil || done == nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queueFlush queues the marker that Flush waits for. It returns a nil channel if the writer has stopped,
+// since everything was written then.
+func queueFlush(ctx context.Context) (chan struct{}, error) {
+	writerState.mu.RLock()
+	defer writerState.mu.RUnlock()
+	if writerState.stopped {
+		return nil, nil
+	}
+
+	done := make(chan struct{})
+	select {
+	case writeCh <- WriteRequest{flushed: done}:
+		return done, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetWriterStats returns the current queue depth and the counters of the database writer.
+func GetWriterStats() WriterStats {
+	return WriterStats{
+		QueueDepth:    len(writeCh),
+		QueueCapacity: cap(writeCh),
+		Written:       writerMetrics.written.Load(),
+		Failed:        writerMetrics.failed.Load(),
+		Batches:       writerMetrics.batches.Load(),
+		LastBatchSize: writerMetrics.lastBatchSize.Load(),
+		Overflows:     writerMetrics.overflows.Load(),
+		Dropped:       writerMetrics.dropped.Load(),
+	}
+}
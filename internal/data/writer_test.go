@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// insertWriterTest is the statement the writer tests queue, with the value to insert as its parameter.
+const insertWriterTest = "INSERT INTO writer_test (n) VALUES (?)"
+
+// writerTestValues returns the values of writer_test in the order they were inserted.
+func writerTestValues(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	rows, err := db.Query("SELECT n FROM writer_test ORDER BY seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}()
+	values := []int{}
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, n)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestDatabaseWriter(t *testing.T) {
+	db := openTestDB(t)
+
+	many := make([]int, maxBatchSize*2+50)
+	for i := range many {
+		many[i] = i + 1
+	}
+
+	tests := []struct {
+		name  string
+		queue func()
+		want  []int
+		// wantFailed is the number of writes that fail.
+		wantFailed int64
+		// minBatches and maxBatches bound the number of transactions the statements are committed in.
+		// Writes queued by other parts of the package may share them, so only the bounds are exact.
+		minBatches, maxBatches int64
+	}{
+		{
+			name: "statements queued together share a transaction",
+			queue: func() {
+				for n := 1; n <= 5; n++ {
+					EnqueueWrite(insertWriterTest, n)
+				}
+			},
+			want:       []int{1, 2, 3, 4, 5},
+			minBatches: 1,
+			maxBatches: 2,
+		},
+		{
+			name: "a batch holds at most maxBatchSize statements",
+			queue: func() {
+				for _, n := range many {
+					EnqueueWrite(insertWriterTest, n)
+				}
+			},
+			want:       many,
+			minBatches: 3,
+			maxBatches: int64(len(many)),
+		},
+		{
+			name: "a failing statement doesn't cost the rest of the batch",
+			queue: func() {
+				EnqueueWrite(insertWriterTest, 1)
+				EnqueueWrite("INSERT INTO missing_table (n) VALUES (?)", 2)
+				EnqueueWrite(insertWriterTest, 3)
+			},
+			want:       []int{1, 3},
+			wantFailed: 1,
+			maxBatches: 3,
+		},
+		{
+			name: "a transaction sees the writes queued before it",
+			queue: func() {
+				EnqueueWrite(insertWriterTest, 1)
+				EnqueueWrite(insertWriterTest, 2)
+				enqueueTx(func(tx *sql.Tx) error {
+					var count int
+					if err := tx.QueryRow("SELECT COUNT(*) FROM writer_test").Scan(&count); err != nil {
+						return err
+					}
+					_, err := tx.Exec(insertWriterTest, count+1)
+					return err
+				})
+				EnqueueWrite(insertWriterTest, 4)
+			},
+			want:       []int{1, 2, 3, 4},
+			minBatches: 2,
+			maxBatches: 4,
+		},
+		{
+			name: "a standalone statement runs between the batches around it",
+			queue: func() {
+				EnqueueWrite(insertWriterTest, 1)
+				EnqueueStandaloneWrite("INSERT INTO writer_test (n) VALUES (2)")
+				EnqueueWrite(insertWriterTest, 3)
+			},
+			want:       []int{1, 2, 3},
+			minBatches: 2,
+			maxBatches: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execTest(t, db, "CREATE TABLE writer_test (seq INTEGER PRIMARY KEY AUTOINCREMENT, n INTEGER NOT NULL)")
+			t.Cleanup(func() {
+				execTest(t, db, "DROP TABLE writer_test")
+			})
+
+			before := GetWriterStats()
+			tt.queue()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := Flush(ctx); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			after := GetWriterStats()
+
+			// Flush returns once every write queued before it has been committed.
+			if got := writerTestValues(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values after Flush = %v, want %v", got, tt.want)
+			}
+			if failed := after.Failed - before.Failed; failed != tt.wantFailed {
+				t.Errorf("%d writes failed, want %d", failed, tt.wantFailed)
+			}
+			if batches := after.Batches - before.Batches; batches < tt.minBatches || batches > tt.maxBatches {
+				t.Errorf("committed in %d transactions, want %d to %d", batches, tt.minBatches, tt.maxBatches)
+			}
+		})
+	}
+}
@@ -2,12 +2,13 @@ package ipc
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"procguard/internal/data"
 	"time"
 )
 
-// HandleLogWebEvent handles requests from internal components to log a visited web URL.
+// HandleLogWebEvent handles requests from internal components to log a visited web URL and the title of the page.
 func HandleLogWebEvent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
@@ -15,7 +16,8 @@ func HandleLogWebEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var payload struct {
-		URL string `json:"url"`
+		URL   string `json:"url"`
+		Title string `json:"title"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -28,7 +30,7 @@ func HandleLogWebEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data.EnqueueWrite("INSERT INTO web_events (url, timestamp) VALUES (?, ?)", payload.URL, time.Now().Unix())
+	data.LogWebEvent(payload.URL, payload.Title, time.Now().Unix())
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -65,7 +67,8 @@ func HandleGetWebBlocklist(w http.ResponseWriter, r *http.Request) {
 	}
 
 	list, err := data.LoadWebBlocklist()
-	if err != nil {
+	// A tampered blocklist still comes with the last list that can be trusted, which stays enforced.
+	if err != nil && !errors.Is(err, data.ErrPolicyTampered) {
 		http.Error(w, "Failed to load web blocklist", http.StatusInternalServerError)
 		return
 	}
@@ -73,6 +76,6 @@ func HandleGetWebBlocklist(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(list); err != nil {
 		// Log the error, but don't return an HTTP error as the header might already be sent.
-		data.GetLogger().Printf("Error encoding web blocklist response: %v", err)
+		data.GetLogger().Error("Error encoding web blocklist response", "err", err)
 	}
 }
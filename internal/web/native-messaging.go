@@ -2,8 +2,10 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"os"
@@ -13,11 +15,18 @@ import (
 	"time"
 )
 
-const (
-	// pollInterval is the interval at which the web blocklist is polled for changes.
-	pollInterval = 2 * time.Second
-	internalAPI  = "http://127.0.0.1:58142"
-)
+// pollInterval is the interval at which the web blocklist is polled for changes.
+const pollInterval = 2 * time.Second
+
+// internalAPI returns the base URL of the internal API of the ProcGuard instance this host belongs to.
+func internalAPI() string {
+	return "http://" + data.Runtime().IPCAddr
+}
+
+// guiURL returns the URL of the web GUI of the ProcGuard instance this host belongs to.
+func guiURL() string {
+	return "http://" + data.Runtime().GUIAddr
+}
 
 // WebMetadataPayload is the payload for the log_web_metadata message from the extension.
 type WebMetadataPayload struct {
@@ -26,6 +35,23 @@ type WebMetadataPayload struct {
 	IconURL string `json:"iconUrl"`
 }
 
+// LogURLPayload is the payload for the log_url message from the extension.
+type LogURLPayload struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// UnmarshalJSON accepts both the {"url", "title"} object and the bare URL string sent by older extensions.
+func (p *LogURLPayload) UnmarshalJSON(b []byte) error {
+	var url string
+	if err := json.Unmarshal(b, &url); err == nil {
+		*p = LogURLPayload{URL: url}
+		return nil
+	}
+	type plain LogURLPayload
+	return json.Unmarshal(b, (*plain)(p))
+}
+
 // Request is a message received from the browser extension.
 type Request struct {
 	Type    string          `json:"type"`
@@ -39,13 +65,37 @@ type Response struct {
 }
 
 // Run starts the native messaging host, which listens for messages from the browser extension.
-func Run() {
-	log := data.GetLogger()
+// It returns when the extension disconnects or ctx is cancelled.
+func Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Tell the extension where the GUI of this instance is, so it can recognize its pages.
+	sendMessage(Response{
+		Type:    "instance",
+		Payload: map[string]string{"gui_url": guiURL()},
+	})
 
 	// Start a goroutine to poll the web blocklist and push updates to the extension.
-	go pollWebBlocklist()
+	go pollWebBlocklist(ctx)
+
+	// Reading from stdin can't be interrupted, so the read loop runs on its own and is abandoned on cancellation.
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		readMessages()
+	}()
+
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+		data.GetLogger().Println("Shutting down native messaging host.")
+	}
+}
 
-	// The main loop reads messages from stdin, which is connected to the browser extension.
+// readMessages reads messages from stdin, which is connected to the browser extension, until EOF.
+func readMessages() {
+	log := data.GetLogger()
 	for {
 		// The native messaging protocol prefixes each message with its length in bytes.
 		var length uint32
@@ -84,20 +134,20 @@ func Run() {
 			}
 			sendMessage(resp)
 		case "log_url":
-			var url string
-			if err := json.Unmarshal(req.Payload, &url); err != nil {
+			var payload LogURLPayload
+			if err := json.Unmarshal(req.Payload, &payload); err != nil {
 				log.Printf("Error unmarshalling log_url payload: %v", err)
 				continue
 			}
 			// Ignore logging the app's own GUI.
-			if strings.HasPrefix(url, "http://127.0.0.1:58141") {
+			if strings.HasPrefix(payload.URL, guiURL()) {
 				continue
 			}
 
 			// Send the URL to the internal API
-			go func(u string) {
-				jsonData, _ := json.Marshal(map[string]string{"url": u})
-				resp, err := http.Post(internalAPI+"/log-web-event", "application/json", bytes.NewBuffer(jsonData))
+			go func(p LogURLPayload) {
+				jsonData, _ := json.Marshal(p)
+				resp, err := http.Post(internalAPI()+"/log-web-event", "application/json", bytes.NewBuffer(jsonData))
 				if err != nil {
 					log.Printf("Failed to send web event to internal API: %v", err)
 					return
@@ -105,7 +155,7 @@ func Run() {
 				if err := resp.Body.Close(); err != nil {
 					log.Printf("Failed to close response body: %v", err)
 				}
-			}(url)
+			}(payload)
 
 		case "log_web_metadata":
 			var payload WebMetadataPayload
@@ -115,7 +165,7 @@ func Run() {
 			}
 			go func(p WebMetadataPayload) {
 				jsonData, _ := json.Marshal(p)
-				resp, err := http.Post(internalAPI+"/log-web-metadata", "application/json", bytes.NewBuffer(jsonData))
+				resp, err := http.Post(internalAPI()+"/log-web-metadata", "application/json", bytes.NewBuffer(jsonData))
 				if err != nil {
 					log.Printf("Failed to send web metadata to internal API: %v", err)
 					return
@@ -128,7 +178,10 @@ func Run() {
 			list, err := data.LoadWebBlocklist()
 			if err != nil {
 				log.Printf("Error loading web blocklist: %v", err)
-				continue
+				// A tampered blocklist still comes with the last list that can be trusted, which stays enforced.
+				if !errors.Is(err, data.ErrPolicyTampered) {
+					continue
+				}
 			}
 			resp := Response{
 				Type:    "web_blocklist",
@@ -141,7 +194,7 @@ func Run() {
 				log.Printf("Error unmarshalling add_to_web_blocklist payload: %v", err)
 				continue
 			}
-			if _, err := data.AddWebsiteToBlocklist(domain); err != nil {
+			if _, err := data.AddWebsiteToBlocklistBy(domain, data.AddedByExtension); err != nil {
 				log.Printf("Error adding to web blocklist: %v", err)
 			}
 		default:
@@ -151,25 +204,31 @@ func Run() {
 }
 
 // pollWebBlocklist periodically checks for changes in the web blocklist and sends updates to the extension.
-func pollWebBlocklist() {
+func pollWebBlocklist(ctx context.Context) {
 	log := data.GetLogger()
 	var lastBlocklist []string
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		resp, err := http.Get(internalAPI + "/get-web-blocklist")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resp, err := http.Get(internalAPI() + "/get-web-blocklist")
 		if err != nil {
 			log.Printf("Failed to get web blocklist from internal API: %v", err)
 			continue
 		}
+		var list []string
+		decodeErr := json.NewDecoder(resp.Body).Decode(&list)
 		if err := resp.Body.Close(); err != nil {
 			log.Printf("Failed to close response body: %v", err)
 		}
-
-		var list []string
-		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
-			log.Printf("Failed to decode web blocklist from internal API: %v", err)
+		if decodeErr != nil {
+			log.Printf("Failed to decode web blocklist from internal API: %v", decodeErr)
 			continue
 		}
 
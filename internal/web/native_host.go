@@ -7,8 +7,6 @@ import (
 	"path/filepath"
 
 	"procguard/internal/data"
-
-	"golang.org/x/sys/windows/registry"
 )
 
 const (
@@ -17,54 +15,145 @@ const (
 	HostName = "com.nixuris.procguard"
 )
 
-// InstallNativeHost sets up the native messaging host for Chrome by creating a registry key
-// that points to a manifest file. This allows the browser extension to communicate with the application.
+// InstallNativeHost sets up the native messaging host for Chrome by writing its manifest file and registering it
+// where Chrome looks for it (see registerNativeHost). This allows the browser extension to communicate with the application.
 func InstallNativeHost(exePath, extensionId string) error {
 	log := data.GetLogger()
-	keyPath := `SOFTWARE\Google\Chrome\NativeMessagingHosts\` + HostName
-
-	// Create the registry key that Chrome will use to find the native messaging host.
-	k, _, err := registry.CreateKey(registry.CURRENT_USER, keyPath, registry.SET_VALUE)
-	if err != nil {
-		log.Printf("Failed to create registry key: %v", err)
-		return fmt.Errorf("failed to create registry key: %w", err)
-	}
-	defer func() {
-		if err := k.Close(); err != nil {
-			log.Printf("Failed to close registry key: %v", err)
-		}
-	}()
 
 	// The manifest file must be stored in a location that the user has access to.
-	// The user's cache directory is a good choice.
-	cacheDir, err := os.UserCacheDir()
+	// The data directory is a good choice.
+	manifestPath, err := ManifestPath()
 	if err != nil {
-		log.Printf("Failed to get user cache dir: %v", err)
-		return fmt.Errorf("failed to get user cache dir: %w", err)
+		log.Printf("Failed to get manifest path: %v", err)
+		return fmt.Errorf("failed to get manifest path: %w", err)
 	}
-	appDataDir := filepath.Join(cacheDir, "procguard")
-	configDir := filepath.Join(appDataDir, "config")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
 		log.Printf("Failed to create config directory: %v", err)
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
 	// Create the manifest file that describes the native messaging host.
-	manifestPath := filepath.Join(configDir, "native-host.json")
 	if err := CreateManifest(manifestPath, exePath, extensionId); err != nil {
 		log.Printf("Failed to create manifest file: %v", err)
 		return fmt.Errorf("failed to create manifest file: %w", err)
 	}
 
-	// Set the default value of the registry key to the path of the manifest file.
-	if err := k.SetStringValue("", manifestPath); err != nil {
-		log.Printf("Failed to set registry key value: %v", err)
-		return fmt.Errorf("failed to set registry key value: %w", err)
+	if err := registerNativeHost(manifestPath); err != nil {
+		return err
+	}
+
+	// Chrome starts the host without the flags or environment of this instance, so the host finds it through this file.
+	if err := writeInstanceFile(); err != nil {
+		log.Printf("Failed to write native host instance file: %v", err)
+		return fmt.Errorf("failed to write native host instance file: %w", err)
+	}
+	return nil
+}
+
+// RemoveNativeHost removes the native messaging host configuration from the system.
+func RemoveNativeHost() error {
+	if err := unregisterNativeHost(); err != nil {
+		return err
+	}
+
+	// Delete the manifest file.
+	manifestPath, err := ManifestPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeInstanceFile()
+}
+
+// InstanceFilePath returns the path of the file that tells the native messaging host which instance registered it.
+// Chrome knows a single host per name and user, so there is a single file per user as well, outside of any data directory.
+func InstanceFilePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "procguard", "native-host-instance.json"), nil
+}
+
+// NativeHostArgs returns the arguments that make data.ResolveRuntimeConfig resolve the runtime configuration
+// of the instance that registered the native messaging host, which Chrome starts without them.
+// An explicit PROCGUARD_CONFIG takes precedence, and without an instance file the defaults apply.
+func NativeHostArgs(getenv func(string) string) []string {
+	if getenv(data.EnvConfigFile) != "" {
+		return nil
+	}
+	path, err := InstanceFilePath()
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return []string{"--config", path}
+}
+
+// writeInstanceFile records the runtime configuration of this instance in the instance file, in the format
+// of a runtime config file.
+func writeInstanceFile() error {
+	path, err := InstanceFilePath()
+	if err != nil {
+		return err
+	}
+	cfg := data.Runtime()
+	if cfg.DataDir, err = filepath.Abs(cfg.DataDir); err != nil {
+		return err
+	}
+	if cfg.LogPath != "" {
+		if cfg.LogPath, err = filepath.Abs(cfg.LogPath); err != nil {
+			return err
+		}
 	}
 
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return data.WriteFileAtomic(path, append(content, '\n'), 0644)
+}
+
+// removeInstanceFile removes the instance file if it names this instance, so that uninstalling one instance
+// doesn't disconnect the extension from another one that registered later.
+func removeInstanceFile() error {
+	path, err := InstanceFilePath()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var registered data.RuntimeConfig
+	if err := json.Unmarshal(content, &registered); err != nil {
+		return os.Remove(path)
+	}
+	dataDir, err := filepath.Abs(data.Runtime().DataDir)
+	if err != nil || registered.DataDir != dataDir {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
+// ManifestPath returns the path of the native messaging host manifest in the data directory.
+func ManifestPath() (string, error) {
+	return data.DataPath("config", "native-host.json")
+}
+
 // CreateManifest creates the native messaging host manifest file.
 // This file tells Chrome how to communicate with the native application.
 func CreateManifest(manifestPath, exePath, extensionId string) error {
@@ -78,19 +167,11 @@ func CreateManifest(manifestPath, exePath, extensionId string) error {
 		},
 	}
 
-	file, err := os.Create(manifestPath)
+	content, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close file: %v", err)
-		}
-	}()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(manifest)
+	return data.WriteFileAtomic(manifestPath, append(content, '\n'), 0644)
 }
 
 // RegisterExtension is a convenience function that gets the current executable's path
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package web
+
+import "errors"
+
+// registerNativeHost is only implemented on Windows.
+func registerNativeHost(manifestPath string) error {
+	return errors.New("native messaging host registration is only supported on Windows")
+}
+
+// unregisterNativeHost does nothing on this platform, since registerNativeHost never registers anything.
+func unregisterNativeHost() error {
+	return nil
+}
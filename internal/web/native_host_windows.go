@@ -0,0 +1,46 @@
+//go:build windows
+
+package web
+
+import (
+	"fmt"
+
+	"procguard/internal/data"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// nativeHostKeyPath is the registry key under HKEY_CURRENT_USER that Chrome reads the manifest path from.
+const nativeHostKeyPath = `SOFTWARE\Google\Chrome\NativeMessagingHosts\` + HostName
+
+// registerNativeHost points Chrome to the manifest at manifestPath through a registry key.
+func registerNativeHost(manifestPath string) error {
+	log := data.GetLogger()
+
+	// Create the registry key that Chrome will use to find the native messaging host.
+	k, _, err := registry.CreateKey(registry.CURRENT_USER, nativeHostKeyPath, registry.SET_VALUE)
+	if err != nil {
+		log.Printf("Failed to create registry key: %v", err)
+		return fmt.Errorf("failed to create registry key: %w", err)
+	}
+	defer func() {
+		if err := k.Close(); err != nil {
+			log.Printf("Failed to close registry key: %v", err)
+		}
+	}()
+
+	// Set the default value of the registry key to the path of the manifest file.
+	if err := k.SetStringValue("", manifestPath); err != nil {
+		log.Printf("Failed to set registry key value: %v", err)
+		return fmt.Errorf("failed to set registry key value: %w", err)
+	}
+	return nil
+}
+
+// unregisterNativeHost deletes the registry key created by registerNativeHost.
+func unregisterNativeHost() error {
+	if err := registry.DeleteKey(registry.CURRENT_USER, nativeHostKeyPath); err != nil && err != registry.ErrNotExist {
+		return err
+	}
+	return nil
+}
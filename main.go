@@ -3,12 +3,17 @@ package main
 //go:generate go run github.com/akavel/rsrc -manifest build/procguard.manifest -o build/cache/rsrc.syso
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"procguard/api"
 	"procguard/gui"
 	"procguard/internal/daemon"
@@ -16,62 +21,141 @@ import (
 	"procguard/internal/ipc"
 	"procguard/internal/web"
 	"strings"
-
+	"sync"
+	"syscall"
 	"time"
 )
 
 const (
-	// defaultPort is the port used by the web server.
-	defaultPort = "58141"
-	// internalIPCPort is the port used for internal communication between ProcGuard components.
-	internalIPCPort = "58142"
 	// chromeExtensionID is the ID of the Chrome extension that communicates with the native messaging host.
 	chromeExtensionID = "ilaocldmkhlifnikhinkmiepekpbefoh"
+	// flushTimeout bounds how long the process waits for queued database writes before exiting.
+	flushTimeout = 5 * time.Second
 )
 
 // main is the entry point of the application. It determines the execution mode based on command-line arguments.
 func main() {
+	// When the application is launched by Chrome as a native messaging host,
+	// the first argument is the origin of the extension.
+	nativeHost := len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "chrome-extension://")
+
+	// Resolve the data directory and addresses before anything touches the disk or the network.
+	// Chrome passes its own arguments to the native messaging host, so it is configured through the environment
+	// or the instance file written by the instance that registered it.
+	args := os.Args[1:]
+	if nativeHost {
+		args = web.NativeHostArgs(os.Getenv)
+	}
+	runtimeCfg, err := data.ResolveRuntimeConfig(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	data.SetRuntimeConfig(runtimeCfg)
+
+	if len(runtimeCfg.Args) > 0 {
+		os.Exit(runCommand(runtimeCfg.Args))
+	}
+
+	// A restore staged by the restore command or the API is swapped in before anything opens the database.
+	// The native messaging host and a second instance leave it to the instance that owns the data directory.
+	restored := false
+	if !nativeHost && data.HasPendingRestore() && !isAppRunning("http://"+runtimeCfg.GUIAddr) {
+		if restored, err = data.ApplyPendingRestore(); err != nil {
+			log.Fatalf("Failed to restore backup: %v", err)
+		}
+	}
+
 	db, err := data.InitDB()
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	data.NewLogger(db)
+	if restored {
+		data.GetLogger().Info("Backup restored")
+	}
 
-	// When the application is launched by Chrome as a native messaging host,
-	// the first argument is the origin of the extension.
-	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "chrome-extension://") {
-		runNativeMessagingHost(db)
-		return
+	// The root context is cancelled on SIGINT/SIGTERM or when a subsystem asks to stop (e.g. on uninstall).
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go cancelOnSignal(cancel)
+
+	if nativeHost {
+		runNativeMessagingHost(ctx, db)
+	} else {
+		startGUIApplication(ctx, cancel, db)
 	}
 
-	startGUIApplication(db)
+	shutdown(ctx)
+}
+
+// cancelOnSignal cancels the root context when the process receives SIGINT or SIGTERM.
+func cancelOnSignal(cancel context.CancelCauseFunc) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigCh
+	// Restore the default behavior, so a second signal terminates the process immediately.
+	signal.Stop(sigCh)
+	cancel(fmt.Errorf("received signal %v", sig))
+}
+
+// shutdown runs the last steps of an ordered shutdown, once every service has stopped:
+// it writes out queued database writes, closes the database and the logger,
+// and completes an uninstall, or restarts after a restore, if that is why the application is stopping.
+func shutdown(ctx context.Context) {
+	logger := data.GetLogger()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := data.CloseDB(closeCtx); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	logger.Close()
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, api.ErrUninstall):
+		api.FinishUninstall(logger)
+	case errors.Is(cause, api.ErrRestore):
+		restartApplication()
+	}
+}
+
+// restartApplication starts a new instance of the application with the same arguments,
+// which swaps in the restored backup before it opens the database.
+func restartApplication() {
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Printf("Failed to restart after restore: %v", err)
+		return
+	}
+	if err := exec.Command(exePath, os.Args[1:]...).Start(); err != nil {
+		log.Printf("Failed to restart after restore: %v", err)
+	}
 }
 
 // runNativeMessagingHost starts the application in native messaging host mode,
 // allowing communication with the browser extension.
-func runNativeMessagingHost(db *sql.DB) {
-	web.Run()
+func runNativeMessagingHost(ctx context.Context, db *sql.DB) {
+	web.Run(ctx)
 }
 
-// startAPIServer initializes and starts the API server in a new goroutine.
-func startAPIServer(db *sql.DB) {
-	addr := "127.0.0.1:" + defaultPort
-	go api.StartWebServer(addr, registerWebRoutes, db)
+// startAPIServer runs the API server until ctx is cancelled.
+func startAPIServer(ctx context.Context, cancel context.CancelCauseFunc, db *sql.DB) {
+	api.StartWebServer(ctx, data.Runtime().GUIAddr, registerWebRoutes, db, cancel)
 }
 
-// startInternalAPIServer initializes and starts the internal IPC server in a new goroutine.
-func startInternalAPIServer(db *sql.DB) {
+// startInternalAPIServer runs the internal IPC server until ctx is cancelled.
+func startInternalAPIServer(ctx context.Context) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/log-web-event", ipc.HandleLogWebEvent)
 	mux.HandleFunc("/log-web-metadata", ipc.HandleLogWebMetadata)
 	mux.HandleFunc("/get-web-blocklist", ipc.HandleGetWebBlocklist)
 
-	addr := "127.0.0.1:" + internalIPCPort
-	go func() {
-		if err := http.ListenAndServe(addr, mux); err != nil {
-			data.GetLogger().Fatalf("Failed to start internal API server: %v", err)
-		}
-	}()
+	if err := api.ServeUntilDone(ctx, &http.Server{Addr: data.Runtime().IPCAddr, Handler: mux}); err != nil {
+		data.GetLogger().Fatalf("Failed to start internal API server: %v", err)
+	}
 }
 
 // registerWebRoutes sets up the routes for the web server.
@@ -85,40 +169,41 @@ func registerWebRoutes(srv *api.Server, r *http.ServeMux) {
 	staticFS := http.FileServer(http.FS(subFS))
 
 	// Serve static assets.
-	r.Handle("/dist/", staticFS)
-	r.Handle("/src/", staticFS)
+	r.Handle("GET /dist/", staticFS)
+	r.Handle("GET /src/", staticFS)
 
 	// Serve application pages.
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		gui.HandleIndex(srv, w, r)
 	})
-	r.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) {
 		gui.HandleLoginTemplate(srv.Logger, w, r)
 	})
-	r.HandleFunc("/ping", gui.HandlePing)
+	r.HandleFunc("GET /ping", gui.HandlePing)
 }
 
 // startDaemonService initializes and starts the background daemon.
-func startDaemonService(db *sql.DB) {
-	daemon.StartDaemon(data.GetLogger(), db)
+// The returned channel is closed once the daemon has stopped after ctx is cancelled.
+func startDaemonService(ctx context.Context, db *sql.DB) <-chan struct{} {
+	return daemon.StartDaemon(ctx, data.GetLogger(), db)
 }
 
 // startGUIApplication handles the main startup logic for the GUI application.
-func startGUIApplication(db *sql.DB) {
+// It runs until ctx is cancelled and every service has stopped.
+func startGUIApplication(ctx context.Context, cancel context.CancelCauseFunc, db *sql.DB) {
 	exePath, err := os.Executable()
 	if err != nil {
-		data.GetLogger().Printf("Error getting executable path: %v", err)
+		data.GetLogger().Error("Error getting executable path", "err", err)
 		// We can continue, but some features might not work.
 	}
 
 	// This setup is necessary for the browser extension to communicate with the application.
 	if err := web.InstallNativeHost(exePath, chromeExtensionID); err != nil {
-		data.GetLogger().Printf("Failed to install native messaging host: %v\n", err)
+		data.GetLogger().Error("Failed to install native messaging host", "err", err)
 		// This is not a fatal error, the application can still run without the extension.
 	}
 
-	guiAddress := "127.0.0.1:" + defaultPort
-	guiUrl := "http://" + guiAddress
+	guiUrl := "http://" + data.Runtime().GUIAddr
 
 	// Check if an instance of the application is already running.
 	if isAppRunning(guiUrl) {
@@ -126,17 +211,23 @@ func startGUIApplication(db *sql.DB) {
 		return
 	}
 
-	// Start the API server and the daemon as goroutines.
-	startAPIServer(db)
-	startInternalAPIServer(db)
-	startDaemonService(db)
+	// Start the API servers and the daemon.
+	var servers sync.WaitGroup
+	servers.Go(func() { startAPIServer(ctx, cancel, db) })
+	servers.Go(func() { startInternalAPIServer(ctx) })
+	daemonDone := startDaemonService(ctx, db)
 
 	// Give the server a moment to start before opening the browser.
 	time.Sleep(1 * time.Second)
 	openBrowser(guiUrl)
 
-	// Keep the main GUI application running.
-	select {}
+	// Keep the main GUI application running until it is asked to stop.
+	<-ctx.Done()
+	data.GetLogger().Info("Shutting down", "cause", context.Cause(ctx))
+
+	// Stop enforcement and the other background services first, then the servers.
+	<-daemonDone
+	servers.Wait()
 }
 
 // isAppRunning checks if another instance of the application is already running by pinging the server.
@@ -147,7 +238,7 @@ func isAppRunning(url string) bool {
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			data.GetLogger().Printf("Failed to close response body in isAppRunning: %v", err)
+			data.GetLogger().Error("Failed to close response body in isAppRunning", "err", err)
 		}
 	}()
 	return resp.StatusCode == http.StatusOK
@@ -156,6 +247,6 @@ func isAppRunning(url string) bool {
 // openBrowser opens the specified URL in the default browser.
 func openBrowser(url string) {
 	if err := exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start(); err != nil {
-		data.GetLogger().Printf("Error opening browser: %v\n", err)
+		data.GetLogger().Error("Error opening browser", "err", err)
 	}
 }